@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+)
+
+// DefaultIdempotencyKeyPrefix prefixes the keys of idempotency records unless
+// WithIdempotencyKeyPrefix is given
+const DefaultIdempotencyKeyPrefix = "idempotency"
+
+var (
+	// ErrInProgress is returned by Idempotency.Begin when the key was claimed by a call
+	// that didn't complete yet
+	ErrInProgress = errors.New("cache: request in progress.")
+	// ErrClaimLost is returned when completing or aborting a claim that expired, once
+	// the key was claimed again by another call
+	ErrClaimLost = errors.New("cache: idempotency claim lost.")
+)
+
+const optionWithIdempotencyKeyPrefix = "optionWithIdempotencyKeyPrefix"
+
+// WithIdempotencyKeyPrefix sets the prefix of the keys of idempotency records
+// (DefaultIdempotencyKeyPrefix by default)
+func WithIdempotencyKeyPrefix(prefix string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithIdempotencyKeyPrefix] = prefix
+	}
+}
+
+// idempotencyRecord is what is stored for an idempotency key: the token of the claim
+// until the call completes, then its serialized result
+type idempotencyRecord struct {
+	Token  string
+	Done   bool
+	Result []byte
+}
+
+// Idempotency makes retried calls, such as POST requests carrying an Idempotency-Key
+// header, run once: the first call claims the key with Begin and stores its result
+// with the Complete method of its claim, and the calls repeating it get that result
+// instead of running again.
+type Idempotency struct {
+	store  persistence.CacheStore
+	prefix string
+}
+
+// IdempotencyClaim is a key claimed with Idempotency.Begin. It holds a random token,
+// also stored in the record of the key, so a call whose claim expired can't complete
+// or abort the claim of the call that took the key over.
+type IdempotencyClaim struct {
+	idem  *Idempotency
+	key   string
+	token string
+}
+
+// NewIdempotency returns an Idempotency keeping its records in store, which must
+// implement Add atomically across the processes sharing it, as RedisStore does.
+func NewIdempotency(store persistence.CacheStore, opt ...persistence.Option) *Idempotency {
+	opts := persistence.GetOpts(opt...)
+	i := &Idempotency{store: store, prefix: DefaultIdempotencyKeyPrefix}
+	if v, ok := opts[optionWithIdempotencyKeyPrefix].(string); ok {
+		i.prefix = v
+	}
+	return i
+}
+
+func (i *Idempotency) key(key string) string {
+	return i.prefix + ":" + key
+}
+
+// newClaimToken returns a random token identifying a claim
+func newClaimToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Begin claims key for ttl, the longest the call and its result are remembered, and
+// returns the claim if it did: the caller then runs the call and calls its Complete
+// method, or Abort if it failed in a way that can be retried. Otherwise, Begin
+// returns a nil claim after deserializing the result of the call that completed into
+// result (unless nil), or ErrInProgress if that call is still running.
+func (i *Idempotency) Begin(key string, ttl time.Duration, result interface{}) (*IdempotencyClaim, error) {
+	token := newClaimToken()
+	err := i.store.Add(i.key(key), idempotencyRecord{Token: token}, ttl)
+	if err == nil {
+		return &IdempotencyClaim{idem: i, key: key, token: token}, nil
+	}
+	if err != persistence.ErrNotStored {
+		return nil, err
+	}
+	var record idempotencyRecord
+	if err := i.store.Get(i.key(key), &record); err != nil {
+		if err == persistence.ErrCacheMiss {
+			// the record expired or was aborted in the meantime
+			return i.Begin(key, ttl, result)
+		}
+		return nil, err
+	}
+	if !record.Done {
+		return nil, ErrInProgress
+	}
+	if result == nil {
+		return nil, nil
+	}
+	return nil, utils.Deserialize(record.Result, result)
+}
+
+// Key returns the key claimed
+func (c *IdempotencyClaim) Key() string {
+	return c.key
+}
+
+// held reports whether the record of the key is still the claim's. The check and the
+// write following it aren't atomic: a claim expiring in between can still be
+// overwritten, which a TTL well above the duration of the call avoids.
+func (c *IdempotencyClaim) held() (bool, error) {
+	var record idempotencyRecord
+	if err := c.idem.store.Get(c.idem.key(c.key), &record); err != nil {
+		return false, err
+	}
+	return !record.Done && record.Token == c.token, nil
+}
+
+// Complete stores the result of the call, for the rest of the TTL of the claim. It
+// returns persistence.ErrCacheMiss if the claim expired, or ErrClaimLost if the key
+// was claimed again since.
+func (c *IdempotencyClaim) Complete(result interface{}) error {
+	if held, err := c.held(); err != nil {
+		return err
+	} else if !held {
+		return ErrClaimLost
+	}
+	key := c.idem.key(c.key)
+	ttl, err := c.idem.store.GetExpiresIn(key)
+	switch err {
+	case nil:
+		if ttl <= 0 {
+			return persistence.ErrCacheMiss
+		}
+	case persistence.ErrCacheNoTTL:
+		ttl = persistence.FOREVER
+	default:
+		return err
+	}
+	b, err := utils.Serialize(result)
+	if err != nil {
+		return err
+	}
+	return c.idem.store.Replace(key, idempotencyRecord{Token: c.token, Done: true, Result: b}, ttl)
+}
+
+// Abort drops the claim, so the call can be retried. It returns ErrClaimLost, leaving
+// the record as is, if the key was claimed again since the claim expired.
+func (c *IdempotencyClaim) Abort() error {
+	held, err := c.held()
+	if err == persistence.ErrCacheMiss {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !held {
+		return ErrClaimLost
+	}
+	err = c.idem.store.Delete(c.idem.key(c.key))
+	if err == persistence.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
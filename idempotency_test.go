@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+	"github.com/Bose/cache/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+type idempotentResponse struct {
+	Status int
+	Body   string
+}
+
+func TestIdempotency(t *testing.T) {
+	for name, store := range map[string]persistence.CacheStore{
+		"inmemory": persistence.NewInMemoryStore(time.Minute),
+		"redis":    persistence.NewRedisCache(miniredistest.Run(t).Addr(), "", time.Minute),
+	} {
+		t.Run(name, func(t *testing.T) {
+			idem := NewIdempotency(store)
+			var res idempotentResponse
+			claim, err := idem.Begin("order-1", time.Minute, &res)
+			assert.NoError(t, err)
+			assert.NotNil(t, claim)
+
+			other, err := idem.Begin("order-1", time.Minute, &res)
+			assert.Equal(t, ErrInProgress, err)
+			assert.Nil(t, other)
+
+			assert.NoError(t, claim.Complete(idempotentResponse{Status: 201, Body: "created"}))
+			other, err = idem.Begin("order-1", time.Minute, &res)
+			assert.NoError(t, err)
+			assert.Nil(t, other)
+			assert.Equal(t, idempotentResponse{Status: 201, Body: "created"}, res)
+			ttl, err := store.GetExpiresIn(DefaultIdempotencyKeyPrefix + ":order-1")
+			assert.NoError(t, err)
+			assert.True(t, ttl > 0 && ttl <= time.Minute, "expected the TTL of the claim kept, got %s", ttl)
+
+			// aborted calls can be retried
+			claim, _ = idem.Begin("order-2", time.Minute, nil)
+			assert.NotNil(t, claim)
+			assert.NoError(t, claim.Abort())
+			claim, err = idem.Begin("order-2", time.Minute, nil)
+			assert.NoError(t, err)
+			assert.NotNil(t, claim)
+
+			claim, _ = idem.Begin("order-3", time.Minute, nil)
+			assert.NoError(t, store.Delete(DefaultIdempotencyKeyPrefix+":order-3"))
+			assert.Equal(t, persistence.ErrCacheMiss, claim.Complete("too late"))
+			assert.NoError(t, claim.Abort())
+
+			// a claim taken over once expired is left to the call holding it
+			lost, _ := idem.Begin("order-4", time.Minute, nil)
+			assert.NoError(t, store.Delete(DefaultIdempotencyKeyPrefix+":order-4"))
+			claim, _ = idem.Begin("order-4", time.Minute, nil)
+			assert.NotNil(t, claim)
+			assert.Equal(t, ErrClaimLost, lost.Complete("stale"))
+			assert.Equal(t, ErrClaimLost, lost.Abort())
+			assert.NoError(t, claim.Complete("fresh"))
+			var got string
+			other, err = idem.Begin("order-4", time.Minute, &got)
+			assert.NoError(t, err)
+			assert.Nil(t, other)
+			assert.Equal(t, "fresh", got)
+		})
+	}
+}
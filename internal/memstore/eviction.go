@@ -0,0 +1,260 @@
+package memstore
+
+import "container/list"
+
+// Policy selects the entries a bounded cache evicts to make room for new ones
+type Policy int
+
+const (
+	// LRU evicts the least recently used entry
+	LRU Policy = iota
+	// LFU evicts the least frequently used entry, the least recently used of them on
+	// ties
+	LFU
+	// TwoQ evicts the entries of a FIFO queue of new entries first (2Q)
+	TwoQ
+)
+
+// policy tracks the keys of a bounded shard, under the shard lock
+type policy interface {
+	// add records a new key
+	add(key string)
+	// touch records an access to key
+	touch(key string)
+	// remove forgets key, deleted from the cache
+	remove(key string)
+	// evict picks the key to evict next and forgets it, false if there are none
+	evict() (string, bool)
+	// reset forgets every key
+	reset()
+}
+
+func newPolicy(p Policy) policy {
+	switch p {
+	case LFU:
+		return newLFUPolicy()
+	case TwoQ:
+		return newTwoQPolicy()
+	}
+	return newLRUPolicy()
+}
+
+// lruPolicy keeps keys from the most to the least recently used
+type lruPolicy struct {
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{order: list.New(), keys: map[string]*list.Element{}}
+}
+
+func (p *lruPolicy) add(key string) {
+	p.keys[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy) touch(key string) {
+	if e, ok := p.keys[key]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy) remove(key string) {
+	if e, ok := p.keys[key]; ok {
+		p.order.Remove(e)
+		delete(p.keys, key)
+	}
+}
+
+func (p *lruPolicy) evict() (string, bool) {
+	e := p.order.Back()
+	if e == nil {
+		return "", false
+	}
+	key := e.Value.(string)
+	p.remove(key)
+	return key, true
+}
+
+func (p *lruPolicy) reset() {
+	p.order.Init()
+	p.keys = map[string]*list.Element{}
+}
+
+// lfuEntry is a key of the lfuPolicy with its access count
+type lfuEntry struct {
+	key  string
+	freq int
+}
+
+// lfuPolicy keeps keys in one list per access count, each from the most to the least
+// recently used, so every operation is O(1)
+type lfuPolicy struct {
+	keys  map[string]*list.Element // of *lfuEntry, in the list of its count
+	freqs map[int]*list.List
+	// min is the lowest count with keys, unless the keys with it were just removed
+	min int
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{keys: map[string]*list.Element{}, freqs: map[int]*list.List{}}
+}
+
+func (p *lfuPolicy) push(e *lfuEntry) *list.Element {
+	l, ok := p.freqs[e.freq]
+	if !ok {
+		l = list.New()
+		p.freqs[e.freq] = l
+	}
+	return l.PushFront(e)
+}
+
+// unlink takes the element out of the list of its count, dropping emptied lists
+func (p *lfuPolicy) unlink(el *list.Element) *lfuEntry {
+	e := el.Value.(*lfuEntry)
+	l := p.freqs[e.freq]
+	l.Remove(el)
+	if l.Len() == 0 {
+		delete(p.freqs, e.freq)
+	}
+	return e
+}
+
+func (p *lfuPolicy) add(key string) {
+	p.keys[key] = p.push(&lfuEntry{key: key, freq: 1})
+	p.min = 1
+}
+
+func (p *lfuPolicy) touch(key string) {
+	el, ok := p.keys[key]
+	if !ok {
+		return
+	}
+	e := p.unlink(el)
+	if e.freq == p.min && p.freqs[e.freq] == nil {
+		p.min++
+	}
+	e.freq++
+	p.keys[key] = p.push(e)
+}
+
+func (p *lfuPolicy) remove(key string) {
+	if el, ok := p.keys[key]; ok {
+		p.unlink(el)
+		delete(p.keys, key)
+	}
+}
+
+func (p *lfuPolicy) evict() (string, bool) {
+	if len(p.keys) == 0 {
+		return "", false
+	}
+	l, ok := p.freqs[p.min]
+	if !ok {
+		// the keys with the lowest count were removed: look for the next one
+		p.min = 0
+		for freq := range p.freqs {
+			if p.min == 0 || freq < p.min {
+				p.min = freq
+			}
+		}
+		l = p.freqs[p.min]
+	}
+	key := l.Back().Value.(*lfuEntry).key
+	p.remove(key)
+	return key, true
+}
+
+func (p *lfuPolicy) reset() {
+	p.keys = map[string]*list.Element{}
+	p.freqs = map[int]*list.List{}
+	p.min = 0
+}
+
+// twoQEntry is a key of the twoQPolicy, in the queue of new keys or the hot list
+type twoQEntry struct {
+	key string
+	hot bool
+}
+
+// twoQPolicy implements the full 2Q algorithm: new keys go through a FIFO queue, and
+// the keys it evicts are remembered for a while without their values (ghosts); keys
+// added again while remembered go to the LRU list of hot keys.
+//
+// The queue is kept to a quarter of the keys, and the ghosts to half of them, rather
+// than to shares of a fixed capacity, as shards may be bounded by cost.
+type twoQPolicy struct {
+	in, hot, ghosts *list.List
+	keys            map[string]*list.Element // of *twoQEntry, in in or hot
+	ghostKeys       map[string]*list.Element // of string, in ghosts
+}
+
+func newTwoQPolicy() *twoQPolicy {
+	p := &twoQPolicy{}
+	p.reset()
+	return p
+}
+
+// share returns the fraction 1/n of the keys, at least 1
+func (p *twoQPolicy) share(n int) int {
+	if v := len(p.keys) / n; v > 1 {
+		return v
+	}
+	return 1
+}
+
+func (p *twoQPolicy) add(key string) {
+	if g, ok := p.ghostKeys[key]; ok {
+		p.ghosts.Remove(g)
+		delete(p.ghostKeys, key)
+		p.keys[key] = p.hot.PushFront(&twoQEntry{key: key, hot: true})
+	} else {
+		p.keys[key] = p.in.PushFront(&twoQEntry{key: key})
+	}
+	// ghosts are trimmed here rather than in evict, which runs first when the shard is
+	// full, so the key being added is still found among them
+	for p.ghosts.Len() > p.share(2) {
+		delete(p.ghostKeys, p.ghosts.Remove(p.ghosts.Back()).(string))
+	}
+}
+
+func (p *twoQPolicy) touch(key string) {
+	// accesses to new keys don't count: they're likely part of the same burst
+	if e, ok := p.keys[key]; ok && e.Value.(*twoQEntry).hot {
+		p.hot.MoveToFront(e)
+	}
+}
+
+func (p *twoQPolicy) remove(key string) {
+	e, ok := p.keys[key]
+	if !ok {
+		return
+	}
+	if e.Value.(*twoQEntry).hot {
+		p.hot.Remove(e)
+	} else {
+		p.in.Remove(e)
+	}
+	delete(p.keys, key)
+}
+
+func (p *twoQPolicy) evict() (string, bool) {
+	if p.in.Len() > p.share(4) || (p.hot.Len() == 0 && p.in.Len() > 0) {
+		key := p.in.Back().Value.(*twoQEntry).key
+		p.remove(key)
+		p.ghostKeys[key] = p.ghosts.PushFront(key)
+		return key, true
+	}
+	if e := p.hot.Back(); e != nil {
+		key := e.Value.(*twoQEntry).key
+		p.remove(key)
+		return key, true
+	}
+	return "", false
+}
+
+func (p *twoQPolicy) reset() {
+	p.in, p.hot, p.ghosts = list.New(), list.New(), list.New()
+	p.keys = map[string]*list.Element{}
+	p.ghostKeys = map[string]*list.Element{}
+}
@@ -0,0 +1,130 @@
+package memstore
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Expiry records when a key is due to expire. Entries are never updated in place:
+// overwriting a key pushes a new entry and the stale one is skipped when it reaches the
+// top of the heap.
+type Expiry struct {
+	Key        string
+	Expiration int64
+}
+
+// ExpiryHeap is a min-heap of expiry entries ordered by expiration
+type ExpiryHeap []Expiry
+
+func (h ExpiryHeap) Len() int            { return len(h) }
+func (h ExpiryHeap) Less(i, j int) bool  { return h[i].Expiration < h[j].Expiration }
+func (h ExpiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *ExpiryHeap) Push(x interface{}) { *h = append(*h, x.(Expiry)) }
+func (h *ExpiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	*h = old[:n-1]
+	return e
+}
+
+// deleteExpired removes the expired items of one shard, at most limit of them if
+// limit > 0, only visiting entries that are actually due instead of sweeping the
+// whole map
+func (s *shard[V]) deleteExpired(now int64, limit int) []evicted[V] {
+	var expired []evicted[V]
+	for len(s.expiries) > 0 && s.expiries[0].Expiration < now && (limit <= 0 || len(expired) < limit) {
+		e := heap.Pop(&s.expiries).(Expiry)
+		it, found := s.items[e.Key]
+		if !found || it.expiration != e.Expiration {
+			// stale entry: the key was deleted or written again since
+			continue
+		}
+		s.remove(e.Key)
+		expired = append(expired, evicted[V]{e.Key, it.value, ReasonExpired})
+	}
+	s.compactExpiries()
+	return expired
+}
+
+// compactExpiries rebuilds the heap once stale entries make up the majority of it, as
+// keys that are overwritten often leave them behind. It runs on writes too, so the heap
+// stays bounded when no janitor sweeps it.
+func (s *shard[V]) compactExpiries() {
+	if len(s.expiries) <= 2*len(s.items)+64 {
+		return
+	}
+	s.expiries = s.expiries[:0]
+	for k, it := range s.items {
+		if it.expiration > 0 {
+			s.expiries = append(s.expiries, Expiry{Key: k, Expiration: it.expiration})
+		}
+	}
+	heap.Init(&s.expiries)
+}
+
+// DeleteExpired removes the expired entries, one shard at a time and at most
+// Config.SweepBatch of them per shard if set. The janitor calls it every
+// Config.CleanupInterval.
+func (c *Cache[V]) DeleteExpired() {
+	for _, s := range c.shards {
+		now := c.now()
+		s.Lock()
+		expired := s.deleteExpired(now, c.sweepBatch)
+		s.Unlock()
+		c.evicted(expired...)
+	}
+}
+
+type janitor struct {
+	interval time.Duration
+	stop     chan bool
+}
+
+func (j *janitor) run(deleteExpired func()) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			deleteExpired()
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// expire removes the item read under key if it expired and wasn't written since, on
+// reads of lazy caches
+func (c *Cache[V]) expire(key string, it item[V]) {
+	s := c.shard(key)
+	s.Lock()
+	cur, found := s.items[key]
+	if !found || cur.expiration != it.expiration || !cur.expired(c.now()) {
+		s.Unlock()
+		return
+	}
+	s.remove(key)
+	s.Unlock()
+	c.evicted(evicted[V]{key, cur.value, ReasonExpired})
+}
+
+// Running reports whether the cache runs goroutines in the background, which only
+// Close stops
+func (c *Cache[V]) Running() bool {
+	return c.janitor != nil || c.monitor != nil
+}
+
+// Close stops the goroutines of the cache: the janitor sweeping expired entries and
+// the memory monitor. The cache is still usable after, but expired entries are no
+// longer swept in the background. It's safe to call more than once.
+func (c *Cache[V]) Close() {
+	c.closeOnce.Do(func() {
+		if c.janitor != nil {
+			close(c.janitor.stop)
+		}
+		if c.monitor != nil {
+			close(c.monitor.stop)
+		}
+	})
+}
@@ -0,0 +1,491 @@
+// Package memstore is the sharded in-memory cache behind persistence.InMemoryStore and
+// memory.Store. It is generic over the type of the values, so memory.Store keeps them
+// as they are, and both stores share the same expiration and eviction behavior.
+package memstore
+
+import (
+	"container/heap"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultShards = 256
+
+	// NoExpiration is the TTL reported for the entries that never expire
+	NoExpiration = time.Duration(-1)
+)
+
+// Reason describes why an entry left the cache
+type Reason int
+
+const (
+	// ReasonExpired - the entry's TTL passed
+	ReasonExpired Reason = iota
+	// ReasonDeleted - the entry was removed via Delete
+	ReasonDeleted
+	// ReasonEvicted - the entry was removed to make room for others
+	ReasonEvicted
+	// ReasonFlushed - the entry was removed via Flush
+	ReasonFlushed
+)
+
+// EvictionFunc is called with every entry that leaves the cache
+type EvictionFunc[V any] func(key string, value V, reason Reason)
+
+// Clock tells the time to the cache
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Config configures a Cache. The zero value is an unbounded cache of 256 shards,
+// whose expired entries are only removed when overwritten or deleted.
+type Config struct {
+	// Shards is the number of shards, rounded up to a power of two
+	Shards int
+	// TTL translates the expiration given to the writes of key into the TTL of the
+	// entry, 0 for none. Expirations <= 0 mean none if it's nil.
+	TTL func(key string, expires time.Duration) time.Duration
+	// Clock tells the time, the wall clock if nil
+	Clock Clock
+	// MaxEntries bounds the number of entries, 0 for no bound
+	MaxEntries int
+	// MaxCost bounds the total cost of the entries as charged by Cost, 1 per entry if
+	// Cost is nil, 0 for no bound
+	MaxCost int64
+	Cost    func(value interface{}) int64
+	// Policy picks the entries evicted beyond the bounds, or shed under memory pressure
+	Policy Policy
+	// Lazy removes expired entries when they're read
+	Lazy bool
+	// CleanupInterval is how often expired entries are swept, 0 for never
+	CleanupInterval time.Duration
+	// SweepBatch bounds the entries removed per shard and sweep, 0 for all
+	SweepBatch int
+	// Pressure makes the cache shed entries when the process runs short of memory
+	Pressure *Pressure
+	// Expvar publishes the Stats of the cache with expvar under this name, if set
+	Expvar string
+}
+
+// FromOptions returns the Config of the options of the persistence package, with
+// entries written with persistence.DEFAULT expiring after defaultExpiration. It is set
+// by the persistence package, which owns the options, so the stores built on Cache
+// take the same options.
+var FromOptions func(defaultExpiration time.Duration, opts map[string]interface{}) Config
+
+// Cache spreads entries across shards keyed by the hash of the key, so concurrent
+// callers only contend on a single shard lock instead of a global one
+type Cache[V any] struct {
+	stats      counters // first so the 64-bit counters stay aligned
+	shards     []*shard[V]
+	mask       uint32
+	ttl        func(key string, expires time.Duration) time.Duration
+	clock      Clock
+	lazy       bool
+	sweepBatch int
+	janitor    *janitor
+	monitor    *monitor
+	closeOnce  sync.Once
+	onEvicted  atomic.Value // EvictionFunc[V]
+}
+
+type shard[V any] struct {
+	sync.RWMutex
+	items    map[string]item[V]
+	expiries ExpiryHeap
+	// policy picks the entries evicted beyond capacity entries or maxCost, nil when the
+	// cache is unbounded
+	policy   policy
+	capacity int
+	maxCost  int64
+	// cost is the total cost of the items, as charged by costOf
+	cost   int64
+	costOf func(value interface{}) int64
+	// bytes is the approximate memory held by the items, the sum of their size
+	bytes int64
+}
+
+type item[V any] struct {
+	value      V
+	expiration int64 // unix nano, 0 means the item never expires
+	cost       int64 // 0 unless the cache is bounded by cost
+	size       int64 // approximate memory held by the key and value (see Stats)
+}
+
+func (i item[V]) expired(now int64) bool {
+	return i.expiration > 0 && now > i.expiration
+}
+
+type evicted[V any] struct {
+	key    string
+	value  V
+	reason Reason
+}
+
+// New returns a Cache configured by cfg. Its goroutines, the janitor sweeping expired
+// entries and the memory monitor, run until Close.
+func New[V any](cfg Config) *Cache[V] {
+	shards := defaultShards
+	if cfg.Shards > 0 {
+		shards = cfg.Shards
+	}
+	// round up to a power of two so the shard can be picked with a mask
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	c := &Cache[V]{
+		shards:     make([]*shard[V], n),
+		mask:       uint32(n - 1),
+		ttl:        cfg.TTL,
+		clock:      cfg.Clock,
+		lazy:       cfg.Lazy,
+		sweepBatch: cfg.SweepBatch,
+	}
+	if c.clock == nil {
+		c.clock = systemClock{}
+	}
+	capacity := 0
+	if cfg.MaxEntries > 0 {
+		// the bound is enforced per shard
+		capacity = (cfg.MaxEntries + n - 1) / n
+	}
+	var maxCost int64
+	if cfg.MaxCost > 0 {
+		maxCost = (cfg.MaxCost + int64(n) - 1) / int64(n)
+	}
+	costOf := cfg.Cost
+	if maxCost > 0 && costOf == nil {
+		costOf = func(interface{}) int64 { return 1 }
+	}
+	for i := range c.shards {
+		s := &shard[V]{items: map[string]item[V]{}, capacity: capacity}
+		// the policy also picks the entries shed under memory pressure
+		if capacity > 0 || maxCost > 0 || cfg.Pressure != nil {
+			s.policy = newPolicy(cfg.Policy)
+			s.maxCost, s.costOf = maxCost, costOf
+		}
+		c.shards[i] = s
+	}
+	if cfg.Expvar != "" {
+		expvar.Publish(cfg.Expvar, expvar.Func(func() interface{} { return c.Stats() }))
+	}
+	if cfg.CleanupInterval > 0 {
+		c.janitor = &janitor{interval: cfg.CleanupInterval, stop: make(chan bool)}
+		go c.janitor.run(c.DeleteExpired)
+	}
+	if cfg.Pressure != nil {
+		if c.monitor = newMonitor(*cfg.Pressure); c.monitor != nil {
+			go c.monitor.run(c.Shed)
+		}
+	}
+	return c
+}
+
+// fnv-1a, inlined to avoid allocating a hash.Hash32 per operation
+func (c *Cache[V]) shard(key string) *shard[V] {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h&c.mask]
+}
+
+// Clock returns the clock telling the time to the cache
+func (c *Cache[V]) Clock() Clock {
+	return c.clock
+}
+
+// now returns the current time of the clock (unix nano)
+func (c *Cache[V]) now() int64 {
+	return c.clock.Now().UnixNano()
+}
+
+func (c *Cache[V]) expiration(key string, expires time.Duration) int64 {
+	if c.ttl != nil {
+		expires = c.ttl(key, expires)
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return c.clock.Now().Add(expires).UnixNano()
+}
+
+// OnEvicted sets an (optional) function that is called with the key, value and reason
+// whenever an entry is expired, deleted, evicted or flushed. It is called outside of
+// any lock, so it may safely call back into the cache. Pass nil to disable.
+func (c *Cache[V]) OnEvicted(f EvictionFunc[V]) {
+	c.onEvicted.Store(f)
+}
+
+func (c *Cache[V]) evicted(items ...evicted[V]) {
+	for _, e := range items {
+		c.stats.record(e.reason)
+	}
+	f, _ := c.onEvicted.Load().(EvictionFunc[V])
+	if f == nil {
+		return
+	}
+	for _, e := range items {
+		f(e.key, e.value, e.reason)
+	}
+}
+
+// set stores the item, and returns the previous one if it had already expired along
+// with the entries evicted to make room for it
+func (s *shard[V]) set(key string, it item[V], now int64) []evicted[V] {
+	var out []evicted[V]
+	if s.costOf != nil {
+		it.cost = s.costOf(it.value)
+		if it.cost > s.maxCost && s.maxCost > 0 {
+			// the item alone is over the bound of the shard: it replaces nothing
+			if old, found := s.items[key]; found {
+				s.remove(key)
+				if old.expired(now) {
+					out = append(out, evicted[V]{key, old.value, ReasonExpired})
+				}
+			}
+			return append(out, evicted[V]{key, it.value, ReasonEvicted})
+		}
+	}
+	it.size = itemSize(key, it.value)
+	old, found := s.items[key]
+	s.items[key] = it
+	s.cost += it.cost - old.cost
+	s.bytes += it.size - old.size
+	if it.expiration > 0 {
+		heap.Push(&s.expiries, Expiry{Key: key, Expiration: it.expiration})
+		s.compactExpiries()
+	}
+	if found && old.expired(now) {
+		out = append(out, evicted[V]{key, old.value, ReasonExpired})
+	}
+	if s.policy == nil {
+		return out
+	}
+	if found {
+		s.policy.touch(key)
+	}
+	// the victims of new keys are picked before they're added, so they get a chance to
+	// be read again even when the policy ranks them last
+	out = s.evictOverflow(out, now)
+	if !found {
+		s.policy.add(key)
+	}
+	return out
+}
+
+// evictOverflow evicts entries picked by the policy until the shard is within its
+// bounds, appending them to out
+func (s *shard[V]) evictOverflow(out []evicted[V], now int64) []evicted[V] {
+	for s.full() {
+		victim, ok := s.policy.evict()
+		if !ok {
+			break
+		}
+		v := s.items[victim]
+		delete(s.items, victim)
+		s.cost -= v.cost
+		s.bytes -= v.size
+		if v.expired(now) {
+			out = append(out, evicted[V]{victim, v.value, ReasonExpired})
+		} else {
+			out = append(out, evicted[V]{victim, v.value, ReasonEvicted})
+		}
+	}
+	return out
+}
+
+// full reports whether the shard holds more than its capacity or its maximum cost
+func (s *shard[V]) full() bool {
+	return (s.capacity > 0 && len(s.items) > s.capacity) || (s.maxCost > 0 && s.cost > s.maxCost)
+}
+
+// remove deletes the item of key
+func (s *shard[V]) remove(key string) {
+	it := s.items[key]
+	s.cost -= it.cost
+	s.bytes -= it.size
+	delete(s.items, key)
+	if s.policy != nil {
+		s.policy.remove(key)
+	}
+}
+
+// get returns the item of key, recording the access for the eviction policy
+func (s *shard[V]) get(key string) (item[V], bool) {
+	if s.policy == nil {
+		s.RLock()
+		it, found := s.items[key]
+		s.RUnlock()
+		return it, found
+	}
+	s.Lock()
+	it, found := s.items[key]
+	if found {
+		s.policy.touch(key)
+	}
+	s.Unlock()
+	return it, found
+}
+
+// Get returns the value of key, false if it isn't in the cache
+func (c *Cache[V]) Get(key string) (V, bool) {
+	it, found := c.shard(key).get(key)
+	if !found || it.expired(c.now()) {
+		if found && c.lazy {
+			c.expire(key, it)
+		}
+		atomic.AddUint64(&c.stats.misses, 1)
+		var zero V
+		return zero, false
+	}
+	atomic.AddUint64(&c.stats.hits, 1)
+	return it.value, true
+}
+
+// TTL returns the time left before key expires, NoExpiration if it never does, and
+// false if it isn't in the cache
+func (c *Cache[V]) TTL(key string) (time.Duration, bool) {
+	s := c.shard(key)
+	s.RLock()
+	it, found := s.items[key]
+	s.RUnlock()
+	now := c.now()
+	if !found || it.expired(now) {
+		if found && c.lazy {
+			c.expire(key, it)
+		}
+		return 0, false
+	}
+	if it.expiration == 0 {
+		return NoExpiration, true
+	}
+	return time.Duration(it.expiration - now), true
+}
+
+// Set stores the value, replacing any existing one
+func (c *Cache[V]) Set(key string, value V, expires time.Duration) {
+	s := c.shard(key)
+	s.Lock()
+	out := s.set(key, item[V]{value: value, expiration: c.expiration(key, expires)}, c.now())
+	s.Unlock()
+	c.evicted(out...)
+}
+
+// Add stores the value only if key isn't in the cache, and reports whether it did
+func (c *Cache[V]) Add(key string, value V, expires time.Duration) bool {
+	now := c.now()
+	s := c.shard(key)
+	s.Lock()
+	if it, found := s.items[key]; found && !it.expired(now) {
+		s.Unlock()
+		return false
+	}
+	out := s.set(key, item[V]{value: value, expiration: c.expiration(key, expires)}, now)
+	s.Unlock()
+	c.evicted(out...)
+	return true
+}
+
+// Replace stores the value only if key is in the cache, and reports whether it did
+func (c *Cache[V]) Replace(key string, value V, expires time.Duration) bool {
+	now := c.now()
+	s := c.shard(key)
+	s.Lock()
+	if it, found := s.items[key]; !found || it.expired(now) {
+		s.Unlock()
+		return false
+	}
+	out := s.set(key, item[V]{value: value, expiration: c.expiration(key, expires)}, now)
+	s.Unlock()
+	c.evicted(out...)
+	return true
+}
+
+// Delete removes key, and reports whether it was in the cache
+func (c *Cache[V]) Delete(key string) bool {
+	s := c.shard(key)
+	s.Lock()
+	it, found := s.items[key]
+	if !found {
+		s.Unlock()
+		return false
+	}
+	s.remove(key)
+	s.Unlock()
+	if it.expired(c.now()) {
+		c.evicted(evicted[V]{key, it.value, ReasonExpired})
+		return false
+	}
+	c.evicted(evicted[V]{key, it.value, ReasonDeleted})
+	return true
+}
+
+// Update replaces the value of key with the one f returns for it, keeping its
+// expiration. It returns false if key isn't in the cache, and the error of f, which
+// leaves the value as is.
+func (c *Cache[V]) Update(key string, f func(V) (V, error)) (bool, error) {
+	now := c.now()
+	s := c.shard(key)
+	s.Lock()
+	it, found := s.items[key]
+	if !found || it.expired(now) {
+		s.Unlock()
+		return false, nil
+	}
+	value, err := f(it.value)
+	if err != nil {
+		s.Unlock()
+		return true, err
+	}
+	it.value = value
+	var out []evicted[V]
+	if s.costOf != nil {
+		// the new value may cost more, and push the shard over its bound
+		it.cost = s.costOf(it.value)
+		s.cost += it.cost - s.items[key].cost
+	}
+	it.size = itemSize(key, it.value)
+	s.bytes += it.size - s.items[key].size
+	s.items[key] = it
+	if s.policy != nil {
+		s.policy.touch(key)
+		out = s.evictOverflow(out, now)
+	}
+	s.Unlock()
+	c.evicted(out...)
+	return true, nil
+}
+
+// Flush removes every entry from the cache
+func (c *Cache[V]) Flush() {
+	f, _ := c.onEvicted.Load().(EvictionFunc[V])
+	for _, s := range c.shards {
+		s.Lock()
+		items := s.items
+		s.items = map[string]item[V]{}
+		s.expiries = nil
+		s.cost = 0
+		s.bytes = 0
+		if s.policy != nil {
+			s.policy.reset()
+		}
+		s.Unlock()
+		if f != nil {
+			for k, it := range items {
+				f(k, it.value, ReasonFlushed)
+			}
+		}
+	}
+}
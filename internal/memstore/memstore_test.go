@@ -0,0 +1,128 @@
+package memstore
+
+import (
+	"math"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestCache_Shards(t *testing.T) {
+	c := New[int](Config{Shards: 3})
+	if len(c.shards) != 4 {
+		t.Errorf("expected shards to be rounded up to 4, got %d", len(c.shards))
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	clock := &fakeClock{time.Now()}
+	c := New[string](Config{Clock: clock, Lazy: true, TTL: func(key string, expires time.Duration) time.Duration {
+		if key == "forever" {
+			return 0
+		}
+		return expires
+	}})
+	c.Set("short", "a", time.Minute)
+	c.Set("forever", "b", time.Minute)
+	if d, found := c.TTL("short"); !found || d != time.Minute {
+		t.Errorf("expected a minute, got %s (%v)", d, found)
+	}
+	if d, found := c.TTL("forever"); !found || d != NoExpiration {
+		t.Errorf("expected no expiration, got %s (%v)", d, found)
+	}
+	clock.now = clock.now.Add(2 * time.Minute)
+	if _, found := c.Get("short"); found {
+		t.Error("expected the entry to expire")
+	}
+	if st := c.Stats(); st.Entries != 1 || st.Expirations != 1 || st.Misses != 1 {
+		t.Errorf("expected the expired entry to be removed when read, got %+v", st)
+	}
+}
+
+func TestCache_LazyExpiriesBounded(t *testing.T) {
+	c := New[int](Config{Shards: 1, Lazy: true})
+	for i := 0; i < 100000; i++ {
+		c.Set("key", i, time.Minute)
+	}
+	// with no janitor popping them, stale heap entries are compacted on writes
+	if n := len(c.shards[0].expiries); n > 2*len(c.shards[0].items)+64 {
+		t.Errorf("expected the expiry heap to stay bounded, got %d entries for 1 item", n)
+	}
+}
+
+func TestCache_UpdateCost(t *testing.T) {
+	c := New[int](Config{Shards: 1, MaxCost: 10, Cost: func(v interface{}) int64 { return int64(v.(int)) }})
+	c.Set("a", 1, 0)
+	c.Set("b", 1, 0)
+	if _, err := c.Update("a", func(v int) (int, error) { return v + 5, nil }); err != nil {
+		t.Fatalf("Error updating: %s", err)
+	}
+	if cost := c.shards[0].cost; cost != 7 {
+		t.Errorf("expected Update to charge the new value, got a cost of %d", cost)
+	}
+	if found, _ := c.Update("missing", func(v int) (int, error) { return v, nil }); found {
+		t.Error("expected a missing key not to be updated")
+	}
+}
+
+func TestPressure_NoLimit(t *testing.T) {
+	if debug.SetMemoryLimit(-1) != math.MaxInt64 {
+		t.Skip("GOMEMLIMIT is set")
+	}
+	if m := newMonitor(Pressure{}); m != nil {
+		t.Errorf("expected no monitor without a limit")
+	}
+}
+
+func TestLFUPolicy(t *testing.T) {
+	p := newLFUPolicy()
+	p.add("a")
+	p.add("b")
+	p.add("c")
+	p.touch("a")
+	p.touch("b")
+	p.touch("b")
+	if k, _ := p.evict(); k != "c" {
+		t.Errorf("expected c, got %s", k)
+	}
+	p.remove("c")
+	if k, _ := p.evict(); k != "a" {
+		t.Errorf("expected a once c is removed, got %s", k)
+	}
+	p.touch("a")
+	p.touch("a")
+	if k, _ := p.evict(); k != "b" {
+		t.Errorf("expected the least recently used of a and b, got %s", k)
+	}
+	p.reset()
+	if _, ok := p.evict(); ok {
+		t.Errorf("expected no victim once reset")
+	}
+}
+
+func TestTwoQPolicy(t *testing.T) {
+	p := newTwoQPolicy()
+	for _, key := range []string{"a", "b", "c"} {
+		p.add(key)
+	}
+	if k, _ := p.evict(); k != "a" {
+		t.Errorf("expected the oldest new key, got %s", k)
+	}
+	p.add("a")
+	p.add("d")
+	p.add("e")
+	// a came back while remembered, so it's hot: it's evicted once the queue of new
+	// keys is down to its share of the keys, then the queue is emptied
+	for _, expected := range []string{"b", "c", "d", "a", "e"} {
+		if k, _ := p.evict(); k != expected {
+			t.Errorf("expected %s, got %s", expected, k)
+		}
+	}
+	if _, ok := p.evict(); ok {
+		t.Errorf("expected no key left")
+	}
+}
@@ -0,0 +1,159 @@
+package memstore
+
+import (
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+const (
+	defaultPressureThreshold = 0.9
+	defaultPressureFraction  = 0.25
+	defaultPressureInterval  = time.Second
+)
+
+// Pressure configures how the cache sheds entries when the process runs short of
+// memory. Its fields are those of persistence.MemoryPressure, documented there.
+type Pressure struct {
+	Limit     uint64
+	Threshold float64
+	Fraction  float64
+	Interval  time.Duration
+	Usage     func() uint64
+}
+
+// runtimeMemoryUsage returns the memory mapped by the Go runtime and not released
+func runtimeMemoryUsage() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// gcCycles returns the number of GC cycles completed since the process started
+func gcCycles() uint64 {
+	samples := []metrics.Sample{{Name: "/gc/cycles/total:gc-cycles"}}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64()
+}
+
+type monitor struct {
+	interval  time.Duration
+	threshold uint64
+	fraction  float64
+	usage     func() uint64
+	stop      chan bool
+}
+
+// newMonitor returns the monitor of p, nil if no limit applies
+func newMonitor(p Pressure) *monitor {
+	limit := p.Limit
+	if limit == 0 {
+		if l := debug.SetMemoryLimit(-1); l != math.MaxInt64 {
+			limit = uint64(l)
+		}
+	}
+	if limit == 0 {
+		return nil
+	}
+	m := &monitor{
+		interval: defaultPressureInterval,
+		fraction: defaultPressureFraction,
+		usage:    runtimeMemoryUsage,
+		stop:     make(chan bool),
+	}
+	threshold := defaultPressureThreshold
+	if p.Threshold > 0 && p.Threshold <= 1 {
+		threshold = p.Threshold
+	}
+	m.threshold = uint64(float64(limit) * threshold)
+	if p.Fraction > 0 && p.Fraction <= 1 {
+		m.fraction = p.Fraction
+	}
+	if p.Interval > 0 {
+		m.interval = p.Interval
+	}
+	if p.Usage != nil {
+		m.usage = p.Usage
+	}
+	return m
+}
+
+func (m *monitor) run(shed func(float64) int) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	shedding, shedAt := false, uint64(0)
+	for {
+		select {
+		case <-ticker.C:
+			// the memory of the entries shed is only freed by the next GC, which is
+			// left to the runtime: until it has run, usage still counts them
+			if m.usage() > m.threshold && (!shedding || gcCycles() > shedAt) {
+				shed(m.fraction)
+				shedding, shedAt = true, gcCycles()
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Shed evicts fraction (0 to 1) of the entries of every shard, the ones its eviction
+// policy would evict first in caches that are bounded or watch memory pressure, and
+// arbitrary ones otherwise, and returns how many were
+func (c *Cache[V]) Shed(fraction float64) int {
+	if fraction <= 0 {
+		return 0
+	}
+	shed := 0
+	for _, s := range c.shards {
+		now := c.now()
+		s.Lock()
+		n := int(math.Ceil(float64(len(s.items)) * math.Min(fraction, 1)))
+		out := s.shed(n, now)
+		s.Unlock()
+		shed += len(out)
+		c.evicted(out...)
+	}
+	return shed
+}
+
+// shed evicts n items from the shard, picked by its eviction policy if it has one
+func (s *shard[V]) shed(n int, now int64) []evicted[V] {
+	victims := make([]string, 0, n)
+	if s.policy != nil {
+		for len(victims) < n {
+			victim, ok := s.policy.evict()
+			if !ok {
+				break
+			}
+			victims = append(victims, victim)
+		}
+	} else {
+		for k := range s.items {
+			if len(victims) == n {
+				break
+			}
+			victims = append(victims, k)
+		}
+	}
+	out := make([]evicted[V], 0, len(victims))
+	for _, key := range victims {
+		it, found := s.items[key]
+		if !found {
+			continue
+		}
+		s.cost -= it.cost
+		s.bytes -= it.size
+		delete(s.items, key)
+		if it.expired(now) {
+			out = append(out, evicted[V]{key, it.value, ReasonExpired})
+		} else {
+			out = append(out, evicted[V]{key, it.value, ReasonEvicted})
+		}
+	}
+	return out
+}
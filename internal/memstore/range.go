@@ -0,0 +1,108 @@
+package memstore
+
+// Entry is an entry of the cache, as handed to Dump
+type Entry[V any] struct {
+	Key        string
+	Value      V
+	Expiration int64 // unix nano, 0 means the entry never expires
+}
+
+// Range calls f for every unexpired entry in the cache until f returns false.
+// Each shard is copied before f is called, so f may safely call back into the
+// cache (e.g. to Delete the entry it was handed); entries written concurrently
+// may or may not be visited.
+func (c *Cache[V]) Range(f func(key string, value V) bool) {
+	var entries []Entry[V]
+	for _, s := range c.shards {
+		entries = s.unexpired(entries[:0], c.now())
+		for _, e := range entries {
+			if !f(e.Key, e.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Dump calls f with a copy of the unexpired entries of every shard in turn, until it
+// returns an error. Copying a shard at a time keeps locks short and memory bounded.
+func (c *Cache[V]) Dump(f func([]Entry[V]) error) error {
+	for _, s := range c.shards {
+		if err := f(s.unexpired(nil, c.now())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unexpired appends the unexpired entries of the shard to entries
+func (s *shard[V]) unexpired(entries []Entry[V], now int64) []Entry[V] {
+	s.RLock()
+	defer s.RUnlock()
+	for k, it := range s.items {
+		if !it.expired(now) {
+			entries = append(entries, Entry[V]{Key: k, Value: it.value, Expiration: it.expiration})
+		}
+	}
+	return entries
+}
+
+// Restore adds an entry written by Dump, keeping its original expiration time. It's
+// skipped if it expired in the meantime, or if key is already in the cache.
+func (c *Cache[V]) Restore(key string, value V, expiration int64) {
+	now := c.now()
+	it := item[V]{value: value, expiration: expiration}
+	if it.expired(now) {
+		return
+	}
+	s := c.shard(key)
+	var out []evicted[V]
+	s.Lock()
+	if old, found := s.items[key]; !found || old.expired(now) {
+		out = s.set(key, it, now)
+	}
+	s.Unlock()
+	c.evicted(out...)
+}
+
+// Len returns the number of unexpired entries in the cache
+func (c *Cache[V]) Len() int {
+	n := 0
+	now := c.now()
+	for _, s := range c.shards {
+		s.RLock()
+		for _, it := range s.items {
+			if !it.expired(now) {
+				n++
+			}
+		}
+		s.RUnlock()
+	}
+	return n
+}
+
+// DeleteMatching removes every entry whose key match accepts and returns the number
+// of unexpired entries removed
+func (c *Cache[V]) DeleteMatching(match func(key string) bool) int {
+	var deleted []evicted[V]
+	now := c.now()
+	count := 0
+	for _, s := range c.shards {
+		s.Lock()
+		for k, it := range s.items {
+			if !match(k) {
+				continue
+			}
+			s.remove(k)
+			if it.expired(now) {
+				deleted = append(deleted, evicted[V]{k, it.value, ReasonExpired})
+				continue
+			}
+			deleted = append(deleted, evicted[V]{k, it.value, ReasonDeleted})
+			count++
+		}
+		s.Unlock()
+		c.evicted(deleted...)
+		deleted = deleted[:0]
+	}
+	return count
+}
@@ -0,0 +1,110 @@
+package memstore
+
+import (
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+)
+
+// counters holds the counters of a cache, updated atomically
+type counters struct {
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	expirations uint64
+}
+
+func (s *counters) record(reason Reason) {
+	switch reason {
+	case ReasonEvicted:
+		atomic.AddUint64(&s.evictions, 1)
+	case ReasonExpired:
+		atomic.AddUint64(&s.expirations, 1)
+	}
+}
+
+// itemOverhead is the fixed cost of an entry on top of its key and value, the same
+// for every type of value so sizes don't depend on how the cache is instantiated
+var itemOverhead = int64(unsafe.Sizeof(item[interface{}]{}))
+
+// itemSize estimates the memory held by an entry, once when it's written
+func itemSize[V any](key string, value V) int64 {
+	return int64(len(key)) + itemOverhead + approxSize(reflect.ValueOf(value), 0)
+}
+
+// Stats is a point in time view of the cache's statistics. Its fields are those of
+// persistence.InMemoryStats, documented there.
+type Stats struct {
+	Entries     int
+	Hits        uint64
+	Misses      uint64
+	HitRatio    float64
+	Evictions   uint64
+	Expirations uint64
+	ApproxBytes int64
+}
+
+// Stats returns the cache's runtime statistics. They're kept up to date as entries
+// are written and removed, so reading them is cheap enough for frequent scrapes.
+func (c *Cache[V]) Stats() Stats {
+	st := Stats{
+		Hits:        atomic.LoadUint64(&c.stats.hits),
+		Misses:      atomic.LoadUint64(&c.stats.misses),
+		Evictions:   atomic.LoadUint64(&c.stats.evictions),
+		Expirations: atomic.LoadUint64(&c.stats.expirations),
+	}
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRatio = float64(st.Hits) / float64(total)
+	}
+	for _, s := range c.shards {
+		s.RLock()
+		st.Entries += len(s.items)
+		st.ApproxBytes += s.bytes
+		s.RUnlock()
+	}
+	return st
+}
+
+// approxSize estimates the memory held by v, following pointers, slices and maps
+// a few levels deep. Shared references are counted each time they are seen.
+func approxSize(v reflect.Value, depth int) int64 {
+	if !v.IsValid() {
+		return 0
+	}
+	size := int64(v.Type().Size())
+	if depth > 8 {
+		return size
+	}
+	switch v.Kind() {
+	case reflect.String:
+		size += int64(v.Len())
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			size += approxSize(v.Elem(), depth+1)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			size += approxSize(v.Index(i), depth+1)
+		}
+	case reflect.Array:
+		size = 0
+		for i := 0; i < v.Len(); i++ {
+			size += approxSize(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			size += approxSize(iter.Key(), depth+1) + approxSize(iter.Value(), depth+1)
+		}
+	case reflect.Struct:
+		size = 0
+		for i := 0; i < v.NumField(); i++ {
+			size += approxSize(v.Field(i), depth+1)
+		}
+		if size < int64(v.Type().Size()) {
+			// account for padding
+			size = int64(v.Type().Size())
+		}
+	}
+	return size
+}
@@ -0,0 +1,44 @@
+// Package miniredistest starts embedded miniredis servers for tests. It is shared by
+// the tests of the persistence package, which can't import persistence/redistest.
+package miniredistest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// clockTick is how often the server clock is moved forward
+const clockTick = 10 * time.Millisecond
+
+// Run starts a miniredis server closed when the test ends. miniredis only expires
+// keys when told to, so its clock is moved forward with the wall clock for tests
+// sleeping past TTLs to behave like against a real redis.
+func Run(t testing.TB) *miniredis.Miniredis {
+	t.Helper()
+	m := miniredis.RunT(t)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(clockTick)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				m.FastForward(now.Sub(last))
+				last = now
+			case <-done:
+				return
+			}
+		}
+	}()
+	// registered after RunT's, so it runs first and the clock stops before the server
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+	})
+	return m
+}
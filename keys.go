@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+const (
+	optionWithKeyVersion = "optionWithKeyVersion"
+	optionWithKeyHashing = "optionWithKeyHashing"
+)
+
+// WithKeyVersion sets the version of the keys built by a KeyBuilder, to bump when the
+// values cached under them change shape so entries of the old shape aren't read back
+func WithKeyVersion(version int) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithKeyVersion] = version
+	}
+}
+
+// WithKeyHashing makes a KeyBuilder hash the parts of the keys longer than maxLength,
+// or of every key for 0, e.g. to keep them under the key size limit of memcached or
+// to keep the values they're made of out of the store
+func WithKeyHashing(maxLength int) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithKeyHashing] = maxLength
+	}
+}
+
+// KeyPartError is returned by KeyBuilder.Key for a part of a type it can't encode
+type KeyPartError struct {
+	Index int
+	Part  interface{}
+}
+
+func (e *KeyPartError) Error() string {
+	return fmt.Sprintf("cache: key part %d of type %T can't be encoded.", e.Index, e.Part)
+}
+
+// Is makes errors.Is match persistence.ErrInvalidArgument
+func (e *KeyPartError) Is(target error) bool {
+	return target == persistence.ErrInvalidArgument
+}
+
+// KeyBuilder builds the keys of a namespace consistently: "<namespace>:v<version>:" then
+// the parts of the key separated by ':', without the version segment unless
+// WithKeyVersion is given.
+//
+// Parts are encoded canonically, so equal values always give the same key and distinct
+// ones distinct keys: strings (and fmt.Stringer) are escaped so they hold no ':' nor
+// glob pattern characters, integers and floats are written in decimal, booleans as
+// true or false, times as escaped RFC 3339 in UTC and byte slices in base64.
+type KeyBuilder struct {
+	prefix    string
+	hash      bool
+	maxLength int
+}
+
+// NewKeyBuilder returns a KeyBuilder of the keys of namespace
+func NewKeyBuilder(namespace string, opt ...persistence.Option) *KeyBuilder {
+	opts := persistence.GetOpts(opt...)
+	b := &KeyBuilder{prefix: namespace + ":"}
+	if v, ok := opts[optionWithKeyVersion].(int); ok {
+		b.prefix += "v" + strconv.Itoa(v) + ":"
+	}
+	if v, ok := opts[optionWithKeyHashing].(int); ok && v >= 0 {
+		b.hash, b.maxLength = true, v
+	}
+	return b
+}
+
+// Key returns the key made of parts. With WithKeyHashing, the parts are replaced by
+// '#' and the hex SHA-256 of their encoding when the key would be too long.
+func (b *KeyBuilder) Key(parts ...interface{}) (string, error) {
+	var s strings.Builder
+	s.WriteString(b.prefix)
+	for i, part := range parts {
+		if i > 0 {
+			s.WriteByte(':')
+		}
+		if err := encodeKeyPart(&s, part); err != nil {
+			return "", &KeyPartError{Index: i, Part: part}
+		}
+	}
+	key := s.String()
+	if b.hash && (b.maxLength == 0 || len(key) > b.maxLength) {
+		sum := sha256.Sum256([]byte(key[len(b.prefix):]))
+		key = b.prefix + "#" + hex.EncodeToString(sum[:])
+	}
+	return key, nil
+}
+
+// MustKey is Key for parts known to be encodable; it panics otherwise
+func (b *KeyBuilder) MustKey(parts ...interface{}) string {
+	key, err := b.Key(parts...)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Prefix returns what all the keys built start with, e.g. to delete them by pattern
+func (b *KeyBuilder) Prefix() string {
+	return b.prefix
+}
+
+// encodeKeyPart writes the canonical encoding of part, by kind so named types such as
+// type UserID int64 are encoded as their underlying type
+func encodeKeyPart(s *strings.Builder, part interface{}) error {
+	switch v := part.(type) {
+	case time.Time:
+		escapeKeyPart(s, v.UTC().Format(time.RFC3339Nano))
+		return nil
+	case []byte:
+		s.WriteString(base64.RawURLEncoding.EncodeToString(v))
+		return nil
+	case fmt.Stringer:
+		escapeKeyPart(s, v.String())
+		return nil
+	}
+	v := reflect.ValueOf(part)
+	switch v.Kind() {
+	case reflect.String:
+		escapeKeyPart(s, v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.WriteString(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32:
+		s.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, 32))
+	case reflect.Float64:
+		s.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	case reflect.Bool:
+		s.WriteString(strconv.FormatBool(v.Bool()))
+	default:
+		return persistence.ErrInvalidArgument
+	}
+	return nil
+}
+
+// escapeKeyPart percent-encodes every byte of v but letters, digits and "-._~"
+func escapeKeyPart(s *strings.Builder, v string) {
+	const hexDigits = "0123456789ABCDEF"
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			s.WriteByte(c)
+		default:
+			s.WriteByte('%')
+			s.WriteByte(hexDigits[c>>4])
+			s.WriteByte(hexDigits[c&15])
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+type userID int64
+
+func TestKeyBuilder(t *testing.T) {
+	kb := NewKeyBuilder("user")
+	assert.Equal(t, "user:", kb.Prefix())
+	assert.Equal(t, "user:profile:42:en", kb.MustKey("profile", 42, "en"))
+	assert.Equal(t, "user:42:true:1.5", kb.MustKey(userID(42), true, 1.5))
+	// separators and glob characters in parts can't make keys collide
+	assert.NotEqual(t, kb.MustKey("a:b"), kb.MustKey("a", "b"))
+	assert.Equal(t, "user:a%3Ab:%2A%3F", kb.MustKey("a:b", "*?"))
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	assert.Equal(t, "user:2024-01-02T02%3A04%3A05Z:AQI", kb.MustKey(at, []byte{1, 2}))
+
+	_, err := kb.Key("profile", map[string]int{})
+	var partErr *KeyPartError
+	assert.True(t, errors.As(err, &partErr))
+	assert.Equal(t, 1, partErr.Index)
+	assert.True(t, errors.Is(err, persistence.ErrInvalidArgument))
+	assert.Panics(t, func() { kb.MustKey(nil) })
+}
+
+func TestKeyBuilder_Version(t *testing.T) {
+	v1 := NewKeyBuilder("user", WithKeyVersion(1))
+	v2 := NewKeyBuilder("user", WithKeyVersion(2))
+	assert.Equal(t, "user:v1:42", v1.MustKey(42))
+	assert.Equal(t, "user:v2:42", v2.MustKey(42))
+	assert.Equal(t, "user:v2:", v2.Prefix())
+}
+
+func TestKeyBuilder_Hashing(t *testing.T) {
+	kb := NewKeyBuilder("search", WithKeyVersion(1), WithKeyHashing(64))
+	assert.Equal(t, "search:v1:short", kb.MustKey("short"))
+	long := kb.MustKey(strings.Repeat("q", 100))
+	assert.True(t, strings.HasPrefix(long, "search:v1:#"))
+	assert.Len(t, long, len("search:v1:#")+64)
+	assert.Equal(t, long, kb.MustKey(strings.Repeat("q", 100)))
+	assert.NotEqual(t, long, kb.MustKey(strings.Repeat("q", 101)))
+
+	always := NewKeyBuilder("user", WithKeyHashing(0))
+	assert.True(t, strings.HasPrefix(always.MustKey("john@example.com"), "user:#"))
+}
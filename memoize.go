@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"golang.org/x/sync/singleflight"
+)
+
+const optionWithCachedErrors = "optionWithMemoizeCachedErrors"
+
+// cachedErrors is the error result policy set by WithCachedErrors
+type cachedErrors struct {
+	ttl  time.Duration
+	errs []error
+}
+
+// WithCachedErrors makes memoized functions cache the errors matching one of errs
+// (see errors.Is) for ttl, e.g. a not found error, so the function isn't called again
+// for a while. The matching error of errs is returned from the cache. Other errors
+// are never cached.
+func WithCachedErrors(ttl time.Duration, errs ...error) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithCachedErrors] = cachedErrors{ttl: ttl, errs: errs}
+	}
+}
+
+// memoEntry is the cached result of a memoized function. Err is 0 for values, or the
+// index of the error in the cached errors plus 1.
+type memoEntry[R any] struct {
+	Value R
+	Err   int
+}
+
+// MemoizeOf wraps fn so its results are cached in store for ttl, under the key keyFn
+// returns for its argument, and concurrent calls with the same key share a single call
+// of fn. Errors aren't cached unless WithCachedErrors says so. Failing to read or
+// write the store doesn't fail calls: fn is called as if nothing was cached.
+//
+// The results must be serializable by the store, e.g. with gob for RedisStore.
+func MemoizeOf[A, R any](store persistence.CacheStore, ttl time.Duration, keyFn func(A) string, fn func(A) (R, error), opt ...persistence.Option) func(A) (R, error) {
+	opts := persistence.GetOpts(opt...)
+	policy, _ := opts[optionWithCachedErrors].(cachedErrors)
+	var group singleflight.Group
+
+	return func(arg A) (R, error) {
+		key := keyFn(arg)
+		var entry memoEntry[R]
+		if err := store.Get(key, &entry); err == nil {
+			if entry.Err > 0 && entry.Err <= len(policy.errs) {
+				return entry.Value, policy.errs[entry.Err-1]
+			}
+			if entry.Err == 0 {
+				return entry.Value, nil
+			}
+		}
+
+		v, err, _ := group.Do(key, func() (interface{}, error) {
+			value, err := fn(arg)
+			if err == nil {
+				store.Set(key, memoEntry[R]{Value: value}, ttl)
+				return value, nil
+			}
+			for i, e := range policy.errs {
+				if errors.Is(err, e) {
+					store.Set(key, memoEntry[R]{Err: i + 1}, policy.ttl)
+					break
+				}
+			}
+			return value, err
+		})
+		value, _ := v.(R)
+		return value, err
+	}
+}
+
+// Memoize is MemoizeOf for functions of any arguments and result. The results are
+// cached as interface values, so with gob their concrete types must be registered
+// (see gob.Register).
+func Memoize(store persistence.CacheStore, ttl time.Duration, keyFn func(args ...interface{}) string, fn func(args ...interface{}) (interface{}, error), opt ...persistence.Option) func(args ...interface{}) (interface{}, error) {
+	memoized := MemoizeOf(store, ttl,
+		func(args []interface{}) string { return keyFn(args...) },
+		func(args []interface{}) (interface{}, error) { return fn(args...) },
+		opt...)
+	return func(args ...interface{}) (interface{}, error) {
+		return memoized(args)
+	}
+}
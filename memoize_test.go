@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+	"github.com/Bose/cache/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoUser struct {
+	ID   int
+	Name string
+}
+
+var errUserNotFound = errors.New("user not found")
+
+func TestMemoizeOf(t *testing.T) {
+	for name, store := range map[string]persistence.CacheStore{
+		"inmemory": persistence.NewInMemoryStore(time.Minute),
+		"redis":    persistence.NewRedisCache(miniredistest.Run(t).Addr(), "", time.Minute),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			getUser := MemoizeOf(store, time.Minute, func(id int) string { return fmt.Sprint("user:", id) },
+				func(id int) (*memoUser, error) {
+					atomic.AddInt32(&calls, 1)
+					switch id {
+					case 0:
+						return nil, errUserNotFound
+					case -1:
+						return nil, errors.New("db down")
+					}
+					return &memoUser{ID: id, Name: "ada"}, nil
+				}, WithCachedErrors(time.Minute, errUserNotFound))
+
+			for i := 0; i < 2; i++ {
+				u, err := getUser(1)
+				assert.NoError(t, err)
+				assert.Equal(t, &memoUser{ID: 1, Name: "ada"}, u)
+			}
+			assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+			// errors are cached only if the policy says so
+			for i := 0; i < 2; i++ {
+				_, err := getUser(0)
+				assert.Equal(t, errUserNotFound, err)
+				_, err = getUser(-1)
+				assert.EqualError(t, err, "db down")
+			}
+			assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestMemoizeOf_Singleflight(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	square := MemoizeOf(persistence.NewInMemoryStore(time.Minute), time.Minute, func(n int) string { return fmt.Sprint(n) },
+		func(n int) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return n * n, nil
+		})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := square(3)
+			assert.NoError(t, err)
+			assert.Equal(t, 9, v)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestMemoize(t *testing.T) {
+	var calls int32
+	add := Memoize(persistence.NewInMemoryStore(time.Minute), time.Minute,
+		func(args ...interface{}) string { return fmt.Sprint(args...) },
+		func(args ...interface{}) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return args[0].(int) + args[1].(int), nil
+		})
+	for i := 0; i < 2; i++ {
+		v, err := add(1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, v)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/gin-gonic/gin"
+)
+
+// ginWriter tees the response a gin handler writes into a buffer
+type ginWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+	ttl  time.Duration
+}
+
+var _ gin.ResponseWriter = &ginWriter{}
+
+func (w *ginWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.body.Write(b[:n])
+	return n, err
+}
+
+func (w *ginWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.body.WriteString(s[:n])
+	return n, err
+}
+
+func (w *ginWriter) setTTL(ttl time.Duration) {
+	w.ttl = ttl
+}
+
+// CachePage returns a gin handler serving the response handler renders from store,
+// where it's cached for ttl unless the handler sets another with SetTTL. Responses of
+// aborted contexts and responses personal to a client (see the package documentation)
+// aren't cached, and failing to read or write the store doesn't fail
+// requests: the response is rendered as if it wasn't cached.
+func CachePage(store persistence.CacheStore, ttl time.Duration, handler gin.HandlerFunc, opt ...persistence.Option) gin.HandlerFunc {
+	cfg := newConfig(opt)
+	return func(c *gin.Context) {
+		if !cacheable(c.Request) {
+			handler(c)
+			return
+		}
+		key := cfg.key(c.Request)
+		var cached response
+		if err := store.Get(key, &cached); err == nil {
+			cached.writeTo(c.Writer)
+			return
+		}
+
+		w := &ginWriter{ResponseWriter: c.Writer, ttl: ttl}
+		c.Writer = w
+		handler(c)
+		c.Writer = w.ResponseWriter
+		if c.IsAborted() || w.ttl == noCache || !successful(w.Status()) || !cfg.shared(c.Request, w.Header()) {
+			return
+		}
+		store.Set(key, response{Status: w.Status(), Header: w.Header().Clone(), Body: w.body.Bytes()}, w.ttl)
+	}
+}
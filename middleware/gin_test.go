@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func performRequest(handler http.Handler, method, target string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// counter renders the number of times it was called
+func counter() gin.HandlerFunc {
+	calls := 0
+	return func(c *gin.Context) {
+		calls++
+		c.Header("X-Calls", fmt.Sprint(calls))
+		c.String(http.StatusOK, "calls %d", calls)
+	}
+}
+
+func TestCachePage(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	router := gin.New()
+	router.GET("/page", CachePage(store, time.Minute, counter()))
+
+	w1 := performRequest(router, "GET", "/page")
+	w2 := performRequest(router, "GET", "/page")
+	assert.Equal(t, http.StatusOK, w2.Code)
+	assert.Equal(t, "calls 1", w1.Body.String())
+	assert.Equal(t, "calls 1", w2.Body.String())
+	assert.Equal(t, "1", w2.Header().Get("X-Calls"))
+	assert.Equal(t, "calls 2", performRequest(router, "GET", "/page?q=1").Body.String())
+
+	assert.NoError(t, Invalidate(store, httptest.NewRequest("GET", "/page", nil)))
+	assert.Equal(t, "calls 3", performRequest(router, "GET", "/page").Body.String())
+}
+
+func TestCachePage_Vary(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	router := gin.New()
+	router.GET("/page", CachePage(store, time.Minute, counter(), WithVary("accept-language"), WithoutQuery()))
+
+	assert.Equal(t, "calls 1", performRequest(router, "GET", "/page", "Accept-Language", "en").Body.String())
+	assert.Equal(t, "calls 2", performRequest(router, "GET", "/page", "Accept-Language", "fr").Body.String())
+	assert.Equal(t, "calls 1", performRequest(router, "GET", "/page?q=1", "Accept-Language", "en").Body.String())
+
+	n, err := InvalidatePath(store, "/page", WithVary("accept-language"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "calls 3", performRequest(router, "GET", "/page", "Accept-Language", "en").Body.String())
+}
+
+func TestCachePage_Personal(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	router := gin.New()
+	calls := 0
+	router.GET("/login", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.SetCookie("session", fmt.Sprint(calls), 0, "/", "", false, true)
+		c.String(http.StatusOK, "calls %d", calls)
+	}))
+	router.GET("/me", CachePage(store, time.Minute, counter()))
+
+	performRequest(router, "GET", "/login")
+	w := performRequest(router, "GET", "/login")
+	assert.Equal(t, "calls 2", w.Body.String())
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "session=2")
+
+	assert.Equal(t, "calls 1", performRequest(router, "GET", "/me", "Authorization", "Bearer 1").Body.String())
+	assert.Equal(t, "calls 2", performRequest(router, "GET", "/me", "Authorization", "Bearer 2").Body.String())
+}
+
+func TestCachePage_TTL(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	router := gin.New()
+	calls := 0
+	router.GET("/page/:id", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		switch c.Param("id") {
+		case "short":
+			SetTTL(c.Writer, time.Second)
+		case "private":
+			NoCache(c.Writer)
+		case "missing":
+			c.String(http.StatusNotFound, "not found")
+			return
+		}
+		c.String(http.StatusOK, "calls %d", calls)
+	}))
+
+	for _, id := range []string{"short", "private", "missing"} {
+		performRequest(router, "GET", "/page/"+id)
+	}
+	ttl, err := store.GetExpiresIn(Key(httptest.NewRequest("GET", "/page/short", nil)))
+	assert.NoError(t, err)
+	assert.True(t, ttl <= time.Second, "expected the TTL set by the handler, got %s", ttl)
+	for _, id := range []string{"private", "missing"} {
+		var v response
+		assert.Equal(t, persistence.ErrCacheMiss, store.Get(Key(httptest.NewRequest("GET", "/page/"+id, nil)), &v), id)
+	}
+
+	// only GET and HEAD responses are cached
+	router.POST("/post", CachePage(store, time.Minute, counter()))
+	performRequest(router, "POST", "/post")
+	assert.Equal(t, "calls 2", performRequest(router, "POST", "/post").Body.String())
+}
+
+func TestKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "/a:b/c*?x=*", nil)
+	assert.Equal(t, DefaultKeyPrefix+":GET:%2Fa%3Ab%2Fc%2A?x%3D%2A", Key(r))
+	r.URL.Path = "/" + strings.Repeat("p", 300)
+	assert.True(t, len(Key(r)) < maxKeyLength, "expected long paths to be hashed")
+}
@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+const optionWithStaleWhileRevalidate = "optionWithMiddlewareStaleWhileRevalidate"
+
+// WithStaleWhileRevalidate makes Handler serve responses for up to d after they
+// expired while it renders them again in the background, so clients don't wait for
+// it. Responses can set their own window with the stale-while-revalidate directive
+// of Cache-Control.
+func WithStaleWhileRevalidate(d time.Duration) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithStaleWhileRevalidate] = d
+	}
+}
+
+// recorder buffers a response, teeing it to the client's response writer if set
+type recorder struct {
+	w      http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+	ttl    time.Duration
+}
+
+func (r *recorder) Header() http.Header {
+	if r.w != nil {
+		return r.w.Header()
+	}
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	if r.w != nil {
+		r.w.WriteHeader(status)
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n := len(b)
+	var err error
+	if r.w != nil {
+		n, err = r.w.Write(b)
+	}
+	r.body.Write(b[:n])
+	return n, err
+}
+
+func (r *recorder) setTTL(ttl time.Duration) {
+	r.ttl = ttl
+}
+
+// cacheControl returns the directives of the Cache-Control header, lower cased, with
+// their value if any
+func cacheControl(h http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// seconds returns the duration of a directive in seconds
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	v, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// etag returns the strong ETag of body
+func etag(body []byte) string {
+	sum := sha1.Sum(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether the If-None-Match header of r matches etag
+func notModified(r *http.Request, etag string) bool {
+	for _, v := range r.Header.Values("If-None-Match") {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// httpCache is the state of a Handler
+type httpCache struct {
+	config
+	store persistence.CacheStore
+	next  http.Handler
+	ttl   time.Duration
+	swr   time.Duration
+	// refreshing holds the keys being rendered again in the background
+	refreshing sync.Map
+}
+
+// Handler returns an http.Handler serving the responses next renders from store,
+// where they are cached for ttl unless they set another with the max-age or s-maxage
+// directives of Cache-Control, or next sets one with SetTTL.
+//
+// Responses marked no-store or private aren't cached, nor are responses personal to a
+// client (see the package documentation), and requests marked no-store
+// or no-cache are rendered by next, the latter refreshing the cached response. Cached
+// responses are given an ETag if they have none, and requests whose If-None-Match
+// matches it are answered with 304 Not Modified. Failing to read or write the store
+// doesn't fail requests: the response is rendered as if it wasn't cached.
+func Handler(store persistence.CacheStore, ttl time.Duration, next http.Handler, opt ...persistence.Option) http.Handler {
+	h := &httpCache{config: newConfig(opt), store: store, next: next, ttl: ttl}
+	h.swr, _ = persistence.GetOpts(opt...)[optionWithStaleWhileRevalidate].(time.Duration)
+	return h
+}
+
+func (h *httpCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := cacheControl(r.Header)
+	if _, noStore := request["no-store"]; noStore || !cacheable(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	key := h.key(r)
+	if _, noCache := request["no-cache"]; !noCache {
+		var cached response
+		if err := h.store.Get(key, &cached); err == nil {
+			if cached.Expires != 0 && time.Now().UnixNano() >= cached.Expires {
+				h.revalidate(key, r)
+			}
+			h.serve(w, r, &cached)
+			return
+		}
+	}
+
+	rec := &recorder{w: w, ttl: h.ttl}
+	h.next.ServeHTTP(rec, r)
+	h.save(key, r, rec)
+}
+
+// serve writes the cached response, or 304 if the client has it already
+func (h *httpCache) serve(w http.ResponseWriter, r *http.Request, cached *response) {
+	if tag := cached.Header.Get("ETag"); tag != "" && notModified(r, tag) {
+		header := w.Header()
+		for _, name := range []string{"ETag", "Cache-Control", "Vary", "Expires", "Last-Modified"} {
+			if v, ok := cached.Header[http.CanonicalHeaderKey(name)]; ok {
+				header[http.CanonicalHeaderKey(name)] = v
+			}
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	cached.writeTo(w)
+}
+
+// save caches the recorded response to r if it can be
+func (h *httpCache) save(key string, r *http.Request, rec *recorder) {
+	header := rec.Header()
+	directives := cacheControl(header)
+	_, noStore := directives["no-store"]
+	_, private := directives["private"]
+	if rec.status == 0 {
+		// nothing was written
+		rec.status = http.StatusOK
+	}
+	if rec.ttl == noCache || noStore || private || !successful(rec.status) || !h.shared(r, header) {
+		return
+	}
+	ttl := rec.ttl
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if d, ok := seconds(directives, name); ok {
+			if d == 0 {
+				return
+			}
+			ttl = d
+			break
+		}
+	}
+	swr := h.swr
+	if d, ok := seconds(directives, "stale-while-revalidate"); ok {
+		swr = d
+	}
+
+	cached := response{Status: rec.status, Header: header.Clone(), Body: rec.body.Bytes()}
+	if cached.Header.Get("ETag") == "" {
+		cached.Header.Set("ETag", etag(cached.Body))
+	}
+	// responses cached with the store's default TTL or forever can't go stale
+	if swr > 0 && ttl > 0 {
+		cached.Expires = time.Now().Add(ttl).UnixNano()
+		ttl += swr
+	}
+	h.store.Set(key, cached, ttl)
+}
+
+// revalidate renders the response to r again in the background, unless it's already
+// being rendered
+func (h *httpCache) revalidate(key string, r *http.Request) {
+	if _, loaded := h.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	r = r.Clone(context.Background())
+	go func() {
+		defer h.refreshing.Delete(key)
+		rec := &recorder{header: http.Header{}, ttl: h.ttl}
+		h.next.ServeHTTP(rec, r)
+		h.save(key, r, rec)
+	}()
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingHandler renders the number of times it was called, with the Cache-Control
+// header given
+func countingHandler(cacheControl string) (http.Handler, *int32) {
+	var calls int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		fmt.Fprintf(w, "calls %d", n)
+	}), &calls
+}
+
+func TestHandler(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	next, _ := countingHandler("")
+	h := Handler(store, time.Minute, next)
+
+	assert.Equal(t, "calls 1", performRequest(h, "GET", "/page").Body.String())
+	w := performRequest(h, "GET", "/page")
+	assert.Equal(t, "calls 1", w.Body.String())
+	tag := w.Header().Get("ETag")
+	assert.NotEmpty(t, tag)
+
+	w = performRequest(h, "GET", "/page", "If-None-Match", tag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, tag, w.Header().Get("ETag"))
+	assert.Equal(t, http.StatusOK, performRequest(h, "GET", "/page", "If-None-Match", `"other"`).Code)
+
+	// no-cache refreshes the cached response, no-store bypasses the cache
+	assert.Equal(t, "calls 2", performRequest(h, "GET", "/page", "Cache-Control", "no-cache").Body.String())
+	assert.Equal(t, "calls 2", performRequest(h, "GET", "/page").Body.String())
+	assert.Equal(t, "calls 3", performRequest(h, "GET", "/page", "Cache-Control", "no-store").Body.String())
+	assert.Equal(t, "calls 2", performRequest(h, "GET", "/page").Body.String())
+}
+
+func TestHandler_ResponseCacheControl(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	for _, directive := range []string{"no-store", "private, max-age=60", "max-age=0"} {
+		next, calls := countingHandler(directive)
+		h := Handler(store, time.Minute, next)
+		performRequest(h, "GET", "/page")
+		performRequest(h, "GET", "/page")
+		assert.Equal(t, int32(2), atomic.LoadInt32(calls), directive)
+	}
+
+	next, _ := countingHandler("public, max-age=5")
+	performRequest(Handler(store, time.Minute, next), "GET", "/page")
+	ttl, err := store.GetExpiresIn(Key(httptest.NewRequest("GET", "/page", nil)))
+	assert.NoError(t, err)
+	assert.True(t, ttl <= 5*time.Second, "expected max-age to set the TTL, got %s", ttl)
+}
+
+func TestHandler_Personal(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	handler := func(header ...string) (http.Handler, *int32) {
+		var calls int32
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i+1 < len(header); i += 2 {
+				w.Header().Add(header[i], header[i+1])
+			}
+			fmt.Fprintf(w, "calls %d", atomic.AddInt32(&calls, 1))
+		}), &calls
+	}
+	cases := []struct {
+		name    string
+		header  []string
+		request []string
+		cached  bool
+	}{
+		{"cookie", []string{"Set-Cookie", "session=1"}, nil, false},
+		{"authorization", nil, []string{"Authorization", "Bearer 1"}, false},
+		{"public authorization", []string{"Cache-Control", "public"}, []string{"Authorization", "Bearer 1"}, true},
+		{"vary", []string{"Vary", "Accept-Encoding, Cookie"}, nil, false},
+		{"vary any", []string{"Vary", "*"}, nil, false},
+		{"vary covered", []string{"Vary", "accept-language"}, nil, true},
+	}
+	for _, c := range cases {
+		next, calls := handler(c.header...)
+		h := Handler(store, time.Minute, next, WithVary("Accept-Language"), WithKeyPrefix(c.name))
+		performRequest(h, "GET", "/page", c.request...)
+		performRequest(h, "GET", "/page", c.request...)
+		assert.Equal(t, c.cached, atomic.LoadInt32(calls) == 1, c.name)
+	}
+}
+
+func TestHandler_StaleWhileRevalidate(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	next, calls := countingHandler("max-age=1")
+	h := Handler(store, time.Minute, next, WithStaleWhileRevalidate(time.Minute))
+
+	assert.Equal(t, "calls 1", performRequest(h, "GET", "/page").Body.String())
+	time.Sleep(1100 * time.Millisecond)
+	// the stale response is served while it's rendered again
+	assert.Equal(t, "calls 1", performRequest(h, "GET", "/page").Body.String())
+	assert.Eventually(t, func() bool {
+		return performRequest(h, "GET", "/page").Body.String() == "calls 2"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+}
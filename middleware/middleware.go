@@ -0,0 +1,282 @@
+// Package middleware caches rendered HTTP responses in a persistence.CacheStore, with
+// CachePage for gin handlers and Handler for any http.Handler.
+//
+// Responses are keyed by method, path, query and the values of the request headers
+// they vary by (see WithVary), and only successful (2xx) responses to GET and HEAD
+// requests are cached. Responses that are personal to a client aren't: those setting
+// cookies, answering requests with an Authorization header, or whose Vary header names
+// request headers the keys don't include. Handlers can pick the TTL of their response with SetTTL, or
+// keep it from being cached with NoCache; cached responses are dropped with Invalidate
+// and InvalidatePath.
+package middleware
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+// DefaultKeyPrefix prefixes the keys of cached responses unless WithKeyPrefix is given
+const DefaultKeyPrefix = "middleware.page"
+
+// maxKeyLength is the length above which the path and query of keys are hashed
+const maxKeyLength = 200
+
+// cachedMethods are the methods whose responses are cached
+var cachedMethods = []string{http.MethodGet, http.MethodHead}
+
+const optionWithVary = "optionWithMiddlewareVary"
+
+// WithVary adds the request headers whose values key cached responses, e.g.
+// "Accept-Encoding", so each variant of a page is cached separately
+func WithVary(headers ...string) persistence.Option {
+	return func(o persistence.Options) {
+		prev, _ := o[optionWithVary].([]string)
+		vary := append([]string{}, prev...)
+		for _, h := range headers {
+			vary = append(vary, http.CanonicalHeaderKey(h))
+		}
+		o[optionWithVary] = vary
+	}
+}
+
+const optionWithoutQuery = "optionWithMiddlewareWithoutQuery"
+
+// WithoutQuery leaves the query out of the keys, so every query of a path is served
+// the same cached response
+func WithoutQuery() persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithoutQuery] = true
+	}
+}
+
+const optionWithKeyPrefix = "optionWithMiddlewareKeyPrefix"
+
+// WithKeyPrefix sets the prefix of the keys of cached responses (DefaultKeyPrefix by
+// default)
+func WithKeyPrefix(prefix string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithKeyPrefix] = prefix
+	}
+}
+
+// config is the configuration the options describe
+type config struct {
+	prefix       string
+	vary         []string
+	withoutQuery bool
+}
+
+func newConfig(opt []persistence.Option) config {
+	opts := persistence.GetOpts(opt...)
+	cfg := config{prefix: DefaultKeyPrefix}
+	if v, ok := opts[optionWithKeyPrefix].(string); ok {
+		cfg.prefix = v
+	}
+	cfg.vary, _ = opts[optionWithVary].([]string)
+	cfg.withoutQuery, _ = opts[optionWithoutQuery].(bool)
+	return cfg
+}
+
+// response is a cached response
+type response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Expires is when the response goes stale (unix nano) if it's served stale for a
+	// while, 0 otherwise
+	Expires int64
+}
+
+// cacheable reports whether the response to r can be cached
+func cacheable(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// shared reports whether the response to r, with header, can be served to other
+// clients than the one it was rendered for. It can't when it sets cookies, varies by
+// request headers that aren't among the vary headers of its key (see WithVary), or
+// answers a request with credentials, unless the Authorization header is among the
+// vary headers or the response is marked shareable with the public, s-maxage or
+// must-revalidate directives of Cache-Control, as shared HTTP caches do.
+func (cfg config) shared(r *http.Request, header http.Header) bool {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !cfg.varies(name) {
+				return false
+			}
+		}
+	}
+	if r.Header.Get("Authorization") == "" || cfg.varies("Authorization") {
+		return true
+	}
+	directives := cacheControl(header)
+	for _, name := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := directives[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// varies reports whether the request header name is among the vary headers, which "*"
+// never is
+func (cfg config) varies(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, v := range cfg.vary {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pathKey returns the part of the keys identifying path, escaped so it holds no glob
+// pattern characters and no separator of the key
+func pathKey(path string) string {
+	return strings.ReplaceAll(url.PathEscape(path), ":", "%3A")
+}
+
+// truncatePath truncates the paths of keys too long to be kept as is
+func truncatePath(p string) string {
+	if len(p) > maxKeyLength/2 {
+		return p[:maxKeyLength/2]
+	}
+	return p
+}
+
+// key returns the key of the cached response to r: the prefix, method and path, then
+// the query after a '?' and the hashed values of the vary headers after a '#'
+func (cfg config) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(cfg.prefix)
+	b.WriteByte(':')
+	b.WriteString(r.Method)
+	b.WriteByte(':')
+	rest := pathKey(r.URL.Path)
+	if q := r.URL.RawQuery; q != "" && !cfg.withoutQuery {
+		rest += "?" + url.QueryEscape(q)
+	}
+	if len(rest) > maxKeyLength {
+		sum := sha1.Sum([]byte(rest))
+		rest = truncatePath(pathKey(r.URL.Path)) + "?" + hex.EncodeToString(sum[:])
+	}
+	b.WriteString(rest)
+	if len(cfg.vary) > 0 {
+		h := sha1.New()
+		for _, name := range cfg.vary {
+			h.Write([]byte(name))
+			h.Write([]byte{0})
+			for _, v := range r.Header[name] {
+				h.Write([]byte(v))
+				h.Write([]byte{0})
+			}
+		}
+		b.WriteByte('#')
+		b.WriteString(hex.EncodeToString(h.Sum(nil)))
+	}
+	return b.String()
+}
+
+// Key returns the key the response to r is cached under with the options given
+func Key(r *http.Request, opt ...persistence.Option) string {
+	return newConfig(opt).key(r)
+}
+
+// Invalidate drops the cached response r would be served, e.g. after the resource it
+// represents changed
+func Invalidate(store persistence.CacheStore, r *http.Request, opt ...persistence.Option) error {
+	err := store.Delete(Key(r, opt...))
+	if err == persistence.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+// patternDeleter is implemented by the stores able to delete keys by pattern, such as
+// persistence.RedisStore and persistence.InMemoryStore
+type patternDeleter interface {
+	DeleteByPattern(pattern string) (int, error)
+}
+
+// InvalidatePath drops every cached response of path, whatever its method, query and
+// variant, and returns how many were. It returns persistence.ErrNotSupport if store
+// can't delete keys by pattern.
+func InvalidatePath(store persistence.CacheStore, path string, opt ...persistence.Option) (int, error) {
+	pd, ok := store.(patternDeleter)
+	if !ok {
+		return 0, persistence.ErrNotSupport
+	}
+	cfg := newConfig(opt)
+	// paths too long to be kept in keys as is are truncated, and can't be told apart
+	p := truncatePath(pathKey(path))
+	count := 0
+	for _, method := range cachedMethods {
+		base := escapeGlob(cfg.prefix) + ":" + method + ":" + p
+		for _, pattern := range []string{base, base + "[?#]*"} {
+			n, err := pd.DeleteByPattern(pattern)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, nil
+}
+
+// escapeGlob escapes the glob pattern characters of s
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ttlSetter is implemented by the response writers of the middleware
+type ttlSetter interface {
+	setTTL(ttl time.Duration)
+}
+
+// SetTTL sets how long the response written to w is cached, overriding the TTL of
+// the route. w is the response writer the handler was given, c.Writer with gin.
+func SetTTL(w http.ResponseWriter, ttl time.Duration) {
+	if s, ok := w.(ttlSetter); ok {
+		s.setTTL(ttl)
+	}
+}
+
+// NoCache keeps the response written to w from being cached
+func NoCache(w http.ResponseWriter) {
+	SetTTL(w, noCache)
+}
+
+// noCache is the TTL set by NoCache
+const noCache = time.Duration(-2)
+
+// writeTo serves the cached response to w
+func (r *response) writeTo(w http.ResponseWriter) {
+	header := w.Header()
+	for k, v := range r.Header {
+		header[k] = v
+	}
+	w.WriteHeader(r.Status)
+	w.Write(r.Body)
+}
+
+// successful reports whether responses with status are cached
+func successful(status int) bool {
+	return status >= 200 && status < 300
+}
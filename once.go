@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+// DoOncePer calls fn unless it was called for key in the last interval by any process
+// sharing store, which must implement Add atomically across them, as RedisStore does
+// with SET NX EX. It reports whether fn was called, with its error. When fn fails,
+// the key is released so the next call tries again.
+//
+// Stores expire entries to the second, so interval must be at least a second.
+func DoOncePer(store persistence.CacheStore, key string, interval time.Duration, fn func() error) (bool, error) {
+	if interval < time.Second {
+		return false, persistence.ErrInvalidArgument
+	}
+	if err := store.Add(key, time.Now().Unix(), interval); err != nil {
+		if err == persistence.ErrNotStored {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := fn(); err != nil {
+		store.Delete(key)
+		return true, err
+	}
+	return true, nil
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+	"github.com/Bose/cache/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoOncePer(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := persistence.NewRedisCache(server.Addr(), "", time.Minute)
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			DoOncePer(store, "email:42", time.Minute, func() error {
+				atomic.AddInt32(&calls, 1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, time.Minute, server.TTL("email:42"))
+
+	server.FastForward(time.Minute)
+	ran, err := DoOncePer(store, "email:42", time.Minute, func() error { return nil })
+	assert.NoError(t, err)
+	assert.True(t, ran)
+
+	// failures release the key
+	ran, err = DoOncePer(store, "sync", time.Minute, func() error { return errors.New("failed") })
+	assert.True(t, ran)
+	assert.EqualError(t, err, "failed")
+	ran, _ = DoOncePer(store, "sync", time.Minute, func() error { return nil })
+	assert.True(t, ran)
+
+	_, err = DoOncePer(store, "fast", time.Millisecond, func() error { return nil })
+	assert.Equal(t, persistence.ErrInvalidArgument, err)
+}
@@ -0,0 +1,262 @@
+// Package badger provides a CacheStore on top of BadgerDB, an embedded LSM
+// store that uses its native per-entry TTL for expiration. It suits edge
+// deployments with datasets too large for memory where a remote Redis adds
+// more latency than a local SSD.
+package badger
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/dgraph-io/badger"
+)
+
+const optionWithGCInterval = "optionWithBadgerGCInterval"
+
+// WithGCInterval sets how often the value log garbage collection runs (the
+// default is every 5 minutes, <= 0 disables it)
+func WithGCInterval(d time.Duration) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithGCInterval] = d
+	}
+}
+
+// maxConflictRetries bounds the retries of read-modify-write transactions
+const maxConflictRetries = 10
+
+// Store represents the cache with badger persistence
+type Store struct {
+	db                *badger.DB
+	defaultExpiration time.Duration
+	stop              chan struct{}
+	closeOnce         sync.Once
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// NewStore opens (or creates) a badger database in dir and returns a Store
+func NewStore(dir string, defaultExpiration time.Duration, opt ...persistence.Option) (*Store, error) {
+	return NewStoreWithOptions(badger.DefaultOptions(dir).WithLogger(nil), defaultExpiration, opt...)
+}
+
+// NewStoreWithOptions returns a Store using the provided badger options
+func NewStoreWithOptions(badgerOpts badger.Options, defaultExpiration time.Duration, opt ...persistence.Option) (*Store, error) {
+	opts := persistence.GetOpts(opt...)
+	db, err := badger.Open(badgerOpts)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db, defaultExpiration: defaultExpiration, stop: make(chan struct{})}
+	interval := 5 * time.Minute
+	if v, ok := opts[optionWithGCInterval].(time.Duration); ok {
+		interval = v
+	}
+	if interval > 0 {
+		go s.gc(interval)
+	}
+	return s, nil
+}
+
+// Close stops the value log garbage collection and closes the database
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	return s.db.Close()
+}
+
+func (s *Store) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// keep collecting until there is nothing left worth rewriting
+			for s.db.RunValueLogGC(0.5) == nil {
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Store) entry(key string, b []byte, expires time.Duration) *badger.Entry {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		expires = 0
+	}
+	e := badger.NewEntry([]byte(key), b)
+	if expires > 0 {
+		e = e.WithTTL(expires)
+	}
+	return e
+}
+
+// update runs fn in a read-write transaction, retrying on conflicts
+func (s *Store) update(fn func(txn *badger.Txn) error) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		if err = s.db.Update(fn); err != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+func convertBadgerError(err error) error {
+	switch err {
+	case badger.ErrKeyNotFound:
+		return persistence.ErrCacheMiss
+	case badger.ErrTxnTooBig, badger.ErrEmptyKey:
+		return persistence.ErrNotStored
+	}
+	return err
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, value interface{}) error {
+	var b []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		b, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return convertBadgerError(err)
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	return convertBadgerError(s.update(func(txn *badger.Txn) error {
+		return txn.SetEntry(s.entry(key, b, expires))
+	}))
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	return s.write(key, value, expires, false)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.write(key, value, expires, true)
+}
+
+// write stores the value if the key's existence matches exists. Badger's
+// optimistic transactions make the check and the write atomic.
+func (s *Store) write(key string, value interface{}, expires time.Duration, exists bool) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	return convertBadgerError(s.update(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(key))
+		switch {
+		case err == badger.ErrKeyNotFound:
+			if exists {
+				return persistence.ErrNotStored
+			}
+		case err != nil:
+			return err
+		case !exists:
+			return persistence.ErrNotStored
+		}
+		return txn.SetEntry(s.entry(key, b, expires))
+	}))
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	return convertBadgerError(s.update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(key)); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(key))
+	}))
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(cur uint64) uint64 { return cur + delta })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(key, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// add applies op to the integer stored under key, keeping its expiration
+func (s *Store) add(key string, op func(uint64) uint64) (uint64, error) {
+	var result uint64
+	err := s.update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		cur, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			return err
+		}
+		result = op(cur)
+		e := badger.NewEntry([]byte(key), []byte(strconv.FormatUint(result, 10)))
+		// ExpiresAt is in unix seconds, 0 when the entry never expires
+		e.ExpiresAt = item.ExpiresAt()
+		return txn.SetEntry(e)
+	})
+	if err != nil {
+		return 0, convertBadgerError(err)
+	}
+	return result, nil
+}
+
+// GetExpiresIn (see CacheStore interface). badger keeps expirations to the second.
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	var expiresAt uint64
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		expiresAt = item.ExpiresAt()
+		return nil
+	})
+	if err != nil {
+		return 0, convertBadgerError(err)
+	}
+	if expiresAt == 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Until(time.Unix(int64(expiresAt), 0)), nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	return s.db.DropAll()
+}
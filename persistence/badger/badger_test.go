@@ -0,0 +1,81 @@
+package badger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+func newStore(t *testing.T, defaultExpiration time.Duration) *Store {
+	s, err := NewStore(t.TempDir(), defaultExpiration, WithGCInterval(0))
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBadger_TypicalGetSet(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if err := s.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := s.Get("value", &value); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if value != "foo" {
+		t.Errorf("Expected to get foo back, got %s", value)
+	}
+}
+
+func TestBadger_IncrDecr(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("int", 10, persistence.DEFAULT)
+	if n, err := s.Increment("int", 50); err != nil || n != 60 {
+		t.Errorf("Expected 60, was %d (%v)", n, err)
+	}
+	if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+		t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+	}
+}
+
+func TestBadger_AddReplaceDelete(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Replace("key", 3, persistence.DEFAULT); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestBadger_Expiration(t *testing.T) {
+	s := newStore(t, time.Second)
+	s.Set("int", 10, persistence.DEFAULT)
+	s.Set("forever", 10, persistence.FOREVER)
+	time.Sleep(2 * time.Second)
+	var v int
+	if err := s.Get("int", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := s.Get("forever", &v); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+}
@@ -0,0 +1,216 @@
+// Package bench measures the throughput and allocations of cache stores and codecs
+// under a configurable load, so performance regressions across releases are visible.
+// Run drives a store from many goroutines, e.g. to load test a redis deployment:
+//
+//	res, err := bench.Run(store, bench.WithConcurrency(64), bench.WithValueSize(4096))
+//	fmt.Println(res)
+//
+// The package's benchmarks (go test -bench . ./persistence/bench) cover the stores
+// running in process and the codecs.
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+)
+
+const (
+	optionWithConcurrency = "optionWithBenchConcurrency"
+	optionWithValueSize   = "optionWithBenchValueSize"
+	optionWithKeys        = "optionWithBenchKeys"
+	optionWithReadRatio   = "optionWithBenchReadRatio"
+	optionWithDuration    = "optionWithBenchDuration"
+)
+
+// WithConcurrency sets how many goroutines Run drives the store from (defaults to
+// GOMAXPROCS)
+func WithConcurrency(n int) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithConcurrency] = n
+	}
+}
+
+// WithValueSize sets the size in bytes of the values Run writes (defaults to 128)
+func WithValueSize(n int) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithValueSize] = n
+	}
+}
+
+// WithKeys sets how many distinct keys Run spreads the load over (defaults to 10000)
+func WithKeys(n int) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithKeys] = n
+	}
+}
+
+// WithReadRatio sets the fraction of the operations of Run that are Gets, the rest
+// being Sets (defaults to 0.9)
+func WithReadRatio(r float64) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithReadRatio] = r
+	}
+}
+
+// WithDuration sets how long Run lasts (defaults to 10s)
+func WithDuration(d time.Duration) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithDuration] = d
+	}
+}
+
+// config is the load Run generates
+type config struct {
+	concurrency int
+	valueSize   int
+	keys        int
+	readRatio   float64
+	duration    time.Duration
+}
+
+func newConfig(opt ...persistence.Option) config {
+	opts := persistence.GetOpts(opt...)
+	c := config{
+		concurrency: runtime.GOMAXPROCS(0),
+		valueSize:   128,
+		keys:        10000,
+		readRatio:   0.9,
+		duration:    10 * time.Second,
+	}
+	if v, ok := opts[optionWithConcurrency].(int); ok && v > 0 {
+		c.concurrency = v
+	}
+	if v, ok := opts[optionWithValueSize].(int); ok && v >= 0 {
+		c.valueSize = v
+	}
+	if v, ok := opts[optionWithKeys].(int); ok && v > 0 {
+		c.keys = v
+	}
+	if v, ok := opts[optionWithReadRatio].(float64); ok && v >= 0 && v <= 1 {
+		c.readRatio = v
+	}
+	if v, ok := opts[optionWithDuration].(time.Duration); ok && v > 0 {
+		c.duration = v
+	}
+	return c
+}
+
+// Result is the outcome of a Run
+type Result struct {
+	Ops      int64
+	Gets     int64
+	Misses   int64
+	Errors   int64
+	Duration time.Duration
+	// AllocsPerOp and BytesPerOp are measured for the whole process, so they include
+	// whatever else it allocates meanwhile
+	AllocsPerOp float64
+	BytesPerOp  float64
+}
+
+// OpsPerSec is the throughput of the run
+func (r Result) OpsPerSec() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Duration.Seconds()
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%d ops in %v (%.0f ops/s, %.1f allocs/op, %.0f B/op), %d misses, %d errors",
+		r.Ops, r.Duration.Round(time.Millisecond), r.OpsPerSec(), r.AllocsPerOp, r.BytesPerOp, r.Misses, r.Errors)
+}
+
+// Key returns the i-th key of the key space used by Run
+func Key(i int) string {
+	return "bench:" + strconv.Itoa(i)
+}
+
+// Value returns a value of size bytes as written by Run
+func Value(size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(b)
+	return b
+}
+
+// Run loads the store with a mix of Gets and Sets over a key space (see WithConcurrency,
+// WithValueSize, WithKeys, WithReadRatio and WithDuration) and reports the throughput
+// reached. The key space is written once before the measurement starts, so Gets only
+// miss when the store evicted or expired keys. Errors other than ErrCacheMiss are
+// counted rather than stopping the run, unless priming the keys fails.
+func Run(store persistence.CacheStore, opt ...persistence.Option) (Result, error) {
+	c := newConfig(opt...)
+	value := Value(c.valueSize)
+	for i := 0; i < c.keys; i++ {
+		if err := store.Set(Key(i), value, persistence.DEFAULT); err != nil {
+			return Result{}, err
+		}
+	}
+
+	var res Result
+	var stop int32
+	var wg sync.WaitGroup
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for w := 0; w < c.concurrency; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			var ops, gets, misses, errs int64
+			var buf []byte
+			for atomic.LoadInt32(&stop) == 0 {
+				key := Key(rnd.Intn(c.keys))
+				var err error
+				if rnd.Float64() < c.readRatio {
+					gets++
+					if err = store.Get(key, &buf); err == persistence.ErrCacheMiss {
+						misses++
+						err = nil
+					}
+				} else {
+					err = store.Set(key, value, persistence.DEFAULT)
+				}
+				if err != nil {
+					errs++
+				}
+				ops++
+			}
+			atomic.AddInt64(&res.Ops, ops)
+			atomic.AddInt64(&res.Gets, gets)
+			atomic.AddInt64(&res.Misses, misses)
+			atomic.AddInt64(&res.Errors, errs)
+		}(int64(w))
+	}
+	time.Sleep(c.duration)
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	res.Duration = time.Since(start)
+	runtime.ReadMemStats(&after)
+	if res.Ops > 0 {
+		res.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(res.Ops)
+		res.BytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / float64(res.Ops)
+	}
+	return res, nil
+}
+
+// Codec is a way to turn values into bytes and back, as the stores do with
+// utils.Serialize and utils.Deserialize
+type Codec struct {
+	Name        string
+	Serialize   func(value interface{}) ([]byte, error)
+	Deserialize func(b []byte, ptr interface{}) error
+}
+
+// Gob is the codec of the stores: integers as decimal text, anything else gob encoded
+var Gob = Codec{Name: "gob", Serialize: utils.Serialize, Deserialize: utils.Deserialize}
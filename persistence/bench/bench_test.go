@@ -0,0 +1,117 @@
+package bench
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/persistence/freecache"
+	"github.com/Bose/cache/persistence/redistest"
+	"github.com/Bose/cache/persistence/ristretto"
+	"github.com/Bose/cache/utils"
+)
+
+var valueSizes = []int{16, 1024, 64 << 10}
+
+// stores are the stores running in process, plus redis on an embedded server
+var stores = []struct {
+	name string
+	new  func(b *testing.B) persistence.CacheStore
+}{
+	{"inmemory", func(b *testing.B) persistence.CacheStore {
+		return persistence.NewInMemoryStore(time.Hour)
+	}},
+	{"freecache", func(b *testing.B) persistence.CacheStore {
+		return freecache.NewStore(256<<20, time.Hour)
+	}},
+	{"ristretto", func(b *testing.B) persistence.CacheStore {
+		s, err := ristretto.NewStore(time.Hour)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return s
+	}},
+	{"redis", func(b *testing.B) persistence.CacheStore {
+		return redistest.NewStore(b, time.Hour)
+	}},
+}
+
+func BenchmarkStores(b *testing.B) {
+	for _, s := range stores {
+		for _, size := range valueSizes {
+			b.Run(s.name+"/"+strconv.Itoa(size), func(b *testing.B) {
+				store := s.new(b)
+				value := Value(size)
+				const keys = 1000
+				for i := 0; i < keys; i++ {
+					store.Set(Key(i), value, persistence.DEFAULT)
+				}
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					var buf []byte
+					for i := 0; pb.Next(); i++ {
+						key := Key(i % keys)
+						if i%10 == 0 {
+							store.Set(key, value, persistence.DEFAULT)
+						} else {
+							store.Get(key, &buf)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+type record struct {
+	ID    int
+	Name  string
+	Tags  []string
+	Attrs map[string]string
+}
+
+var codecs = []Codec{Gob, {Name: "cbor", Serialize: utils.CBOR.Marshal, Deserialize: utils.CBOR.Unmarshal}}
+
+func BenchmarkCodecs(b *testing.B) {
+	values := []struct {
+		name  string
+		value interface{}
+		new   func() interface{}
+	}{
+		{"int", 123456789, func() interface{} { return new(int) }},
+		{"string", string(Value(1024)), func() interface{} { return new(string) }},
+		{"struct", record{ID: 1, Name: "name", Tags: []string{"a", "b"}, Attrs: map[string]string{"k": "v"}}, func() interface{} { return new(record) }},
+	}
+	for _, c := range codecs {
+		for _, v := range values {
+			b.Run(c.Name+"/"+v.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					data, err := c.Serialize(v.value)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := c.Deserialize(data, v.new()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	res, err := Run(persistence.NewInMemoryStore(time.Hour), WithConcurrency(4), WithKeys(100), WithValueSize(32), WithDuration(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Ops == 0 || res.Gets == 0 || res.Gets == res.Ops || res.Errors != 0 || res.Misses != 0 {
+		t.Errorf("unexpected result %s", res)
+	}
+	if res.OpsPerSec() <= 0 {
+		t.Errorf("expected a throughput, got %v", res.OpsPerSec())
+	}
+}
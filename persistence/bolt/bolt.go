@@ -0,0 +1,308 @@
+// Package bolt provides a file-backed CacheStore on top of bbolt, for single
+// node services that want their cache to survive restarts without running Redis.
+//
+// Every entry is stored with its expiration time, and an expiry index bucket
+// ordered by expiration lets expired entries be purged without scanning the
+// whole file. Expired entries are never returned: they are treated as misses
+// until the purge (on an interval, or via PurgeExpired) removes them.
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	entriesBucket  = []byte("entries")
+	expiriesBucket = []byte("expiries")
+)
+
+const optionWithPurgeInterval = "optionWithBoltPurgeInterval"
+
+// WithPurgeInterval sets how often expired entries are removed from the file
+// (the default is every minute, <= 0 disables the background purge)
+func WithPurgeInterval(d time.Duration) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithPurgeInterval] = d
+	}
+}
+
+// Store represents the cache with bbolt persistence
+type Store struct {
+	db                *bolt.DB
+	defaultExpiration time.Duration
+	stop              chan struct{}
+	closeOnce         sync.Once
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// NewStore opens (or creates) the bbolt file at path and returns a Store. Call
+// Close to stop the background purge and release the file lock.
+func NewStore(path string, defaultExpiration time.Duration, opt ...persistence.Option) (*Store, error) {
+	opts := persistence.GetOpts(opt...)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(entriesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(expiriesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &Store{db: db, defaultExpiration: defaultExpiration, stop: make(chan struct{})}
+	interval := time.Minute
+	if v, ok := opts[optionWithPurgeInterval].(time.Duration); ok {
+		interval = v
+	}
+	if interval > 0 {
+		go s.purge(interval)
+	}
+	return s, nil
+}
+
+// Close stops the background purge and closes the file
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	return s.db.Close()
+}
+
+func (s *Store) purge(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = s.PurgeExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// PurgeExpired removes every expired entry and returns how many were removed
+func (s *Store) PurgeExpired() (int, error) {
+	n := 0
+	now := uint64(time.Now().UnixNano())
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		expiries := tx.Bucket(expiriesBucket)
+		c := expiries.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) < now; k, _ = c.First() {
+			key := k[8:]
+			// the index entry may be stale if the key was rewritten since
+			if v := entries.Get(key); v != nil && bytes.Equal(v[:8], k[:8]) {
+				if err := entries.Delete(key); err != nil {
+					return err
+				}
+				n++
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *Store) expiration(expires time.Duration) uint64 {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return uint64(time.Now().Add(expires).UnixNano())
+}
+
+// entry layout: 8 byte big endian expiration (unix nano, 0 = never) followed by the value
+func encodeEntry(expiration uint64, b []byte) []byte {
+	e := make([]byte, 8+len(b))
+	binary.BigEndian.PutUint64(e, expiration)
+	copy(e[8:], b)
+	return e
+}
+
+// lookup returns the value and expiration of an unexpired entry, nil if there is none
+func lookup(tx *bolt.Tx, key []byte) ([]byte, uint64) {
+	v := tx.Bucket(entriesBucket).Get(key)
+	if len(v) < 8 {
+		return nil, 0
+	}
+	exp := binary.BigEndian.Uint64(v)
+	if exp > 0 && exp < uint64(time.Now().UnixNano()) {
+		return nil, 0
+	}
+	return v[8:], exp
+}
+
+func put(tx *bolt.Tx, key []byte, b []byte, expiration uint64) error {
+	if err := tx.Bucket(entriesBucket).Put(key, encodeEntry(expiration, b)); err != nil {
+		return err
+	}
+	if expiration == 0 {
+		return nil
+	}
+	idx := make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(idx, expiration)
+	copy(idx[8:], key)
+	return tx.Bucket(expiriesBucket).Put(idx, nil)
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, value interface{}) error {
+	var b []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v, _ := lookup(tx, []byte(key))
+		if v == nil {
+			return persistence.ErrCacheMiss
+		}
+		// the slice is only valid for the lifetime of the transaction
+		b = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, []byte(key), b, s.expiration(expires))
+	})
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	return s.update(key, value, expires, false)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.update(key, value, expires, true)
+}
+
+// update writes the value if the key's existence matches exists
+func (s *Store) update(key string, value interface{}, expires time.Duration, exists bool) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if v, _ := lookup(tx, []byte(key)); (v != nil) != exists {
+			return persistence.ErrNotStored
+		}
+		return put(tx, []byte(key), b, s.expiration(expires))
+	})
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		v, _ := lookup(tx, []byte(key))
+		if err := tx.Bucket(entriesBucket).Delete([]byte(key)); err != nil {
+			return err
+		}
+		if v == nil {
+			return persistence.ErrCacheMiss
+		}
+		return nil
+	})
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(cur uint64) uint64 { return cur + delta })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(key, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// add applies op to the integer stored under key, keeping its expiration
+func (s *Store) add(key string, op func(uint64) uint64) (uint64, error) {
+	var result uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		v, exp := lookup(tx, []byte(key))
+		if v == nil {
+			return persistence.ErrCacheMiss
+		}
+		cur, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			return err
+		}
+		result = op(cur)
+		// the expiration is unchanged, so the existing index entry stays valid
+		return tx.Bucket(entriesBucket).Put([]byte(key), encodeEntry(exp, []byte(strconv.FormatUint(result, 10))))
+	})
+	return result, err
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	var exp uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var v []byte
+		if v, exp = lookup(tx, []byte(key)); v == nil {
+			return persistence.ErrCacheMiss
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if exp == 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Duration(int64(exp) - time.Now().UnixNano()), nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, expiriesBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
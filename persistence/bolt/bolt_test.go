@@ -0,0 +1,131 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+func newStore(t *testing.T, defaultExpiration time.Duration) *Store {
+	s, err := NewStore(filepath.Join(t.TempDir(), "cache.db"), defaultExpiration, WithPurgeInterval(0))
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBolt_TypicalGetSet(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if err := s.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := s.Get("value", &value); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if value != "foo" {
+		t.Errorf("Expected to get foo back, got %s", value)
+	}
+}
+
+func TestBolt_IncrDecr(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("int", 10, persistence.DEFAULT)
+	if n, err := s.Increment("int", 50); err != nil || n != 60 {
+		t.Errorf("Expected 60, was %d (%v)", n, err)
+	}
+	if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+		t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+	}
+}
+
+func TestBolt_AddReplaceDelete(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Replace("key", 3, persistence.DEFAULT); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestBolt_ExpirationAndPurge(t *testing.T) {
+	s := newStore(t, 10*time.Millisecond)
+	s.Set("default", 1, persistence.DEFAULT)
+	s.Set("forever", 1, persistence.FOREVER)
+	// rewritten with a longer TTL, so its first index entry is stale
+	s.Set("rewritten", 1, persistence.DEFAULT)
+	s.Set("rewritten", 2, time.Hour)
+	time.Sleep(20 * time.Millisecond)
+
+	var v int
+	if err := s.Get("default", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	n, err := s.PurgeExpired()
+	if err != nil {
+		t.Fatalf("Error purging: %s", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 entry purged, got %d", n)
+	}
+	if err := s.Get("rewritten", &v); err != nil || v != 2 {
+		t.Errorf("expected 2, got %d (%v)", v, err)
+	}
+	if err := s.Get("forever", &v); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+}
+
+func TestBolt_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	s, err := NewStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	s.Set("key", "value", persistence.DEFAULT)
+	s.Close()
+
+	s, err = NewStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Error reopening store: %s", err)
+	}
+	defer s.Close()
+	var v string
+	if err := s.Get("key", &v); err != nil || v != "value" {
+		t.Errorf("expected value, got %s (%v)", v, err)
+	}
+}
+
+func TestBolt_GetExpiresIn(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if _, err := s.GetExpiresIn("missing"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("ttl", 1, time.Minute)
+	if ttl, err := s.GetExpiresIn("ttl"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected a ttl within a minute, got %s (%v)", ttl, err)
+	}
+	s.Set("forever", 1, persistence.FOREVER)
+	if _, err := s.GetExpiresIn("forever"); err != persistence.ErrCacheNoTTL {
+		t.Errorf("expected ErrCacheNoTTL, got %v", err)
+	}
+}
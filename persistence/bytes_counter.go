@@ -0,0 +1,76 @@
+package persistence
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// bytesCounterLocks serialize the updates of counters rewritten by IncrementBytes and
+// DecrementBytes, striped by key
+var bytesCounterLocks [64]sync.Mutex
+
+// IncrementBytes increments the counter of key, for the servers fronting a store, which
+// write values as []byte holding decimal text. It uses store.Increment, which handles
+// them in the stores serializing values, and falls back to reading, updating and
+// rewriting the text for the others, whose Increment only handles integers and fails
+// with ErrNotInteger. The rewrite keeps the time left before the key expires, and is
+// only atomic against the other updates made with IncrementBytes and DecrementBytes
+// in the process.
+func IncrementBytes(store CacheStore, key string, delta uint64) (uint64, error) {
+	n, err := store.Increment(key, delta)
+	if !errors.Is(err, ErrNotInteger) {
+		return n, err
+	}
+	return rewriteBytesCounter(store, key, func(cur uint64) uint64 { return cur + delta })
+}
+
+// DecrementBytes decrements the counter of key like IncrementBytes, stopping at 0
+func DecrementBytes(store CacheStore, key string, delta uint64) (uint64, error) {
+	n, err := store.Decrement(key, delta)
+	if !errors.Is(err, ErrNotInteger) {
+		return n, err
+	}
+	return rewriteBytesCounter(store, key, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// rewriteBytesCounter applies op to the decimal text stored under key
+func rewriteBytesCounter(store CacheStore, key string, op func(uint64) uint64) (uint64, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &bytesCounterLocks[h.Sum32()%uint32(len(bytesCounterLocks))]
+	mu.Lock()
+	defer mu.Unlock()
+
+	var b []byte
+	if err := store.Get(key, &b); err != nil {
+		return 0, err
+	}
+	cur, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	expires := FOREVER
+	ttl, err := store.GetExpiresIn(key)
+	switch {
+	case err == nil:
+		expires = ttl
+	case !errors.Is(err, ErrCacheNoTTL):
+		return 0, err
+	}
+	n := op(cur)
+	if err := store.Replace(key, []byte(strconv.FormatUint(n, 10)), expires); err != nil {
+		if errors.Is(err, ErrNotStored) {
+			// the key expired or was deleted meanwhile
+			return 0, ErrCacheMiss
+		}
+		return 0, err
+	}
+	return n, nil
+}
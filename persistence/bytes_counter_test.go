@@ -0,0 +1,43 @@
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIncrementBytes(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	store.Set("counter", []byte("10"), time.Minute)
+	if n, err := IncrementBytes(store, "counter", 5); err != nil || n != 15 {
+		t.Errorf("expected 15, got %d (%v)", n, err)
+	}
+	var b []byte
+	if err := store.Get("counter", &b); err != nil || string(b) != "15" {
+		t.Errorf("expected the text to be rewritten, got %q (%v)", b, err)
+	}
+	if ttl, err := store.GetExpiresIn("counter"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the expiration to be kept, got %s (%v)", ttl, err)
+	}
+	if n, err := DecrementBytes(store, "counter", 20); err != nil || n != 0 {
+		t.Errorf("expected to stop at 0, got %d (%v)", n, err)
+	}
+
+	// stores handling the value natively are left to do it
+	store.Set("int", 1, DEFAULT)
+	if n, err := IncrementBytes(store, "int", 1); err != nil || n != 2 {
+		t.Errorf("expected 2, got %d (%v)", n, err)
+	}
+	var i int
+	if err := store.Get("int", &i); err != nil || i != 2 {
+		t.Errorf("expected the integer to stay an integer, got %d (%v)", i, err)
+	}
+
+	store.Set("text", []byte("abc"), DEFAULT)
+	if _, err := IncrementBytes(store, "text", 1); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("expected ErrNotInteger, got %v", err)
+	}
+	if _, err := IncrementBytes(store, "missing", 1); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
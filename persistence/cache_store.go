@@ -2,7 +2,10 @@ package persistence
 
 import (
 	"errors"
+	"reflect"
 	"time"
+
+	"github.com/Bose/cache/utils"
 )
 
 const (
@@ -15,12 +18,20 @@ var (
 	ErrCacheMiss    = errors.New("cache: key not found.")
 	ErrNotStored    = errors.New("cache: not stored.")
 	ErrNotSupport   = errors.New("cache: not support.")
+	// ErrCacheNoTTL is returned by GetExpiresIn for items that don't expire
+	ErrCacheNoTTL = errors.New("cache: key has no TTL.")
+	// ErrNilValue is returned by Get when the key holds a cached nil, as stored by
+	// setting a nil value or pointer, rather than by the miss ErrCacheMiss reports
+	ErrNilValue = utils.ErrNilValue
+	// ErrValueTooLarge is matched by the *ValueTooLargeError returned for oversized values
+	ErrValueTooLarge = errors.New("cache: value too large.")
 )
 
 // CacheStore is the interface of a cache backend
 type CacheStore interface {
 	// Get retrieves an item from the cache. Returns the item or nil, and a bool indicating
-	// whether the key was found.
+	// whether the key was found. Returns ErrNilValue, leaving value as is, if a nil was
+	// cached for the key.
 	Get(key string, value interface{}) error
 
 	// Set sets an item to the cache, replacing any existing item.
@@ -45,4 +56,63 @@ type CacheStore interface {
 
 	// Flush seletes all items from the cache.
 	Flush() error
+
+	// GetExpiresIn returns the time left before an item expires. Returns ErrCacheNoTTL
+	// if the item never expires, ErrCacheMiss if it isn't in the cache, and
+	// ErrNotSupport if the store can't tell.
+	GetExpiresIn(key string) (time.Duration, error)
+
+	// GetOrSet gets the item of key into ptr and returns true if it's cached. Otherwise
+	// it sets ptr to the value fill returns, caches it for ttl and returns false. A nil
+	// value is cached and reported with ErrNilValue as Get does.
+	GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error)
+}
+
+// GetOrSet implements CacheStore.GetOrSet with the Get and Set of store, for the stores
+// that have no better way of doing it. Failing to read the key is handled as a miss,
+// and ptr is set even if caching the value fails, in which case the error is
+// returned.
+func GetOrSet(store CacheStore, key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	err := store.Get(key, ptr)
+	if err == nil || err == ErrNilValue {
+		return true, err
+	}
+	v, err := fill()
+	if err != nil {
+		return false, err
+	}
+	if utils.IsNil(v) {
+		if err := store.Set(key, nil, ttl); err != nil {
+			return false, err
+		}
+		return false, ErrNilValue
+	}
+	if err := assign(ptr, v); err != nil {
+		return false, err
+	}
+	// cache the value as ptr holds it, so it reads back into the same type
+	return false, store.Set(key, reflect.ValueOf(ptr).Elem().Interface(), ttl)
+}
+
+// assign sets the value ptr points to to v, or to what v points to, converting it
+// through serialization if its type doesn't match
+func assign(ptr, v interface{}) error {
+	dst := reflect.ValueOf(ptr)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return ErrInvalidArgument
+	}
+	elem, src := dst.Elem(), reflect.ValueOf(v)
+	switch {
+	case src.Type().AssignableTo(elem.Type()):
+		elem.Set(src)
+	case src.Kind() == reflect.Ptr && src.Elem().Type().AssignableTo(elem.Type()):
+		elem.Set(src.Elem())
+	default:
+		b, err := utils.Serialize(v)
+		if err != nil {
+			return err
+		}
+		return utils.Deserialize(b, ptr)
+	}
+	return nil
 }
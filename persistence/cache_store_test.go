@@ -8,6 +8,18 @@ import (
 
 type cacheFactory func(*testing.T, time.Duration) CacheStore
 
+// wait lets d pass for cache: instantly for an in-memory store on a FakeClock, by
+// sleeping otherwise
+func wait(cache CacheStore, d time.Duration) {
+	if s, ok := cache.(*InMemoryStore); ok {
+		if clock, ok := s.cache.Clock().(*FakeClock); ok {
+			clock.Advance(d)
+			return
+		}
+	}
+	time.Sleep(d)
+}
+
 // Test typical cache interactions
 func typicalGetSet(t *testing.T, newCache cacheFactory) {
 	var err error
@@ -80,7 +92,7 @@ func expiration(t *testing.T, newCache cacheFactory) {
 	if err := cache.Set("int", value, DEFAULT); err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 	}
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	err = cache.Get("int", &value)
 	if err != ErrCacheMiss {
 		t.Errorf("Expected CacheMiss, but got: %s", err)
@@ -90,7 +102,7 @@ func expiration(t *testing.T, newCache cacheFactory) {
 	if err := cache.Set("int", value, time.Second); err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 	}
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	err = cache.Get("int", &value)
 	if err != ErrCacheMiss {
 		t.Errorf("Expected CacheMiss, but got: %s", err)
@@ -100,7 +112,7 @@ func expiration(t *testing.T, newCache cacheFactory) {
 	if err := cache.Set("int", value, time.Hour); err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 	}
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	err = cache.Get("int", &value)
 	if err != nil {
 		t.Errorf("Expected to get the value, but got: %s", err)
@@ -110,7 +122,7 @@ func expiration(t *testing.T, newCache cacheFactory) {
 	if err := cache.Set("int", value, FOREVER); err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 	}
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	err = cache.Get("int", &value)
 	if err != nil {
 		t.Errorf("Expected to get the value, but got: %s", err)
@@ -171,7 +183,7 @@ func testReplace(t *testing.T, newCache cacheFactory) {
 	}
 
 	// Wait for it to expire and replace with 3 (unsuccessfully).
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	if err = cache.Replace("int", 3, time.Second); err != ErrNotStored && err != ErrCacheMiss {
 		t.Errorf("Expected ErrNotStored or ErrCacheMiss, got: %s", err)
 	}
@@ -194,7 +206,7 @@ func testAdd(t *testing.T, newCache cacheFactory) {
 	}
 
 	// Wait for it to expire, and add again.
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	if err = cache.Add("int", 3, time.Second); err != nil {
 		t.Errorf("Unexpected error adding to cache: %s", err)
 	}
@@ -208,3 +220,101 @@ func testAdd(t *testing.T, newCache cacheFactory) {
 		t.Errorf("Expected 3, got: %d", i)
 	}
 }
+
+// Test the TTL left on items
+func getExpiresIn(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+
+	if err := cache.Set("expiring", 10, time.Second); err != nil {
+		t.Errorf("Error setting int: %s", err)
+	}
+	ttl, err := cache.GetExpiresIn("expiring")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if ttl < 500*time.Millisecond || ttl > time.Second {
+		t.Errorf("unexpected ttl: %v", ttl)
+	}
+
+	if err := cache.Set("forever", 10, FOREVER); err != nil {
+		t.Errorf("Error setting int: %s", err)
+	}
+	if _, err := cache.GetExpiresIn("forever"); err != ErrCacheNoTTL {
+		t.Errorf("expected ErrCacheNoTTL, got %v", err)
+	}
+	if _, err := cache.GetExpiresIn("missing"); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+// Test that a cached nil is told apart from a miss
+func cachedNil(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+
+	if err := cache.Set("nil", nil, DEFAULT); err != nil {
+		t.Fatalf("Error setting nil: %s", err)
+	}
+	value := "untouched"
+	if err := cache.Get("nil", &value); err != ErrNilValue {
+		t.Errorf("expected ErrNilValue, got %v", err)
+	}
+	if value != "untouched" {
+		t.Errorf("expected the value to be left as is, got %q", value)
+	}
+	if err := cache.Get("missing", &value); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+
+	// replacing with nil stores a nil like Set does
+	if err := cache.Set("replaced", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting: %s", err)
+	}
+	if err := cache.Replace("replaced", nil, DEFAULT); err != nil {
+		t.Errorf("expected replacing with nil to succeed, got %v", err)
+	}
+	if err := cache.Get("replaced", &value); err != ErrNilValue {
+		t.Errorf("expected ErrNilValue after replacing with nil, got %v", err)
+	}
+}
+
+// Test that GetOrSet fills and caches misses, and serves hits
+func getOrSet(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+
+	type user struct{ Name string }
+	calls := 0
+	fill := func() (interface{}, error) {
+		calls++
+		return &user{Name: "ada"}, nil
+	}
+	for i, want := range []bool{false, true} {
+		var u user
+		hit, err := cache.GetOrSet("user", time.Minute, &u, fill)
+		if err != nil || hit != want || u.Name != "ada" {
+			t.Errorf("call %d: expected hit %v and the user, got %v %+v (%v)", i, want, hit, u, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected a single fill, got %d", calls)
+	}
+	if ttl, err := cache.GetExpiresIn("user"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the TTL given, got %s (%v)", ttl, err)
+	}
+
+	var n int
+	if _, err := cache.GetOrSet("failing", DEFAULT, &n, func() (interface{}, error) {
+		return nil, ErrNotStored
+	}); err != ErrNotStored {
+		t.Errorf("expected the error of fill, got %v", err)
+	}
+	if err := cache.Get("failing", &n); err != ErrCacheMiss {
+		t.Errorf("expected errors not to be cached, got %v", err)
+	}
+	for i, want := range []bool{false, true} {
+		if hit, err := cache.GetOrSet("nil", DEFAULT, &n, func() (interface{}, error) {
+			return nil, nil
+		}); err != ErrNilValue || hit != want {
+			t.Errorf("call %d: expected hit %v and ErrNilValue, got %v (%v)", i, want, hit, err)
+		}
+	}
+}
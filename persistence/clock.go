@@ -0,0 +1,60 @@
+package persistence
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the stores and wrappers keeping track of expirations on
+// their side, so their expiration behavior can be tested with a FakeClock rather than
+// by sleeping (see WithClock)
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock of the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func newClock(opts Options) Clock {
+	if v, ok := opts[optionWithClock].(Clock); ok && v != nil {
+		return v
+	}
+	return systemClock{}
+}
+
+// FakeClock is a Clock that only moves when told to, for tests. It is safe for
+// concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time the clock is set to
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Set sets the clock to now
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.mu.Unlock()
+}
@@ -0,0 +1,46 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	if now := clock.Now(); !now.Equal(start) {
+		t.Errorf("expected %s, got %s", start, now)
+	}
+	clock.Advance(time.Hour)
+	if now := clock.Now(); !now.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected the clock to move by an hour, got %s", now)
+	}
+	clock.Set(start)
+	if now := clock.Now(); !now.Equal(start) {
+		t.Errorf("expected the clock to be set back, got %s", now)
+	}
+}
+
+func TestReadThrough_Clock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	loads := 0
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		loads++
+		return loads, nil
+	}
+	r := NewReadThrough(NewInMemoryStore(time.Hour, WithClock(clock)), time.Minute, loader,
+		WithXFetchBeta(0), WithClock(clock))
+	var v int
+	if err := r.Get("key", &v); err != nil || v != 1 {
+		t.Fatalf("expected the loaded value, got %d (%v)", v, err)
+	}
+	clock.Advance(59 * time.Second)
+	if err := r.Get("key", &v); err != nil || v != 1 {
+		t.Errorf("expected the cached value, got %d (%v)", v, err)
+	}
+	clock.Advance(2 * time.Second)
+	if err := r.Get("key", &v); err != nil || v != 2 {
+		t.Errorf("expected the value to be loaded again once expired, got %d (%v)", v, err)
+	}
+}
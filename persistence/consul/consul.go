@@ -0,0 +1,333 @@
+// Package consul provides a CacheStore on top of the Consul KV store, for
+// caching small coordination data without running Redis next to Consul.
+//
+// Consul KV has no per-key TTL, so every expiring write acquires its key
+// with a session whose TTL matches the expiration and whose behavior is
+// "delete": once the session lapses Consul removes the key. Sessions only
+// support TTLs between 10s and 24h and may lapse up to twice their TTL late,
+// so each value also carries its exact expiration and reads treat expired keys
+// as missing. Keys expiring more than 24h out are only filtered on read.
+package consul
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	defaultPrefix = "cache/"
+
+	// Consul session limits
+	minSessionTTL = 10 * time.Second
+	maxSessionTTL = 24 * time.Hour
+
+	maxCASRetries = 10
+)
+
+// the subsets of the Consul API used by the store, so tests can fake them
+type kvAPI interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error)
+	DeleteTree(prefix string, w *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+type sessionAPI interface {
+	CreateNoChecks(se *api.SessionEntry, q *api.WriteOptions) (string, *api.WriteMeta, error)
+}
+
+// Store represents the cache with Consul KV persistence
+type Store struct {
+	kv                kvAPI
+	sessions          sessionAPI
+	prefix            string
+	defaultExpiration time.Duration
+}
+
+var _ persistence.CacheStore = &Store{}
+
+const optionWithPrefix = "optionWithConsulPrefix"
+
+// WithPrefix stores every key under the prefix (default "cache/"), which also scopes Flush
+func WithPrefix(prefix string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithPrefix] = prefix
+	}
+}
+
+// NewStore returns a Store using the KV store of the provided client
+func NewStore(client *api.Client, defaultExpiration time.Duration, opt ...persistence.Option) *Store {
+	return newStore(client.KV(), client.Session(), defaultExpiration, opt...)
+}
+
+func newStore(kv kvAPI, sessions sessionAPI, defaultExpiration time.Duration, opt ...persistence.Option) *Store {
+	opts := persistence.GetOpts(opt...)
+	s := &Store{kv: kv, sessions: sessions, prefix: defaultPrefix, defaultExpiration: defaultExpiration}
+	if v, ok := opts[optionWithPrefix].(string); ok {
+		s.prefix = v
+	}
+	return s
+}
+
+// expiration returns the absolute expiration in unix nano, 0 when the key never expires
+func (s *Store) expiration(expires time.Duration) int64 {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return time.Now().Add(expires).UnixNano()
+}
+
+// entries are stored as an 8 byte big endian expiration followed by the value
+func encode(b []byte, expiration int64) []byte {
+	entry := make([]byte, 8+len(b))
+	binary.BigEndian.PutUint64(entry, uint64(expiration))
+	copy(entry[8:], b)
+	return entry
+}
+
+// decode returns the value and expiration of an unexpired pair, nil if there is none
+func decode(pair *api.KVPair) ([]byte, int64) {
+	if pair == nil || len(pair.Value) < 8 {
+		return nil, 0
+	}
+	expiration := int64(binary.BigEndian.Uint64(pair.Value))
+	if expiration > 0 && time.Now().UnixNano() > expiration {
+		return nil, 0
+	}
+	return pair.Value[8:], expiration
+}
+
+// session creates a session that deletes the key once the expiration has passed,
+// or returns "" when the key doesn't expire within what a session can express
+func (s *Store) session(expiration int64) (string, error) {
+	if expiration == 0 {
+		return "", nil
+	}
+	ttl := time.Until(time.Unix(0, expiration))
+	if ttl > maxSessionTTL {
+		return "", nil
+	}
+	if ttl < minSessionTTL {
+		ttl = minSessionTTL
+	}
+	id, _, err := s.sessions.CreateNoChecks(&api.SessionEntry{
+		Name:     "cache",
+		TTL:      strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10) + "s",
+		Behavior: api.SessionBehaviorDelete,
+		// the default 15s lock delay would block re-adding the key right after it expired
+		LockDelay: time.Millisecond,
+	}, nil)
+	return id, err
+}
+
+// write stores the entry in a single transaction guarded by check. The key is
+// deleted first so a lock still held by an earlier session doesn't block the
+// write, then set or locked with the session that expires it.
+func (s *Store) write(key string, b []byte, expiration int64, session string, check *api.KVTxnOp) (bool, error) {
+	k := s.prefix + key
+	ops := api.KVTxnOps{}
+	if check != nil {
+		ops = append(ops, check)
+	}
+	ops = append(ops, &api.KVTxnOp{Verb: api.KVDelete, Key: k})
+	if session == "" {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVSet, Key: k, Value: encode(b, expiration)})
+	} else {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVLock, Key: k, Value: encode(b, expiration), Session: session})
+	}
+	ok, _, _, err := s.kv.Txn(ops, nil)
+	return ok, err
+}
+
+// unchanged guards a write on the key still being at the index it was read at
+func unchanged(k string, pair *api.KVPair) *api.KVTxnOp {
+	if pair == nil {
+		return &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: k}
+	}
+	return &api.KVTxnOp{Verb: api.KVCheckIndex, Key: k, Index: pair.ModifyIndex}
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, ptrValue interface{}) error {
+	pair, _, err := s.kv.Get(s.prefix+key, nil)
+	if err != nil {
+		return err
+	}
+	b, _ := decode(pair)
+	if b == nil {
+		return persistence.ErrCacheMiss
+	}
+	return utils.Deserialize(b, ptrValue)
+}
+
+// Mget retrieves a list of items for the list of keys provided.
+// If any item does not exist, an ErrCacheMiss is returned.
+func (s *Store) Mget(ptrValue []interface{}, keys ...string) error {
+	if len(ptrValue) != len(keys) {
+		return &persistence.ValueCountError{Got: len(ptrValue), Required: len(keys)}
+	}
+	for i, key := range keys {
+		if err := s.Get(key, ptrValue[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	expiration := s.expiration(expires)
+	session, err := s.session(expiration)
+	if err != nil {
+		return err
+	}
+	ok, err := s.write(key, b, expiration, session, nil)
+	if err == nil && !ok {
+		return persistence.ErrNotStored
+	}
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	return s.put(key, value, expires, false)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.put(key, value, expires, true)
+}
+
+// put writes the value only if the key's liveness matches exists and the key
+// hasn't been changed since it was checked
+func (s *Store) put(key string, value interface{}, expires time.Duration, exists bool) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	pair, _, err := s.kv.Get(s.prefix+key, nil)
+	if err != nil {
+		return err
+	}
+	if cur, _ := decode(pair); (cur != nil) != exists {
+		return persistence.ErrNotStored
+	}
+	expiration := s.expiration(expires)
+	session, err := s.session(expiration)
+	if err != nil {
+		return err
+	}
+	ok, err := s.write(key, b, expiration, session, unchanged(s.prefix+key, pair))
+	if err == nil && !ok {
+		return persistence.ErrNotStored
+	}
+	return err
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	k := s.prefix + key
+	pair, _, err := s.kv.Get(k, nil)
+	if err != nil {
+		return err
+	}
+	if pair == nil {
+		return persistence.ErrCacheMiss
+	}
+	// expired keys are removed as well, but still reported as a miss
+	ok, _, _, err := s.kv.Txn(api.KVTxnOps{{Verb: api.KVDeleteCAS, Key: k, Index: pair.ModifyIndex}}, nil)
+	if err != nil {
+		return err
+	}
+	if b, _ := decode(pair); !ok || b == nil {
+		return persistence.ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(cur uint64) uint64 { return cur + delta })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(key, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// add applies op to the integer stored under key with a check-and-set on the
+// index it read, keeping the key's expiration and session
+func (s *Store) add(key string, op func(uint64) uint64) (uint64, error) {
+	k := s.prefix + key
+	for i := 0; i < maxCASRetries; i++ {
+		pair, _, err := s.kv.Get(k, nil)
+		if err != nil {
+			return 0, err
+		}
+		b, expiration := decode(pair)
+		if b == nil {
+			return 0, persistence.ErrCacheMiss
+		}
+		cur, err := strconv.ParseUint(string(b), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		result := op(cur)
+		ok, err := s.write(key, []byte(strconv.FormatUint(result, 10)), expiration, pair.Session, unchanged(k, pair))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return result, nil
+		}
+		// somebody else wrote in between, try again
+	}
+	return 0, persistence.ErrNotStored
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	pair, _, err := s.kv.Get(s.prefix+key, nil)
+	if err != nil {
+		return 0, err
+	}
+	b, expiration := decode(pair)
+	if b == nil {
+		return 0, persistence.ErrCacheMiss
+	}
+	if expiration == 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Duration(expiration - time.Now().UnixNano()), nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush deletes every key under the store's prefix
+func (s *Store) Flush() error {
+	_, err := s.kv.DeleteTree(s.prefix, nil)
+	return err
+}
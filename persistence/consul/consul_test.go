@@ -0,0 +1,183 @@
+package consul
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeConsul keeps pairs in a map and applies transactions atomically
+type fakeConsul struct {
+	sync.Mutex
+	pairs    map[string]*api.KVPair
+	index    uint64
+	sessions int
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{pairs: map[string]*api.KVPair{}}
+}
+
+func (f *fakeConsul) Get(key string, _ *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	f.Lock()
+	defer f.Unlock()
+	if p, ok := f.pairs[key]; ok {
+		cp := *p
+		return &cp, nil, nil
+	}
+	return nil, nil, nil
+}
+
+func (f *fakeConsul) Txn(ops api.KVTxnOps, _ *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	f.Lock()
+	defer f.Unlock()
+	pairs := make(map[string]*api.KVPair, len(f.pairs))
+	for k, p := range f.pairs {
+		pairs[k] = p
+	}
+	for _, op := range ops {
+		p, exists := pairs[op.Key]
+		switch op.Verb {
+		case api.KVCheckNotExists:
+			if exists {
+				return false, nil, nil, nil
+			}
+		case api.KVCheckIndex:
+			if !exists || p.ModifyIndex != op.Index {
+				return false, nil, nil, nil
+			}
+		case api.KVDeleteCAS:
+			if !exists || p.ModifyIndex != op.Index {
+				return false, nil, nil, nil
+			}
+			delete(pairs, op.Key)
+		case api.KVDelete:
+			delete(pairs, op.Key)
+		case api.KVSet, api.KVLock:
+			if exists && p.Session != "" && p.Session != op.Session {
+				return false, nil, nil, nil
+			}
+			f.index++
+			pairs[op.Key] = &api.KVPair{Key: op.Key, Value: op.Value, Session: op.Session, ModifyIndex: f.index}
+		}
+	}
+	f.pairs = pairs
+	return true, &api.KVTxnResponse{}, nil, nil
+}
+
+func (f *fakeConsul) DeleteTree(prefix string, _ *api.WriteOptions) (*api.WriteMeta, error) {
+	f.Lock()
+	defer f.Unlock()
+	for k := range f.pairs {
+		if strings.HasPrefix(k, prefix) {
+			delete(f.pairs, k)
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeConsul) CreateNoChecks(se *api.SessionEntry, _ *api.WriteOptions) (string, *api.WriteMeta, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.sessions++
+	return "session-" + strconv.Itoa(f.sessions), nil, nil
+}
+
+func newTestStore(defaultExpiration time.Duration) (*Store, *fakeConsul) {
+	f := newFakeConsul()
+	return newStore(f, f, defaultExpiration), f
+}
+
+func TestConsul_TypicalGetSet(t *testing.T) {
+	s, f := newTestStore(time.Hour)
+	if err := s.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := s.Get("value", &value); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if value != "foo" {
+		t.Errorf("Expected to get foo back, got %s", value)
+	}
+	if p := f.pairs["cache/value"]; p == nil || p.Session == "" {
+		t.Errorf("expected the key to be held by an expiring session, got %+v", p)
+	}
+	// overwriting a key held by another session must still succeed
+	if err := s.Set("value", "bar", persistence.FOREVER); err != nil {
+		t.Fatalf("Error overwriting a value: %s", err)
+	}
+	if p := f.pairs["cache/value"]; p.Session != "" {
+		t.Errorf("expected a FOREVER key not to be held by a session, got %s", p.Session)
+	}
+}
+
+func TestConsul_IncrDecr(t *testing.T) {
+	s, f := newTestStore(time.Hour)
+	if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("int", 10, persistence.DEFAULT)
+	session := f.pairs["cache/int"].Session
+	if n, err := s.Increment("int", 50); err != nil || n != 60 {
+		t.Errorf("Expected 60, was %d (%v)", n, err)
+	}
+	if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+		t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+	}
+	if f.pairs["cache/int"].Session != session {
+		t.Errorf("expected Increment to keep the key's session")
+	}
+}
+
+func TestConsul_AddReplaceDelete(t *testing.T) {
+	s, _ := newTestStore(time.Hour)
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Replace("key", 3, persistence.DEFAULT); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestConsul_Expiration(t *testing.T) {
+	s, _ := newTestStore(time.Hour)
+	s.Set("int", 1, 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	var v int
+	if err := s.Get("int", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := s.Add("int", 2, persistence.DEFAULT); err != nil {
+		t.Errorf("expected Add to overwrite an expired key: %s", err)
+	}
+}
+
+func TestConsul_Flush(t *testing.T) {
+	s, f := newTestStore(time.Hour)
+	f.pairs["other/key"] = &api.KVPair{Key: "other/key"}
+	s.Set("a", 1, persistence.DEFAULT)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if len(f.pairs) != 1 {
+		t.Errorf("expected only the key outside the prefix to remain, got %d", len(f.pairs))
+	}
+}
@@ -0,0 +1,115 @@
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DedupStore coalesces concurrent identical Gets into a single Get on the wrapped
+// store, and optionally identical Sets into a single Set, to cut redundant traffic
+// when many callers hit the same hot key at once.
+//
+// Callers that share a Get receive shallow copies of one value, so maps, slices and
+// pointers inside it are shared between them and must not be modified.
+type DedupStore struct {
+	CacheStore
+	gets singleflight.Group
+	sets *setGroup
+}
+
+var _ CacheStore = &DedupStore{}
+
+// NewDedupStore returns a DedupStore wrapping store
+func NewDedupStore(store CacheStore, opt ...Option) *DedupStore {
+	opts := GetOpts(opt...)
+	s := &DedupStore{CacheStore: store}
+	if v, ok := opts[optionWithDedupSets].(bool); ok && v {
+		s.sets = &setGroup{calls: map[string]*setCall{}}
+	}
+	return s
+}
+
+// Get (see CacheStore interface)
+func (s *DedupStore) Get(key string, value interface{}) error {
+	ptr := reflect.ValueOf(value)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return s.CacheStore.Get(key, value)
+	}
+	// only callers reading into the same type can share a result
+	t := ptr.Type().Elem()
+	v, err, _ := s.gets.Do(key+"\x00"+t.String(), func() (interface{}, error) {
+		v := reflect.New(t)
+		if err := s.CacheStore.Get(key, v.Interface()); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	if err != nil {
+		return err
+	}
+	ptr.Elem().Set(v.(reflect.Value).Elem())
+	return nil
+}
+
+// Set (see CacheStore interface)
+func (s *DedupStore) Set(key string, value interface{}, expires time.Duration) error {
+	if s.sets == nil {
+		return s.CacheStore.Set(key, value, expires)
+	}
+	return s.sets.do(key, value, expires, s.CacheStore.Set)
+}
+
+// errDedupSetPanicked is returned to the callers that joined a Set that panicked
+var errDedupSetPanicked = errors.New("cache: dedup: Set panicked")
+
+// setGroup joins a Set to one already in flight for the same key, value and expiration
+type setGroup struct {
+	mu    sync.Mutex
+	calls map[string]*setCall
+}
+
+type setCall struct {
+	wg      sync.WaitGroup
+	value   interface{}
+	expires time.Duration
+	err     error
+}
+
+func (g *setGroup) do(key string, value interface{}, expires time.Duration, set func(string, interface{}, time.Duration) error) error {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		if c.expires == expires && reflect.DeepEqual(c.value, value) {
+			g.mu.Unlock()
+			c.wg.Wait()
+			return c.err
+		}
+		// a different write for the key isn't deduplicated, but it does take over
+		// as the call later identical Sets join
+	}
+	c := &setCall{value: value, expires: expires}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// the callers joining are released, with an error, even if set panics
+	c.err = errDedupSetPanicked
+	defer func() {
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.err = set(key, value, expires)
+	return c.err
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *DedupStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(s, key, ttl, ptr, fill)
+}
@@ -0,0 +1,119 @@
+package persistence
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowStore counts the calls reaching the wrapped store and slows them down so they overlap
+type slowStore struct {
+	CacheStore
+	gets, sets int32
+}
+
+func (s *slowStore) Get(key string, value interface{}) error {
+	atomic.AddInt32(&s.gets, 1)
+	time.Sleep(20 * time.Millisecond)
+	return s.CacheStore.Get(key, value)
+}
+
+func (s *slowStore) Set(key string, value interface{}, expires time.Duration) error {
+	atomic.AddInt32(&s.sets, 1)
+	time.Sleep(20 * time.Millisecond)
+	return s.CacheStore.Set(key, value, expires)
+}
+
+func TestDedupStore_Get(t *testing.T) {
+	backend := &slowStore{CacheStore: NewInMemoryStore(time.Hour)}
+	backend.CacheStore.Set("key", "value", DEFAULT)
+	s := NewDedupStore(backend)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v string
+			if err := s.Get("key", &v); err != nil || v != "value" {
+				t.Errorf("unexpected value %q (%v)", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&backend.gets); n != 1 {
+		t.Errorf("expected a single Get on the wrapped store, got %d", n)
+	}
+	var v string
+	if err := s.Get("missing", &v); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestDedupStore_Set(t *testing.T) {
+	backend := &slowStore{CacheStore: NewInMemoryStore(time.Hour)}
+	s := NewDedupStore(backend, WithDedupSets())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Set("key", []string{"a", "b"}, DEFAULT); err != nil {
+				t.Errorf("Error setting a value: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&backend.sets); n != 1 {
+		t.Errorf("expected a single Set on the wrapped store, got %d", n)
+	}
+	s.Set("key", []string{"c"}, DEFAULT)
+	if n := atomic.LoadInt32(&backend.sets); n != 2 {
+		t.Errorf("expected a different value to be written, got %d Sets", n)
+	}
+}
+
+// panickingStore panics on every Get and Set
+type panickingStore struct {
+	CacheStore
+}
+
+func (s panickingStore) Get(key string, value interface{}) error {
+	panic("get")
+}
+
+func (s panickingStore) Set(key string, value interface{}, expires time.Duration) error {
+	panic("set")
+}
+
+func TestDedupStore_Panic(t *testing.T) {
+	s := NewDedupStore(panickingStore{NewInMemoryStore(time.Hour)}, WithDedupSets())
+	for i := 0; i < 2; i++ {
+		done := make(chan interface{})
+		go func() {
+			defer func() { done <- recover() }()
+			var v string
+			s.Get("key", &v)
+		}()
+		select {
+		case p := <-done:
+			if p == nil {
+				t.Error("expected the panic of Get to reach the caller")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected Get not to block after a panic")
+		}
+		go func() {
+			defer func() { done <- recover() }()
+			s.Set("key", "value", DEFAULT)
+		}()
+		select {
+		case p := <-done:
+			if p == nil {
+				t.Error("expected the panic of Set to reach the caller")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected Set not to block after a panic")
+		}
+	}
+}
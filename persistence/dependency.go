@@ -0,0 +1,170 @@
+package persistence
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDependencyCycle is returned by DependsOn when the dependency would make a key depend on itself
+var ErrDependencyCycle = errors.New("cache: dependency cycle.")
+
+// DependencyStore invalidates derived keys along with the keys they were derived from:
+// once a child is registered with DependsOn, every write to or deletion of one of its
+// parents also deletes the child, and in turn everything that depends on the child.
+//
+// The dependency graph is kept in process, so every process writing parents needs the
+// same dependencies registered.
+type DependencyStore struct {
+	CacheStore
+
+	mu         sync.RWMutex
+	dependents map[string]map[string]struct{} // parent -> children
+}
+
+var _ CacheStore = &DependencyStore{}
+
+// NewDependencyStore returns a DependencyStore wrapping store
+func NewDependencyStore(store CacheStore) *DependencyStore {
+	return &DependencyStore{CacheStore: store, dependents: map[string]map[string]struct{}{}}
+}
+
+// reaches reports whether to can be reached from from following the dependents, with
+// the lock held
+func (s *DependencyStore) reaches(from, to string) bool {
+	seen := map[string]struct{}{from: {}}
+	queue := []string{from}
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		if key == to {
+			return true
+		}
+		for child := range s.dependents[key] {
+			if _, ok := seen[child]; !ok {
+				seen[child] = struct{}{}
+				queue = append(queue, child)
+			}
+		}
+	}
+	return false
+}
+
+// DependsOn registers child as derived from the parents. It returns ErrDependencyCycle,
+// without registering anything, if one of the parents already depends on child.
+func (s *DependencyStore) DependsOn(child string, parents ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, parent := range parents {
+		if s.reaches(child, parent) {
+			return ErrDependencyCycle
+		}
+	}
+	for _, parent := range parents {
+		children, ok := s.dependents[parent]
+		if !ok {
+			children = map[string]struct{}{}
+			s.dependents[parent] = children
+		}
+		children[child] = struct{}{}
+	}
+	return nil
+}
+
+// RemoveDependency unregisters child as derived from the parents
+func (s *DependencyStore) RemoveDependency(child string, parents ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, parent := range parents {
+		delete(s.dependents[parent], child)
+		if len(s.dependents[parent]) == 0 {
+			delete(s.dependents, parent)
+		}
+	}
+}
+
+// invalidateDependents deletes everything transitively derived from key
+func (s *DependencyStore) invalidateDependents(key string) error {
+	s.mu.RLock()
+	var dependents []string
+	seen := map[string]struct{}{key: {}}
+	queue := []string{key}
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		for child := range s.dependents[k] {
+			if _, ok := seen[child]; !ok {
+				seen[child] = struct{}{}
+				dependents = append(dependents, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	s.mu.RUnlock()
+	for _, child := range dependents {
+		if err := s.CacheStore.Delete(child); err != nil && err != ErrCacheMiss {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set (see CacheStore interface)
+func (s *DependencyStore) Set(key string, value interface{}, expires time.Duration) error {
+	if err := s.CacheStore.Set(key, value, expires); err != nil {
+		return err
+	}
+	return s.invalidateDependents(key)
+}
+
+// Add (see CacheStore interface)
+func (s *DependencyStore) Add(key string, value interface{}, expires time.Duration) error {
+	if err := s.CacheStore.Add(key, value, expires); err != nil {
+		return err
+	}
+	return s.invalidateDependents(key)
+}
+
+// Replace (see CacheStore interface)
+func (s *DependencyStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if err := s.CacheStore.Replace(key, value, expires); err != nil {
+		return err
+	}
+	return s.invalidateDependents(key)
+}
+
+// Delete (see CacheStore interface)
+func (s *DependencyStore) Delete(key string) error {
+	err := s.CacheStore.Delete(key)
+	if err != nil && err != ErrCacheMiss {
+		return err
+	}
+	// dependents may outlive an expired parent, so they go even on a miss
+	if err := s.invalidateDependents(key); err != nil {
+		return err
+	}
+	return err
+}
+
+// Increment (see CacheStore interface)
+func (s *DependencyStore) Increment(key string, delta uint64) (uint64, error) {
+	n, err := s.CacheStore.Increment(key, delta)
+	if err != nil {
+		return n, err
+	}
+	return n, s.invalidateDependents(key)
+}
+
+// Decrement (see CacheStore interface)
+func (s *DependencyStore) Decrement(key string, delta uint64) (uint64, error) {
+	n, err := s.CacheStore.Decrement(key, delta)
+	if err != nil {
+		return n, err
+	}
+	return n, s.invalidateDependents(key)
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *DependencyStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(s, key, ttl, ptr, fill)
+}
@@ -0,0 +1,73 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDependencyStore_Cascade(t *testing.T) {
+	s := NewDependencyStore(NewInMemoryStore(time.Hour))
+	for _, k := range []string{"orders", "users", "orders:total", "report", "unrelated"} {
+		s.Set(k, 1, DEFAULT)
+	}
+	if err := s.DependsOn("orders:total", "orders"); err != nil {
+		t.Fatalf("Error registering a dependency: %s", err)
+	}
+	if err := s.DependsOn("report", "orders:total", "users"); err != nil {
+		t.Fatalf("Error registering a dependency: %s", err)
+	}
+
+	if _, err := s.Increment("orders", 1); err != nil {
+		t.Fatalf("Error incrementing: %s", err)
+	}
+	var v int
+	for _, k := range []string{"orders:total", "report"} {
+		if err := s.Get(k, &v); err != ErrCacheMiss {
+			t.Errorf("expected %s to be invalidated, got %v", k, err)
+		}
+	}
+	for _, k := range []string{"orders", "users", "unrelated"} {
+		if err := s.Get(k, &v); err != nil {
+			t.Errorf("expected %s to be kept, got %v", k, err)
+		}
+	}
+
+	s.Set("report", 1, DEFAULT)
+	if err := s.Delete("users"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Get("report", &v); err != ErrCacheMiss {
+		t.Errorf("expected report to be invalidated, got %v", err)
+	}
+
+	s.Set("report", 1, DEFAULT)
+	s.RemoveDependency("report", "users", "orders:total")
+	s.Set("users", 2, DEFAULT)
+	if err := s.Get("report", &v); err != nil {
+		t.Errorf("expected report to be kept once its dependencies are removed, got %v", err)
+	}
+}
+
+func TestDependencyStore_Cycles(t *testing.T) {
+	s := NewDependencyStore(NewInMemoryStore(time.Hour))
+	s.DependsOn("b", "a")
+	s.DependsOn("c", "b")
+	if err := s.DependsOn("a", "c"); err != ErrDependencyCycle {
+		t.Errorf("expected ErrDependencyCycle, got %v", err)
+	}
+	if err := s.DependsOn("a", "a"); err != ErrDependencyCycle {
+		t.Errorf("expected ErrDependencyCycle, got %v", err)
+	}
+	// a diamond is not a cycle
+	if err := s.DependsOn("c", "a"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	s.Set("c", 1, DEFAULT)
+	if err := s.Set("a", 1, DEFAULT); err != nil {
+		t.Errorf("Error setting: %s", err)
+	}
+	var v int
+	if err := s.Get("c", &v); err != ErrCacheMiss {
+		t.Errorf("expected c to be invalidated, got %v", err)
+	}
+}
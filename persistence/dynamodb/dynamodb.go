@@ -0,0 +1,337 @@
+// Package dynamodb provides a CacheStore on top of a DynamoDB table, for
+// serverless deployments where a Redis cluster is overkill.
+//
+// The table needs a string partition key named "k" and should have DynamoDB
+// TTL enabled on the "ttl" attribute. DynamoDB deletes expired items lazily
+// (possibly days later), so the store also filters them out on every read
+// and treats them as missing in conditional writes.
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	attrKey   = "k"
+	attrValue = "v"
+	attrTTL   = "ttl"
+
+	// DynamoDB limits
+	maxBatchGet     = 100
+	maxCASRetries   = 10
+	conditionNotSet = "attribute_not_exists(k) OR #ttl < :now"
+	conditionLive   = "attribute_exists(k) AND (attribute_not_exists(#ttl) OR #ttl >= :now)"
+	conditionSame   = "v = :old"
+)
+
+// API is the subset of the DynamoDB client used by the store, satisfied by *dynamodb.Client
+type API interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+// Store represents the cache with DynamoDB persistence
+type Store struct {
+	client            API
+	table             string
+	defaultExpiration time.Duration
+	consistentRead    bool
+}
+
+var _ persistence.CacheStore = &Store{}
+
+const optionWithConsistentReads = "optionWithDynamoDBConsistentReads"
+
+// WithConsistentReads makes Get and Mget use strongly consistent reads
+func WithConsistentReads() persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithConsistentReads] = true
+	}
+}
+
+// NewStore returns a Store using the table through the provided client
+func NewStore(client API, table string, defaultExpiration time.Duration, opt ...persistence.Option) *Store {
+	opts := persistence.GetOpts(opt...)
+	s := &Store{client: client, table: table, defaultExpiration: defaultExpiration}
+	if v, ok := opts[optionWithConsistentReads].(bool); ok {
+		s.consistentRead = v
+	}
+	return s
+}
+
+// ttl returns the expiration as epoch seconds, 0 when the item never expires.
+// DynamoDB TTLs have second granularity, so sub-second expirations round up.
+func (s *Store) ttl(expires time.Duration) int64 {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return time.Now().Add(expires + time.Second - 1).Unix()
+}
+
+func key(k string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{attrKey: &types.AttributeValueMemberS{Value: k}}
+}
+
+func (s *Store) item(k string, b []byte, ttl int64) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		attrKey:   &types.AttributeValueMemberS{Value: k},
+		attrValue: &types.AttributeValueMemberB{Value: b},
+	}
+	if ttl > 0 {
+		item[attrTTL] = &types.AttributeValueMemberN{Value: strconv.FormatInt(ttl, 10)}
+	}
+	return item
+}
+
+func now() types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)}
+}
+
+// value returns the value and TTL of an unexpired item, nil if there is none
+func value(item map[string]types.AttributeValue) ([]byte, int64) {
+	if item == nil {
+		return nil, 0
+	}
+	var ttl int64
+	if n, ok := item[attrTTL].(*types.AttributeValueMemberN); ok {
+		ttl, _ = strconv.ParseInt(n.Value, 10, 64)
+		if ttl > 0 && ttl < time.Now().Unix() {
+			return nil, 0
+		}
+	}
+	b, ok := item[attrValue].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, 0
+	}
+	return b.Value, ttl
+}
+
+func isConditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	return errors.As(err, &ccf)
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(k string, ptrValue interface{}) error {
+	out, err := s.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            key(k),
+		ConsistentRead: aws.Bool(s.consistentRead),
+	})
+	if err != nil {
+		return err
+	}
+	b, _ := value(out.Item)
+	if b == nil {
+		return persistence.ErrCacheMiss
+	}
+	return utils.Deserialize(b, ptrValue)
+}
+
+// Mget retrieves a list of items for the list of keys provided with BatchGetItem, 100
+// keys per request. If any item does not exist, an ErrCacheMiss is returned.
+func (s *Store) Mget(ptrValue []interface{}, keys ...string) error {
+	if len(ptrValue) != len(keys) {
+		return &persistence.ValueCountError{Got: len(ptrValue), Required: len(keys)}
+	}
+	found := make(map[string][]byte, len(keys))
+	for start := 0; start < len(keys); start += maxBatchGet {
+		end := start + maxBatchGet
+		if end > len(keys) {
+			end = len(keys)
+		}
+		ks := make([]map[string]types.AttributeValue, 0, end-start)
+		seen := map[string]bool{}
+		for _, k := range keys[start:end] {
+			// BatchGetItem rejects duplicate keys
+			if !seen[k] {
+				seen[k] = true
+				ks = append(ks, key(k))
+			}
+		}
+		request := map[string]types.KeysAndAttributes{s.table: {Keys: ks, ConsistentRead: aws.Bool(s.consistentRead)}}
+		for len(request) > 0 {
+			out, err := s.client.BatchGetItem(context.Background(), &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return err
+			}
+			for _, item := range out.Responses[s.table] {
+				if b, _ := value(item); b != nil {
+					found[item[attrKey].(*types.AttributeValueMemberS).Value] = b
+				}
+			}
+			// throttled keys come back unprocessed and have to be asked for again
+			request = out.UnprocessedKeys
+		}
+	}
+	for i, k := range keys {
+		b, ok := found[k]
+		if !ok {
+			return persistence.ErrCacheMiss
+		}
+		if err := utils.Deserialize(b, ptrValue[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(k string, v interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      s.item(k, b, s.ttl(expires)),
+	})
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(k string, v interface{}, expires time.Duration) error {
+	return s.put(k, v, expires, conditionNotSet)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(k string, v interface{}, expires time.Duration) error {
+	return s.put(k, v, expires, conditionLive)
+}
+
+// put writes the item with a conditional write, mapping a failed condition to ErrNotStored
+func (s *Store) put(k string, v interface{}, expires time.Duration, condition string) error {
+	b, err := utils.Serialize(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:                 aws.String(s.table),
+		Item:                      s.item(k, b, s.ttl(expires)),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  map[string]string{"#ttl": attrTTL},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": now()},
+	})
+	if isConditionFailed(err) {
+		return persistence.ErrNotStored
+	}
+	return err
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(k string) error {
+	out, err := s.client.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName:    aws.String(s.table),
+		Key:          key(k),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return err
+	}
+	if b, _ := value(out.Attributes); b == nil {
+		return persistence.ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(k string, delta uint64) (uint64, error) {
+	return s.add(k, func(cur uint64) uint64 { return cur + delta })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(k string, delta uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(k, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// add applies op to the integer stored under key with an optimistic
+// compare-and-set on the old value, keeping the item's TTL
+func (s *Store) add(k string, op func(uint64) uint64) (uint64, error) {
+	for i := 0; i < maxCASRetries; i++ {
+		out, err := s.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+			TableName:      aws.String(s.table),
+			Key:            key(k),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return 0, err
+		}
+		b, ttl := value(out.Item)
+		if b == nil {
+			return 0, persistence.ErrCacheMiss
+		}
+		cur, err := strconv.ParseUint(string(b), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		result := op(cur)
+		_, err = s.client.PutItem(context.Background(), &dynamodb.PutItemInput{
+			TableName:                 aws.String(s.table),
+			Item:                      s.item(k, []byte(strconv.FormatUint(result, 10)), ttl),
+			ConditionExpression:       aws.String(conditionSame),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":old": &types.AttributeValueMemberB{Value: b}},
+		})
+		if isConditionFailed(err) {
+			// somebody else wrote in between, try again
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return result, nil
+	}
+	return 0, persistence.ErrNotStored
+}
+
+// GetExpiresIn (see CacheStore interface). DynamoDB TTLs have second granularity.
+func (s *Store) GetExpiresIn(k string) (time.Duration, error) {
+	out, err := s.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            key(k),
+		ConsistentRead: aws.Bool(s.consistentRead),
+	})
+	if err != nil {
+		return 0, err
+	}
+	b, ttl := value(out.Item)
+	if b == nil {
+		return 0, persistence.ErrCacheMiss
+	}
+	if ttl == 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Until(time.Unix(ttl, 0)), nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	return persistence.ErrNotSupport
+}
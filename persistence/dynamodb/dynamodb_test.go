@@ -0,0 +1,180 @@
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDynamoDB keeps items in a map and evaluates the store's condition expressions
+type fakeDynamoDB struct {
+	sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: map[string]map[string]types.AttributeValue{}}
+}
+
+func keyOf(item map[string]types.AttributeValue) string {
+	return item[attrKey].(*types.AttributeValueMemberS).Value
+}
+
+func (f *fakeDynamoDB) GetItem(_ context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	return &dynamodb.GetItemOutput{Item: f.items[keyOf(in.Key)]}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(_ context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	k := keyOf(in.Item)
+	old, exists := f.items[k]
+	live := exists
+	if exists {
+		if n, ok := old[attrTTL].(*types.AttributeValueMemberN); ok {
+			ttl, _ := strconv.ParseInt(n.Value, 10, 64)
+			live = ttl >= time.Now().Unix()
+		}
+	}
+	var ok bool
+	switch aws.ToString(in.ConditionExpression) {
+	case "":
+		ok = true
+	case conditionNotSet:
+		ok = !live
+	case conditionLive:
+		ok = live
+	case conditionSame:
+		ok = exists && bytes.Equal(old[attrValue].(*types.AttributeValueMemberB).Value,
+			in.ExpressionAttributeValues[":old"].(*types.AttributeValueMemberB).Value)
+	}
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	f.items[k] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(_ context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	k := keyOf(in.Key)
+	old := f.items[k]
+	delete(f.items, k)
+	return &dynamodb.DeleteItemOutput{Attributes: old}, nil
+}
+
+func (f *fakeDynamoDB) BatchGetItem(_ context.Context, in *dynamodb.BatchGetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	out := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]types.AttributeValue{}}
+	for table, ka := range in.RequestItems {
+		for _, k := range ka.Keys {
+			if item, ok := f.items[keyOf(k)]; ok {
+				out.Responses[table] = append(out.Responses[table], item)
+			}
+		}
+	}
+	return out, nil
+}
+
+func newStore(defaultExpiration time.Duration) *Store {
+	return NewStore(newFakeDynamoDB(), "cache", defaultExpiration)
+}
+
+func TestDynamoDB_TypicalGetSet(t *testing.T) {
+	s := newStore(time.Hour)
+	if err := s.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := s.Get("value", &value); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if value != "foo" {
+		t.Errorf("Expected to get foo back, got %s", value)
+	}
+}
+
+func TestDynamoDB_Mget(t *testing.T) {
+	s := newStore(time.Hour)
+	values := make([]interface{}, 150)
+	keys := make([]string, 150)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+		s.Set(keys[i], i, persistence.DEFAULT)
+		values[i] = new(int)
+	}
+	if err := s.Mget(values, keys...); err != nil {
+		t.Fatalf("Error in mget: %s", err)
+	}
+	for i, v := range values {
+		if *(v.(*int)) != i {
+			t.Errorf("expected %d, got %d", i, *(v.(*int)))
+		}
+	}
+	var missing int
+	if err := s.Mget([]interface{}{&missing}, "missing"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestDynamoDB_IncrDecr(t *testing.T) {
+	s := newStore(time.Hour)
+	if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("int", 10, persistence.DEFAULT)
+	if n, err := s.Increment("int", 50); err != nil || n != 60 {
+		t.Errorf("Expected 60, was %d (%v)", n, err)
+	}
+	if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+		t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+	}
+}
+
+func TestDynamoDB_AddReplaceDelete(t *testing.T) {
+	s := newStore(time.Hour)
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Replace("key", 3, persistence.DEFAULT); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestDynamoDB_ExpiredItemsAreMisses(t *testing.T) {
+	s := newStore(time.Hour)
+	// items that DynamoDB hasn't reaped yet must still read as missing
+	expired := s.item("expired", []byte("1"), time.Now().Add(-time.Minute).Unix())
+	s.client.(*fakeDynamoDB).items["expired"] = expired
+	var v int
+	if err := s.Get("expired", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := s.Add("expired", 2, persistence.DEFAULT); err != nil {
+		t.Errorf("expected Add to overwrite an expired item: %s", err)
+	}
+}
@@ -0,0 +1,199 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// ErrSerialization is matched by the *SerializationError returned when a value
+	// can't be serialized or deserialized
+	ErrSerialization = errors.New("cache: serialization failed.")
+	// ErrConn is matched by the *ConnError returned when the store can't be reached
+	ErrConn = errors.New("cache: connection failed.")
+	// ErrInvalidArgument is matched by the errors returned for calls made with invalid
+	// arguments, such as *ValueCountError
+	ErrInvalidArgument = errors.New("cache: invalid argument.")
+	// ErrDecodeFailed is matched by the *SerializationError returned when a stored value
+	// can't be deserialized
+	ErrDecodeFailed = errors.New("cache: decoding failed.")
+	// ErrNotInteger is returned when incrementing or decrementing a value that isn't an integer
+	ErrNotInteger = errors.New("cache: value is not an integer.")
+	// ErrWrongType is matched by the *WrongTypeError returned when reading a key that
+	// holds another type of entry than a cached value, such as a hash
+	ErrWrongType = errors.New("cache: wrong type of entry.")
+	// ErrInvalidKey is matched by the *InvalidKeyError returned for keys a
+	// KeyValidatingStore rejects
+	ErrInvalidKey = errors.New("cache: invalid key.")
+)
+
+// SerializationError is returned when the value of Key can't be serialized or
+// deserialized; errors.Is(err, ErrSerialization) reports true for it, and
+// errors.Is(err, ErrDecodeFailed) too when it is about deserializing
+type SerializationError struct {
+	Key   string
+	Cause error
+	// Decoding is set when deserializing failed
+	Decoding bool
+}
+
+func (e *SerializationError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("cache: serialization failed: %v", e.Cause)
+	}
+	return fmt.Sprintf("cache: serialization of %s failed: %v", e.Key, e.Cause)
+}
+
+// Unwrap returns the codec error
+func (e *SerializationError) Unwrap() error {
+	return e.Cause
+}
+
+// Is makes errors.Is match ErrSerialization, and ErrDecodeFailed when decoding
+func (e *SerializationError) Is(target error) bool {
+	return target == ErrSerialization || (e.Decoding && target == ErrDecodeFailed)
+}
+
+// ConnError is returned when the store can't be reached, wrapping the error of the
+// client, such as a network error or a timeout; errors.Is(err, ErrConn) reports true
+// for it. The error replies of the server aren't ConnErrors.
+type ConnError struct {
+	Cause error
+}
+
+func (e *ConnError) Error() string {
+	return fmt.Sprintf("cache: connection failed: %v", e.Cause)
+}
+
+// Unwrap returns the client error
+func (e *ConnError) Unwrap() error {
+	return e.Cause
+}
+
+// Is makes errors.Is match ErrConn
+func (e *ConnError) Is(target error) bool {
+	return target == ErrConn
+}
+
+// Retryable reports true: the store may well be reachable again on the next attempt
+func (e *ConnError) Retryable() bool {
+	return true
+}
+
+// WrongTypeError is returned when reading a key holding another type of entry than a
+// cached value; errors.Is(err, ErrWrongType) reports true for it
+type WrongTypeError struct {
+	Key string
+	// Type is the type of the entry as reported by the redis TYPE command, e.g. "hash"
+	Type  string
+	Cause error
+}
+
+func (e *WrongTypeError) Error() string {
+	return fmt.Sprintf("cache: %s holds a %s, not a value.", e.Key, e.Type)
+}
+
+// Unwrap returns the error reply of the server
+func (e *WrongTypeError) Unwrap() error {
+	return e.Cause
+}
+
+// Is makes errors.Is match ErrWrongType
+func (e *WrongTypeError) Is(target error) bool {
+	return target == ErrWrongType
+}
+
+// ValueCountError is returned by the multi-key operations when the number of values
+// doesn't match the number of keys; errors.Is(err, ErrInvalidArgument) reports true for it
+type ValueCountError struct {
+	Got      int
+	Required int
+}
+
+func (e *ValueCountError) Error() string {
+	return fmt.Sprintf("Length of value array is different from number of keys. Got %v, requires %v", e.Got, e.Required)
+}
+
+// Is makes errors.Is match ErrInvalidArgument
+func (e *ValueCountError) Is(target error) bool {
+	return target == ErrInvalidArgument
+}
+
+// KeyTypeError is returned by MSetNX when a key isn't a string; errors.Is(err,
+// ErrInvalidArgument) reports true for it
+type KeyTypeError struct {
+	Index int
+	Key   interface{}
+}
+
+func (e *KeyTypeError) Error() string {
+	return fmt.Sprintf("cache: key %v: %v is not a string.", e.Index, e.Key)
+}
+
+// Is makes errors.Is match ErrInvalidArgument
+func (e *KeyTypeError) Is(target error) bool {
+	return target == ErrInvalidArgument
+}
+
+// InvalidKeyError is returned by a KeyValidatingStore for a key it rejects, with the
+// reason why; errors.Is(err, ErrInvalidKey) and errors.Is(err, ErrInvalidArgument)
+// report true for it
+type InvalidKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("cache: invalid key %q: %s.", e.Key, e.Reason)
+}
+
+// Is makes errors.Is match ErrInvalidKey and ErrInvalidArgument
+func (e *InvalidKeyError) Is(target error) bool {
+	return target == ErrInvalidKey || target == ErrInvalidArgument
+}
+
+// retryableReplies are the prefixes of the redis error replies for conditions expected
+// to clear up by themselves, such as a server loading its dataset or a failover
+var retryableReplies = []string{"LOADING", "BUSY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN", "READONLY"}
+
+// IsRetryable reports whether err is transient, so the operation may succeed if tried
+// again: connection failures and timeouts, and the redis error replies of a server
+// loading its data or failing over. Misses, serialization failures, invalid arguments
+// and the other error replies (e.g. WRONGTYPE) are permanent.
+//
+// Errors can tag themselves with a Retryable() bool method, which is honored anywhere
+// in their chain.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var tagged interface{ Retryable() bool }
+	if errors.As(err, &tagged) {
+		return tagged.Retryable()
+	}
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		for _, prefix := range retryableReplies {
+			if strings.HasPrefix(string(reply), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,109 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestRedisStore_TypedErrors(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour)
+	store.Set("key", "value", DEFAULT)
+
+	var n int
+	err := store.Get("key", &n)
+	var serErr *SerializationError
+	if !errors.Is(err, ErrSerialization) || !errors.As(err, &serErr) || serErr.Key != "key" {
+		t.Errorf("expected a SerializationError for key, got %v", err)
+	}
+	if err := store.Set("key", func() {}, DEFAULT); !errors.Is(err, ErrSerialization) {
+		t.Errorf("expected a SerializationError, got %v", err)
+	}
+
+	var a, b string
+	err = store.Mget([]interface{}{&a, &b}, "key")
+	var countErr *ValueCountError
+	if !errors.Is(err, ErrInvalidArgument) || !errors.As(err, &countErr) || countErr.Got != 2 || countErr.Required != 1 {
+		t.Errorf("expected a ValueCountError, got %v", err)
+	}
+	if err := store.MSetNX(DEFAULT, "a", 1, 2, 3); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected a KeyTypeError, got %v", err)
+	}
+
+	// nothing listens on a closed listener's address
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	err = NewRedisCache(l.Addr().String(), "", time.Hour).Get("key", &a)
+	var connErr *ConnError
+	if !errors.Is(err, ErrConn) || !errors.As(err, &connErr) {
+		t.Errorf("expected a ConnError rather than a miss, got %v", err)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		t.Errorf("expected the network error to be wrapped, got %v", err)
+	}
+	if !IsRetryable(err) {
+		t.Errorf("expected %v to be retryable", err)
+	}
+}
+
+func TestInMemoryStore_NotInteger(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	store.Set("key", "value", DEFAULT)
+	if _, err := store.Increment("key", 1); err != ErrNotInteger {
+		t.Errorf("expected ErrNotInteger, got %v", err)
+	}
+}
+
+func TestSerializationError_Unwrap(t *testing.T) {
+	err := error(&SerializationError{Key: "key", Cause: &utils.DecodeError{Panic: "boom"}})
+	var decodeErr *utils.DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Errorf("expected the codec error to be wrapped, got %v", err)
+	}
+}
+
+// tagged is an error of another store tagging itself
+type tagged bool
+
+func (t tagged) Error() string   { return "tagged" }
+func (t tagged) Retryable() bool { return bool(t) }
+
+func TestIsRetryable(t *testing.T) {
+	for _, c := range []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{ErrCacheMiss, false},
+		{ErrNotStored, false},
+		{&SerializationError{Key: "key", Cause: errors.New("bad")}, false},
+		{&ValueCountError{Got: 1, Required: 2}, false},
+		{&ConnError{Cause: errors.New("dial tcp: connection refused")}, true},
+		{redis.Error("LOADING Redis is loading the dataset in memory"), true},
+		{redis.Error("READONLY You can't write against a read only replica."), true},
+		{redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{fmt.Errorf("eval: %w", redis.Error("BUSY Redis is busy running a script")), true},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("write: %w", syscall.ECONNRESET), true},
+		{tagged(true), true},
+		{fmt.Errorf("wrapped: %w", tagged(false)), false},
+	} {
+		if got := IsRetryable(c.err); got != c.retryable {
+			t.Errorf("IsRetryable(%v) = %v, expected %v", c.err, got, c.retryable)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package persistence
+
+import (
+	"errors"
+	"expvar"
+	"time"
+)
+
+// ExpvarMiddleware returns a Middleware counting the operations in a map published
+// with expvar as name, so they show at /debug/vars: calls and errors by operation
+// ("calls.get", "errors.set"...), the nanoseconds operations took ("duration_ns.get"),
+// and the Get operations finding their key or not ("hits", "misses"). Misses aren't
+// counted as errors. Like expvar.Publish, it panics if name is already published.
+func ExpvarMiddleware(name string) Middleware {
+	m := expvar.NewMap(name)
+	return func(op Op, key string, next func() error) error {
+		start := time.Now()
+		err := next()
+		m.Add("duration_ns."+string(op), int64(time.Since(start)))
+		m.Add("calls."+string(op), 1)
+		switch {
+		case errors.Is(err, ErrCacheMiss):
+			if op == OpGet {
+				m.Add("misses", 1)
+			}
+		case err != nil && !errors.Is(err, ErrNilValue):
+			m.Add("errors."+string(op), 1)
+		case op == OpGet:
+			m.Add("hits", 1)
+		}
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+package persistence
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWithExpvar(t *testing.T) {
+	s := NewMiddlewareStore(NewInMemoryStore(time.Hour, WithExpvar("test_inmemory")), WithExpvar("test_middleware"))
+	s.Set("key", 1, DEFAULT)
+	var v int
+	s.Get("key", &v)
+	s.Get("missing", &v)
+	s.Replace("missing", 1, DEFAULT)
+
+	m := expvar.Get("test_middleware").(*expvar.Map)
+	for name, expected := range map[string]string{
+		"calls.get": "2", "calls.set": "1", "hits": "1", "misses": "1", "errors.replace": "1",
+	} {
+		if got := m.Get(name); got == nil || got.String() != expected {
+			t.Errorf("expected %s to be %s, got %v", name, expected, got)
+		}
+	}
+	if m.Get("duration_ns.get") == nil {
+		t.Error("expected the duration of gets to be published")
+	}
+
+	var stats InMemoryStats
+	if err := json.Unmarshal([]byte(expvar.Get("test_inmemory").String()), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 1 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestExpvarMiddleware_WrappedErrors(t *testing.T) {
+	mw := ExpvarMiddleware("test_wrapped")
+	mw(OpGet, "key", func() error { return fmt.Errorf("shard 1: %w", ErrCacheMiss) })
+	mw(OpGet, "key", func() error { return fmt.Errorf("shard 1: %w", ErrNilValue) })
+
+	m := expvar.Get("test_wrapped").(*expvar.Map)
+	if got := m.Get("misses"); got == nil || got.String() != "1" {
+		t.Errorf("expected the wrapped miss to count as a miss, got %v", got)
+	}
+	if got := m.Get("hits"); got == nil || got.String() != "1" {
+		t.Errorf("expected the wrapped nil value to count as a hit, got %v", got)
+	}
+	if got := m.Get("errors.get"); got != nil {
+		t.Errorf("expected no errors, got %v", got)
+	}
+}
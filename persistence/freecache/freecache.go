@@ -0,0 +1,188 @@
+// Package freecache provides an off-heap CacheStore backed by coocood/freecache.
+// Serialized entries live in a handful of large pre-allocated byte segments, so
+// the GC has almost no pointers to scan no matter how many entries are cached.
+// Use it when caching tens of millions of small entries makes GC pauses the
+// constraint; for small caches the InMemoryStore avoids the serialization cost.
+package freecache
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/coocood/freecache"
+)
+
+// Store represents the cache with off-heap persistence
+type Store struct {
+	cache             *freecache.Cache
+	defaultExpiration time.Duration
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// NewStore returns a Store holding up to size bytes (at least 512KB). Values
+// larger than 1/1024 of size are rejected with ErrNotStored.
+func NewStore(size int, defaultExpiration time.Duration) *Store {
+	return &Store{freecache.NewCache(size), defaultExpiration}
+}
+
+// expireSeconds converts the expiration to freecache's seconds, where 0 means
+// the entry never expires. Sub-second expirations round up to one second.
+func (s *Store) expireSeconds(expires time.Duration) int {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return int((expires + time.Second - 1) / time.Second)
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, value interface{}) error {
+	b, err := s.cache.Get([]byte(key))
+	if err != nil {
+		return convertFreecacheError(err)
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	return convertFreecacheError(s.cache.Set([]byte(key), b, s.expireSeconds(expires)))
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	return s.update(key, value, expires, false)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.update(key, value, expires, true)
+}
+
+// update atomically writes the value if the key's existence matches exists
+func (s *Store) update(key string, value interface{}, expires time.Duration, exists bool) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	exp := s.expireSeconds(expires)
+	_, replaced, err := s.cache.Update([]byte(key), func(_ []byte, found bool) ([]byte, bool, int) {
+		return b, found == exists, exp
+	})
+	if err != nil {
+		return convertFreecacheError(err)
+	}
+	if !replaced {
+		return persistence.ErrNotStored
+	}
+	return nil
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	if !s.cache.Del([]byte(key)) {
+		return persistence.ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(cur uint64) uint64 { return cur + delta })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(key, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// add atomically applies op to the integer stored under key, keeping its expiration
+func (s *Store) add(key string, op func(uint64) uint64) (uint64, error) {
+	k := []byte(key)
+	// freecache can't report the TTL from inside Update, so read it first; the
+	// expiration is only lost if the key is rewritten in between
+	_, expireAt, err := s.cache.GetWithExpiration(k)
+	if err != nil {
+		return 0, convertFreecacheError(err)
+	}
+	exp := 0
+	if expireAt > 0 {
+		if exp = int(int64(expireAt) - time.Now().Unix()); exp <= 0 {
+			exp = 1
+		}
+	}
+	var result uint64
+	var opErr error
+	found, _, err := s.cache.Update(k, func(value []byte, found bool) ([]byte, bool, int) {
+		if !found {
+			return nil, false, 0
+		}
+		cur, err := strconv.ParseUint(string(value), 10, 64)
+		if err != nil {
+			opErr = err
+			return nil, false, 0
+		}
+		result = op(cur)
+		return []byte(strconv.FormatUint(result, 10)), true, exp
+	})
+	if err != nil {
+		return 0, convertFreecacheError(err)
+	}
+	if !found {
+		return 0, persistence.ErrCacheMiss
+	}
+	return result, opErr
+}
+
+// GetExpiresIn (see CacheStore interface). freecache keeps expirations to the second.
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	_, expireAt, err := s.cache.GetWithExpiration([]byte(key))
+	if err != nil {
+		return 0, convertFreecacheError(err)
+	}
+	if expireAt == 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Until(time.Unix(int64(expireAt), 0)), nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	s.cache.Clear()
+	return nil
+}
+
+func convertFreecacheError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case freecache.ErrNotFound:
+		return persistence.ErrCacheMiss
+	case freecache.ErrLargeKey, freecache.ErrLargeEntry:
+		return persistence.ErrNotStored
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package freecache
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+func TestFreecache_TypicalGetSet(t *testing.T) {
+	s := NewStore(1024*1024, time.Hour)
+	type user struct{ Name string }
+	if err := s.Set("user", user{"alice"}, persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var u user
+	if err := s.Get("user", &u); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if u.Name != "alice" {
+		t.Errorf("Expected to get alice back, got %s", u.Name)
+	}
+}
+
+func TestFreecache_IncrDecr(t *testing.T) {
+	s := NewStore(1024*1024, time.Hour)
+	if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("int", 10, persistence.DEFAULT)
+	if n, err := s.Increment("int", 50); err != nil || n != 60 {
+		t.Errorf("Expected 60, was %d (%v)", n, err)
+	}
+	if n, err := s.Increment("int", math.MaxUint64-5); err != nil || n != 54 {
+		t.Errorf("Expected wraparound 54, was %d (%v)", n, err)
+	}
+	if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+		t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+	}
+	var v int
+	if err := s.Get("int", &v); err != nil || v != 0 {
+		t.Errorf("Expected 0, was %d (%v)", v, err)
+	}
+}
+
+func TestFreecache_AddReplaceDelete(t *testing.T) {
+	s := NewStore(1024*1024, time.Hour)
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Replace("key", 3, persistence.DEFAULT); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestFreecache_Expiration(t *testing.T) {
+	s := NewStore(1024*1024, time.Second)
+	s.Set("int", 10, persistence.DEFAULT)
+	s.Set("forever", 10, persistence.FOREVER)
+	time.Sleep(2 * time.Second)
+	var v int
+	if err := s.Get("int", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := s.Get("forever", &v); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package groupcache provides a CacheStore over golang/groupcache, letting a
+// fleet of pods share an L1 for read-mostly data without a central Redis.
+//
+// groupcache is self-filling: a miss on the peer that owns a key calls the
+// Loader exactly once (concurrent callers share the result) and every other
+// peer fetches the value from the owner. Entries are immutable and only leave
+// the cache through LRU eviction, so Set, Add, Replace, Delete, Increment,
+// Decrement and Flush all return ErrNotSupport. Peers are wired up the usual
+// groupcache way, e.g. with groupcache.NewHTTPPool(self).Set(peers...).
+package groupcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/golang/groupcache"
+)
+
+// Loader fills the cache for a key on the peer that owns it. Returning
+// persistence.ErrCacheMiss makes Get return ErrCacheMiss.
+type Loader func(ctx context.Context, key string) (interface{}, error)
+
+// Store represents the cache with groupcache persistence
+type Store struct {
+	group *groupcache.Group
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// NewStore returns a Store for the groupcache group name, holding up to
+// cacheBytes of serialized values on this peer. Group names are global to the
+// process, creating two stores with the same name panics.
+func NewStore(name string, cacheBytes int64, loader Loader) *Store {
+	getter := groupcache.GetterFunc(func(ctx context.Context, key string, dest groupcache.Sink) error {
+		v, err := loader(ctx, key)
+		if err != nil {
+			return err
+		}
+		b, err := utils.Serialize(v)
+		if err != nil {
+			return err
+		}
+		return dest.SetBytes(b)
+	})
+	return &Store{groupcache.NewGroup(name, cacheBytes, getter)}
+}
+
+// Group returns the underlying groupcache group, e.g. to read its stats
+func (s *Store) Group() *groupcache.Group {
+	return s.group
+}
+
+// GetWithContext is Get with a context passed on to the Loader and peer requests
+func (s *Store) GetWithContext(ctx context.Context, key string, value interface{}) error {
+	var b []byte
+	if err := s.group.Get(ctx, key, groupcache.AllocatingByteSliceSink(&b)); err != nil {
+		if err == persistence.ErrCacheMiss || err.Error() == persistence.ErrCacheMiss.Error() {
+			// errors from remote peers only keep their message
+			return persistence.ErrCacheMiss
+		}
+		return err
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, value interface{}) error {
+	return s.GetWithContext(context.Background(), key, value)
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	return persistence.ErrNotSupport
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	return persistence.ErrNotSupport
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	return persistence.ErrNotSupport
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	return persistence.ErrNotSupport
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return 0, persistence.ErrNotSupport
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	return 0, persistence.ErrNotSupport
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	return persistence.ErrNotSupport
+}
+
+// GetExpiresIn (see CacheStore interface). groupcache entries don't expire.
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	return 0, persistence.ErrNotSupport
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
@@ -0,0 +1,41 @@
+package groupcache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Bose/cache/persistence"
+)
+
+func TestGroupcache_SelfFilling(t *testing.T) {
+	var loads int32
+	s := NewStore("test-self-filling", 1<<20, func(_ context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		if key == "missing" {
+			return nil, persistence.ErrCacheMiss
+		}
+		return "value for " + key, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		var v string
+		if err := s.Get("a", &v); err != nil {
+			t.Fatalf("Error getting a value: %s", err)
+		}
+		if v != "value for a" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("expected the loader to be called once, got %d", n)
+	}
+
+	var v string
+	if err := s.Get("missing", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := s.Set("a", "other", persistence.DEFAULT); err != persistence.ErrNotSupport {
+		t.Errorf("expected ErrNotSupport, got %v", err)
+	}
+}
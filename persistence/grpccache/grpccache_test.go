@@ -0,0 +1,101 @@
+package grpccache
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/persistence/freecache"
+	"github.com/Bose/cache/persistence/groupcache"
+	"github.com/Bose/cache/persistence/grpccache/cachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newStore(t *testing.T, backend persistence.CacheStore) *Store {
+	t.Helper()
+	l := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	cachepb.RegisterCacheServer(srv, NewServer(backend))
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewStore(conn)
+}
+
+func TestGRPC_TypicalGetSet(t *testing.T) {
+	s := newStore(t, freecache.NewStore(1<<20, time.Hour))
+	if err := s.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := s.Get("value", &value); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if value != "foo" {
+		t.Errorf("Expected to get foo back, got %s", value)
+	}
+	if err := s.Get("missing", &value); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestGRPC_IncrDecr(t *testing.T) {
+	// the in-memory store keeps the []byte values the server hands it as is, rather
+	// than serializing them, and can't increment them itself
+	for name, backend := range map[string]persistence.CacheStore{
+		"serializing": freecache.NewStore(1<<20, time.Hour),
+		"in-memory":   persistence.NewInMemoryStore(time.Hour),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t, backend)
+			if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss, got %v", err)
+			}
+			s.Set("int", 10, persistence.DEFAULT)
+			if n, err := s.Increment("int", 50); err != nil || n != 60 {
+				t.Errorf("Expected 60, was %d (%v)", n, err)
+			}
+			if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+				t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+			}
+		})
+	}
+}
+
+func TestGRPC_AddReplaceDelete(t *testing.T) {
+	s := newStore(t, freecache.NewStore(1<<20, time.Hour))
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestGRPC_NotSupported(t *testing.T) {
+	s := newStore(t, groupcache.NewStore("grpccache-test", 1<<20, func(context.Context, string) (interface{}, error) {
+		return nil, persistence.ErrCacheMiss
+	}))
+	if err := s.Set("key", 1, persistence.DEFAULT); err != persistence.ErrNotSupport {
+		t.Errorf("expected ErrNotSupport, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+// Package grpccache serves a CacheStore over gRPC and provides a CacheStore
+// that talks to such a server, so cache access from many services can go
+// through one place where auth and quotas are enforced (with interceptors on
+// the grpc.Server).
+//
+// Values travel serialized with utils.Serialize: the server hands them to its
+// store as []byte and the client store deserializes them on Get.
+package grpccache
+
+import (
+	"context"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/persistence/grpccache/cachepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements cachepb.CacheServer on top of a CacheStore
+type Server struct {
+	cachepb.UnimplementedCacheServer
+	store persistence.CacheStore
+}
+
+var _ cachepb.CacheServer = &Server{}
+
+// NewServer returns a Server fronting the store, to be registered with
+// cachepb.RegisterCacheServer
+func NewServer(store persistence.CacheStore) *Server {
+	return &Server{store: store}
+}
+
+// toStatus maps the persistence errors onto gRPC status codes
+func toStatus(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case persistence.ErrCacheMiss:
+		return status.Error(codes.NotFound, err.Error())
+	case persistence.ErrNotStored:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case persistence.ErrNotSupport:
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Get (see cachepb.CacheServer)
+func (s *Server) Get(_ context.Context, req *cachepb.GetRequest) (*cachepb.GetResponse, error) {
+	var b []byte
+	if err := s.store.Get(req.Key, &b); err != nil {
+		return nil, toStatus(err)
+	}
+	return &cachepb.GetResponse{Value: b}, nil
+}
+
+// Set (see cachepb.CacheServer)
+func (s *Server) Set(_ context.Context, req *cachepb.StoreRequest) (*cachepb.StoreResponse, error) {
+	if err := s.store.Set(req.Key, req.Value, time.Duration(req.Expires)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &cachepb.StoreResponse{}, nil
+}
+
+// Add (see cachepb.CacheServer)
+func (s *Server) Add(_ context.Context, req *cachepb.StoreRequest) (*cachepb.StoreResponse, error) {
+	if err := s.store.Add(req.Key, req.Value, time.Duration(req.Expires)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &cachepb.StoreResponse{}, nil
+}
+
+// Replace (see cachepb.CacheServer)
+func (s *Server) Replace(_ context.Context, req *cachepb.StoreRequest) (*cachepb.StoreResponse, error) {
+	if err := s.store.Replace(req.Key, req.Value, time.Duration(req.Expires)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &cachepb.StoreResponse{}, nil
+}
+
+// Delete (see cachepb.CacheServer)
+func (s *Server) Delete(_ context.Context, req *cachepb.DeleteRequest) (*cachepb.DeleteResponse, error) {
+	if err := s.store.Delete(req.Key); err != nil {
+		return nil, toStatus(err)
+	}
+	return &cachepb.DeleteResponse{}, nil
+}
+
+// Increment (see cachepb.CacheServer)
+func (s *Server) Increment(_ context.Context, req *cachepb.IncrementRequest) (*cachepb.IncrementResponse, error) {
+	n, err := persistence.IncrementBytes(s.store, req.Key, req.Delta)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &cachepb.IncrementResponse{Value: n}, nil
+}
+
+// Decrement (see cachepb.CacheServer)
+func (s *Server) Decrement(_ context.Context, req *cachepb.IncrementRequest) (*cachepb.IncrementResponse, error) {
+	n, err := persistence.DecrementBytes(s.store, req.Key, req.Delta)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &cachepb.IncrementResponse{Value: n}, nil
+}
+
+// Flush (see cachepb.CacheServer)
+func (s *Server) Flush(_ context.Context, _ *cachepb.FlushRequest) (*cachepb.FlushResponse, error) {
+	if err := s.store.Flush(); err != nil {
+		return nil, toStatus(err)
+	}
+	return &cachepb.FlushResponse{}, nil
+}
@@ -0,0 +1,128 @@
+package grpccache
+
+import (
+	"context"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/persistence/grpccache/cachepb"
+	"github.com/Bose/cache/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store represents the cache persisted by a remote Server
+type Store struct {
+	client cachepb.CacheClient
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// NewStore returns a Store calling the Server over the connection. Expirations
+// are passed through as is, so DEFAULT means the server store's default.
+func NewStore(conn grpc.ClientConnInterface) *Store {
+	return &Store{client: cachepb.NewCacheClient(conn)}
+}
+
+// fromStatus maps gRPC status codes back onto the persistence errors
+func fromStatus(err error) error {
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound:
+		return persistence.ErrCacheMiss
+	case codes.FailedPrecondition:
+		return persistence.ErrNotStored
+	case codes.Unimplemented:
+		return persistence.ErrNotSupport
+	}
+	return err
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, ptrValue interface{}) error {
+	resp, err := s.client.Get(context.Background(), &cachepb.GetRequest{Key: key})
+	if err != nil {
+		return fromStatus(err)
+	}
+	return utils.Deserialize(resp.Value, ptrValue)
+}
+
+// Mget retrieves a list of items for the list of keys provided.
+// If any item does not exist, an ErrCacheMiss is returned.
+func (s *Store) Mget(ptrValue []interface{}, keys ...string) error {
+	if len(ptrValue) != len(keys) {
+		return &persistence.ValueCountError{Got: len(ptrValue), Required: len(keys)}
+	}
+	for i, key := range keys {
+		if err := s.Get(key, ptrValue[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) store(call func(context.Context, *cachepb.StoreRequest, ...grpc.CallOption) (*cachepb.StoreResponse, error), key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	_, err = call(context.Background(), &cachepb.StoreRequest{Key: key, Value: b, Expires: int64(expires)})
+	return fromStatus(err)
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	return s.store(s.client.Set, key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	return s.store(s.client.Add, key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.store(s.client.Replace, key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	_, err := s.client.Delete(context.Background(), &cachepb.DeleteRequest{Key: key})
+	return fromStatus(err)
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	resp, err := s.client.Increment(context.Background(), &cachepb.IncrementRequest{Key: key, Delta: delta})
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	return resp.Value, nil
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	resp, err := s.client.Decrement(context.Background(), &cachepb.IncrementRequest{Key: key, Delta: delta})
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	return resp.Value, nil
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	_, err := s.client.Flush(context.Background(), &cachepb.FlushRequest{})
+	return fromStatus(err)
+}
+
+// GetExpiresIn (see CacheStore interface). The Cache service has no RPC for it yet.
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	return 0, persistence.ErrNotSupport
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
@@ -0,0 +1,247 @@
+// Package httpcache serves a CacheStore over a small REST API and provides a
+// CacheStore that talks to it, for debugging, edge caching and consumers that
+// aren't written in Go.
+//
+// Items live at the path of their key, relative to where the Handler is mounted:
+//
+//	GET    /{key}                  the value
+//	GET    /{key}?ttl              milliseconds until the key expires, -1 if it never does
+//	PUT    /{key}?ttl=30s          Set; "If-None-Match: *" makes it an Add and "If-Match: *" a Replace
+//	POST   /{key}?op=incr&delta=1  Increment (or op=decr), answering the new value
+//	DELETE /{key}                  Delete
+//	DELETE /                       Flush
+//
+// A PUT without ttl uses the store's default expiration and ttl=forever never expires.
+// Values are stored exactly as sent. Go clients send utils.Serialize output
+// (application/x-gob), other clients can send and request application/json; a
+// value that isn't valid JSON is answered with 406 to a client only accepting JSON.
+// Cache misses are answered with 404, items not stored with 412 and operations
+// the store doesn't support with 501.
+package httpcache
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+const (
+	// ContentTypeGob marks values serialized with utils.Serialize
+	ContentTypeGob = "application/x-gob"
+	// ContentTypeJSON marks JSON values
+	ContentTypeJSON = "application/json"
+
+	defaultMaxBodySize = 32 << 20
+)
+
+// Handler is an http.Handler exposing a CacheStore
+type Handler struct {
+	store       persistence.CacheStore
+	maxBodySize int64
+}
+
+var _ http.Handler = &Handler{}
+
+const optionWithMaxBodySize = "optionWithHTTPMaxBodySize"
+
+// WithMaxBodySize sets the largest value the Handler accepts, 32MB by default
+func WithMaxBodySize(n int64) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithMaxBodySize] = n
+	}
+}
+
+// NewHandler returns a Handler fronting the store; mount it with http.StripPrefix
+// when it doesn't serve the root
+func NewHandler(store persistence.CacheStore, opt ...persistence.Option) *Handler {
+	opts := persistence.GetOpts(opt...)
+	h := &Handler{store: store, maxBodySize: defaultMaxBodySize}
+	if v, ok := opts[optionWithMaxBodySize].(int64); ok && v > 0 {
+		h.maxBodySize = v
+	}
+	return h
+}
+
+// statusCode maps the persistence errors onto HTTP status codes
+func statusCode(err error) int {
+	switch err {
+	case persistence.ErrCacheMiss:
+		return http.StatusNotFound
+	case persistence.ErrNotStored:
+		return http.StatusPreconditionFailed
+	case persistence.ErrNotSupport:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), statusCode(err))
+}
+
+// ServeHTTP (see http.Handler)
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if key == "" {
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", http.MethodDelete)
+			http.Error(w, "a key is required", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := h.store.Flush(); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if _, ok := r.URL.Query()["ttl"]; ok {
+			h.ttl(w, key)
+			return
+		}
+		h.get(w, r, key)
+	case http.MethodPut:
+		h.put(w, r, key)
+	case http.MethodPost:
+		h.incr(w, r, key)
+	case http.MethodDelete:
+		if err := h.store.Delete(key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// accepts reports whether the Accept header allows the media type
+func accepts(r *http.Request, mediaType string) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		t, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if t == "*/*" || t == mediaType || t == "application/*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, key string) {
+	var b []byte
+	if err := h.store.Get(key, &b); err != nil {
+		writeError(w, err)
+		return
+	}
+	switch {
+	case accepts(r, ContentTypeGob):
+		w.Header().Set("Content-Type", ContentTypeGob)
+	case accepts(r, ContentTypeJSON) && json.Valid(b):
+		w.Header().Set("Content-Type", ContentTypeJSON)
+	default:
+		http.Error(w, "the value is only available as "+ContentTypeGob, http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+// ttl writes the milliseconds left before key expires, -1 if it never does
+func (h *Handler) ttl(w http.ResponseWriter, key string) {
+	ttl, err := h.store.GetExpiresIn(key)
+	ms := ttl.Milliseconds()
+	if err == persistence.ErrCacheNoTTL {
+		ms, err = -1, nil
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, strconv.FormatInt(ms, 10))
+}
+
+// expiration parses the ttl query parameter
+func expiration(ttl string) (time.Duration, error) {
+	switch ttl {
+	case "", "default":
+		return persistence.DEFAULT, nil
+	case "forever":
+		return persistence.FOREVER, nil
+	}
+	return time.ParseDuration(ttl)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, key string) {
+	expires, err := expiration(r.URL.Query().Get("ttl"))
+	if err != nil {
+		http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); t == ContentTypeJSON && !json.Valid(b) {
+		http.Error(w, "invalid JSON value", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.Header.Get("If-None-Match") == "*":
+		err = h.store.Add(key, b, expires)
+	case r.Header.Get("If-Match") == "*":
+		err = h.store.Replace(key, b, expires)
+	default:
+		err = h.store.Set(key, b, expires)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) incr(w http.ResponseWriter, r *http.Request, key string) {
+	q := r.URL.Query()
+	delta := uint64(1)
+	if d := q.Get("delta"); d != "" {
+		var err error
+		if delta, err = strconv.ParseUint(d, 10, 64); err != nil {
+			http.Error(w, "invalid delta: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var n uint64
+	var err error
+	switch q.Get("op") {
+	case "incr":
+		n, err = persistence.IncrementBytes(h.store, key, delta)
+	case "decr":
+		n, err = persistence.DecrementBytes(h.store, key, delta)
+	default:
+		http.Error(w, "op must be incr or decr", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, strconv.FormatUint(n, 10))
+}
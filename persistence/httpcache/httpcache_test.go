@@ -0,0 +1,168 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/persistence/freecache"
+)
+
+func newStore(t *testing.T, backend persistence.CacheStore, opt ...persistence.Option) (*Store, *httptest.Server) {
+	t.Helper()
+	srv := httptest.NewServer(http.StripPrefix("/cache", NewHandler(backend)))
+	t.Cleanup(srv.Close)
+	return NewStore(srv.URL+"/cache", opt...), srv
+}
+
+func TestHTTP_TypicalGetSet(t *testing.T) {
+	s, _ := newStore(t, freecache.NewStore(1<<20, time.Hour))
+	if err := s.Set("a/value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := s.Get("a/value", &value); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if value != "foo" {
+		t.Errorf("Expected to get foo back, got %s", value)
+	}
+	if err := s.Get("missing", &value); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestHTTP_IncrDecr(t *testing.T) {
+	// the in-memory store keeps the []byte values the server hands it as is, rather
+	// than serializing them, and can't increment them itself
+	for name, backend := range map[string]persistence.CacheStore{
+		"serializing": freecache.NewStore(1<<20, time.Hour),
+		"in-memory":   persistence.NewInMemoryStore(time.Hour),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, _ := newStore(t, backend)
+			if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss, got %v", err)
+			}
+			s.Set("int", 10, persistence.DEFAULT)
+			if n, err := s.Increment("int", 50); err != nil || n != 60 {
+				t.Errorf("Expected 60, was %d (%v)", n, err)
+			}
+			if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+				t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+			}
+		})
+	}
+}
+
+func TestHTTP_AddReplaceDelete(t *testing.T) {
+	s, _ := newStore(t, freecache.NewStore(1<<20, time.Hour))
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Replace("key", 3, time.Minute); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Errorf("Error flushing: %s", err)
+	}
+}
+
+func TestHTTP_JSON(t *testing.T) {
+	type doc struct{ Name string }
+	s, srv := newStore(t, freecache.NewStore(1<<20, time.Hour), WithJSON())
+	if err := s.Set("doc", doc{"foo"}, persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var d doc
+	if err := s.Get("doc", &d); err != nil || d.Name != "foo" {
+		t.Errorf("Expected to get foo back, got %+v (%v)", d, err)
+	}
+
+	// non-Go consumers only see JSON
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/cache/doc", nil)
+	req.Header.Set("Accept", ContentTypeJSON)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != ContentTypeJSON || string(b) != `{"Name":"foo"}` {
+		t.Errorf("unexpected JSON response %s %q", resp.Header.Get("Content-Type"), b)
+	}
+
+	// gob values can't be offered as JSON
+	NewStore(srv.URL+"/cache").Set("gob", doc{"bar"}, persistence.DEFAULT)
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/cache/gob", nil)
+	req.Header.Set("Accept", ContentTypeJSON)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("expected 406, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, srv.URL+"/cache/bad", strings.NewReader("{"))
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid JSON, got %d", resp.StatusCode)
+	}
+}
+
+// ttlStore fakes the TTLs of a store for the ttl endpoint
+type ttlStore struct {
+	persistence.CacheStore
+}
+
+func (ttlStore) GetExpiresIn(key string) (time.Duration, error) {
+	switch key {
+	case "forever":
+		return 0, persistence.ErrCacheNoTTL
+	case "missing":
+		return 0, persistence.ErrCacheMiss
+	case "unsupported":
+		return 0, persistence.ErrNotSupport
+	}
+	return 1500 * time.Millisecond, nil
+}
+
+func TestHTTP_GetExpiresIn(t *testing.T) {
+	s, _ := newStore(t, ttlStore{freecache.NewStore(1<<20, time.Hour)})
+	if ttl, err := s.GetExpiresIn("key"); err != nil || ttl != 1500*time.Millisecond {
+		t.Errorf("expected 1.5s, got %v (%v)", ttl, err)
+	}
+	if _, err := s.GetExpiresIn("forever"); err != persistence.ErrCacheNoTTL {
+		t.Errorf("expected ErrCacheNoTTL, got %v", err)
+	}
+	if _, err := s.GetExpiresIn("missing"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+
+	if _, err := s.GetExpiresIn("unsupported"); err != persistence.ErrNotSupport {
+		t.Errorf("expected ErrNotSupport, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package httpcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+)
+
+// Store represents the cache persisted by a remote Handler
+type Store struct {
+	baseURL string
+	client  *http.Client
+	json    bool
+}
+
+var _ persistence.CacheStore = &Store{}
+
+const (
+	optionWithHTTPClient = "optionWithHTTPClient"
+	optionWithJSON       = "optionWithHTTPJSON"
+)
+
+// WithHTTPClient sets the client used for requests, http.DefaultClient by default
+func WithHTTPClient(c *http.Client) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithHTTPClient] = c
+	}
+}
+
+// WithJSON encodes values as JSON instead of utils.Serialize, so they can be
+// shared with consumers that aren't written in Go
+func WithJSON() persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithJSON] = true
+	}
+}
+
+// NewStore returns a Store calling the Handler mounted at baseURL. Expirations
+// are passed through as is, so DEFAULT means the server store's default.
+func NewStore(baseURL string, opt ...persistence.Option) *Store {
+	opts := persistence.GetOpts(opt...)
+	s := &Store{baseURL: strings.TrimSuffix(baseURL, "/"), client: http.DefaultClient}
+	if v, ok := opts[optionWithHTTPClient].(*http.Client); ok && v != nil {
+		s.client = v
+	}
+	if v, ok := opts[optionWithJSON].(bool); ok {
+		s.json = v
+	}
+	return s
+}
+
+func (s *Store) url(key string, query url.Values) string {
+	u := s.baseURL + "/" + url.PathEscape(key)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// do sends the request and returns the response body, mapping error statuses
+// back onto the persistence errors
+func (s *Store) do(req *http.Request) ([]byte, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return b, nil
+	case http.StatusNotFound:
+		return nil, persistence.ErrCacheMiss
+	case http.StatusPreconditionFailed:
+		return nil, persistence.ErrNotStored
+	case http.StatusNotImplemented:
+		return nil, persistence.ErrNotSupport
+	}
+	return nil, fmt.Errorf("httpcache: %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(b)))
+}
+
+func (s *Store) contentType() string {
+	if s.json {
+		return ContentTypeJSON
+	}
+	return ContentTypeGob
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, ptrValue interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, s.url(key, nil), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", s.contentType())
+	b, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	if s.json {
+		return json.Unmarshal(b, ptrValue)
+	}
+	return utils.Deserialize(b, ptrValue)
+}
+
+// Mget retrieves a list of items for the list of keys provided.
+// If any item does not exist, an ErrCacheMiss is returned.
+func (s *Store) Mget(ptrValue []interface{}, keys ...string) error {
+	if len(ptrValue) != len(keys) {
+		return &persistence.ValueCountError{Got: len(ptrValue), Required: len(keys)}
+	}
+	for i, key := range keys {
+		if err := s.Get(key, ptrValue[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url(key, url.Values{"ttl": {""}}), nil)
+	if err != nil {
+		return 0, err
+	}
+	b, err := s.do(req)
+	if err != nil {
+		return 0, err
+	}
+	ms, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ms < 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+func (s *Store) put(key string, value interface{}, expires time.Duration, header, headerValue string) error {
+	var b []byte
+	var err error
+	if s.json {
+		b, err = json.Marshal(value)
+	} else {
+		b, err = utils.Serialize(value)
+	}
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	switch {
+	case expires == persistence.FOREVER:
+		query.Set("ttl", "forever")
+	case expires != persistence.DEFAULT:
+		query.Set("ttl", expires.String())
+	}
+	req, err := http.NewRequest(http.MethodPut, s.url(key, query), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", s.contentType())
+	if header != "" {
+		req.Header.Set(header, headerValue)
+	}
+	_, err = s.do(req)
+	return err
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	return s.put(key, value, expires, "", "")
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	return s.put(key, value, expires, "If-None-Match", "*")
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.put(key, value, expires, "If-Match", "*")
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.url(key, nil), nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(req)
+	return err
+}
+
+func (s *Store) incr(op, key string, delta uint64) (uint64, error) {
+	query := url.Values{"op": {op}, "delta": {strconv.FormatUint(delta, 10)}}
+	req, err := http.NewRequest(http.MethodPost, s.url(key, query), nil)
+	if err != nil {
+		return 0, err
+	}
+	b, err := s.do(req)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return s.incr("incr", key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	return s.incr("decr", key, delta)
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	req, err := http.NewRequest(http.MethodDelete, s.baseURL+"/", nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(req)
+	return err
+}
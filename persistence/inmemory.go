@@ -1,56 +1,173 @@
 package persistence
 
 import (
+	"fmt"
 	"reflect"
+	"runtime"
 	"time"
 
-	"github.com/robfig/go-cache"
+	"github.com/Bose/cache/internal/memstore"
+	"github.com/Bose/cache/utils"
 )
 
-//InMemoryStore represents the cache with memory persistence
+// Reason describes why an entry left the in-memory store
+type Reason int
+
+const (
+	// ReasonExpired - the entry's TTL passed
+	ReasonExpired Reason = iota
+	// ReasonDeleted - the entry was removed via Delete
+	ReasonDeleted
+	// ReasonEvicted - the entry was removed to make room for others
+	ReasonEvicted
+	// ReasonFlushed - the entry was removed via Flush
+	ReasonFlushed
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonDeleted:
+		return "deleted"
+	case ReasonEvicted:
+		return "evicted"
+	case ReasonFlushed:
+		return "flushed"
+	}
+	return fmt.Sprintf("Reason(%d)", int(r))
+}
+
+// EvictionFunc is called with every entry that leaves the in-memory store
+type EvictionFunc func(key string, value interface{}, reason Reason)
+
+// InMemoryStore represents the cache with memory persistence
 type InMemoryStore struct {
-	cache.Cache
+	cache *memstore.Cache[interface{}]
+}
+
+func init() {
+	memstore.FromOptions = inMemoryConfig
+}
+
+// inMemoryConfig returns the configuration of the in-memory stores set by the options
+// opts, shared by InMemoryStore and memory.Store
+func inMemoryConfig(defaultExpiration time.Duration, opts map[string]interface{}) memstore.Config {
+	jitter, policy, clamp := newTTLJitter(opts), newTTLPolicy(opts), newTTLClamp(opts)
+	cfg := memstore.Config{
+		TTL: func(key string, expires time.Duration) time.Duration {
+			jitter := jitter
+			if expires == DEFAULT {
+				expires, jitter = policy.resolve(key, defaultExpiration, jitter)
+			}
+			if expires <= 0 {
+				return 0
+			}
+			return jitter.apply(clamp.apply(expires))
+		},
+		Clock:           newClock(opts),
+		CleanupInterval: defaultCleanupInterval,
+	}
+	cfg.Shards, _ = opts[optionWithShards].(int)
+	cfg.MaxEntries, _ = opts[optionWithMaxEntries].(int)
+	cfg.MaxCost, _ = opts[optionWithMaxCost].(int64)
+	cfg.Cost, _ = opts[optionWithCost].(func(interface{}) int64)
+	cfg.SweepBatch, _ = opts[optionWithSweepBatchSize].(int)
+	cfg.Expvar, _ = opts[optionWithExpvar].(string)
+	policyOpt, _ := opts[optionWithEvictionPolicy].(EvictionPolicy)
+	cfg.Policy = memstore.Policy(policyOpt)
+	if p, ok := opts[optionWithMemoryPressure].(MemoryPressure); ok {
+		pressure := memstore.Pressure(p)
+		cfg.Pressure = &pressure
+	}
+	if v, ok := opts[optionWithCleanupInterval].(time.Duration); ok {
+		cfg.CleanupInterval = v
+	}
+	mode, _ := opts[optionWithExpirationMode].(ExpirationMode)
+	cfg.Lazy = mode == ExpireLazy || mode == ExpireHybrid
+	if mode == ExpireLazy {
+		cfg.CleanupInterval = 0
+	}
+	return cfg
 }
 
 // NewInMemoryStore returns a InMemoryStore
-func NewInMemoryStore(defaultExpiration time.Duration) *InMemoryStore {
-	return &InMemoryStore{*cache.New(defaultExpiration, time.Minute)}
+func NewInMemoryStore(defaultExpiration time.Duration, opt ...Option) *InMemoryStore {
+	store := &InMemoryStore{memstore.New[interface{}](inMemoryConfig(defaultExpiration, GetOpts(opt...)))}
+	if store.cache.Running() {
+		// the janitor and the monitor only reference the inner cache, so the finalizer
+		// fires once the store itself is unreachable and stops their goroutines if
+		// Close wasn't called
+		runtime.SetFinalizer(store, (*InMemoryStore).Close)
+	}
+	return store
+}
+
+// OnEvicted sets an (optional) function that is called with the key, value and reason
+// whenever an entry is expired, deleted, evicted or flushed. It is called outside of
+// any lock, so it may safely call back into the store. Pass nil to disable.
+func (c *InMemoryStore) OnEvicted(f EvictionFunc) {
+	if f == nil {
+		c.cache.OnEvicted(nil)
+		return
+	}
+	c.cache.OnEvicted(func(key string, value interface{}, reason memstore.Reason) {
+		f(key, value, Reason(reason))
+	})
 }
 
 // Get (see CacheStore interface)
 func (c *InMemoryStore) Get(key string, value interface{}) error {
-	val, found := c.Cache.Get(key)
+	item, found := c.cache.Get(key)
 	if !found {
 		return ErrCacheMiss
 	}
+	if utils.IsNil(item) {
+		return ErrNilValue
+	}
 
 	v := reflect.ValueOf(value)
 	if v.Type().Kind() == reflect.Ptr && v.Elem().CanSet() {
-		v.Elem().Set(reflect.ValueOf(val))
+		v.Elem().Set(reflect.ValueOf(item))
 		return nil
 	}
 	return ErrNotStored
 }
 
+// GetExpiresIn (see CacheStore interface)
+func (c *InMemoryStore) GetExpiresIn(key string) (time.Duration, error) {
+	ttl, found := c.cache.TTL(key)
+	if !found {
+		return 0, ErrCacheMiss
+	}
+	if ttl == memstore.NoExpiration {
+		return 0, ErrCacheNoTTL
+	}
+	return ttl, nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (c *InMemoryStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(c, key, ttl, ptr, fill)
+}
+
 // Set (see CacheStore interface)
 func (c *InMemoryStore) Set(key string, value interface{}, expires time.Duration) error {
-	// NOTE: go-cache understands the values of DEFAULT and FOREVER
-	c.Cache.Set(key, value, expires)
+	c.cache.Set(key, value, expires)
 	return nil
 }
 
 // Add (see CacheStore interface)
 func (c *InMemoryStore) Add(key string, value interface{}, expires time.Duration) error {
-	err := c.Cache.Add(key, value, expires)
-	if err == cache.ErrKeyExists {
+	if !c.cache.Add(key, value, expires) {
 		return ErrNotStored
 	}
-	return err
+	return nil
 }
 
 // Replace (see CacheStore interface)
 func (c *InMemoryStore) Replace(key string, value interface{}, expires time.Duration) error {
-	if err := c.Cache.Replace(key, value, expires); err != nil {
+	if !c.cache.Replace(key, value, expires) {
 		return ErrNotStored
 	}
 	return nil
@@ -58,7 +175,7 @@ func (c *InMemoryStore) Replace(key string, value interface{}, expires time.Dura
 
 // Delete (see CacheStore interface)
 func (c *InMemoryStore) Delete(key string) error {
-	if found := c.Cache.Delete(key); !found {
+	if !c.cache.Delete(key) {
 		return ErrCacheMiss
 	}
 	return nil
@@ -66,24 +183,56 @@ func (c *InMemoryStore) Delete(key string) error {
 
 // Increment (see CacheStore interface)
 func (c *InMemoryStore) Increment(key string, n uint64) (uint64, error) {
-	newValue, err := c.Cache.Increment(key, n)
-	if err == cache.ErrCacheMiss {
-		return 0, ErrCacheMiss
-	}
-	return newValue, err
+	return c.add(key, func(cur uint64) uint64 { return cur + n }, func(cur int64) int64 { return cur + int64(n) })
 }
 
 // Decrement (see CacheStore interface)
 func (c *InMemoryStore) Decrement(key string, n uint64) (uint64, error) {
-	newValue, err := c.Cache.Decrement(key, n)
-	if err == cache.ErrCacheMiss {
+	// Decrement stops at 0 on underflow
+	return c.add(key,
+		func(cur uint64) uint64 {
+			if cur > n {
+				return cur - n
+			}
+			return 0
+		},
+		func(cur int64) int64 {
+			if cur > int64(n) {
+				return cur - int64(n)
+			}
+			return 0
+		})
+}
+
+// add applies op to the integer stored under key, keeping the stored type
+func (c *InMemoryStore) add(key string, uop func(uint64) uint64, iop func(int64) int64) (uint64, error) {
+	var result uint64
+	found, err := c.cache.Update(key, func(cur interface{}) (interface{}, error) {
+		v := reflect.ValueOf(cur)
+		if !v.IsValid() {
+			return nil, ErrNotInteger
+		}
+		nv := reflect.New(v.Type()).Elem()
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			nv.SetInt(iop(v.Int()))
+			result = uint64(nv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			nv.SetUint(uop(v.Uint()))
+			result = nv.Uint()
+		default:
+			return nil, ErrNotInteger
+		}
+		return nv.Interface(), nil
+	})
+	if !found {
 		return 0, ErrCacheMiss
 	}
-	return newValue, err
+	return result, err
 }
 
 // Flush (see CacheStore interface)
 func (c *InMemoryStore) Flush() error {
-	c.Cache.Flush()
+	c.cache.Flush()
 	return nil
 }
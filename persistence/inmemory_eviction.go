@@ -0,0 +1,17 @@
+package persistence
+
+// EvictionPolicy selects the entries a bounded in-memory store evicts to make room for
+// new ones (see WithMaxEntries)
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entry
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used entry, the least recently used of them
+	// on ties, so entries read often survive scans of keys read once
+	EvictLFU
+	// Evict2Q keeps new entries in a FIFO queue of a quarter of the entries, and only
+	// promotes them to the LRU list of the others when they're written again after
+	// leaving it, so scans only ever displace other new entries (2Q)
+	Evict2Q
+)
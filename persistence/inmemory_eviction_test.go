@@ -0,0 +1,201 @@
+package persistence
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInMemoryCache_EvictLRU(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMaxEntries(3))
+	var evicted []string
+	store.OnEvicted(func(key string, value interface{}, reason Reason) {
+		if reason == ReasonEvicted {
+			evicted = append(evicted, key)
+		}
+	})
+	store.Set("a", 1, DEFAULT)
+	store.Set("b", 2, DEFAULT)
+	store.Set("c", 3, DEFAULT)
+	var v int
+	store.Get("a", &v)
+	store.Set("d", 4, DEFAULT)
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("expected the least recently used entry to be evicted, got %v", evicted)
+	}
+	if n := store.Len(); n != 3 {
+		t.Errorf("expected 3 entries, got %d", n)
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if err := store.Get(key, &v); err != nil {
+			t.Errorf("expected %s to be kept, got %v", key, err)
+		}
+	}
+	if st := store.Stats(); st.Evictions != 1 {
+		t.Errorf("expected 1 eviction, got %d", st.Evictions)
+	}
+}
+
+func TestInMemoryCache_EvictLFU(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMaxEntries(10), WithEvictionPolicy(EvictLFU))
+	var v int
+	for i := 0; i < 5; i++ {
+		store.Set(fmt.Sprintf("hot-%d", i), i, DEFAULT)
+		for j := 0; j < 3; j++ {
+			store.Get(fmt.Sprintf("hot-%d", i), &v)
+		}
+	}
+	// a scan of keys read once doesn't push the hot keys out
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("scan-%d", i), i, DEFAULT)
+		store.Get(fmt.Sprintf("scan-%d", i), &v)
+	}
+	for i := 0; i < 5; i++ {
+		if err := store.Get(fmt.Sprintf("hot-%d", i), &v); err != nil || v != i {
+			t.Errorf("expected hot-%d to be kept, got %d (%v)", i, v, err)
+		}
+	}
+	if n := store.Len(); n != 10 {
+		t.Errorf("expected 10 entries, got %d", n)
+	}
+	if err := store.Get("scan-0", &v); err != ErrCacheMiss {
+		t.Errorf("expected the oldest scanned key to be evicted, got %v", err)
+	}
+	if err := store.Get("scan-99", &v); err != nil {
+		t.Errorf("expected the latest scanned key to be kept, got %v", err)
+	}
+}
+
+func TestInMemoryCache_Unbounded(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1))
+	for i := 0; i < 1000; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), i, DEFAULT)
+	}
+	if n := store.Len(); n != 1000 {
+		t.Errorf("expected the store to be unbounded by default, got %d entries", n)
+	}
+}
+
+func TestInMemoryCache_Evict2Q(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMaxEntries(8), WithEvictionPolicy(Evict2Q))
+	var v int
+	for i := 0; i < 4; i++ {
+		store.Set(fmt.Sprintf("hot-%d", i), i, DEFAULT)
+	}
+	// push the hot keys out of the queue of new keys, then write them again so they're
+	// promoted for having been seen before
+	for i := 0; i < 8; i++ {
+		store.Set(fmt.Sprintf("filler-%d", i), i, DEFAULT)
+	}
+	for i := 0; i < 4; i++ {
+		if err := store.Get(fmt.Sprintf("hot-%d", i), &v); err != ErrCacheMiss {
+			t.Fatalf("expected hot-%d to have been evicted from the queue, got %v", i, err)
+		}
+		store.Set(fmt.Sprintf("hot-%d", i), i, DEFAULT)
+	}
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("scan-%d", i), i, DEFAULT)
+		store.Get(fmt.Sprintf("scan-%d", i), &v)
+	}
+	for i := 0; i < 4; i++ {
+		if err := store.Get(fmt.Sprintf("hot-%d", i), &v); err != nil || v != i {
+			t.Errorf("expected hot-%d to survive the scan, got %d (%v)", i, v, err)
+		}
+	}
+	if n := store.Len(); n != 8 {
+		t.Errorf("expected 8 entries, got %d", n)
+	}
+}
+
+func TestInMemoryCache_MaxCost(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMaxCost(100), WithCost(func(v interface{}) int64 {
+		return int64(len(v.(string)))
+	}))
+	var evicted []string
+	store.OnEvicted(func(key string, value interface{}, reason Reason) {
+		if reason == ReasonEvicted {
+			evicted = append(evicted, key)
+		}
+	})
+	for i := 0; i < 9; i++ {
+		store.Set(fmt.Sprintf("small-%d", i), "0123456789", DEFAULT)
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("expected 90 of 100 not to evict anything, got %v", evicted)
+	}
+	// a large entry is charged for its size rather than as a single entry
+	store.Set("large", string(make([]byte, 50)), DEFAULT)
+	if len(evicted) != 4 || evicted[0] != "small-0" {
+		t.Errorf("expected the 4 least recently used entries to be evicted, got %v", evicted)
+	}
+	if n := store.Len(); n != 6 {
+		t.Errorf("expected 6 entries, got %d", n)
+	}
+
+	// overwrites and deletes are accounted for
+	store.Set("large", "0123456789", DEFAULT)
+	store.Delete("small-5")
+	evicted = nil
+	for i := 0; i < 6; i++ {
+		store.Set(fmt.Sprintf("more-%d", i), "0123456789", DEFAULT)
+	}
+	if len(evicted) != 1 || evicted[0] != "small-4" {
+		t.Errorf("expected a single eviction, got %v", evicted)
+	}
+
+	// an entry over the whole bound isn't kept
+	store.Set("huge", string(make([]byte, 200)), DEFAULT)
+	var v string
+	if err := store.Get("huge", &v); err != ErrCacheMiss {
+		t.Errorf("expected the oversized entry to be evicted, got %v", err)
+	}
+	if n := store.Len(); n != 10 {
+		t.Errorf("expected the other entries to be kept, got %d", n)
+	}
+}
+
+func TestInMemoryCache_MaxCostReplace(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMaxCost(30), WithCost(func(v interface{}) int64 {
+		return int64(len(v.(string)))
+	}))
+	var evicted []string
+	store.OnEvicted(func(key string, value interface{}, reason Reason) {
+		if reason == ReasonEvicted {
+			evicted = append(evicted, key)
+		}
+	})
+	store.Set("a", "0123456789", DEFAULT)
+	store.Set("b", "0123456789", DEFAULT)
+	// growing b past the bound evicts a, which must be reported
+	if err := store.Replace("b", string(make([]byte, 25)), DEFAULT); err != nil {
+		t.Fatalf("expected Replace to succeed, got %v", err)
+	}
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Errorf("expected a to be evicted, got %v", evicted)
+	}
+	if s := store.Stats(); s.Evictions != 1 {
+		t.Errorf("expected the eviction to be counted, got %d", s.Evictions)
+	}
+}
+
+func TestInMemoryCache_MaxCostIncrement(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMaxCost(10), WithCost(func(v interface{}) int64 {
+		return int64(v.(int))
+	}))
+	store.Set("a", 1, DEFAULT)
+	store.Set("b", 1, DEFAULT)
+	if _, err := store.Increment("a", 5); err != nil {
+		t.Fatalf("Error incrementing: %s", err)
+	}
+	// going over the bound evicts the least recently used entry
+	if _, err := store.Increment("a", 4); err != nil {
+		t.Fatalf("Error incrementing: %s", err)
+	}
+	var v int
+	if err := store.Get("b", &v); err != ErrCacheMiss {
+		t.Errorf("expected b to be evicted, got %v", err)
+	}
+	if err := store.Get("a", &v); err != nil || v != 10 {
+		t.Errorf("expected a to be kept, got %d (%v)", v, err)
+	}
+}
@@ -0,0 +1,30 @@
+package persistence
+
+import "time"
+
+const defaultCleanupInterval = time.Minute
+
+// ExpirationMode selects how the in-memory store removes expired entries (see
+// WithExpirationMode). Expired entries are never returned, whatever the mode: it only
+// decides when the memory they hold is reclaimed and OnEvicted is called for them.
+type ExpirationMode int
+
+const (
+	// ExpireActive sweeps expired entries every cleanup interval (see WithCleanupInterval),
+	// so they don't linger in memory or in Stats for longer than that
+	ExpireActive ExpirationMode = iota
+	// ExpireLazy runs no janitor: expired entries are only removed when read, or
+	// overwritten, which costs no CPU in the background but keeps entries nobody reads
+	// again in memory until they're evicted
+	ExpireLazy
+	// ExpireHybrid both sweeps expired entries and removes them when read
+	ExpireHybrid
+)
+
+// Close stops the goroutines of the store: the janitor sweeping expired entries and
+// the monitor set by WithMemoryPressure. The store is still usable after, but expired
+// entries are no longer swept in the background. It's safe to call more than once.
+func (c *InMemoryStore) Close() error {
+	c.cache.Close()
+	return nil
+}
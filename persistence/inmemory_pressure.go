@@ -0,0 +1,28 @@
+package persistence
+
+import "time"
+
+// MemoryPressure configures how the in-memory store sheds entries when the process
+// runs short of memory (see WithMemoryPressure)
+type MemoryPressure struct {
+	// Limit is the memory the process may use in bytes, 0 for the Go memory limit
+	// (GOMEMLIMIT, see debug.SetMemoryLimit); nothing is shed without either
+	Limit uint64
+	// Threshold is the share of Limit over which entries are shed, 0.9 by default
+	Threshold float64
+	// Fraction is the share of the entries shed each time, 0.25 by default
+	Fraction float64
+	// Interval is how often memory use is checked, every second by default
+	Interval time.Duration
+	// Usage returns the memory in use in bytes, by default the memory the Go runtime
+	// holds from the OS, as the Go memory limit counts it
+	Usage func() uint64
+}
+
+// Shed evicts fraction (0 to 1) of the entries of every shard, the ones its eviction
+// policy would evict first in stores that are bounded (see WithMaxEntries) or watch
+// memory pressure, and arbitrary ones otherwise, and returns how many were. The
+// monitor set WithMemoryPressure calls it when memory runs short.
+func (c *InMemoryStore) Shed(fraction float64) int {
+	return c.cache.Shed(fraction)
+}
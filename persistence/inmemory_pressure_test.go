@@ -0,0 +1,94 @@
+package persistence
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInMemoryCache_Shed(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1))
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), i, DEFAULT)
+	}
+	if n := store.Shed(0.25); n != 25 {
+		t.Errorf("expected 25 entries to be shed, got %d", n)
+	}
+	if n := store.Len(); n != 75 {
+		t.Errorf("expected 75 entries left, got %d", n)
+	}
+	if st := store.Stats(); st.Evictions != 25 {
+		t.Errorf("expected the shed entries to count as evictions, got %d", st.Evictions)
+	}
+	if n := store.Shed(0); n != 0 {
+		t.Errorf("expected nothing to be shed, got %d", n)
+	}
+}
+
+func TestInMemoryCache_ShedLRU(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMaxEntries(10))
+	for i := 0; i < 10; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), i, DEFAULT)
+	}
+	var v int
+	store.Get("key-0", &v)
+	store.Shed(0.5)
+	for i := 0; i < 10; i++ {
+		err := store.Get(fmt.Sprintf("key-%d", i), &v)
+		if shed := i > 0 && i <= 5; shed != (err == ErrCacheMiss) {
+			t.Errorf("key-%d: expected shed=%v, got %v", i, shed, err)
+		}
+	}
+}
+
+func TestInMemoryCache_MemoryPressure(t *testing.T) {
+	var usage uint64 = 50
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMemoryPressure(MemoryPressure{
+		Limit:    100,
+		Fraction: 0.5,
+		Interval: 5 * time.Millisecond,
+		Usage:    func() uint64 { return atomic.LoadUint64(&usage) },
+	}))
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), i, DEFAULT)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := store.Len(); n != 100 {
+		t.Fatalf("expected nothing to be shed under the threshold, got %d entries", n)
+	}
+	atomic.StoreUint64(&usage, 95)
+	for deadline := time.Now().Add(time.Second); store.Len() == 100 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	atomic.StoreUint64(&usage, 50)
+	if n := store.Len(); n >= 100 || n == 0 {
+		t.Errorf("expected some entries to be shed over the threshold, got %d entries", n)
+	}
+}
+
+func TestInMemoryCache_MemoryPressureLRU(t *testing.T) {
+	var usage uint64 = 50
+	store := NewInMemoryStore(time.Hour, WithShards(1), WithMemoryPressure(MemoryPressure{
+		Limit:    100,
+		Fraction: 0.5,
+		Interval: 5 * time.Millisecond,
+		Usage:    func() uint64 { return atomic.LoadUint64(&usage) },
+	}))
+	for i := 0; i < 10; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), i, DEFAULT)
+	}
+	var v int
+	store.Get("key-0", &v)
+	atomic.StoreUint64(&usage, 95)
+	for deadline := time.Now().Add(time.Second); store.Len() == 10 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreUint64(&usage, 50)
+	if n := store.Len(); n == 10 {
+		t.Fatal("expected entries to be shed over the threshold")
+	}
+	if err := store.Get("key-0", &v); err != nil {
+		t.Errorf("expected the entry read last to be kept by the LRU policy, got %v", err)
+	}
+}
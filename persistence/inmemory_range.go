@@ -0,0 +1,20 @@
+package persistence
+
+// Range calls f for every unexpired entry in the store until f returns false.
+// Each shard is copied before f is called, so f may safely call back into the
+// store (e.g. to Delete the entry it was handed); entries written concurrently
+// may or may not be visited.
+func (c *InMemoryStore) Range(f func(key string, value interface{}) bool) {
+	c.cache.Range(f)
+}
+
+// Len returns the number of unexpired entries in the store
+func (c *InMemoryStore) Len() int {
+	return c.cache.Len()
+}
+
+// DeleteByPattern removes every entry whose key matches the glob pattern (using the
+// same syntax as redis KEYS/SCAN) and returns the number of entries removed
+func (c *InMemoryStore) DeleteByPattern(pattern string) (int, error) {
+	return c.cache.DeleteMatching(func(key string) bool { return globMatch(pattern, key) }), nil
+}
@@ -0,0 +1,111 @@
+package persistence
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/Bose/cache/internal/memstore"
+)
+
+// snapshotItem is the on-disk representation of an in-memory entry
+type snapshotItem struct {
+	Key        string
+	Value      interface{}
+	Expiration int64 // unix nano, 0 means the item never expires
+}
+
+// Save writes the unexpired entries of the store to w using gob. The concrete
+// types of the cached values must be registered with gob.Register first.
+func (c *InMemoryStore) Save(w io.Writer) (err error) {
+	defer func() {
+		// gob panics on unregistered interface types
+		if r := recover(); r != nil {
+			err = &SerializationError{Cause: fmt.Errorf("registering item types with gob: %v", r)}
+		}
+	}()
+	enc := gob.NewEncoder(w)
+	// one slice per shard keeps locks short and memory bounded while encoding
+	return c.cache.Dump(func(entries []memstore.Entry[interface{}]) error {
+		items := make([]snapshotItem, len(entries))
+		for i, e := range entries {
+			items[i] = snapshotItem(e)
+		}
+		return enc.Encode(items)
+	})
+}
+
+// SaveToFile writes the unexpired entries of the store to the named file,
+// replacing it atomically once the snapshot is synced to disk, so a crash never
+// leaves a truncated snapshot behind
+func (c *InMemoryStore) SaveToFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = c.Save(f); err == nil {
+		// the data must be on disk before the rename makes it the snapshot
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the entries of the directory dir to disk, so a rename in it survives
+// a crash. Windows can't sync directories, and doesn't need it.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Load adds the entries written by Save to the store. Entries that expired in the
+// meantime are skipped, the others keep their original expiration time. Keys
+// that already exist in the store are not overwritten.
+func (c *InMemoryStore) Load(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	for {
+		var items []snapshotItem
+		if err := dec.Decode(&items); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		for _, si := range items {
+			c.cache.Restore(si.Key, si.Value, si.Expiration)
+		}
+	}
+}
+
+// LoadFromFile adds the entries written by SaveToFile to the store (see Load)
+func (c *InMemoryStore) LoadFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Load(f)
+}
@@ -0,0 +1,27 @@
+package persistence
+
+// InMemoryStats is a point in time view of the in-memory store's statistics
+type InMemoryStats struct {
+	// Entries is the number of entries in the store, including the expired ones not
+	// removed yet (see ExpirationMode); Len only counts the unexpired ones
+	Entries int
+	// Hits and Misses count the Get calls since the store was created
+	Hits   uint64
+	Misses uint64
+	// HitRatio is Hits / (Hits + Misses), 0 if there were no Gets yet
+	HitRatio float64
+	// Evictions counts the entries removed to make room for others
+	Evictions uint64
+	// Expirations counts the entries removed because their TTL passed
+	Expirations uint64
+	// ApproxBytes is a rough estimate of the memory held by keys and values, as
+	// estimated when they were written, including the expired entries not removed yet
+	ApproxBytes int64
+}
+
+// Stats returns the store's runtime statistics. They're kept up to date as entries
+// are written and removed, so reading them is cheap enough for frequent scrapes (see
+// WithExpvar).
+func (c *InMemoryStore) Stats() InMemoryStats {
+	return InMemoryStats(c.cache.Stats())
+}
@@ -1,12 +1,15 @@
 package persistence
 
 import (
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
 
 var newInMemoryStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
-	return NewInMemoryStore(defaultExpiration)
+	return NewInMemoryStore(defaultExpiration, WithClock(NewFakeClock(time.Now())))
 }
 
 // Test typical cache interactions
@@ -30,6 +33,327 @@ func TestInMemoryCache_Replace(t *testing.T) {
 	testReplace(t, newInMemoryStore)
 }
 
+func TestInMemoryCache_GetExpiresIn(t *testing.T) {
+	getExpiresIn(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_CachedNil(t *testing.T) {
+	cachedNil(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_GetOrSet(t *testing.T) {
+	getOrSet(t, newInMemoryStore)
+}
+
 func TestInMemoryCache_Add(t *testing.T) {
 	testAdd(t, newInMemoryStore)
 }
+
+func TestInMemoryCache_Shards(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(3))
+	typicalGetSet(t, func(_ *testing.T, _ time.Duration) CacheStore { return store })
+}
+
+func TestInMemoryCache_Concurrent(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%d-%d", g, i%50)
+				if err := store.Set(key, i, DEFAULT); err != nil {
+					t.Errorf("Error setting a value: %s", err)
+				}
+				var v int
+				if err := store.Get(key, &v); err != nil {
+					t.Errorf("Error getting a value: %s", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := store.Set("counter", 0, DEFAULT); err != nil {
+		t.Fatalf("Error setting counter: %s", err)
+	}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := store.Increment("counter", 1); err != nil {
+					t.Errorf("Error incrementing: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var counter int
+	if err := store.Get("counter", &counter); err != nil || counter != 800 {
+		t.Errorf("expected counter of 800, got %d (%v)", counter, err)
+	}
+}
+
+func TestInMemoryCache_OnEvicted(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	store := NewInMemoryStore(time.Hour, WithClock(clock))
+	var mu sync.Mutex
+	reasons := map[string]Reason{}
+	store.OnEvicted(func(key string, value interface{}, reason Reason) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons[key] = reason
+	})
+
+	store.Set("deleted", 1, DEFAULT)
+	store.Set("expired", 2, 10*time.Millisecond)
+	store.Set("flushed", 3, DEFAULT)
+	if err := store.Delete("deleted"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	clock.Advance(20 * time.Millisecond)
+	store.cache.DeleteExpired()
+	store.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := map[string]Reason{"deleted": ReasonDeleted, "expired": ReasonExpired, "flushed": ReasonFlushed}
+	for k, r := range expected {
+		if reasons[k] != r {
+			t.Errorf("expected %s to be %s, got %s", k, r, reasons[k])
+		}
+	}
+}
+
+func TestInMemoryCache_CleanupInterval(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithCleanupInterval(10*time.Millisecond))
+	expired := make(chan string, 1)
+	store.OnEvicted(func(key string, _ interface{}, reason Reason) {
+		if reason == ReasonExpired {
+			expired <- key
+		}
+	})
+	store.Set("short", 1, 20*time.Millisecond)
+	// overwriting with a longer TTL leaves a stale expiry entry that must be skipped
+	store.Set("long", 1, 20*time.Millisecond)
+	store.Set("long", 2, time.Hour)
+
+	select {
+	case key := <-expired:
+		if key != "short" {
+			t.Errorf("expected short to expire, got %s", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("janitor did not purge the expired entry")
+	}
+	var v int
+	if err := store.Get("long", &v); err != nil || v != 2 {
+		t.Errorf("expected long to survive the sweep, got %d (%v)", v, err)
+	}
+}
+
+func TestInMemoryCache_ExpirationModes(t *testing.T) {
+	for _, mode := range []ExpirationMode{ExpireActive, ExpireLazy, ExpireHybrid} {
+		clock := NewFakeClock(time.Now())
+		store := NewInMemoryStore(time.Hour, WithClock(clock), WithExpirationMode(mode))
+		var expired []string
+		store.OnEvicted(func(key string, _ interface{}, reason Reason) {
+			if reason == ReasonExpired {
+				expired = append(expired, key)
+			}
+		})
+		store.Set("read", 1, time.Second)
+		store.Set("unread", 1, time.Second)
+		clock.Advance(2 * time.Second)
+
+		var v int
+		if err := store.Get("read", &v); err != ErrCacheMiss {
+			t.Errorf("%d: expected a miss on an expired entry, got %v", mode, err)
+		}
+		lazy := mode != ExpireActive
+		if got := len(expired) == 1; got != lazy {
+			t.Errorf("%d: expected the read to remove the expired entry: %v, got %v", mode, lazy, expired)
+		}
+		if running := store.cache.Running(); running != (mode != ExpireLazy) {
+			t.Errorf("%d: expected a janitor: %v, got %v", mode, !running, running)
+		}
+		if n := store.Stats().Entries; (lazy && n != 1) || (!lazy && n != 2) {
+			t.Errorf("%d: expected the entry not read to stay until swept, got %d entries", mode, n)
+		}
+		store.Close()
+	}
+}
+
+func TestInMemoryCache_Close(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithCleanupInterval(time.Millisecond), WithMemoryPressure(MemoryPressure{Limit: 1 << 40}))
+	if err := store.Close(); err != nil {
+		t.Fatalf("expected Close to succeed, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("expected closing again to succeed, got %v", err)
+	}
+	store.Set("key", 1, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if n := store.Stats().Entries; n != 1 {
+		t.Error("expected the janitor to be stopped")
+	}
+	var v int
+	if err := store.Get("key", &v); err != ErrCacheMiss {
+		t.Errorf("expected expired entries to still be misses, got %v", err)
+	}
+}
+
+func TestInMemoryCache_SweepBatchSize(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	store := NewInMemoryStore(time.Hour, WithClock(clock), WithShards(1), WithSweepBatchSize(2), WithCleanupInterval(0))
+	for i := 0; i < 5; i++ {
+		store.Set(fmt.Sprint(i), i, time.Second)
+	}
+	clock.Advance(2 * time.Second)
+	for _, remaining := range []int{3, 1, 0} {
+		store.cache.DeleteExpired()
+		if n := store.Stats().Entries; n != remaining {
+			t.Errorf("expected %d entries left after the sweep, got %d", remaining, n)
+		}
+	}
+}
+
+func TestInMemoryCache_SaveLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.gob")
+	clock := NewFakeClock(time.Now())
+	store := NewInMemoryStore(time.Hour, WithClock(clock))
+	store.Set("forever", "a", FOREVER)
+	store.Set("ttl", "b", time.Hour)
+	store.Set("expired", "c", time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+	if err := store.SaveToFile(path); err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+
+	restored := NewInMemoryStore(time.Hour, WithClock(clock))
+	restored.Set("ttl", "existing", DEFAULT)
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatalf("Error loading: %s", err)
+	}
+	var v string
+	if err := restored.Get("forever", &v); err != nil || v != "a" {
+		t.Errorf("expected a, got %s (%v)", v, err)
+	}
+	if err := restored.Get("ttl", &v); err != nil || v != "existing" {
+		t.Errorf("expected existing entries to be kept, got %s (%v)", v, err)
+	}
+	if err := restored.Get("expired", &v); err != ErrCacheMiss {
+		t.Errorf("expected expired entries to be skipped, got %v", err)
+	}
+}
+
+func TestInMemoryCache_Stats(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	store := NewInMemoryStore(time.Hour, WithClock(clock))
+	store.Set("a", "value", DEFAULT)
+	store.Set("b", []byte("0123456789"), DEFAULT)
+	store.Set("expired", 1, time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+
+	var v string
+	store.Get("a", &v)
+	store.Get("a", &v)
+	store.Get("missing", &v)
+	store.Delete("expired")
+
+	st := store.Stats()
+	if st.Entries != 2 {
+		t.Errorf("expected 2 entries, got %d", st.Entries)
+	}
+	if st.Hits != 2 || st.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss, got %d/%d", st.Hits, st.Misses)
+	}
+	if st.HitRatio < 0.66 || st.HitRatio > 0.67 {
+		t.Errorf("unexpected hit ratio: %f", st.HitRatio)
+	}
+	if st.Expirations != 1 {
+		t.Errorf("expected 1 expiration, got %d", st.Expirations)
+	}
+	if st.ApproxBytes < int64(len("value")+10) {
+		t.Errorf("unexpected approximate size: %d", st.ApproxBytes)
+	}
+}
+
+func TestInMemoryCache_StatsApproxBytes(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithShards(1))
+	if b := store.Stats().ApproxBytes; b != 0 {
+		t.Fatalf("expected an empty store to hold nothing, got %d", b)
+	}
+	store.Set("key", make([]byte, 1000), DEFAULT)
+	large := store.Stats().ApproxBytes
+	if large < 1000 {
+		t.Errorf("expected at least the 1000 bytes of the value, got %d", large)
+	}
+	store.Set("key", make([]byte, 10), DEFAULT)
+	if b := store.Stats().ApproxBytes; b >= large || b < 10 {
+		t.Errorf("expected overwriting with a smaller value to shrink the size from %d, got %d", large, b)
+	}
+	store.Delete("key")
+	if b := store.Stats().ApproxBytes; b != 0 {
+		t.Errorf("expected the size to go back to 0 after Delete, got %d", b)
+	}
+}
+
+func TestInMemoryCache_RangeLen(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	store := NewInMemoryStore(time.Hour, WithClock(clock))
+	for i := 0; i < 10; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), i, DEFAULT)
+	}
+	store.Set("expired", 0, time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+	if n := store.Len(); n != 10 {
+		t.Errorf("expected 10 entries, got %d", n)
+	}
+
+	// delete the odd values from within Range
+	store.Range(func(key string, value interface{}) bool {
+		if value.(int)%2 == 1 {
+			if err := store.Delete(key); err != nil {
+				t.Errorf("Error deleting %s: %s", key, err)
+			}
+		}
+		return true
+	})
+	if n := store.Len(); n != 5 {
+		t.Errorf("expected 5 entries, got %d", n)
+	}
+
+	visited := 0
+	store.Range(func(string, interface{}) bool {
+		visited++
+		return visited < 2
+	})
+	if visited != 2 {
+		t.Errorf("expected Range to stop after 2 entries, visited %d", visited)
+	}
+}
+
+func TestInMemoryCache_DeleteByPattern(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	store.Set("user:1:profile", 1, DEFAULT)
+	store.Set("user:2:profile", 2, DEFAULT)
+	store.Set("user:2:settings", 3, DEFAULT)
+	store.Set("group:1:profile", 4, DEFAULT)
+
+	n, err := store.DeleteByPattern("user:*:profile")
+	if err != nil {
+		t.Fatalf("Error deleting by pattern: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 keys deleted, got %d", n)
+	}
+	var v int
+	if err := store.Get("user:2:settings", &v); err != nil {
+		t.Errorf("expected user:2:settings to be kept: %s", err)
+	}
+	if err := store.Get("user:1:profile", &v); err != ErrCacheMiss {
+		t.Errorf("expected user:1:profile to be deleted, got %v", err)
+	}
+}
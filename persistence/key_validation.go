@@ -0,0 +1,173 @@
+package persistence
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultMaxKeyLength = 250
+
+// KeyValidatingStore checks every key before passing it on to the wrapped store, and
+// fails the operations on malformed keys, such as keys built from user input, with an
+// *InvalidKeyError rather than creating entries that can't be read back or matched by
+// pattern. Keys are rejected when empty, over the WithMaxKeyLength limit, or holding
+// whitespace, control characters or WithForbiddenKeyChars.
+//
+// WithKeyNormalization percent-encodes the offending characters instead, so every key
+// maps to a safe one; only empty keys and those still too long are rejected then.
+type KeyValidatingStore struct {
+	store     CacheStore
+	maxLength int
+	forbidden string
+	normalize bool
+}
+
+var _ CacheStore = &KeyValidatingStore{}
+
+// NewKeyValidatingStore returns a KeyValidatingStore wrapping store
+func NewKeyValidatingStore(store CacheStore, opt ...Option) *KeyValidatingStore {
+	opts := GetOpts(opt...)
+	s := &KeyValidatingStore{store: store, maxLength: defaultMaxKeyLength}
+	if v, ok := opts[optionWithMaxKeyLength].(int); ok {
+		s.maxLength = v
+	}
+	s.forbidden, _ = opts[optionWithForbiddenKeyChars].(string)
+	s.normalize, _ = opts[optionWithKeyNormalization].(bool)
+	return s
+}
+
+// Key returns the key the operations on key are passed on with: key itself, or its
+// normalized form with WithKeyNormalization. It returns an *InvalidKeyError for the
+// keys rejected.
+func (s *KeyValidatingStore) Key(key string) (string, error) {
+	if key == "" {
+		return "", &InvalidKeyError{Key: key, Reason: "empty"}
+	}
+	for i := 0; i < len(key); i++ {
+		if !s.safe(key[i]) {
+			if !s.normalize {
+				return "", &InvalidKeyError{Key: key, Reason: "forbidden character " + strconv.QuoteRune(rune(key[i]))}
+			}
+			key = s.escape(key)
+			break
+		}
+	}
+	if s.maxLength > 0 && len(key) > s.maxLength {
+		return "", &InvalidKeyError{Key: key, Reason: "longer than " + strconv.Itoa(s.maxLength) + " bytes"}
+	}
+	return key, nil
+}
+
+// safe reports whether c may be left as is in keys
+func (s *KeyValidatingStore) safe(c byte) bool {
+	if c <= ' ' || c == 0x7f || strings.IndexByte(s.forbidden, c) >= 0 {
+		return false
+	}
+	// with normalization, '%' is escaped too so distinct keys stay distinct, and
+	// non-ASCII bytes so normalized keys are plain ASCII
+	return !s.normalize || (c != '%' && c < 0x80)
+}
+
+// escape percent-encodes the bytes of key that aren't safe
+func (s *KeyValidatingStore) escape(key string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if s.safe(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+// Get (see CacheStore interface)
+func (s *KeyValidatingStore) Get(key string, value interface{}) error {
+	key, err := s.Key(key)
+	if err != nil {
+		return err
+	}
+	return s.store.Get(key, value)
+}
+
+// Set (see CacheStore interface)
+func (s *KeyValidatingStore) Set(key string, value interface{}, expires time.Duration) error {
+	key, err := s.Key(key)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *KeyValidatingStore) Add(key string, value interface{}, expires time.Duration) error {
+	key, err := s.Key(key)
+	if err != nil {
+		return err
+	}
+	return s.store.Add(key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *KeyValidatingStore) Replace(key string, value interface{}, expires time.Duration) error {
+	key, err := s.Key(key)
+	if err != nil {
+		return err
+	}
+	return s.store.Replace(key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *KeyValidatingStore) Delete(key string) error {
+	key, err := s.Key(key)
+	if err != nil {
+		return err
+	}
+	return s.store.Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (s *KeyValidatingStore) Increment(key string, delta uint64) (uint64, error) {
+	key, err := s.Key(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.store.Increment(key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (s *KeyValidatingStore) Decrement(key string, delta uint64) (uint64, error) {
+	key, err := s.Key(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.store.Decrement(key, delta)
+}
+
+// Flush (see CacheStore interface)
+func (s *KeyValidatingStore) Flush() error {
+	return s.store.Flush()
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *KeyValidatingStore) GetExpiresIn(key string) (time.Duration, error) {
+	key, err := s.Key(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.store.GetExpiresIn(key)
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *KeyValidatingStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	key, err := s.Key(key)
+	if err != nil {
+		return false, err
+	}
+	return s.store.GetOrSet(key, ttl, ptr, fill)
+}
@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyValidatingStore(t *testing.T) {
+	inner := NewInMemoryStore(time.Minute)
+	s := NewKeyValidatingStore(inner, WithMaxKeyLength(16), WithForbiddenKeyChars("*?"))
+	if err := s.Set("user:1", 1, DEFAULT); err != nil {
+		t.Fatalf("expected a valid key to be stored, got %v", err)
+	}
+	var v int
+	if err := s.Get("user:1", &v); err != nil || v != 1 {
+		t.Errorf("expected to read the value back, got %d (%v)", v, err)
+	}
+	for _, key := range []string{"", "user 1", "user:\n", "user:\x00", "user:*", strings.Repeat("k", 17)} {
+		err := s.Set(key, 1, DEFAULT)
+		var keyErr *InvalidKeyError
+		if !errors.As(err, &keyErr) || keyErr.Key != key {
+			t.Errorf("%q: expected an *InvalidKeyError, got %v", key, err)
+		}
+		if !errors.Is(err, ErrInvalidKey) || !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%q: expected the error to match ErrInvalidKey and ErrInvalidArgument", key)
+		}
+		if _, err := s.Increment(key, 1); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected Increment to fail, got %v", key, err)
+		}
+	}
+	if err := inner.Get("user 1", &v); err != ErrCacheMiss {
+		t.Errorf("expected nothing to be written for invalid keys, got %v", err)
+	}
+}
+
+func TestKeyValidatingStore_Normalization(t *testing.T) {
+	inner := NewInMemoryStore(time.Minute)
+	s := NewKeyValidatingStore(inner, WithKeyNormalization(), WithForbiddenKeyChars("*"))
+	cases := map[string]string{
+		"user:1":     "user:1",
+		"user 1":     "user%201",
+		"user%201":   "user%25201",
+		"user:*":     "user:%2A",
+		"user:é":     "user:%C3%A9",
+		"line\nfeed": "line%0Afeed",
+	}
+	for key, expected := range cases {
+		if got, err := s.Key(key); err != nil || got != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", key, expected, got, err)
+		}
+	}
+	if err := s.Set("user 1", 1, DEFAULT); err != nil {
+		t.Fatalf("expected the key to be normalized, got %v", err)
+	}
+	var v int
+	if err := inner.Get("user%201", &v); err != nil || v != 1 {
+		t.Errorf("expected the value under the normalized key, got %d (%v)", v, err)
+	}
+	if err := s.Get("user 1", &v); err != nil || v != 1 {
+		t.Errorf("expected to read the value back, got %d (%v)", v, err)
+	}
+	if _, err := s.Key(""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected empty keys to be rejected, got %v", err)
+	}
+	if _, err := s.Key(strings.Repeat(" ", 100)); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected keys too long once normalized to be rejected, got %v", err)
+	}
+}
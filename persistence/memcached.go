@@ -1,6 +1,7 @@
 package persistence
 
 import (
+	"runtime"
 	"time"
 
 	"github.com/Bose/cache/utils"
@@ -11,30 +12,76 @@ import (
 type MemcachedStore struct {
 	*memcache.Client
 	defaultExpiration time.Duration
+	valueSize         valueSizeLimit
+	ttlJitter         ttlJitter
+	ttlPolicy         ttlPolicy
+	ttlClamp          ttlClamp
+	selector          memcache.ServerSelector
+	nodes             *memcachedNodes // nil without WithMemcachedHealthCheck
+	meta              *memcachedMeta
+	// useMeta sends the CacheStore operations with meta commands
+	useMeta bool
 }
 
 // NewMemcachedStore returns a MemcachedStore
-func NewMemcachedStore(hostList []string, defaultExpiration time.Duration) *MemcachedStore {
-	return &MemcachedStore{memcache.New(hostList...), defaultExpiration}
+func NewMemcachedStore(hostList []string, defaultExpiration time.Duration, opt ...Option) *MemcachedStore {
+	opts := GetOpts(opt...)
+	// the servers are shared with the meta protocol client, so both pick the same
+	// server for each key
+	servers := new(memcache.ServerList)
+	servers.SetServers(hostList...)
+	var selector memcache.ServerSelector = servers
+	var nodes *memcachedNodes
+	if v, ok := opts[optionWithMemcachedHealthCheck].(MemcachedHealthCheck); ok {
+		nodes = newMemcachedNodes(servers, v)
+		selector = nodes
+	}
+	useMeta, _ := opts[optionWithMemcachedMetaProtocol].(bool)
+	store := &MemcachedStore{
+		Client:            memcache.NewFromSelector(selector),
+		defaultExpiration: defaultExpiration,
+		valueSize:         newValueSizeLimit(opts),
+		ttlJitter:         newTTLJitter(opts),
+		ttlPolicy:         newTTLPolicy(opts),
+		ttlClamp:          newTTLClamp(opts),
+		selector:          selector,
+		nodes:             nodes,
+		meta:              newMemcachedMeta(selector),
+		useMeta:           useMeta,
+	}
+	if nodes != nil {
+		go nodes.run()
+		// the probes only reference the nodes, so the finalizer fires once the store
+		// itself is unreachable and stops them
+		runtime.SetFinalizer(store, stopMemcachedHealthCheck)
+	}
+	return store
 }
 
 // Set (see CacheStore interface)
 func (c *MemcachedStore) Set(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Set, key, value, expires)
+	return c.invoke((*memcache.Client).Set, 'S', key, value, expires)
 }
 
 // Add (see CacheStore interface)
 func (c *MemcachedStore) Add(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Add, key, value, expires)
+	return c.invoke((*memcache.Client).Add, 'E', key, value, expires)
 }
 
 // Replace (see CacheStore interface)
 func (c *MemcachedStore) Replace(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Replace, key, value, expires)
+	return c.invoke((*memcache.Client).Replace, 'R', key, value, expires)
 }
 
 // Get (see CacheStore interface)
 func (c *MemcachedStore) Get(key string, value interface{}) error {
+	if c.useMeta {
+		reply, err := c.meta.get(key, "v")
+		if err != nil {
+			return err
+		}
+		return utils.Deserialize(reply.value, value)
+	}
 	item, err := c.Client.Get(key)
 	if err != nil {
 		return convertMemcacheError(err)
@@ -44,6 +91,9 @@ func (c *MemcachedStore) Get(key string, value interface{}) error {
 
 // Delete (see CacheStore interface)
 func (c *MemcachedStore) Delete(key string) error {
+	if c.useMeta {
+		return c.meta.delete(key)
+	}
 	return convertMemcacheError(c.Client.Delete(key))
 }
 
@@ -64,20 +114,60 @@ func (c *MemcachedStore) Flush() error {
 	return ErrNotSupport
 }
 
-func (c *MemcachedStore) invoke(storeFn func(*memcache.Client, *memcache.Item) error,
-	key string, value interface{}, expire time.Duration) error {
+// GetExpiresIn (see CacheStore interface). The memcached text protocol doesn't expose
+// expirations, so it reads them with the meta protocol whether or not the store uses
+// it (see WithMemcachedMetaProtocol), which needs memcached 1.6 or later.
+func (c *MemcachedStore) GetExpiresIn(key string) (time.Duration, error) {
+	reply, err := c.meta.get(key, "t")
+	if err != nil {
+		return 0, err
+	}
+	ttl, err := metaTTL(reply)
+	if err == nil && ttl == FOREVER {
+		return 0, ErrCacheNoTTL
+	}
+	return ttl, err
+}
 
-	switch expire {
-	case DEFAULT:
-		expire = c.defaultExpiration
-	case FOREVER:
-		expire = time.Duration(0)
+// GetOrSet (see CacheStore interface)
+func (c *MemcachedStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(c, key, ttl, ptr, fill)
+}
+
+// ttl resolves the expiration of key written with expires, 0 for entries that never
+// expire
+func (c *MemcachedStore) ttl(key string, expires time.Duration) time.Duration {
+	jitter := c.ttlJitter
+	if expires == DEFAULT {
+		expires, jitter = c.ttlPolicy.resolve(key, c.defaultExpiration, jitter)
+	}
+	if expires == FOREVER {
+		expires = time.Duration(0)
 	}
+	return jitter.apply(c.ttlClamp.apply(expires))
+}
+
+// invoke stores value with storeFn, or with the meta set command in mode with the meta
+// protocol
+func (c *MemcachedStore) invoke(storeFn func(*memcache.Client, *memcache.Item) error, mode byte,
+	key string, value interface{}, expire time.Duration) error {
 
+	expire = c.ttl(key, expire)
 	b, err := utils.Serialize(value)
 	if err != nil {
 		return err
 	}
+	if skip, err := c.valueSize.check(key, len(b)); err != nil {
+		return err
+	} else if skip {
+		if err := c.Delete(key); err != ErrCacheMiss {
+			return err
+		}
+		return nil
+	}
+	if c.useMeta {
+		return c.meta.set(key, b, int32(expire/time.Second), mode)
+	}
 	return convertMemcacheError(storeFn(c.Client, &memcache.Item{
 		Key:        key,
 		Value:      b,
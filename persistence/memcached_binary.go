@@ -12,47 +12,62 @@ import (
 type MemcachedBinaryStore struct {
 	*mc.Client
 	defaultExpiration time.Duration
+	valueSize         valueSizeLimit
+	ttlJitter         ttlJitter
+	ttlPolicy         ttlPolicy
+	ttlClamp          ttlClamp
 }
 
 // NewMemcachedBinaryStore returns a MemcachedBinaryStore
-func NewMemcachedBinaryStore(hostList, username, password string, defaultExpiration time.Duration) *MemcachedBinaryStore {
-	return &MemcachedBinaryStore{mc.NewMC(hostList, username, password), defaultExpiration}
+func NewMemcachedBinaryStore(hostList, username, password string, defaultExpiration time.Duration, opt ...Option) *MemcachedBinaryStore {
+	opts := GetOpts(opt...)
+	return &MemcachedBinaryStore{mc.NewMC(hostList, username, password), defaultExpiration, newValueSizeLimit(opts), newTTLJitter(opts), newTTLPolicy(opts), newTTLClamp(opts)}
 }
 
 // NewMemcachedBinaryStoreWithConfig returns a MemcachedBinaryStore using the provided configuration
-func NewMemcachedBinaryStoreWithConfig(hostList, username, password string, defaultExpiration time.Duration, config *mc.Config) *MemcachedBinaryStore {
-	return &MemcachedBinaryStore{mc.NewMCwithConfig(hostList, username, password, config), defaultExpiration}
+func NewMemcachedBinaryStoreWithConfig(hostList, username, password string, defaultExpiration time.Duration, config *mc.Config, opt ...Option) *MemcachedBinaryStore {
+	opts := GetOpts(opt...)
+	return &MemcachedBinaryStore{mc.NewMCwithConfig(hostList, username, password, config), defaultExpiration, newValueSizeLimit(opts), newTTLJitter(opts), newTTLPolicy(opts), newTTLClamp(opts)}
 }
 
 // Set (see CacheStore interface)
 func (s *MemcachedBinaryStore) Set(key string, value interface{}, expires time.Duration) error {
-	exp := s.getExpiration(expires)
+	exp := s.getExpiration(key, expires)
 	b, err := utils.Serialize(value)
 	if err != nil {
 		return err
 	}
+	if skip, err := s.checkValueSize(key, b); skip || err != nil {
+		return err
+	}
 	_, err = s.Client.Set(key, string(b), 0, exp, 0)
 	return convertMcError(err)
 }
 
 // Add (see CacheStore interface)
 func (s *MemcachedBinaryStore) Add(key string, value interface{}, expires time.Duration) error {
-	exp := s.getExpiration(expires)
+	exp := s.getExpiration(key, expires)
 	b, err := utils.Serialize(value)
 	if err != nil {
 		return err
 	}
+	if skip, err := s.checkValueSize(key, b); skip || err != nil {
+		return err
+	}
 	_, err = s.Client.Add(key, string(b), 0, exp)
 	return convertMcError(err)
 }
 
 // Replace (see CacheStore interface)
 func (s *MemcachedBinaryStore) Replace(key string, value interface{}, expires time.Duration) error {
-	exp := s.getExpiration(expires)
+	exp := s.getExpiration(key, expires)
 	b, err := utils.Serialize(value)
 	if err != nil {
 		return err
 	}
+	if skip, err := s.checkValueSize(key, b); skip || err != nil {
+		return err
+	}
 	_, err = s.Client.Replace(key, string(b), 0, exp, 0)
 	return convertMcError(err)
 }
@@ -88,17 +103,44 @@ func (s *MemcachedBinaryStore) Flush() error {
 	return convertMcError(s.Client.Flush(0))
 }
 
+// GetExpiresIn (see CacheStore interface). The memcached binary protocol doesn't
+// expose expirations, so it returns ErrNotSupport for the keys that exist.
+func (s *MemcachedBinaryStore) GetExpiresIn(key string) (time.Duration, error) {
+	if _, _, _, err := s.Client.Get(key); err != nil {
+		return 0, convertMcError(err)
+	}
+	return 0, ErrNotSupport
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *MemcachedBinaryStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// checkValueSize applies the WithMaxValueSize limit, removing the key when an
+// oversized value is skipped
+func (s *MemcachedBinaryStore) checkValueSize(key string, b []byte) (bool, error) {
+	skip, err := s.valueSize.check(key, len(b))
+	if skip {
+		if err := convertMcError(s.Client.Del(key)); err != ErrCacheMiss {
+			return true, err
+		}
+	}
+	return skip, err
+}
+
 // getExpiration converts a gin-contrib/cache expiration in the form of a
 // time.Duration to a valid memcached expiration either in seconds (<30 days)
 // or a Unix timestamp (>30 days)
-func (s *MemcachedBinaryStore) getExpiration(expires time.Duration) uint32 {
-	switch expires {
-	case DEFAULT:
-		expires = s.defaultExpiration
-	case FOREVER:
+func (s *MemcachedBinaryStore) getExpiration(key string, expires time.Duration) uint32 {
+	jitter := s.ttlJitter
+	if expires == DEFAULT {
+		expires, jitter = s.ttlPolicy.resolve(key, s.defaultExpiration, jitter)
+	}
+	if expires == FOREVER {
 		expires = time.Duration(0)
 	}
-	exp := uint32(expires.Seconds())
+	exp := uint32(jitter.apply(s.ttlClamp.apply(expires)).Seconds())
 	if exp > 60*60*24*30 { // > 30 days
 		exp += uint32(time.Now().Unix())
 	}
@@ -0,0 +1,197 @@
+package persistence
+
+import (
+	"bufio"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	defaultHealthCheckInterval = time.Second
+	defaultHealthCheckTimeout  = 500 * time.Millisecond
+	defaultHealthCheckFailures = 2
+)
+
+// MemcachedHealthCheck configures the probing of the memcached nodes (see
+// WithMemcachedHealthCheck)
+type MemcachedHealthCheck struct {
+	// Interval is how often every node is probed, every second by default
+	Interval time.Duration
+	// Timeout bounds each probe, 500ms by default
+	Timeout time.Duration
+	// Failures is the number of probes in a row a node must fail to be ejected, 2 by
+	// default; a single successful probe brings it back
+	Failures int
+	// OnChange is called, outside of any lock, whenever a node is ejected (healthy is
+	// false) or rejoins (healthy is true)
+	OnChange func(node string, healthy bool)
+}
+
+// memcachedNodes picks the memcached node of each key among the healthy ones, with
+// rendezvous hashing so ejecting or restoring a node only moves its own keys
+type memcachedNodes struct {
+	check MemcachedHealthCheck
+	names []string
+	addrs []net.Addr
+	stop  chan bool
+
+	mu       sync.RWMutex
+	healthy  []bool
+	failures []int
+}
+
+var _ memcache.ServerSelector = &memcachedNodes{}
+
+func newMemcachedNodes(servers *memcache.ServerList, check MemcachedHealthCheck) *memcachedNodes {
+	if check.Interval <= 0 {
+		check.Interval = defaultHealthCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+	if check.Failures <= 0 {
+		check.Failures = defaultHealthCheckFailures
+	}
+	n := &memcachedNodes{check: check, stop: make(chan bool)}
+	servers.Each(func(addr net.Addr) error {
+		n.names = append(n.names, addr.String())
+		n.addrs = append(n.addrs, addr)
+		return nil
+	})
+	n.healthy = make([]bool, len(n.addrs))
+	n.failures = make([]int, len(n.addrs))
+	for i := range n.healthy {
+		n.healthy[i] = true
+	}
+	return n
+}
+
+// PickServer returns the healthy node scoring highest for key
+func (n *memcachedNodes) PickServer(key string) (net.Addr, error) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	keyHash := h.Sum64()
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	best, bestScore := -1, uint64(0)
+	for i := range n.addrs {
+		if !n.healthy[i] {
+			continue
+		}
+		if score := mix64(keyHash ^ uint64(i+1)*0x9e3779b97f4a7c15); best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return nil, memcache.ErrNoServers
+	}
+	return n.addrs[best], nil
+}
+
+// Each calls f with every healthy node
+func (n *memcachedNodes) Each(f func(net.Addr) error) error {
+	for _, addr := range n.healthyAddrs() {
+		if err := f(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *memcachedNodes) healthyAddrs() []net.Addr {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	var addrs []net.Addr
+	for i, addr := range n.addrs {
+		if n.healthy[i] {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// probe checks that the node at addr answers the version command
+func (n *memcachedNodes) probe(addr net.Addr) bool {
+	c, err := net.DialTimeout(addr.Network(), addr.String(), n.check.Timeout)
+	if err != nil {
+		return false
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(n.check.Timeout))
+	if _, err := c.Write([]byte("version\r\n")); err != nil {
+		return false
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	return err == nil && strings.HasPrefix(line, "VERSION ")
+}
+
+// checkAll probes every node concurrently, ejecting and restoring them as needed
+func (n *memcachedNodes) checkAll() {
+	ok := make([]bool, len(n.addrs))
+	var wg sync.WaitGroup
+	for i, addr := range n.addrs {
+		wg.Add(1)
+		go func(i int, addr net.Addr) {
+			defer wg.Done()
+			ok[i] = n.probe(addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	var changed []int
+	n.mu.Lock()
+	for i := range n.addrs {
+		if ok[i] {
+			n.failures[i] = 0
+			if !n.healthy[i] {
+				n.healthy[i] = true
+				changed = append(changed, i)
+			}
+			continue
+		}
+		n.failures[i]++
+		if n.healthy[i] && n.failures[i] >= n.check.Failures {
+			n.healthy[i] = false
+			changed = append(changed, i)
+		}
+	}
+	n.mu.Unlock()
+	if n.check.OnChange != nil {
+		for _, i := range changed {
+			n.check.OnChange(n.names[i], ok[i])
+		}
+	}
+}
+
+func (n *memcachedNodes) run() {
+	ticker := time.NewTicker(n.check.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.checkAll()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// HealthyNodes returns the addresses of the nodes keys are currently spread over: all
+// of them unless WithMemcachedHealthCheck ejected some
+func (c *MemcachedStore) HealthyNodes() []string {
+	var nodes []string
+	c.selector.Each(func(addr net.Addr) error {
+		nodes = append(nodes, addr.String())
+		return nil
+	})
+	return nodes
+}
+
+func stopMemcachedHealthCheck(c *MemcachedStore) {
+	c.nodes.stop <- true
+}
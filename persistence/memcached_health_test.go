@@ -0,0 +1,92 @@
+package persistence
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type nodeChange struct {
+	node    string
+	healthy bool
+}
+
+func TestMemcachedHealthCheck(t *testing.T) {
+	srvA, addrA := runMetaServer(t)
+	srvB, addrB := runMetaServer(t)
+	changes := make(chan nodeChange, 10)
+	store := NewMemcachedStore([]string{addrA, addrB}, time.Hour, WithMemcachedMetaProtocol(),
+		WithMemcachedHealthCheck(MemcachedHealthCheck{
+			Interval: 10 * time.Millisecond,
+			Failures: 2,
+			OnChange: func(node string, healthy bool) { changes <- nodeChange{node, healthy} },
+		}))
+	defer stopMemcachedHealthCheck(store)
+
+	// find keys held by each node
+	var keyA, keyB string
+	for i := 0; keyA == "" || keyB == ""; i++ {
+		key := fmt.Sprint("key", i)
+		addr, _ := store.selector.PickServer(key)
+		if addr.String() == addrA {
+			keyA = key
+		} else {
+			keyB = key
+		}
+	}
+	store.Set(keyA, "a", DEFAULT)
+	store.Set(keyB, "b", DEFAULT)
+	if len(srvA.entries) != 1 || len(srvB.entries) != 1 {
+		t.Fatalf("expected one key per node, got %d and %d", len(srvA.entries), len(srvB.entries))
+	}
+
+	srvB.close()
+	select {
+	case c := <-changes:
+		if c != (nodeChange{addrB, false}) {
+			t.Fatalf("expected %s to be ejected, got %+v", addrB, c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the failed node wasn't ejected")
+	}
+	if nodes := store.HealthyNodes(); len(nodes) != 1 || nodes[0] != addrA {
+		t.Errorf("expected only %s to be healthy, got %v", addrA, nodes)
+	}
+	// the keys of the failed node move to the other one, and only them
+	if err := store.Set(keyB, "b2", DEFAULT); err != nil {
+		t.Errorf("expected the key of the ejected node to be written elsewhere, got %v", err)
+	}
+	var v string
+	if err := store.Get(keyA, &v); err != nil || v != "a" {
+		t.Errorf("expected the keys of the healthy node to stay, got %q (%v)", v, err)
+	}
+
+	srvB.listen(t, addrB)
+	select {
+	case c := <-changes:
+		if c != (nodeChange{addrB, true}) {
+			t.Fatalf("expected %s to rejoin, got %+v", addrB, c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the recovered node didn't rejoin")
+	}
+	if err := store.Get(keyB, &v); err != nil || v != "b" {
+		t.Errorf("expected the key to be read from its node again, got %q (%v)", v, err)
+	}
+}
+
+func TestMemcachedHealthCheck_NoNodes(t *testing.T) {
+	srv, addr := runMetaServer(t)
+	store := NewMemcachedStore([]string{addr}, time.Hour, WithMemcachedMetaProtocol(),
+		WithMemcachedHealthCheck(MemcachedHealthCheck{Interval: 10 * time.Millisecond, Failures: 1}))
+	defer stopMemcachedHealthCheck(store)
+	srv.close()
+	deadline := time.Now().Add(time.Second)
+	for len(store.HealthyNodes()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var v string
+	if err := store.Get("key", &v); err == nil {
+		t.Error("expected reads to fail with every node ejected")
+	}
+}
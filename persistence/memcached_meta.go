@@ -0,0 +1,253 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/utils"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// metaReply is the reply of a memcached meta command: its status code ("HD", "VA",
+// "EN", "NS", "NF", ...), the flags returned, keyed by their letter, and the value of
+// "VA" replies
+type metaReply struct {
+	status string
+	flags  map[byte]string
+	value  []byte
+}
+
+// metaConn is an idle connection to a memcached server
+type metaConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// memcachedMeta speaks the memcached meta protocol (mg, ms and md, memcached 1.6+),
+// which gomemcache doesn't, to the server gomemcache picks for each key, so both reach
+// the same entries. Connections are pooled per server like gomemcache does.
+type memcachedMeta struct {
+	servers memcache.ServerSelector
+	timeout time.Duration
+	mu      sync.Mutex
+	idle    map[string][]*metaConn
+}
+
+func newMemcachedMeta(servers memcache.ServerSelector) *memcachedMeta {
+	return &memcachedMeta{servers: servers, timeout: memcache.DefaultTimeout, idle: map[string][]*metaConn{}}
+}
+
+// metaKey reports whether key can be sent as is in meta commands, which have the same
+// limits as the text protocol
+func metaKey(key string) bool {
+	if len(key) == 0 || len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *memcachedMeta) conn(addr net.Addr) (*metaConn, error) {
+	m.mu.Lock()
+	if idle := m.idle[addr.String()]; len(idle) > 0 {
+		cn := idle[len(idle)-1]
+		m.idle[addr.String()] = idle[:len(idle)-1]
+		m.mu.Unlock()
+		return cn, nil
+	}
+	m.mu.Unlock()
+	nc, err := net.DialTimeout(addr.Network(), addr.String(), m.timeout)
+	if err != nil {
+		return nil, &ConnError{Cause: err}
+	}
+	return &metaConn{conn: nc, rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))}, nil
+}
+
+func (m *memcachedMeta) release(addr net.Addr, cn *metaConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.idle[addr.String()]) >= memcache.DefaultMaxIdleConns {
+		cn.conn.Close()
+		return
+	}
+	m.idle[addr.String()] = append(m.idle[addr.String()], cn)
+}
+
+// do sends the meta command cmd for key, followed by the data block data unless nil,
+// and reads its reply. The error replies of the server are returned as errors.
+func (m *memcachedMeta) do(key, cmd string, data []byte) (*metaReply, error) {
+	if !metaKey(key) {
+		return nil, memcache.ErrMalformedKey
+	}
+	addr, err := m.servers.PickServer(key)
+	if err != nil {
+		return nil, err
+	}
+	cn, err := m.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	cn.conn.SetDeadline(time.Now().Add(m.timeout))
+	reply, err := m.roundTrip(cn.rw, cmd, data)
+	if _, ok := err.(*ConnError); ok {
+		// the connection is in an unknown state
+		cn.conn.Close()
+		return nil, err
+	}
+	m.release(addr, cn)
+	return reply, err
+}
+
+func (m *memcachedMeta) roundTrip(rw *bufio.ReadWriter, cmd string, data []byte) (*metaReply, error) {
+	rw.WriteString(cmd)
+	rw.WriteString("\r\n")
+	if data != nil {
+		rw.Write(data)
+		rw.WriteString("\r\n")
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, &ConnError{Cause: err}
+	}
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		return nil, &ConnError{Cause: err}
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return nil, fmt.Errorf("cache: memcached: %s", line)
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, &ConnError{Cause: fmt.Errorf("cache: memcached: malformed reply %q", line)}
+	}
+	reply := &metaReply{status: fields[0], flags: map[byte]string{}}
+	fields = fields[1:]
+	if reply.status == "VA" {
+		if len(fields) == 0 {
+			return nil, &ConnError{Cause: fmt.Errorf("cache: memcached: malformed reply %q", line)}
+		}
+		size, err := strconv.Atoi(fields[0])
+		if err != nil || size < 0 {
+			return nil, &ConnError{Cause: fmt.Errorf("cache: memcached: malformed reply %q", line)}
+		}
+		reply.value = make([]byte, size+2)
+		if _, err := io.ReadFull(rw, reply.value); err != nil {
+			return nil, &ConnError{Cause: err}
+		}
+		if !bytes.HasSuffix(reply.value, []byte("\r\n")) {
+			return nil, &ConnError{Cause: fmt.Errorf("cache: memcached: malformed value for %q", line)}
+		}
+		reply.value = reply.value[:size]
+		fields = fields[1:]
+	}
+	for _, f := range fields {
+		reply.flags[f[0]] = f[1:]
+	}
+	return reply, nil
+}
+
+// get sends mg for key with flags, returning ErrCacheMiss on misses
+func (m *memcachedMeta) get(key string, flags ...string) (*metaReply, error) {
+	reply, err := m.do(key, strings.Join(append([]string{"mg", key}, flags...), " "), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch reply.status {
+	case "VA", "HD":
+		return reply, nil
+	case "EN":
+		return nil, ErrCacheMiss
+	}
+	return nil, fmt.Errorf("cache: memcached: unexpected reply %q to mg", reply.status)
+}
+
+// set sends ms for key in mode ('S' set, 'E' add, 'R' replace), returning ErrNotStored
+// when the mode prevented it
+func (m *memcachedMeta) set(key string, value []byte, ttl int32, mode byte) error {
+	cmd := fmt.Sprintf("ms %s %d T%d M%c", key, len(value), ttl, mode)
+	reply, err := m.do(key, cmd, value)
+	if err != nil {
+		return err
+	}
+	switch reply.status {
+	case "HD":
+		return nil
+	case "NS", "NF", "EX":
+		return ErrNotStored
+	}
+	return fmt.Errorf("cache: memcached: unexpected reply %q to ms", reply.status)
+}
+
+// delete sends md for key, returning ErrCacheMiss if it wasn't found
+func (m *memcachedMeta) delete(key string) error {
+	reply, err := m.do(key, "md "+key, nil)
+	if err != nil {
+		return err
+	}
+	switch reply.status {
+	case "HD":
+		return nil
+	case "NF":
+		return ErrCacheMiss
+	}
+	return fmt.Errorf("cache: memcached: unexpected reply %q to md", reply.status)
+}
+
+// metaTTL converts the t flag of mg replies, -1 for entries that never expire
+func metaTTL(reply *metaReply) (time.Duration, error) {
+	t, err := strconv.ParseInt(reply.flags['t'], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cache: memcached: malformed TTL %q", reply.flags['t'])
+	}
+	if t < 0 {
+		return FOREVER, nil
+	}
+	return time.Duration(t) * time.Second, nil
+}
+
+// GetWithTTL reads the value of key along with the time left before it expires in a
+// single round trip, FOREVER for entries that never expire. It uses the meta protocol,
+// so it needs memcached 1.6 or later, with or without WithMemcachedMetaProtocol.
+func (c *MemcachedStore) GetWithTTL(key string, value interface{}) (time.Duration, error) {
+	reply, err := c.meta.get(key, "v", "t")
+	if err != nil {
+		return 0, err
+	}
+	ttl, err := metaTTL(reply)
+	if err != nil {
+		return 0, err
+	}
+	return ttl, utils.Deserialize(reply.value, value)
+}
+
+// GetAndTouch reads the value of key and resets its expiration to expires, as Set does,
+// in a single round trip. It uses the meta protocol, so it needs memcached 1.6 or later.
+func (c *MemcachedStore) GetAndTouch(key string, value interface{}, expires time.Duration) error {
+	reply, err := c.meta.get(key, "v", "T"+strconv.Itoa(int(c.ttl(key, expires)/time.Second)))
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(reply.value, value)
+}
+
+// Peek reads the value of key without counting it as an access, so reading it doesn't
+// save it from the memcached LRU. It uses the meta protocol, so it needs memcached 1.6
+// or later.
+func (c *MemcachedStore) Peek(key string, value interface{}) error {
+	reply, err := c.meta.get(key, "v", "u")
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(reply.value, value)
+}
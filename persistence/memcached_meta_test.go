@@ -0,0 +1,225 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type metaEntry struct {
+	value []byte
+	ttl   int
+}
+
+// metaServer is a memcached server speaking just enough of the meta protocol for the
+// tests, recording the commands it receives
+type metaServer struct {
+	mu       sync.Mutex
+	entries  map[string]metaEntry
+	commands []string
+	listener net.Listener
+}
+
+func runMetaServer(t *testing.T) (*metaServer, string) {
+	s := &metaServer{entries: map[string]metaEntry{}}
+	return s, s.listen(t, "127.0.0.1:0")
+}
+
+// listen serves on address until close is called, returning the address listened on
+func (s *metaServer) listen(t *testing.T, address string) string {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// close stops accepting connections, the open ones are kept
+func (s *metaServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listener.Close()
+}
+
+func (s *metaServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 1 && fields[0] == "version" {
+			fmt.Fprint(c, "VERSION 1.6.21\r\n")
+			continue
+		}
+		if len(fields) == 1 && fields[0] == "stats" {
+			s.mu.Lock()
+			fmt.Fprintf(c, "STAT version 1.6.21\r\nSTAT curr_items %d\r\nEND\r\n", len(s.entries))
+			s.mu.Unlock()
+			continue
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(fields, " "))
+		flags := map[byte]string{}
+		for _, f := range fields[2:] {
+			flags[f[0]] = f[1:]
+		}
+		e, found := s.entries[fields[1]]
+		switch fields[0] {
+		case "mg":
+			if !found {
+				fmt.Fprint(c, "EN\r\n")
+				break
+			}
+			if ttl, ok := flags['T']; ok {
+				e.ttl, _ = strconv.Atoi(ttl)
+				s.entries[fields[1]] = e
+			}
+			var ret []string
+			if _, ok := flags['t']; ok {
+				ret = append(ret, "t"+strconv.Itoa(e.ttl))
+			}
+			if _, ok := flags['v']; ok {
+				fmt.Fprintf(c, "VA %d %s\r\n%s\r\n", len(e.value), strings.Join(ret, " "), e.value)
+			} else {
+				fmt.Fprintf(c, "HD %s\r\n", strings.Join(ret, " "))
+			}
+		case "ms":
+			n, _ := strconv.Atoi(fields[2])
+			value := make([]byte, n+2)
+			io.ReadFull(r, value)
+			ttl, _ := strconv.Atoi(flags['T'])
+			if ttl == 0 {
+				ttl = -1
+			}
+			if (flags['M'] == "E" && found) || (flags['M'] == "R" && !found) {
+				fmt.Fprint(c, "NS\r\n")
+				break
+			}
+			s.entries[fields[1]] = metaEntry{value: value[:n], ttl: ttl}
+			fmt.Fprint(c, "HD\r\n")
+		case "md":
+			if !found {
+				fmt.Fprint(c, "NF\r\n")
+				break
+			}
+			delete(s.entries, fields[1])
+			fmt.Fprint(c, "HD\r\n")
+		default:
+			fmt.Fprint(c, "ERROR\r\n")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *metaServer) lastCommand() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands[len(s.commands)-1]
+}
+
+func TestMemcachedMeta_Store(t *testing.T) {
+	_, addr := runMetaServer(t)
+	store := NewMemcachedStore([]string{addr}, time.Hour, WithMemcachedMetaProtocol())
+	if err := store.Set("key", "value", time.Minute); err != nil {
+		t.Fatalf("expected Set to succeed, got %v", err)
+	}
+	var v string
+	if err := store.Get("key", &v); err != nil || v != "value" {
+		t.Errorf("expected value, got %q (%v)", v, err)
+	}
+	if ttl, err := store.GetExpiresIn("key"); err != nil || ttl != time.Minute {
+		t.Errorf("expected a minute left, got %s (%v)", ttl, err)
+	}
+	if err := store.Add("key", "other", DEFAULT); err != ErrNotStored {
+		t.Errorf("expected Add on an existing key to fail, got %v", err)
+	}
+	if err := store.Replace("missing", "other", DEFAULT); err != ErrNotStored {
+		t.Errorf("expected Replace on a missing key to fail, got %v", err)
+	}
+	if err := store.Set("forever", 1, FOREVER); err != nil {
+		t.Fatalf("expected Set to succeed, got %v", err)
+	}
+	if _, err := store.GetExpiresIn("forever"); err != ErrCacheNoTTL {
+		t.Errorf("expected ErrCacheNoTTL, got %v", err)
+	}
+	if err := store.Delete("key"); err != nil {
+		t.Errorf("expected Delete to succeed, got %v", err)
+	}
+	if err := store.Get("key", &v); err != ErrCacheMiss {
+		t.Errorf("expected a miss after Delete, got %v", err)
+	}
+	if err := store.Delete("key"); err != ErrCacheMiss {
+		t.Errorf("expected a miss deleting again, got %v", err)
+	}
+	if err := store.Get("bad key", &v); err == nil {
+		t.Error("expected keys with spaces to be rejected")
+	}
+}
+
+func TestMemcachedMeta_GetWithTTL(t *testing.T) {
+	srv, addr := runMetaServer(t)
+	store := NewMemcachedStore([]string{addr}, time.Hour, WithMemcachedMetaProtocol())
+	store.Set("key", 42, 30*time.Second)
+	var v int
+	if ttl, err := store.GetWithTTL("key", &v); err != nil || v != 42 || ttl != 30*time.Second {
+		t.Errorf("expected 42 with 30s left, got %d with %s (%v)", v, ttl, err)
+	}
+	if err := store.GetAndTouch("key", &v, 2*time.Minute); err != nil || v != 42 {
+		t.Errorf("expected 42, got %d (%v)", v, err)
+	}
+	if ttl, err := store.GetExpiresIn("key"); err != nil || ttl != 2*time.Minute {
+		t.Errorf("expected the TTL to be reset to 2m, got %s (%v)", ttl, err)
+	}
+	if err := store.Peek("key", &v); err != nil || v != 42 {
+		t.Errorf("expected 42, got %d (%v)", v, err)
+	}
+	if cmd := srv.lastCommand(); cmd != "mg key v u" {
+		t.Errorf("expected Peek not to bump the LRU, sent %q", cmd)
+	}
+	store.Set("forever", 1, FOREVER)
+	if ttl, err := store.GetWithTTL("forever", &v); err != nil || ttl != FOREVER {
+		t.Errorf("expected FOREVER, got %s (%v)", ttl, err)
+	}
+	if _, err := store.GetWithTTL("missing", &v); err != ErrCacheMiss {
+		t.Errorf("expected a miss, got %v", err)
+	}
+}
+
+func TestMemcached_GetExpiresIn(t *testing.T) {
+	srv, addr := runMetaServer(t)
+	srv.entries["key"] = metaEntry{value: []byte("1"), ttl: 90}
+	srv.entries["forever"] = metaEntry{value: []byte("1"), ttl: -1}
+	// the TTLs are read with the meta protocol even when the store doesn't use it
+	store := NewMemcachedStore([]string{addr}, time.Hour)
+	if ttl, err := store.GetExpiresIn("key"); err != nil || ttl != 90*time.Second {
+		t.Errorf("expected 90s left, got %s (%v)", ttl, err)
+	}
+	if _, err := store.GetExpiresIn("forever"); err != ErrCacheNoTTL {
+		t.Errorf("expected ErrCacheNoTTL, got %v", err)
+	}
+	if _, err := store.GetExpiresIn("missing"); err != ErrCacheMiss {
+		t.Errorf("expected a miss, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// MemcachedNodeStats is the parsed reply of the memcached stats command of one node
+type MemcachedNodeStats struct {
+	// Node is the address of the node
+	Node            string
+	Version         string
+	Uptime          time.Duration
+	CurrConnections int64
+	CurrItems       int64
+	// Bytes is the memory used by the items, out of LimitMaxBytes
+	Bytes         int64
+	LimitMaxBytes int64
+	GetHits       int64
+	GetMisses     int64
+	// HitRate is the share of the gets that were hits, 0 before any get
+	HitRate   float64
+	Evictions int64
+	// Fields holds every statistic returned, by name, e.g. "total_items"
+	Fields map[string]string
+}
+
+// Stats returns the statistics of every memcached node, by address; only the healthy
+// ones with WithMemcachedHealthCheck. The nodes that fail to answer are left out and
+// the error of the first of them is returned along with the statistics of the others.
+func (c *MemcachedStore) Stats() (map[string]*MemcachedNodeStats, error) {
+	stats := map[string]*MemcachedNodeStats{}
+	var firstErr error
+	c.selector.Each(func(addr net.Addr) error {
+		s, err := c.meta.stats(addr)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("cache: memcached stats of %s: %w", addr, err)
+			}
+			return nil
+		}
+		stats[addr.String()] = s
+		return nil
+	})
+	return stats, firstErr
+}
+
+// stats sends the stats command to the node at addr
+func (m *memcachedMeta) stats(addr net.Addr) (*MemcachedNodeStats, error) {
+	cn, err := m.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	cn.conn.SetDeadline(time.Now().Add(m.timeout))
+	var reply strings.Builder
+	err = func() error {
+		if _, err := cn.rw.WriteString("stats\r\n"); err != nil {
+			return &ConnError{Cause: err}
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return &ConnError{Cause: err}
+		}
+		for {
+			line, err := cn.rw.ReadString('\n')
+			if err != nil {
+				return &ConnError{Cause: err}
+			}
+			if line == "END\r\n" {
+				return nil
+			}
+			reply.WriteString(line)
+		}
+	}()
+	if err != nil {
+		cn.conn.Close()
+		return nil, err
+	}
+	m.release(addr, cn)
+	return parseMemcachedStats(addr.String(), reply.String()), nil
+}
+
+// parseMemcachedStats parses the "STAT name value" lines of a stats reply, statistics
+// it can't parse being left to their zero value
+func parseMemcachedStats(node, reply string) *MemcachedNodeStats {
+	s := &MemcachedNodeStats{Node: node, Fields: map[string]string{}}
+	scanner := bufio.NewScanner(strings.NewReader(reply))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 3)
+		if len(fields) == 3 && fields[0] == "STAT" {
+			s.Fields[fields[1]] = fields[2]
+		}
+	}
+	f := s.Fields
+	s.Version = f["version"]
+	s.Uptime = time.Duration(parseInt(f["uptime"])) * time.Second
+	s.CurrConnections = parseInt(f["curr_connections"])
+	s.CurrItems = parseInt(f["curr_items"])
+	s.Bytes = parseInt(f["bytes"])
+	s.LimitMaxBytes = parseInt(f["limit_maxbytes"])
+	s.GetHits = parseInt(f["get_hits"])
+	s.GetMisses = parseInt(f["get_misses"])
+	if gets := s.GetHits + s.GetMisses; gets > 0 {
+		s.HitRate = float64(s.GetHits) / float64(gets)
+	}
+	s.Evictions = parseInt(f["evictions"])
+	return s
+}
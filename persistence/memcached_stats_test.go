@@ -0,0 +1,60 @@
+package persistence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMemcachedStats(t *testing.T) {
+	s := parseMemcachedStats("node:11211", "STAT pid 1\r\nSTAT uptime 3600\r\nSTAT version 1.6.21\r\n"+
+		"STAT curr_connections 10\r\nSTAT curr_items 42\r\nSTAT bytes 1048576\r\nSTAT limit_maxbytes 67108864\r\n"+
+		"STAT get_hits 75\r\nSTAT get_misses 25\r\nSTAT evictions 3\r\nSTAT rusage_user 0.5\r\n")
+	expected := MemcachedNodeStats{
+		Node:            "node:11211",
+		Version:         "1.6.21",
+		Uptime:          time.Hour,
+		CurrConnections: 10,
+		CurrItems:       42,
+		Bytes:           1048576,
+		LimitMaxBytes:   67108864,
+		GetHits:         75,
+		GetMisses:       25,
+		HitRate:         0.75,
+		Evictions:       3,
+	}
+	if fields := s.Fields; fields["rusage_user"] != "0.5" || len(fields) != 11 {
+		t.Errorf("expected the raw statistics, got %v", fields)
+	}
+	s.Fields = nil
+	if !reflect.DeepEqual(*s, expected) {
+		t.Errorf("expected %+v, got %+v", expected, *s)
+	}
+	if s := parseMemcachedStats("node:11211", ""); s.HitRate != 0 {
+		t.Errorf("expected no hit rate before any get, got %v", s.HitRate)
+	}
+}
+
+func TestMemcachedStore_Stats(t *testing.T) {
+	_, addrA := runMetaServer(t)
+	srvB, addrB := runMetaServer(t)
+	store := NewMemcachedStore([]string{addrA, addrB}, time.Hour, WithMemcachedMetaProtocol())
+	for _, key := range []string{"a", "b", "c", "d"} {
+		store.Set(key, 1, DEFAULT)
+	}
+	stats, err := store.Stats()
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("expected the stats of both nodes, got %v (%v)", stats, err)
+	}
+	if items := stats[addrA].CurrItems + stats[addrB].CurrItems; items != 4 || stats[addrA].Version != "1.6.21" {
+		t.Errorf("unexpected stats %+v and %+v", stats[addrA], stats[addrB])
+	}
+
+	srvB.close()
+	// drop the pooled connections to the closed node
+	store.meta = newMemcachedMeta(store.selector)
+	stats, err = store.Stats()
+	if err == nil || len(stats) != 1 || stats[addrA] == nil {
+		t.Errorf("expected the stats of the node still up and an error, got %v (%v)", stats, err)
+	}
+}
@@ -0,0 +1,141 @@
+// Package memory provides a typed in-process cache. Unlike the stores in the
+// persistence package it keeps values as-is: there is no serialization and no
+// interface boxing, so a Get returns the value exactly as it was Set.
+//
+// Store is built on the same cache as persistence.InMemoryStore and takes the same
+// options, so sharding, bounds and eviction policies, expiration modes, TTL jitter,
+// policies and clamping, the clock, memory pressure and statistics all behave alike
+// (see NewInMemoryStore). It only lacks what needs the values to be boxed or encoded:
+// Increment, Decrement and the snapshots of Save and Load.
+package memory
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/Bose/cache/internal/memstore"
+	"github.com/Bose/cache/persistence"
+)
+
+// EvictionFunc is called with every entry that leaves the store
+type EvictionFunc[T any] func(key string, value T, reason persistence.Reason)
+
+// Store is a sharded, typed in-memory cache
+type Store[T any] struct {
+	cache *memstore.Cache[T]
+}
+
+// New returns a Store configured by the options of persistence.NewInMemoryStore.
+// Entries stored with persistence.DEFAULT expire after defaultExpiration, never if
+// it's <= 0.
+func New[T any](defaultExpiration time.Duration, opt ...persistence.Option) *Store[T] {
+	s := &Store[T]{memstore.New[T](memstore.FromOptions(defaultExpiration, persistence.GetOpts(opt...)))}
+	if s.cache.Running() {
+		// the janitor and the monitor only reference the inner cache, so the finalizer
+		// fires once the Store itself is unreachable and stops their goroutines if
+		// Close wasn't called
+		runtime.SetFinalizer(s, (*Store[T]).Close)
+	}
+	return s
+}
+
+// OnEvicted sets an (optional) function that is called with the key, value and reason
+// whenever an entry is expired, deleted, evicted or flushed. It is called outside of
+// any lock, so it may safely call back into the store. Pass nil to disable.
+func (s *Store[T]) OnEvicted(f EvictionFunc[T]) {
+	if f == nil {
+		s.cache.OnEvicted(nil)
+		return
+	}
+	s.cache.OnEvicted(func(key string, value T, reason memstore.Reason) {
+		f(key, value, persistence.Reason(reason))
+	})
+}
+
+// Get returns the value stored under key, or persistence.ErrCacheMiss
+func (s *Store[T]) Get(key string) (T, error) {
+	value, found := s.cache.Get(key)
+	if !found {
+		return value, persistence.ErrCacheMiss
+	}
+	return value, nil
+}
+
+// GetExpiresIn returns the time left before key expires, persistence.ErrCacheNoTTL if
+// it never does, or persistence.ErrCacheMiss
+func (s *Store[T]) GetExpiresIn(key string) (time.Duration, error) {
+	ttl, found := s.cache.TTL(key)
+	if !found {
+		return 0, persistence.ErrCacheMiss
+	}
+	if ttl == memstore.NoExpiration {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return ttl, nil
+}
+
+// Set stores the value, replacing any existing one
+func (s *Store[T]) Set(key string, value T, expires time.Duration) {
+	s.cache.Set(key, value, expires)
+}
+
+// Add stores the value only if the key doesn't exist yet, otherwise it
+// returns persistence.ErrNotStored
+func (s *Store[T]) Add(key string, value T, expires time.Duration) error {
+	if !s.cache.Add(key, value, expires) {
+		return persistence.ErrNotStored
+	}
+	return nil
+}
+
+// Replace stores the value only if the key already exists, otherwise it
+// returns persistence.ErrNotStored
+func (s *Store[T]) Replace(key string, value T, expires time.Duration) error {
+	if !s.cache.Replace(key, value, expires) {
+		return persistence.ErrNotStored
+	}
+	return nil
+}
+
+// Delete removes the key, returning persistence.ErrCacheMiss if it doesn't exist
+func (s *Store[T]) Delete(key string) error {
+	if !s.cache.Delete(key) {
+		return persistence.ErrCacheMiss
+	}
+	return nil
+}
+
+// Flush removes every entry from the store
+func (s *Store[T]) Flush() {
+	s.cache.Flush()
+}
+
+// Len returns the number of unexpired entries in the store
+func (s *Store[T]) Len() int {
+	return s.cache.Len()
+}
+
+// Range calls f for every unexpired entry in the store until f returns false.
+// Each shard is copied before f is called, so f may safely call back into the store.
+func (s *Store[T]) Range(f func(key string, value T) bool) {
+	s.cache.Range(f)
+}
+
+// Shed evicts fraction (0 to 1) of the entries of every shard (see
+// persistence.InMemoryStore.Shed) and returns how many were
+func (s *Store[T]) Shed(fraction float64) int {
+	return s.cache.Shed(fraction)
+}
+
+// Stats returns the store's runtime statistics (see persistence.InMemoryStats)
+func (s *Store[T]) Stats() persistence.InMemoryStats {
+	return persistence.InMemoryStats(s.cache.Stats())
+}
+
+// Close stops the goroutines of the store: the janitor sweeping expired entries and
+// the monitor set by persistence.WithMemoryPressure. The store is still usable after.
+// It's safe to call more than once.
+func (s *Store[T]) Close() error {
+	s.cache.Close()
+	return nil
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+type user struct {
+	ID   int
+	Name string
+}
+
+func TestStore_TypicalGetSet(t *testing.T) {
+	s := New[*user](time.Hour)
+	u := &user{ID: 1, Name: "alice"}
+	s.Set("user:1", u, persistence.DEFAULT)
+
+	got, err := s.Get("user:1")
+	if err != nil {
+		t.Fatalf("Error getting a value: %s", err)
+	}
+	if got != u {
+		t.Errorf("expected the stored pointer back, got %v", got)
+	}
+	if _, err := s.Get("user:2"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestStore_AddReplaceDelete(t *testing.T) {
+	s := New[int](time.Hour)
+	if err := s.Replace("n", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored replacing a missing key, got %v", err)
+	}
+	if err := s.Add("n", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("n", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored adding an existing key, got %v", err)
+	}
+	if err := s.Replace("n", 3, persistence.DEFAULT); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if v, _ := s.Get("n"); v != 3 {
+		t.Errorf("expected 3, got %d", v)
+	}
+	if err := s.Delete("n"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("n"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestStore_Expiration(t *testing.T) {
+	s := New[string](10*time.Millisecond, persistence.WithCleanupInterval(5*time.Millisecond))
+	var mu sync.Mutex
+	var reasons []persistence.Reason
+	s.OnEvicted(func(_ string, _ string, reason persistence.Reason) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons = append(reasons, reason)
+	})
+	s.Set("default", "a", persistence.DEFAULT)
+	s.Set("forever", "b", persistence.FOREVER)
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := s.Get("default"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected the entry to expire, got %v", err)
+	}
+	if v, err := s.Get("forever"); err != nil || v != "b" {
+		t.Errorf("expected b, got %s (%v)", v, err)
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("expected 1 entry, got %d", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reasons) != 1 || reasons[0] != persistence.ReasonExpired {
+		t.Errorf("expected one expiry callback, got %v", reasons)
+	}
+}
+
+func TestStore_MaxEntries(t *testing.T) {
+	s := New[int](time.Hour, persistence.WithShards(1), persistence.WithMaxEntries(2))
+	var evicted []string
+	s.OnEvicted(func(key string, _ int, reason persistence.Reason) {
+		if reason == persistence.ReasonEvicted {
+			evicted = append(evicted, key)
+		}
+	})
+	s.Set("a", 1, persistence.DEFAULT)
+	s.Set("b", 2, persistence.DEFAULT)
+	s.Get("a")
+	s.Set("c", 3, persistence.DEFAULT)
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("expected the least recently used entry to be evicted, got %v", evicted)
+	}
+	st := s.Stats()
+	if st.Entries != 2 || st.Evictions != 1 || st.Hits != 1 {
+		t.Errorf("expected 2 entries, 1 eviction and 1 hit, got %+v", st)
+	}
+}
+
+func TestStore_Clock(t *testing.T) {
+	clock := persistence.NewFakeClock(time.Now())
+	s := New[string](time.Minute, persistence.WithClock(clock), persistence.WithExpirationMode(persistence.ExpireLazy),
+		persistence.WithTTLPolicy(persistence.TTLRule{Pattern: "long:*", TTL: time.Hour}))
+	s.Set("short", "a", persistence.DEFAULT)
+	s.Set("long:1", "b", persistence.DEFAULT)
+	if d, err := s.GetExpiresIn("long:1"); err != nil || d != time.Hour {
+		t.Errorf("expected the TTL of the policy, got %s (%v)", d, err)
+	}
+	clock.Advance(2 * time.Minute)
+	if _, err := s.Get("short"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected the entry to expire with the clock, got %v", err)
+	}
+	if v, err := s.Get("long:1"); err != nil || v != "b" {
+		t.Errorf("expected b, got %s (%v)", v, err)
+	}
+	if st := s.Stats(); st.Entries != 1 || st.Expirations != 1 {
+		t.Errorf("expected the expired entry to be removed when read, got %+v", st)
+	}
+}
+
+func BenchmarkStore_Get(b *testing.B) {
+	s := New[user](time.Hour)
+	s.Set("user", user{ID: 1, Name: "alice"}, persistence.DEFAULT)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Get("user")
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package persistence
+
+import (
+	"errors"
+	"time"
+)
+
+// Names of the metrics MetricsMiddleware reports
+const (
+	MetricOpDuration = "cache.op.duration"
+	MetricHit        = "cache.hit"
+	MetricMiss       = "cache.miss"
+	MetricError      = "cache.error"
+)
+
+// MetricsSink receives the metrics of MetricsMiddleware, e.g. to ship them to StatsD.
+// Tags are "name:value" pairs, as DogStatsD has them.
+type MetricsSink interface {
+	// Timing records how long something took
+	Timing(name string, d time.Duration, tags ...string)
+	// Count adds n to a counter
+	Count(name string, n int64, tags ...string)
+}
+
+// MetricsMiddleware returns a Middleware reporting to sink how long every operation
+// takes (MetricOpDuration), the operations failing (MetricError, misses aside), and
+// the Get operations finding their key or not (MetricHit and MetricMiss), from which
+// the hit ratio follows. Metrics are tagged with store and the operation.
+func MetricsMiddleware(sink MetricsSink, store string) Middleware {
+	storeTag := "store:" + store
+	return func(op Op, key string, next func() error) error {
+		start := time.Now()
+		err := next()
+		opTag := "op:" + string(op)
+		sink.Timing(MetricOpDuration, time.Since(start), storeTag, opTag)
+		switch {
+		case errors.Is(err, ErrCacheMiss):
+			if op == OpGet {
+				sink.Count(MetricMiss, 1, storeTag, opTag)
+			}
+		case err != nil && !errors.Is(err, ErrNilValue):
+			sink.Count(MetricError, 1, storeTag, opTag)
+		case op == OpGet:
+			sink.Count(MetricHit, 1, storeTag, opTag)
+		}
+		return err
+	}
+}
@@ -0,0 +1,67 @@
+package persistence
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink counts the metrics it receives by name and tags
+type recordingSink struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (s *recordingSink) record(name string, n int64, tags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = map[string]int64{}
+	}
+	s.counts[name+" "+strings.Join(tags, ",")] += n
+}
+
+func (s *recordingSink) Timing(name string, d time.Duration, tags ...string) {
+	s.record(name, 1, tags)
+}
+
+func (s *recordingSink) Count(name string, n int64, tags ...string) {
+	s.record(name, n, tags)
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	sink := &recordingSink{}
+	s := NewMiddlewareStore(NewInMemoryStore(time.Hour), WithMiddleware(MetricsMiddleware(sink, "sessions")))
+	s.Set("key", 1, DEFAULT)
+	var v int
+	s.Get("key", &v)
+	s.Get("missing", &v)
+	s.Replace("missing", 1, DEFAULT)
+
+	for metric, expected := range map[string]int64{
+		"cache.op.duration store:sessions,op:set":     1,
+		"cache.op.duration store:sessions,op:get":     2,
+		"cache.op.duration store:sessions,op:replace": 1,
+		"cache.hit store:sessions,op:get":             1,
+		"cache.miss store:sessions,op:get":            1,
+		"cache.error store:sessions,op:replace":       1,
+	} {
+		if sink.counts[metric] != expected {
+			t.Errorf("expected %d for %q, got %d", expected, metric, sink.counts[metric])
+		}
+	}
+	if len(sink.counts) != 6 {
+		t.Errorf("unexpected metrics: %v", sink.counts)
+	}
+}
+
+func TestMetricsMiddleware_WrappedErrors(t *testing.T) {
+	sink := &recordingSink{}
+	mw := MetricsMiddleware(sink, "sessions")
+	mw(OpGet, "key", func() error { return fmt.Errorf("shard 1: %w", ErrCacheMiss) })
+	if sink.counts["cache.miss store:sessions,op:get"] != 1 || sink.counts["cache.error store:sessions,op:get"] != 0 {
+		t.Errorf("expected the wrapped miss to count as a miss, got %v", sink.counts)
+	}
+}
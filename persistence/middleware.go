@@ -0,0 +1,145 @@
+package persistence
+
+import "time"
+
+// Op names the CacheStore operation passed through middleware
+type Op string
+
+const (
+	OpGet          Op = "get"
+	OpSet          Op = "set"
+	OpAdd          Op = "add"
+	OpReplace      Op = "replace"
+	OpDelete       Op = "delete"
+	OpIncrement    Op = "increment"
+	OpDecrement    Op = "decrement"
+	OpFlush        Op = "flush"
+	OpGetExpiresIn Op = "get_expires_in"
+)
+
+// Middleware intercepts a store operation on key (empty for OpFlush). It runs the
+// operation by calling next, and returns its error or one of its own; not calling
+// next skips the operation altogether.
+type Middleware func(op Op, key string, next func() error) error
+
+// Call describes a finished store operation, as passed to Hooks.After
+type Call struct {
+	Op       Op
+	Key      string
+	Duration time.Duration
+	Err      error
+}
+
+// Hooks are the callbacks of HookMiddleware; either may be nil
+type Hooks struct {
+	Before func(op Op, key string)
+	After  func(call Call)
+}
+
+// HookMiddleware returns a Middleware calling h.Before ahead of each operation and
+// h.After once it has finished, with how long it took and its error
+func HookMiddleware(h Hooks) Middleware {
+	return func(op Op, key string, next func() error) error {
+		if h.Before != nil {
+			h.Before(op, key)
+		}
+		start := time.Now()
+		err := next()
+		if h.After != nil {
+			h.After(Call{Op: op, Key: key, Duration: time.Since(start), Err: err})
+		}
+		return err
+	}
+}
+
+// MiddlewareStore passes every operation on the wrapped store through the middleware
+// given WithMiddleware, so metrics, tracing and audit logging can be layered on any
+// store without a bespoke wrapper each
+type MiddlewareStore struct {
+	store CacheStore
+	chain []Middleware
+}
+
+var _ CacheStore = &MiddlewareStore{}
+
+// NewMiddlewareStore returns a MiddlewareStore wrapping store
+func NewMiddlewareStore(store CacheStore, opt ...Option) *MiddlewareStore {
+	opts := GetOpts(opt...)
+	chain, _ := opts[optionWithMiddleware].([]Middleware)
+	if name, ok := opts[optionWithExpvar].(string); ok {
+		chain = append(chain[:len(chain):len(chain)], ExpvarMiddleware(name))
+	}
+	return &MiddlewareStore{store: store, chain: chain}
+}
+
+// do runs fn through the chain, outermost middleware first
+func (s *MiddlewareStore) do(op Op, key string, fn func() error) error {
+	next := fn
+	for i := len(s.chain) - 1; i >= 0; i-- {
+		mw, inner := s.chain[i], next
+		next = func() error { return mw(op, key, inner) }
+	}
+	return next()
+}
+
+// Get (see CacheStore interface)
+func (s *MiddlewareStore) Get(key string, value interface{}) error {
+	return s.do(OpGet, key, func() error { return s.store.Get(key, value) })
+}
+
+// Set (see CacheStore interface)
+func (s *MiddlewareStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.do(OpSet, key, func() error { return s.store.Set(key, value, expires) })
+}
+
+// Add (see CacheStore interface)
+func (s *MiddlewareStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.do(OpAdd, key, func() error { return s.store.Add(key, value, expires) })
+}
+
+// Replace (see CacheStore interface)
+func (s *MiddlewareStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.do(OpReplace, key, func() error { return s.store.Replace(key, value, expires) })
+}
+
+// Delete (see CacheStore interface)
+func (s *MiddlewareStore) Delete(key string) error {
+	return s.do(OpDelete, key, func() error { return s.store.Delete(key) })
+}
+
+// Increment (see CacheStore interface)
+func (s *MiddlewareStore) Increment(key string, delta uint64) (n uint64, err error) {
+	err = s.do(OpIncrement, key, func() (err error) {
+		n, err = s.store.Increment(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (s *MiddlewareStore) Decrement(key string, delta uint64) (n uint64, err error) {
+	err = s.do(OpDecrement, key, func() (err error) {
+		n, err = s.store.Decrement(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+func (s *MiddlewareStore) Flush() error {
+	return s.do(OpFlush, "", s.store.Flush)
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *MiddlewareStore) GetExpiresIn(key string) (ttl time.Duration, err error) {
+	err = s.do(OpGetExpiresIn, key, func() (err error) {
+		ttl, err = s.store.GetExpiresIn(key)
+		return err
+	})
+	return ttl, err
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *MiddlewareStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(s, key, ttl, ptr, fill)
+}
@@ -0,0 +1,68 @@
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareStore_Order(t *testing.T) {
+	var trace []string
+	record := func(name string) Middleware {
+		return func(op Op, key string, next func() error) error {
+			trace = append(trace, name+" before "+string(op)+" "+key)
+			err := next()
+			trace = append(trace, name+" after")
+			return err
+		}
+	}
+	s := NewMiddlewareStore(NewInMemoryStore(time.Hour), WithMiddleware(record("outer")), WithMiddleware(record("inner")))
+	if err := s.Set("key", 1, DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"outer before set key", "inner before set key", "inner after", "outer after"}
+	if !reflect.DeepEqual(trace, expected) {
+		t.Errorf("expected %v, got %v", expected, trace)
+	}
+	if n, err := s.Increment("key", 2); err != nil || n != 3 {
+		t.Errorf("expected 3, got %d (%v)", n, err)
+	}
+}
+
+func TestMiddlewareStore_ShortCircuit(t *testing.T) {
+	denied := errors.New("denied")
+	backend := NewInMemoryStore(time.Hour)
+	s := NewMiddlewareStore(backend, WithMiddleware(func(op Op, key string, next func() error) error {
+		if op == OpDelete {
+			return denied
+		}
+		return next()
+	}))
+	s.Set("key", "value", DEFAULT)
+	if err := s.Delete("key"); err != denied {
+		t.Errorf("expected the middleware error, got %v", err)
+	}
+	var v string
+	if err := backend.Get("key", &v); err != nil || v != "value" {
+		t.Errorf("expected the key to survive, got %q (%v)", v, err)
+	}
+}
+
+func TestHookMiddleware(t *testing.T) {
+	var before []Op
+	var calls []Call
+	s := NewMiddlewareStore(NewInMemoryStore(time.Hour), WithMiddleware(HookMiddleware(Hooks{
+		Before: func(op Op, key string) { before = append(before, op) },
+		After:  func(call Call) { calls = append(calls, call) },
+	})))
+	var v string
+	s.Get("missing", &v)
+	s.Flush()
+	if !reflect.DeepEqual(before, []Op{OpGet, OpFlush}) {
+		t.Errorf("unexpected before hooks %v", before)
+	}
+	if len(calls) != 2 || calls[0].Key != "missing" || calls[0].Err != ErrCacheMiss || calls[1].Op != OpFlush || calls[1].Err != nil {
+		t.Errorf("unexpected after hooks %+v", calls)
+	}
+}
@@ -0,0 +1,142 @@
+package persistence
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultMigrateConcurrency = 4
+
+// KeyScanner is implemented by the stores able to list their keys
+type KeyScanner interface {
+	// ScanKeys calls f for every key matching the glob pattern until f returns false
+	ScanKeys(pattern string, f func(key string) bool) error
+}
+
+// Migrate copies every key of src matching the glob pattern to dst, e.g. to move from
+// memcached to redis or between redis clusters (see WithMigrateConcurrency,
+// WithMigrateRate and WithMigrateKeys). Values are copied in their serialized form, so
+// both stores have to serialize with utils.Serialize (as the redis and memcached ones
+// do). Keys keep the TTL they have left when src can tell it (see GetExpiresIn), and
+// get the default expiration of dst otherwise.
+//
+// src has to be a KeyScanner unless the keys are given WithMigrateKeys. Keys gone from
+// src by the time they are copied are skipped. Migrate stops at the first error and
+// returns the number of keys copied.
+func Migrate(src, dst CacheStore, pattern string, opt ...Option) (int, error) {
+	opts := GetOpts(opt...)
+	concurrency := defaultMigrateConcurrency
+	if v, ok := opts[optionWithMigrateConcurrency].(int); ok && v > 0 {
+		concurrency = v
+	}
+	var tick <-chan time.Time
+	if v, ok := opts[optionWithMigrateRate].(int); ok && v > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(v))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	scan := func(f func(key string) bool) error {
+		return ErrNotSupport
+	}
+	if keys, ok := opts[optionWithMigrateKeys].([]string); ok {
+		scan = func(f func(key string) bool) error {
+			for _, key := range keys {
+				if globMatch(pattern, key) && !f(key) {
+					break
+				}
+			}
+			return nil
+		}
+	} else if scanner, ok := src.(KeyScanner); ok {
+		scan = func(f func(key string) bool) error {
+			return scanner.ScanKeys(pattern, f)
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		copied int
+		failed error
+		done   = make(chan struct{})
+		once   sync.Once
+		wg     sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			mu.Lock()
+			failed = err
+			mu.Unlock()
+			close(done)
+		})
+	}
+	keys := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				if tick != nil {
+					select {
+					case <-tick:
+					case <-done:
+						return
+					}
+				}
+				ok, err := migrateKey(src, dst, key)
+				if err != nil {
+					fail(err)
+					return
+				}
+				if ok {
+					mu.Lock()
+					copied++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	err := scan(func(key string) bool {
+		select {
+		case keys <- key:
+			return true
+		case <-done:
+			return false
+		}
+	})
+	close(keys)
+	wg.Wait()
+	if err != nil {
+		fail(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return copied, failed
+}
+
+// migrateKey copies key, returning false if it is gone from src
+func migrateKey(src, dst CacheStore, key string) (bool, error) {
+	expires := DEFAULT
+	ttl, err := src.GetExpiresIn(key)
+	switch err {
+	case nil:
+		// round up, as a sub-second TTL rounded down would mean no expiration
+		expires = (ttl + time.Second - 1) / time.Second * time.Second
+	case ErrCacheNoTTL:
+		expires = FOREVER
+	case ErrCacheMiss:
+		return false, nil
+	case ErrNotSupport:
+	default:
+		return false, err
+	}
+	var b []byte
+	if err := src.Get(key, &b); err == ErrCacheMiss {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := dst.Set(key, b, expires); err != nil {
+		return false, err
+	}
+	return true, nil
+}
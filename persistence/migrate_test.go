@@ -0,0 +1,62 @@
+package persistence
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+	server := newRedisServer(t)
+	src := NewRedisCache(server, "", time.Hour)
+	dst := NewRedisCache(server, "", time.Hour, WithSelectDatabase(1))
+	for i := 0; i < 20; i++ {
+		src.Set(fmt.Sprintf("user:%d", i), fmt.Sprintf("user %d", i), time.Minute)
+	}
+	src.Set("counter", 7, FOREVER)
+	src.Set("other", "skipped", DEFAULT)
+
+	n, err := Migrate(src, dst, "user:*", WithMigrateConcurrency(3), WithMigrateRate(1000))
+	if err != nil || n != 20 {
+		t.Fatalf("expected 20 keys migrated, got %d (%v)", n, err)
+	}
+	var s string
+	if err := dst.Get("user:7", &s); err != nil || s != "user 7" {
+		t.Errorf("expected user 7, got %q (%v)", s, err)
+	}
+	if ttl, err := dst.GetExpiresIn("user:7"); err != nil || ttl > time.Minute {
+		t.Errorf("expected the TTL left to be kept, got %d (%v)", ttl, err)
+	}
+	if err := dst.Get("other", &s); err != ErrCacheMiss {
+		t.Errorf("expected other not to be migrated, got %v", err)
+	}
+
+	if n, err := Migrate(src, dst, "counter"); err != nil || n != 1 {
+		t.Fatalf("expected 1 key migrated, got %d (%v)", n, err)
+	}
+	if _, err := dst.GetExpiresIn("counter"); err != ErrCacheNoTTL {
+		t.Errorf("expected counter not to expire, got %v", err)
+	}
+	if n, err := dst.Increment("counter", 1); err != nil || n != 8 {
+		t.Errorf("expected 8, got %d (%v)", n, err)
+	}
+}
+
+func TestMigrate_Keys(t *testing.T) {
+	// stands in for a store that can't list its keys, such as memcached
+	src := NewInMemoryStore(time.Hour)
+	src.Set("a:1", []byte("1"), DEFAULT)
+	src.Set("a:2", []byte("2"), DEFAULT)
+	dst := NewInMemoryStore(time.Hour)
+	if _, err := Migrate(src, dst, "a:*"); err != ErrNotSupport {
+		t.Errorf("expected ErrNotSupport without the keys, got %v", err)
+	}
+	n, err := Migrate(src, dst, "a:*", WithMigrateKeys("a:1", "a:2", "a:3", "b:1"))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 keys migrated, got %d (%v)", n, err)
+	}
+	var b []byte
+	if err := dst.Get("a:2", &b); err != nil || string(b) != "2" {
+		t.Errorf("expected 2, got %q (%v)", b, err)
+	}
+}
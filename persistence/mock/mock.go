@@ -0,0 +1,256 @@
+// Package mock provides a CacheStore for unit tests: it records every call and can be
+// scripted to fail or slow down on chosen operations and keys, so code using a cache
+// can be tested on misses, timeouts and partial failures without a redis server.
+//
+//	store := mock.NewStore()
+//	store.On(persistence.OpGet, "user:42").Return(persistence.ErrCacheMiss)
+//	store.Expect(persistence.OpSet, "user:42").Times(1)
+//	... exercise the code under test ...
+//	store.AssertExpectations(t)
+package mock
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+// Call is a call made on the Store
+type Call struct {
+	Op      persistence.Op
+	Key     string
+	Value   interface{}
+	Expires time.Duration
+	Delta   uint64
+	// Err is the error the call returned
+	Err error
+}
+
+// Rule scripts how the Store handles the calls it matches
+type Rule struct {
+	op       persistence.Op
+	key      string
+	err      error
+	fail     bool
+	delay    time.Duration
+	times    int
+	expected bool
+	matched  int
+}
+
+// Return makes the matched calls return err (which may be nil) without reaching the
+// backing store
+func (r *Rule) Return(err error) *Rule {
+	r.err, r.fail = err, true
+	return r
+}
+
+// Delay makes the matched calls take d longer
+func (r *Rule) Delay(d time.Duration) *Rule {
+	r.delay = d
+	return r
+}
+
+// Times limits the rule to the next n matching calls; for an expectation, it is also
+// the number of calls expected
+func (r *Rule) Times(n int) *Rule {
+	r.times = n
+	return r
+}
+
+func (r *Rule) matches(op persistence.Op, key string) bool {
+	return (r.op == "" || r.op == op) && (r.key == "" || r.key == key) && (r.times == 0 || r.matched < r.times)
+}
+
+// Store is a CacheStore keeping its entries in an InMemoryStore, recording its calls
+// and following the rules set with On and Expect. It is safe for concurrent use.
+type Store struct {
+	store persistence.CacheStore
+
+	mu      sync.Mutex
+	rules   []*Rule
+	calls   []Call
+	latency time.Duration
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// NewStore returns an empty Store
+func NewStore() *Store {
+	return &Store{store: persistence.NewInMemoryStore(time.Hour)}
+}
+
+// On adds a rule for the calls of op on key; an empty op or key matches any. Calls are
+// handled by the first rule matching them, in the order the rules were added.
+func (s *Store) On(op persistence.Op, key string) *Rule {
+	r := &Rule{op: op, key: key}
+	s.mu.Lock()
+	s.rules = append(s.rules, r)
+	s.mu.Unlock()
+	return r
+}
+
+// Expect adds a rule like On, which AssertExpectations checks has been matched (Times
+// times, when set)
+func (s *Store) Expect(op persistence.Op, key string) *Rule {
+	r := s.On(op, key)
+	r.expected = true
+	return r
+}
+
+// SetLatency makes every call take at least d
+func (s *Store) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.latency = d
+	s.mu.Unlock()
+}
+
+// Calls returns the calls made so far, in order
+func (s *Store) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsTo returns the calls of op made so far, in order
+func (s *Store) CallsTo(op persistence.Op) []Call {
+	var calls []Call
+	for _, c := range s.Calls() {
+		if c.Op == op {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the rules and the calls; the entries are kept
+func (s *Store) Reset() {
+	s.mu.Lock()
+	s.rules, s.calls, s.latency = nil, nil, 0
+	s.mu.Unlock()
+}
+
+// AssertExpectations fails t for every expectation that hasn't been met
+func (s *Store) AssertExpectations(t testing.TB) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rules {
+		if !r.expected {
+			continue
+		}
+		if r.matched == 0 || (r.times > 0 && r.matched != r.times) {
+			want := "at least once"
+			if r.times > 0 {
+				want = fmt.Sprintf("%d times", r.times)
+			}
+			op := r.op
+			if op == "" {
+				op = "any operation"
+			}
+			t.Errorf("mock: expected %s on %q %s, got %d calls", op, r.key, want, r.matched)
+		}
+	}
+}
+
+// do runs fn as the call c unless a rule says otherwise, and records it
+func (s *Store) do(c Call, fn func() error) error {
+	s.mu.Lock()
+	delay := s.latency
+	var rule *Rule
+	for _, r := range s.rules {
+		if r.matches(c.Op, c.Key) {
+			rule = r
+			r.matched++
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if rule != nil {
+		delay += rule.delay
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if rule != nil && rule.fail {
+		c.Err = rule.err
+	} else {
+		c.Err = fn()
+	}
+	s.mu.Lock()
+	s.calls = append(s.calls, c)
+	s.mu.Unlock()
+	return c.Err
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, value interface{}) error {
+	return s.do(Call{Op: persistence.OpGet, Key: key}, func() error { return s.store.Get(key, value) })
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	return s.do(Call{Op: persistence.OpSet, Key: key, Value: value, Expires: expires}, func() error {
+		return s.store.Set(key, value, expires)
+	})
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	return s.do(Call{Op: persistence.OpAdd, Key: key, Value: value, Expires: expires}, func() error {
+		return s.store.Add(key, value, expires)
+	})
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.do(Call{Op: persistence.OpReplace, Key: key, Value: value, Expires: expires}, func() error {
+		return s.store.Replace(key, value, expires)
+	})
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	return s.do(Call{Op: persistence.OpDelete, Key: key}, func() error { return s.store.Delete(key) })
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (n uint64, err error) {
+	err = s.do(Call{Op: persistence.OpIncrement, Key: key, Delta: delta}, func() (err error) {
+		n, err = s.store.Increment(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (n uint64, err error) {
+	err = s.do(Call{Op: persistence.OpDecrement, Key: key, Delta: delta}, func() (err error) {
+		n, err = s.store.Decrement(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	return s.do(Call{Op: persistence.OpFlush}, s.store.Flush)
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *Store) GetExpiresIn(key string) (ttl time.Duration, err error) {
+	err = s.do(Call{Op: persistence.OpGetExpiresIn, Key: key}, func() (err error) {
+		ttl, err = s.store.GetExpiresIn(key)
+		return err
+	})
+	return ttl, err
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
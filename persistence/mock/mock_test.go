@@ -0,0 +1,81 @@
+package mock
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+func TestStore_Rules(t *testing.T) {
+	s := NewStore()
+	timeout := errors.New("i/o timeout")
+	s.On(persistence.OpGet, "flaky").Return(timeout).Times(2)
+	s.On(persistence.OpSet, "").Delay(20 * time.Millisecond)
+
+	start := time.Now()
+	if err := s.Set("flaky", "value", persistence.DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("expected the Set to be delayed, took %v", d)
+	}
+	var v string
+	for i := 0; i < 2; i++ {
+		if err := s.Get("flaky", &v); err != timeout {
+			t.Errorf("expected the scripted error, got %v", err)
+		}
+	}
+	if err := s.Get("flaky", &v); err != nil || v != "value" {
+		t.Errorf("expected the stored value once the rule is exhausted, got %q (%v)", v, err)
+	}
+	if err := s.Get("missing", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+
+	calls := s.CallsTo(persistence.OpGet)
+	if len(calls) != 4 || calls[0].Err != timeout || calls[2].Err != nil || calls[3].Key != "missing" {
+		t.Errorf("unexpected calls %+v", calls)
+	}
+	if calls := s.Calls(); calls[0].Op != persistence.OpSet || calls[0].Value != "value" {
+		t.Errorf("unexpected calls %+v", calls)
+	}
+}
+
+// recorder collects the failures of AssertExpectations
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestStore_Expectations(t *testing.T) {
+	s := NewStore()
+	s.Expect(persistence.OpDelete, "key").Times(1)
+	s.Expect(persistence.OpIncrement, "counter")
+	s.Expect(persistence.OpFlush, "")
+	s.Delete("key")
+	s.Delete("key")
+	s.Flush()
+
+	r := &recorder{TB: t}
+	s.AssertExpectations(r)
+	if len(r.errors) != 1 {
+		t.Fatalf("expected 1 unmet expectation, got %v", r.errors)
+	}
+	if r.errors[0] != `mock: expected increment on "counter" at least once, got 0 calls` {
+		t.Errorf("unexpected failure %q", r.errors[0])
+	}
+	s.Reset()
+	s.AssertExpectations(t)
+	if len(s.Calls()) != 0 {
+		t.Errorf("expected the calls to be forgotten")
+	}
+}
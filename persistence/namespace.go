@@ -0,0 +1,191 @@
+package persistence
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Namespaces groups keys into namespaces that can be invalidated in O(1).
+//
+// Every namespace has a generation counter kept in the wrapped store under
+// "<namespace>:generation", and its keys are written as "<namespace>:<generation>:<key>".
+// InvalidateNamespace bumps the counter, so the keys of older generations are never
+// read again and are left to expire; keys set to never expire are never reclaimed.
+type Namespaces struct {
+	store         CacheStore
+	generationTTL time.Duration
+	clock         Clock
+
+	mu          sync.Mutex
+	generations map[string]cachedGeneration
+}
+
+type cachedGeneration struct {
+	generation int
+	expiration time.Time
+}
+
+// NewNamespaces returns Namespaces keeping its keys and counters in store
+func NewNamespaces(store CacheStore, opt ...Option) *Namespaces {
+	opts := GetOpts(opt...)
+	n := &Namespaces{store: store, generations: map[string]cachedGeneration{}, clock: newClock(opts)}
+	if v, ok := opts[optionWithGenerationTTL].(time.Duration); ok && v > 0 {
+		n.generationTTL = v
+	}
+	return n
+}
+
+func generationKey(name string) string {
+	return name + ":generation"
+}
+
+// generation returns the current generation of the namespace, creating the counter
+func (n *Namespaces) generation(name string) (int, error) {
+	if n.generationTTL > 0 {
+		n.mu.Lock()
+		g, ok := n.generations[name]
+		n.mu.Unlock()
+		if ok && n.clock.Now().Before(g.expiration) {
+			return g.generation, nil
+		}
+	}
+	var generation int
+	err := n.store.Get(generationKey(name), &generation)
+	if err == ErrCacheMiss {
+		generation = 1
+		if err = n.store.Add(generationKey(name), generation, FOREVER); err == ErrNotStored {
+			// somebody else created it first
+			err = n.store.Get(generationKey(name), &generation)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n.generationTTL > 0 {
+		n.mu.Lock()
+		n.generations[name] = cachedGeneration{generation, n.clock.Now().Add(n.generationTTL)}
+		n.mu.Unlock()
+	}
+	return generation, nil
+}
+
+// InvalidateNamespace makes every key currently in the namespace unreachable by
+// bumping its generation. With WithGenerationTTL, other processes keep reading the
+// old generation until their cached generation expires.
+func (n *Namespaces) InvalidateNamespace(name string) error {
+	_, err := n.store.Increment(generationKey(name), 1)
+	if err == ErrCacheMiss {
+		// no generation yet: start past the one readers will create
+		if err = n.store.Add(generationKey(name), 2, FOREVER); err == ErrNotStored {
+			_, err = n.store.Increment(generationKey(name), 1)
+		}
+	}
+	n.mu.Lock()
+	delete(n.generations, name)
+	n.mu.Unlock()
+	return err
+}
+
+// Namespace returns a CacheStore whose keys live in the namespace
+func (n *Namespaces) Namespace(name string) *NamespaceStore {
+	return &NamespaceStore{namespaces: n, name: name}
+}
+
+// NamespaceStore is a CacheStore scoped to one namespace of a Namespaces
+type NamespaceStore struct {
+	namespaces *Namespaces
+	name       string
+}
+
+var _ CacheStore = &NamespaceStore{}
+
+func (s *NamespaceStore) key(key string) (string, error) {
+	generation, err := s.namespaces.generation(s.name)
+	if err != nil {
+		return "", err
+	}
+	return s.name + ":" + strconv.Itoa(generation) + ":" + key, nil
+}
+
+// Get (see CacheStore interface)
+func (s *NamespaceStore) Get(key string, value interface{}) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.namespaces.store.Get(k, value)
+}
+
+// Set (see CacheStore interface)
+func (s *NamespaceStore) Set(key string, value interface{}, expires time.Duration) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.namespaces.store.Set(k, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *NamespaceStore) Add(key string, value interface{}, expires time.Duration) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.namespaces.store.Add(k, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *NamespaceStore) Replace(key string, value interface{}, expires time.Duration) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.namespaces.store.Replace(k, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *NamespaceStore) Delete(key string) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.namespaces.store.Delete(k)
+}
+
+// Increment (see CacheStore interface)
+func (s *NamespaceStore) Increment(key string, delta uint64) (uint64, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.namespaces.store.Increment(k, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (s *NamespaceStore) Decrement(key string, delta uint64) (uint64, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.namespaces.store.Decrement(k, delta)
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *NamespaceStore) GetExpiresIn(key string) (time.Duration, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.namespaces.store.GetExpiresIn(k)
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *NamespaceStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush invalidates the namespace, leaving the rest of the store alone
+func (s *NamespaceStore) Flush() error {
+	return s.namespaces.InvalidateNamespace(s.name)
+}
@@ -0,0 +1,73 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func namespaces(t *testing.T, newStore cacheFactory) {
+	ns := NewNamespaces(newStore(t, time.Hour))
+	users := ns.Namespace("users")
+	orders := ns.Namespace("orders")
+	if err := users.Set("42", "alice", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	orders.Set("42", "order", DEFAULT)
+
+	if err := ns.InvalidateNamespace("users"); err != nil {
+		t.Fatalf("Error invalidating a namespace: %s", err)
+	}
+	var v string
+	if err := users.Get("42", &v); err != ErrCacheMiss {
+		t.Errorf("expected the namespace to be invalidated, got %q (%v)", v, err)
+	}
+	if err := orders.Get("42", &v); err != nil || v != "order" {
+		t.Errorf("expected other namespaces to be kept, got %q (%v)", v, err)
+	}
+	users.Set("42", "bob", DEFAULT)
+	if err := users.Get("42", &v); err != nil || v != "bob" {
+		t.Errorf("expected the new generation to be readable, got %q (%v)", v, err)
+	}
+
+	// invalidating a namespace that was never used must not hide later writes
+	if err := ns.InvalidateNamespace("fresh"); err != nil {
+		t.Fatalf("Error invalidating a namespace: %s", err)
+	}
+	fresh := ns.Namespace("fresh")
+	fresh.Set("key", "value", DEFAULT)
+	if err := fresh.Get("key", &v); err != nil || v != "value" {
+		t.Errorf("unexpected value %q (%v)", v, err)
+	}
+	if err := fresh.Flush(); err != nil {
+		t.Errorf("Error flushing a namespace: %s", err)
+	}
+	if err := fresh.Get("key", &v); err != ErrCacheMiss {
+		t.Errorf("expected Flush to invalidate the namespace, got %v", err)
+	}
+}
+
+func TestInMemoryCache_Namespaces(t *testing.T) {
+	namespaces(t, newInMemoryStore)
+}
+
+func TestRedisCache_Namespaces(t *testing.T) {
+	namespaces(t, newRedisStore)
+}
+
+func TestNamespaces_GenerationTTL(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	a := NewNamespaces(store, WithGenerationTTL(time.Hour))
+	b := NewNamespaces(store, WithGenerationTTL(time.Hour))
+	a.Namespace("ns").Set("key", 1, DEFAULT)
+	var v int
+	if err := b.Namespace("ns").Get("key", &v); err != nil {
+		t.Fatalf("Error getting a value: %s", err)
+	}
+	a.InvalidateNamespace("ns")
+	if err := a.Namespace("ns").Get("key", &v); err != ErrCacheMiss {
+		t.Errorf("expected the invalidating process to see the new generation, got %v", err)
+	}
+	if err := b.Namespace("ns").Get("key", &v); err != nil {
+		t.Errorf("expected other processes to keep their cached generation, got %v", err)
+	}
+}
@@ -1,5 +1,12 @@
 package persistence
 
+import (
+	"time"
+
+	"github.com/Bose/cache/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
 // GetOpts - iterate the inbound Options and return a struct
 func GetOpts(opt ...Option) Options {
 	opts := getDefaultOptions()
@@ -29,3 +36,647 @@ func WithSelectDatabase(d int) Option {
 		o[optionWithSelectDatabase] = d
 	}
 }
+
+const optionWithShards = "optionWithShards"
+
+// WithShards sets the number of shards used by the in-memory store (rounded up to a power of two)
+func WithShards(n int) Option {
+	return func(o Options) {
+		o[optionWithShards] = n
+	}
+}
+
+const optionWithMaxEntries = "optionWithMaxEntries"
+
+// WithMaxEntries bounds the in-memory store to about n entries, evicting entries with
+// the WithEvictionPolicy policy (LRU by default) to make room for new ones. The bound
+// is enforced per shard, each holding up to n divided by the number of shards, so small
+// bounds are best used with fewer shards (see WithShards).
+func WithMaxEntries(n int) Option {
+	return func(o Options) {
+		o[optionWithMaxEntries] = n
+	}
+}
+
+const optionWithMaxCost = "optionWithMaxCost"
+
+// WithMaxCost bounds the total cost of the entries of the in-memory store, as charged
+// by WithCost (1 per entry by default), evicting entries as WithMaxEntries does. Like
+// that bound, it is enforced per shard, and an entry costing more than the share of its
+// shard is evicted as soon as it's written.
+func WithMaxCost(n int64) Option {
+	return func(o Options) {
+		o[optionWithMaxCost] = n
+	}
+}
+
+const optionWithCost = "optionWithCost"
+
+// WithCost sets the function charging the entries of the in-memory store against
+// WithMaxCost, e.g. with their approximate size in bytes so entries holding large
+// structures count for more than small ones. It is called with every value written.
+func WithCost(f func(value interface{}) int64) Option {
+	return func(o Options) {
+		o[optionWithCost] = f
+	}
+}
+
+const optionWithEvictionPolicy = "optionWithEvictionPolicy"
+
+// WithEvictionPolicy sets the policy a bounded in-memory store evicts entries with
+// (see WithMaxEntries), and sheds them with under memory pressure (see
+// WithMemoryPressure)
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(o Options) {
+		o[optionWithEvictionPolicy] = p
+	}
+}
+
+const optionWithMemoryPressure = "optionWithMemoryPressure"
+
+// WithMemoryPressure makes the in-memory store watch the memory use of the process, and
+// shed a fraction of its entries whenever it crosses a threshold of the limit, so a
+// load spike filling the cache doesn't get the process killed (see MemoryPressure)
+func WithMemoryPressure(p MemoryPressure) Option {
+	return func(o Options) {
+		o[optionWithMemoryPressure] = p
+	}
+}
+
+const optionWithCleanupInterval = "optionWithCleanupInterval"
+
+// WithCleanupInterval sets how often the in-memory store purges expired entries (<= 0 disables the janitor)
+func WithCleanupInterval(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithCleanupInterval] = d
+	}
+}
+
+const optionWithExpirationMode = "optionWithExpirationMode"
+
+// WithExpirationMode sets when the in-memory store removes expired entries: sweeping
+// them in the background (ExpireActive, the default), when they're read (ExpireLazy)
+// or both (ExpireHybrid)
+func WithExpirationMode(m ExpirationMode) Option {
+	return func(o Options) {
+		o[optionWithExpirationMode] = m
+	}
+}
+
+const optionWithSweepBatchSize = "optionWithSweepBatchSize"
+
+// WithSweepBatchSize bounds the expired entries the in-memory store janitor removes from
+// each shard per sweep, to cap the time shard locks are held; the others are left to the
+// next sweeps (<= 0 removes them all)
+func WithSweepBatchSize(n int) Option {
+	return func(o Options) {
+		o[optionWithSweepBatchSize] = n
+	}
+}
+
+const optionWithMemcachedMetaProtocol = "optionWithMemcachedMetaProtocol"
+
+// WithMemcachedMetaProtocol makes the memcached store send Get, Set, Add, Replace, Delete
+// and GetExpiresIn as meta commands (mg, ms and md, memcached 1.6+), so GetExpiresIn
+// returns the time left rather than ErrNotSupport
+func WithMemcachedMetaProtocol() Option {
+	return func(o Options) {
+		o[optionWithMemcachedMetaProtocol] = true
+	}
+}
+
+const optionWithMemcachedHealthCheck = "optionWithMemcachedHealthCheck"
+
+// WithMemcachedHealthCheck makes the memcached store probe its nodes in the background,
+// ejecting the ones that stop answering and bringing them back once they do. Keys are
+// then spread over the healthy nodes with rendezvous hashing rather than gomemcache's
+// modulo hashing, so only the keys of a failed node move.
+func WithMemcachedHealthCheck(c MemcachedHealthCheck) Option {
+	return func(o Options) {
+		o[optionWithMemcachedHealthCheck] = c
+	}
+}
+
+const optionWithChunkSize = "optionWithChunkSize"
+
+// WithChunkSize makes the redis store split serialized values larger than n bytes into
+// "key:chunk:N" parts behind a manifest stored under the key (<= 0 disables chunking)
+func WithChunkSize(n int) Option {
+	return func(o Options) {
+		o[optionWithChunkSize] = n
+	}
+}
+
+const optionWithMaxValueSize = "optionWithMaxValueSize"
+
+// WithMaxValueSize makes the redis and memcached stores reject values whose serialized
+// size exceeds n bytes with a *ValueTooLargeError (<= 0 disables the limit)
+func WithMaxValueSize(n int) Option {
+	return func(o Options) {
+		o[optionWithMaxValueSize] = n
+	}
+}
+
+const optionWithSkipOversizedValues = "optionWithSkipOversizedValues"
+
+// WithSkipOversizedValues makes writes over the WithMaxValueSize limit silently remove
+// the key instead of failing, for callers that treat the cache as best effort
+func WithSkipOversizedValues() Option {
+	return func(o Options) {
+		o[optionWithSkipOversizedValues] = true
+	}
+}
+
+const optionWithTTLJitter = "optionWithTTLJitter"
+
+// WithTTLJitter randomizes every expiration by up to ±fraction (0.1 for ±10%) when it is
+// set, so keys loaded together don't all expire, and hit the origin, at the same time
+func WithTTLJitter(fraction float64) Option {
+	return func(o Options) {
+		o[optionWithTTLJitter] = fraction
+	}
+}
+
+const optionWithClock = "optionWithClock"
+
+// WithClock sets the Clock the in-memory store, ReadThrough, StaleStore, Namespaces and
+// Refresher tell the time with, e.g. a FakeClock in tests (the wall clock by default)
+func WithClock(c Clock) Option {
+	return func(o Options) {
+		o[optionWithClock] = c
+	}
+}
+
+const optionWithMinTTL = "optionWithMinTTL"
+
+// WithMinTTL makes the redis, memcached and in-memory stores raise the expirations
+// shorter than d to d, before WithTTLJitter applies
+func WithMinTTL(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithMinTTL] = d
+	}
+}
+
+const optionWithMaxTTL = "optionWithMaxTTL"
+
+// WithMaxTTL makes the redis, memcached and in-memory stores lower the expirations
+// longer than d to d, before WithTTLJitter applies. Keys set to never expire are left
+// alone.
+func WithMaxTTL(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithMaxTTL] = d
+	}
+}
+
+const optionWithTTLPolicy = "optionWithTTLPolicy"
+
+// WithTTLPolicy sets the expiration of the keys written with DEFAULT by key pattern, so
+// it can be managed in one place rather than at every call site. The first rule matching
+// a key applies; keys matching none get the default expiration of the store. Explicit
+// expirations are left alone.
+func WithTTLPolicy(rules ...TTLRule) Option {
+	return func(o Options) {
+		o[optionWithTTLPolicy] = rules
+	}
+}
+
+const optionWithStaleTTL = "optionWithStaleTTL"
+
+// WithStaleTTL sets how long the StaleStore keeps serving an entry past its expiration
+// while it is refreshed, one minute by default
+func WithStaleTTL(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithStaleTTL] = d
+	}
+}
+
+const optionWithXFetchBeta = "optionWithXFetchBeta"
+
+// WithXFetchBeta scales how eagerly ReadThrough recomputes entries before they expire:
+// 1 by default, larger values recompute earlier and 0 disables early recomputes
+func WithXFetchBeta(beta float64) Option {
+	return func(o Options) {
+		o[optionWithXFetchBeta] = beta
+	}
+}
+
+const optionWithLoadLock = "optionWithLoadLock"
+
+// WithLoadLock makes ReadThrough coalesce loads across the processes sharing its store:
+// on a miss, the process that claims the lock of the key for ttl loads it, while the
+// others poll the store for up to wait before loading it themselves. Stores expire
+// entries to the second, so ttl is at least a second.
+func WithLoadLock(ttl, wait time.Duration) Option {
+	return func(o Options) {
+		o[optionWithLoadLock] = loadLock{ttl: ttl, wait: wait}
+	}
+}
+
+const optionWithDedupSets = "optionWithDedupSets"
+
+// WithDedupSets makes the DedupStore also coalesce concurrent Sets of the same key,
+// value and expiration
+func WithDedupSets() Option {
+	return func(o Options) {
+		o[optionWithDedupSets] = true
+	}
+}
+
+const optionWithGenerationTTL = "optionWithGenerationTTL"
+
+// WithGenerationTTL lets Namespaces cache namespace generations in process for d,
+// saving a round trip per operation at the cost of seeing invalidations up to d late
+func WithGenerationTTL(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithGenerationTTL] = d
+	}
+}
+
+const optionWithMiddleware = "optionWithMiddleware"
+
+// WithMiddleware adds middleware to a MiddlewareStore; the first one given is the
+// outermost, and repeated WithMiddleware options append to the chain
+func WithMiddleware(mw ...Middleware) Option {
+	return func(o Options) {
+		chain, _ := o[optionWithMiddleware].([]Middleware)
+		o[optionWithMiddleware] = append(chain, mw...)
+	}
+}
+
+const optionWithInvalidationPubSub = "optionWithInvalidationPubSub"
+
+type invalidationPubSub struct {
+	pool    *redis.Pool
+	channel string
+}
+
+// WithInvalidationPubSub makes a TieredStore broadcast its writes on the redis pub/sub
+// channel, and drop keys from its L1 when other TieredStores on the channel write them
+func WithInvalidationPubSub(pool *redis.Pool, channel string) Option {
+	return func(o Options) {
+		o[optionWithInvalidationPubSub] = invalidationPubSub{pool: pool, channel: channel}
+	}
+}
+
+const optionWithWarmBatchSize = "optionWithWarmBatchSize"
+
+// WithWarmBatchSize sets how many entries Warm pipelines per round trip (defaults to 100)
+func WithWarmBatchSize(n int) Option {
+	return func(o Options) {
+		o[optionWithWarmBatchSize] = n
+	}
+}
+
+const optionWithWarmProgress = "optionWithWarmProgress"
+
+// WithWarmProgress has Warm call f with the number of entries loaded so far after
+// every batch
+func WithWarmProgress(f func(loaded int)) Option {
+	return func(o Options) {
+		o[optionWithWarmProgress] = f
+	}
+}
+
+const optionWithMigrateConcurrency = "optionWithMigrateConcurrency"
+
+// WithMigrateConcurrency sets how many keys Migrate copies at once (defaults to 4)
+func WithMigrateConcurrency(n int) Option {
+	return func(o Options) {
+		o[optionWithMigrateConcurrency] = n
+	}
+}
+
+const optionWithMigrateRate = "optionWithMigrateRate"
+
+// WithMigrateRate limits Migrate to copying n keys per second
+func WithMigrateRate(n int) Option {
+	return func(o Options) {
+		o[optionWithMigrateRate] = n
+	}
+}
+
+const optionWithMigrateKeys = "optionWithMigrateKeys"
+
+// WithMigrateKeys gives Migrate the keys to copy from a store it can't list the keys
+// of, such as memcached; only the ones matching the pattern are copied
+func WithMigrateKeys(keys ...string) Option {
+	return func(o Options) {
+		o[optionWithMigrateKeys] = keys
+	}
+}
+
+const optionWithVerifySample = "optionWithVerifySample"
+
+// WithVerifySample sets how many keys Verify samples (defaults to 1000); 0 checks them all
+func WithVerifySample(n int) Option {
+	return func(o Options) {
+		o[optionWithVerifySample] = n
+	}
+}
+
+const optionWithVerifyValue = "optionWithVerifyValue"
+
+// WithVerifyValue has Verify compare values whose serialized bytes differ once
+// deserialized into the type ptr points to, as e.g. gob encodes maps in random order
+func WithVerifyValue(ptr interface{}) Option {
+	return func(o Options) {
+		o[optionWithVerifyValue] = ptr
+	}
+}
+
+const optionWithVerifyMaxTTLDrift = "optionWithVerifyMaxTTLDrift"
+
+// WithVerifyMaxTTLDrift sets how far apart the TTLs of a key may be before Verify
+// reports it (defaults to 2s)
+func WithVerifyMaxTTLDrift(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithVerifyMaxTTLDrift] = d
+	}
+}
+
+const optionWithOperationTimeout = "optionWithOperationTimeout"
+
+// WithOperationTimeout bounds how long the redis store waits for each reply, so a
+// degraded server fails operations rather than stalls them. NewRedisCache also bounds
+// connecting to and writing to the server with it.
+func WithOperationTimeout(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithOperationTimeout] = d
+	}
+}
+
+const optionWithHealthCheck = "optionWithHealthCheck"
+
+// WithHealthCheck sets which of the connections NewRedisCache borrows from its pool
+// are checked with a PING first: by default all of them, which costs a round trip per
+// operation. With idle > 0, only those that were idle for longer than idle are, and
+// with idle < 0 none are.
+func WithHealthCheck(idle time.Duration) Option {
+	return func(o Options) {
+		o[optionWithHealthCheck] = idle
+	}
+}
+
+const optionWithMaxConnLifetime = "optionWithMaxConnLifetime"
+
+// WithMaxConnLifetime makes NewRedisCache close the connections of its pool once they
+// are older than d, e.g. so connections get spread over servers added behind a load
+// balancer. By default they are kept as long as they are in use.
+func WithMaxConnLifetime(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithMaxConnLifetime] = d
+	}
+}
+
+const optionWithDeleteUndecodable = "optionWithDeleteUndecodable"
+
+// WithDeleteUndecodable makes the redis store delete the values Get and Mget fail to
+// deserialize (e.g. after an incompatible change of their type), so they are missed
+// and reloaded from then on instead of failing every read until they expire
+func WithDeleteUndecodable() Option {
+	return func(o Options) {
+		o[optionWithDeleteUndecodable] = true
+	}
+}
+
+const optionWithDecodeQuarantine = "optionWithDecodeQuarantine"
+
+// WithDecodeQuarantine works like WithDeleteUndecodable, but first copies the value to
+// prefix+key for up to a day, so it can be inspected
+func WithDecodeQuarantine(prefix string) Option {
+	return func(o Options) {
+		o[optionWithDecodeQuarantine] = prefix
+	}
+}
+
+const optionWithCodec = "optionWithCodec"
+
+// WithCodec sets the codec the redis store serializes values with (defaults to
+// utils.Gob). Enveloped values are decoded with the codec their envelope names
+// whatever the option.
+func WithCodec(codec utils.Codec) Option {
+	return func(o Options) {
+		o[optionWithCodec] = codec
+	}
+}
+
+const optionWithEnvelope = "optionWithEnvelope"
+
+// WithEnvelope makes the redis store write values in an envelope naming their codec
+// and schema version (see utils.Encode), so codecs can be changed without breaking
+// the values already stored. Values written with another schema version fail to
+// decode like values of an incompatible type, see WithDeleteUndecodable.
+func WithEnvelope(schema uint16) Option {
+	return func(o Options) {
+		o[optionWithEnvelope] = schema
+	}
+}
+
+const optionWithRawStrings = "optionWithRawStrings"
+
+// WithRawStrings makes the redis store write string values as is rather than through
+// its codec, so they're readable in redis-cli and interoperable with writers in other
+// languages, and read values into *string targets as is. Strings written by the codec
+// before the option was set read back serialized, so rewrite them when turning it on.
+func WithRawStrings() Option {
+	return func(o Options) {
+		o[optionWithRawStrings] = true
+	}
+}
+
+const optionWithNativeIntegers = "optionWithNativeIntegers"
+
+// WithNativeIntegers makes the redis store write integer values, and pointers to them,
+// as plain decimal redis integers whatever its codec or envelope, and read integer
+// targets from them, so IncrementAtomic and writers in other languages interoperate
+// with values written by Set. The built-in codecs already write integers (but not
+// pointers to them) that way.
+func WithNativeIntegers() Option {
+	return func(o Options) {
+		o[optionWithNativeIntegers] = true
+	}
+}
+
+const optionWithMgetBatchSize = "optionWithMgetBatchSize"
+
+// WithMgetBatchSize sets the number of keys the redis store's Mget asks for per MGET
+// (defaults to 1000), longer key lists being fetched with pipelined MGETs rather than
+// one enormous reply monopolizing the connection. 0 disables batching.
+func WithMgetBatchSize(n int) Option {
+	return func(o Options) {
+		o[optionWithMgetBatchSize] = n
+	}
+}
+
+const optionWithMgetConcurrency = "optionWithMgetConcurrency"
+
+// WithMgetConcurrency spreads the batches of the redis store's Mget over up to n
+// connections fetching them concurrently (defaults to 1)
+func WithMgetConcurrency(n int) Option {
+	return func(o Options) {
+		o[optionWithMgetConcurrency] = n
+	}
+}
+
+const optionWithUnlink = "optionWithUnlink"
+
+// WithUnlink makes the redis store remove keys with UNLINK rather than DEL, so the
+// memory of large values is reclaimed in the background instead of blocking the server
+func WithUnlink() Option {
+	return func(o Options) {
+		o[optionWithUnlink] = true
+	}
+}
+
+const optionWithRESP3 = "optionWithRESP3"
+
+// WithRESP3 makes NewRedisCache switch its connections to the RESP3 protocol with
+// HELLO 3, which requires redis 6 or later. Replies keep the types they have with
+// RESP2 as far as possible, so stores behave the same with either protocol.
+func WithRESP3() Option {
+	return func(o Options) {
+		o[optionWithRESP3] = true
+	}
+}
+
+const optionWithExpvar = "optionWithExpvar"
+
+// WithExpvar publishes the counters of a store with expvar as name, so they show at
+// /debug/vars. NewMiddlewareStore counts the operations of any store with an innermost
+// ExpvarMiddleware, and NewInMemoryStore publishes its Stats. As with expvar, name
+// must be unique in the process.
+func WithExpvar(name string) Option {
+	return func(o Options) {
+		o[optionWithExpvar] = name
+	}
+}
+
+const optionWithRedlockRetries = "optionWithRedlockRetries"
+
+// WithRedlockRetries sets how many more times Redlock tries to acquire a lock held by
+// someone else (3 by default), waiting a random delay of up to d between attempts
+func WithRedlockRetries(n int, d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithRedlockRetries] = redlockRetries{count: n, delay: d}
+	}
+}
+
+const optionWithRedlockDriftFactor = "optionWithRedlockDriftFactor"
+
+// WithRedlockDriftFactor sets the fraction of the TTL of locks Redlock allows for the
+// clock drift between nodes (0.01 by default), which is taken off their validity
+func WithRedlockDriftFactor(f float64) Option {
+	return func(o Options) {
+		o[optionWithRedlockDriftFactor] = f
+	}
+}
+
+const optionWithSemaphorePollInterval = "optionWithSemaphorePollInterval"
+
+// WithSemaphorePollInterval sets how often Semaphore.Acquire checks for a free permit
+// while it waits (50ms by default)
+func WithSemaphorePollInterval(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithSemaphorePollInterval] = d
+	}
+}
+
+const optionWithCounterFlushInterval = "optionWithCounterFlushInterval"
+
+// WithCounterFlushInterval sets how often a Counter flushes its increments (every
+// second by default)
+func WithCounterFlushInterval(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithCounterFlushInterval] = d
+	}
+}
+
+const optionWithCounterFlushThreshold = "optionWithCounterFlushThreshold"
+
+// WithCounterFlushThreshold makes a Counter flush as soon as n increments are buffered,
+// rather than only at its interval
+func WithCounterFlushThreshold(n int) Option {
+	return func(o Options) {
+		o[optionWithCounterFlushThreshold] = n
+	}
+}
+
+const optionWithCounterFlushError = "optionWithCounterFlushError"
+
+// WithCounterFlushError sets a function called with the errors of the flushes a
+// Counter makes in the background, whose increments are retried at the next flush
+func WithCounterFlushError(f func(err error)) Option {
+	return func(o Options) {
+		o[optionWithCounterFlushError] = f
+	}
+}
+
+const optionWithRefreshAhead = "optionWithRefreshAhead"
+
+// WithRefreshAhead sets how long before entries expire a Refresher reloads them (a
+// tenth of their TTL by default)
+func WithRefreshAhead(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithRefreshAhead] = d
+	}
+}
+
+const optionWithRefreshJitter = "optionWithRefreshJitter"
+
+// WithRefreshJitter sets the fraction of the refresh-ahead time, between 0 and 1, a
+// Refresher randomly adds to it (0.5 by default), spreading the reloads of entries
+// written at the same time
+func WithRefreshJitter(fraction float64) Option {
+	return func(o Options) {
+		o[optionWithRefreshJitter] = fraction
+	}
+}
+
+const optionWithRefreshIdle = "optionWithRefreshIdle"
+
+// WithRefreshIdle sets how long a Refresher keeps refreshing a key read with GetOrLoad
+// after it was last read (twice the TTL by default)
+func WithRefreshIdle(d time.Duration) Option {
+	return func(o Options) {
+		o[optionWithRefreshIdle] = d
+	}
+}
+
+const optionWithRefreshWorkers = "optionWithRefreshWorkers"
+
+// WithRefreshWorkers sets how many keys a Refresher reloads at once (4 by default)
+func WithRefreshWorkers(n int) Option {
+	return func(o Options) {
+		o[optionWithRefreshWorkers] = n
+	}
+}
+
+const optionWithMaxKeyLength = "optionWithMaxKeyLength"
+
+// WithMaxKeyLength makes a KeyValidatingStore reject the keys longer than n bytes (250,
+// the memcached limit, by default; <= 0 disables the limit)
+func WithMaxKeyLength(n int) Option {
+	return func(o Options) {
+		o[optionWithMaxKeyLength] = n
+	}
+}
+
+const optionWithForbiddenKeyChars = "optionWithForbiddenKeyChars"
+
+// WithForbiddenKeyChars makes a KeyValidatingStore also reject the keys holding one of
+// chars, on top of whitespace and control characters
+func WithForbiddenKeyChars(chars string) Option {
+	return func(o Options) {
+		o[optionWithForbiddenKeyChars] = chars
+	}
+}
+
+const optionWithKeyNormalization = "optionWithKeyNormalization"
+
+// WithKeyNormalization makes a KeyValidatingStore percent-encode the characters it
+// would reject keys for, along with '%' and non-ASCII bytes, rather than fail
+func WithKeyNormalization() Option {
+	return func(o Options) {
+		o[optionWithKeyNormalization] = true
+	}
+}
@@ -0,0 +1,104 @@
+// Package otelcache reports the operations of cache stores as OpenTelemetry metrics,
+// so services exporting to an OTel collector don't need a separate Prometheus path.
+//
+// Operations are measured by a persistence.Middleware, so any store wrapped in a
+// persistence.MiddlewareStore can be instrumented:
+//
+//	mw, err := otelcache.NewMiddleware(otel.Meter("cache"), "sessions")
+//	...
+//	store := persistence.NewMiddlewareStore(redisStore, persistence.WithMiddleware(mw))
+package otelcache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Names of the instruments and attributes
+const (
+	OperationDuration = "cache.operation.duration"
+	Hits              = "cache.hits"
+	Misses            = "cache.misses"
+	PoolConnections   = "cache.pool.connections"
+
+	// NameKey is the attribute telling the stores apart
+	NameKey = attribute.Key("cache.name")
+	// OperationKey is the operation, e.g. "get"
+	OperationKey = attribute.Key("cache.operation")
+	// ErrorKey is set on the durations of operations that failed, misses and cached nils
+	// aside
+	ErrorKey = attribute.Key("cache.error")
+	// StateKey is the state of pool connections, "idle" or "used"
+	StateKey = attribute.Key("cache.pool.state")
+)
+
+// NewMiddleware returns a persistence.Middleware recording to meter how long every
+// operation takes, in the OperationDuration histogram, and counting the Get operations
+// finding their key or not as Hits and Misses. Measurements carry name as NameKey.
+func NewMiddleware(meter metric.Meter, name string) (persistence.Middleware, error) {
+	duration, err := meter.Float64Histogram(OperationDuration,
+		metric.WithDescription("Duration of cache store operations"), metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	hits, err := meter.Int64Counter(Hits, metric.WithDescription("Cache reads finding their key"))
+	if err != nil {
+		return nil, err
+	}
+	misses, err := meter.Int64Counter(Misses, metric.WithDescription("Cache reads missing their key"))
+	if err != nil {
+		return nil, err
+	}
+	storeAttr := NameKey.String(name)
+	lookup := metric.WithAttributes(storeAttr)
+
+	return func(op persistence.Op, key string, next func() error) error {
+		start := time.Now()
+		err := next()
+		ctx := context.Background()
+		miss := errors.Is(err, persistence.ErrCacheMiss)
+		// a cached nil is a hit, not a failure
+		found := err == nil || errors.Is(err, persistence.ErrNilValue)
+		duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+			storeAttr, OperationKey.String(string(op)), ErrorKey.Bool(!found && !miss)))
+		if op == persistence.OpGet {
+			if miss {
+				misses.Add(ctx, 1, lookup)
+			} else if found {
+				hits.Add(ctx, 1, lookup)
+			}
+		}
+		return err
+	}, nil
+}
+
+// PoolStatser is implemented by the stores reporting the connections of their pool,
+// such as persistence.RedisStore
+type PoolStatser interface {
+	PoolStats() redis.PoolStats
+}
+
+// RegisterPoolMetrics reports the connections of the store's pool, by StateKey, as
+// the PoolConnections gauge of meter until the registration returned is unregistered
+func RegisterPoolMetrics(meter metric.Meter, name string, store PoolStatser) (metric.Registration, error) {
+	gauge, err := meter.Int64ObservableGauge(PoolConnections,
+		metric.WithDescription("Connections of the cache store's pool"), metric.WithUnit("{connection}"))
+	if err != nil {
+		return nil, err
+	}
+	storeAttr := NameKey.String(name)
+	idle := metric.WithAttributes(storeAttr, StateKey.String("idle"))
+	used := metric.WithAttributes(storeAttr, StateKey.String("used"))
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := store.PoolStats()
+		o.ObserveInt64(gauge, int64(stats.IdleCount), idle)
+		o.ObserveInt64(gauge, int64(stats.ActiveCount-stats.IdleCount), used)
+		return nil
+	}, gauge)
+}
@@ -0,0 +1,144 @@
+package otelcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+	"github.com/Bose/cache/persistence"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	return metrics
+}
+
+func sum(t *testing.T, data metricdata.Aggregation) int64 {
+	s, ok := data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("expected a sum, got %T", data)
+	}
+	var total int64
+	for _, dp := range s.DataPoints {
+		total += dp.Value
+	}
+	return total
+}
+
+func TestMiddleware(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	mw, err := NewMiddleware(meter, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := persistence.NewMiddlewareStore(persistence.NewInMemoryStore(time.Hour), persistence.WithMiddleware(mw))
+
+	store.Set("key", 1, persistence.DEFAULT)
+	var v int
+	store.Get("key", &v)
+	store.Get("key", &v)
+	store.Get("missing", &v)
+	store.Replace("missing", 1, persistence.DEFAULT)
+
+	metrics := collect(t, reader)
+	if n := sum(t, metrics[Hits]); n != 2 {
+		t.Errorf("expected 2 hits, got %d", n)
+	}
+	if n := sum(t, metrics[Misses]); n != 1 {
+		t.Errorf("expected 1 miss, got %d", n)
+	}
+	h, ok := metrics[OperationDuration].(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("expected a histogram, got %T", metrics[OperationDuration])
+	}
+	counts := map[string]uint64{}
+	for _, dp := range h.DataPoints {
+		op, _ := dp.Attributes.Value(OperationKey)
+		failed, _ := dp.Attributes.Value(ErrorKey)
+		counts[op.AsString()+" "+failed.Emit()] += dp.Count
+	}
+	for series, expected := range map[string]uint64{"set false": 1, "get false": 3, "replace true": 1} {
+		if counts[series] != expected {
+			t.Errorf("expected %d %q operations, got %d", expected, series, counts[series])
+		}
+	}
+}
+
+// wrappingStore wraps the errors of Get, as stores layered over others may
+type wrappingStore struct {
+	persistence.CacheStore
+}
+
+func (s wrappingStore) Get(key string, value interface{}) error {
+	if err := s.CacheStore.Get(key, value); err != nil {
+		return fmt.Errorf("wrapped: %w", err)
+	}
+	return nil
+}
+
+func TestMiddleware_WrappedErrors(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	mw, err := NewMiddleware(meter, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := persistence.NewMiddlewareStore(wrappingStore{persistence.NewInMemoryStore(time.Hour)}, persistence.WithMiddleware(mw))
+	store.Set("nil", nil, persistence.DEFAULT)
+	var v int
+	store.Get("missing", &v)
+	store.Get("nil", &v)
+
+	metrics := collect(t, reader)
+	if n := sum(t, metrics[Misses]); n != 1 {
+		t.Errorf("expected the wrapped miss to count as a miss, got %d", n)
+	}
+	if n := sum(t, metrics[Hits]); n != 1 {
+		t.Errorf("expected the wrapped nil value to count as a hit, got %d", n)
+	}
+	h := metrics[OperationDuration].(metricdata.Histogram[float64])
+	for _, dp := range h.DataPoints {
+		if failed, _ := dp.Attributes.Value(ErrorKey); failed.AsBool() {
+			t.Errorf("expected no operation to count as failed, got %d", dp.Count)
+		}
+	}
+}
+
+func TestRegisterPoolMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	store := persistence.NewRedisCache(miniredistest.Run(t).Addr(), "", time.Hour)
+	store.Set("key", 1, persistence.DEFAULT)
+
+	reg, err := RegisterPoolMetrics(meter, "test", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Unregister()
+	g, ok := collect(t, reader)[PoolConnections].(metricdata.Gauge[int64])
+	if !ok {
+		t.Fatalf("expected a gauge")
+	}
+	states := map[string]int64{}
+	for _, dp := range g.DataPoints {
+		state, _ := dp.Attributes.Value(StateKey)
+		states[state.AsString()] = dp.Value
+	}
+	if states["idle"] != 1 || states["used"] != 0 {
+		t.Errorf("expected a single idle connection, got %v", states)
+	}
+}
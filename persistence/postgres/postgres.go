@@ -0,0 +1,303 @@
+// Package postgres provides a CacheStore on top of a PostgreSQL UNLOGGED table,
+// for teams that already run Postgres and want shared caching semantics without
+// adding Redis. UNLOGGED tables skip the WAL, which makes writes much cheaper
+// at the cost of the table being truncated after a crash - fine for a cache.
+//
+// Expiration uses the database clock, so pods with skewed clocks agree on
+// whether an entry is still live. A background reaper deletes expired rows.
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/lib/pq"
+)
+
+const (
+	optionWithTableName      = "optionWithPostgresTableName"
+	optionWithReapInterval   = "optionWithPostgresReapInterval"
+	optionWithoutCreateTable = "optionWithoutPostgresCreateTable"
+)
+
+// WithTableName sets the table used by the store (the default is "cache")
+func WithTableName(name string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithTableName] = name
+	}
+}
+
+// WithReapInterval sets how often expired rows are deleted (the default is
+// every minute, <= 0 disables the reaper)
+func WithReapInterval(d time.Duration) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithReapInterval] = d
+	}
+}
+
+// WithoutCreateTable skips creating the table, for deployments where the
+// application role isn't allowed to run DDL
+func WithoutCreateTable() persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithoutCreateTable] = true
+	}
+}
+
+// Store represents the cache with PostgreSQL persistence
+type Store struct {
+	db                *sql.DB
+	table             string
+	defaultExpiration time.Duration
+	stop              chan struct{}
+	closeOnce         sync.Once
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// Open connects to the database described by the lib/pq connection string and returns a Store
+func Open(dsn string, defaultExpiration time.Duration, opt ...persistence.Option) (*Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewStore(db, defaultExpiration, opt...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewStore returns a Store using the provided database, creating the table if needed
+func NewStore(db *sql.DB, defaultExpiration time.Duration, opt ...persistence.Option) (*Store, error) {
+	opts := persistence.GetOpts(opt...)
+	s := &Store{db: db, table: "cache", defaultExpiration: defaultExpiration, stop: make(chan struct{})}
+	if v, ok := opts[optionWithTableName].(string); ok {
+		s.table = v
+	}
+	if skip, _ := opts[optionWithoutCreateTable].(bool); !skip {
+		_, err := db.Exec(fmt.Sprintf(`CREATE UNLOGGED TABLE IF NOT EXISTS %[1]s (
+			key TEXT PRIMARY KEY,
+			value BYTEA NOT NULL,
+			expires_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (expires_at) WHERE expires_at IS NOT NULL;`,
+			pq.QuoteIdentifier(s.table), pq.QuoteIdentifier(s.table+"_expires_at")))
+		if err != nil {
+			return nil, err
+		}
+	}
+	interval := time.Minute
+	if v, ok := opts[optionWithReapInterval].(time.Duration); ok {
+		interval = v
+	}
+	if interval > 0 {
+		go s.reap(interval)
+	}
+	return s, nil
+}
+
+// Close stops the reaper and closes the database
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	return s.db.Close()
+}
+
+func (s *Store) reap(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = s.DeleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// DeleteExpired removes every expired row and returns how many were removed
+func (s *Store) DeleteExpired() (int64, error) {
+	res, err := s.db.Exec(s.query("DELETE FROM %s WHERE expires_at <= now()"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) query(q string) string {
+	return fmt.Sprintf(q, pq.QuoteIdentifier(s.table))
+}
+
+// ttl returns the TTL in seconds to pass to the expiresAt expression, 0 meaning never
+func (s *Store) ttl(expires time.Duration) float64 {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return expires.Seconds()
+}
+
+const (
+	// live is the condition matching unexpired rows
+	live = "(expires_at IS NULL OR expires_at > now())"
+	// expiresAt computes the expiration from a TTL in seconds parameter
+	expiresAt = "CASE WHEN %[1]s::float8 > 0 THEN now() + %[1]s::float8 * interval '1 second' END"
+)
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, value interface{}) error {
+	var b []byte
+	err := s.db.QueryRow(s.query("SELECT value FROM %s WHERE key = $1 AND "+live), key).Scan(&b)
+	if err == sql.ErrNoRows {
+		return persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.query(`INSERT INTO %s AS c (key, value, expires_at) VALUES ($1, $2, `+fmt.Sprintf(expiresAt, "$3")+`)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`),
+		key, b, s.ttl(expires))
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	// an expired row counts as missing, so it may be overwritten
+	res, err := s.db.Exec(s.query(`INSERT INTO %s AS c (key, value, expires_at) VALUES ($1, $2, `+fmt.Sprintf(expiresAt, "$3")+`)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at
+		WHERE c.expires_at <= now()`),
+		key, b, s.ttl(expires))
+	return notStored(res, err)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(s.query("UPDATE %s SET value = $2, expires_at = "+fmt.Sprintf(expiresAt, "$3")+" WHERE key = $1 AND "+live),
+		key, b, s.ttl(expires))
+	return notStored(res, err)
+}
+
+// notStored maps a write that affected no rows to ErrNotStored
+func notStored(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return persistence.ErrNotStored
+	}
+	return nil
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	res, err := s.db.Exec(s.query("DELETE FROM %s WHERE key = $1 AND "+live), key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return persistence.ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(cur uint64) uint64 { return cur + delta })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(key, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// add applies op to the integer stored under key, locking the row for the
+// duration of the transaction and keeping its expiration
+func (s *Store) add(key string, op func(uint64) uint64) (uint64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var b []byte
+	err = tx.QueryRow(s.query("SELECT value FROM %s WHERE key = $1 AND "+live+" FOR UPDATE"), key).Scan(&b)
+	if err == sql.ErrNoRows {
+		return 0, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return 0, err
+	}
+	cur, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	result := op(cur)
+	if _, err := tx.Exec(s.query("UPDATE %s SET value = $2 WHERE key = $1"), key, []byte(strconv.FormatUint(result, 10))); err != nil {
+		return 0, err
+	}
+	return result, tx.Commit()
+}
+
+// GetExpiresIn (see CacheStore interface), as told by the database clock
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	var left sql.NullFloat64
+	err := s.db.QueryRow(s.query("SELECT EXTRACT(EPOCH FROM expires_at - now()) FROM %s WHERE key = $1 AND "+live), key).Scan(&left)
+	if err == sql.ErrNoRows {
+		return 0, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !left.Valid {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Duration(left.Float64 * float64(time.Second)), nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	_, err := s.db.Exec(s.query("TRUNCATE %s"))
+	return err
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+// These tests require a PostgreSQL server, set POSTGRES_TEST_DSN to point them at one
+func newStore(t *testing.T, defaultExpiration time.Duration) *Store {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		dsn = "postgres://postgres@localhost:5432/postgres?sslmode=disable"
+	}
+	s, err := Open(dsn, defaultExpiration, WithTableName("cache_test"), WithReapInterval(0))
+	if err != nil {
+		t.Skipf("couldn't connect to postgres on %s: %s", dsn, err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestPostgres_TypicalGetSet(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if err := s.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := s.Get("value", &value); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if value != "foo" {
+		t.Errorf("Expected to get foo back, got %s", value)
+	}
+}
+
+func TestPostgres_IncrDecr(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("int", 10, persistence.DEFAULT)
+	if n, err := s.Increment("int", 50); err != nil || n != 60 {
+		t.Errorf("Expected 60, was %d (%v)", n, err)
+	}
+	if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+		t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+	}
+}
+
+func TestPostgres_AddReplaceDelete(t *testing.T) {
+	s := newStore(t, time.Hour)
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Replace("key", 3, persistence.DEFAULT); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestPostgres_Expiration(t *testing.T) {
+	s := newStore(t, 100*time.Millisecond)
+	s.Set("int", 10, persistence.DEFAULT)
+	s.Set("forever", 10, persistence.FOREVER)
+	time.Sleep(200 * time.Millisecond)
+	var v int
+	if err := s.Get("int", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if n, err := s.DeleteExpired(); err != nil || n != 1 {
+		t.Errorf("expected 1 expired row deleted, got %d (%v)", n, err)
+	}
+	if err := s.Get("forever", &v); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+}
@@ -0,0 +1,230 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/Bose/cache/utils"
+	"golang.org/x/sync/singleflight"
+)
+
+const defaultXFetchBeta = 1.0
+
+// loadLockSuffix is appended to keys to name the lock of their load (see WithLoadLock)
+const loadLockSuffix = ":loadlock"
+
+// loadLockPollInterval is how often processes waiting for the holder of a load lock
+// poll the store
+const loadLockPollInterval = 20 * time.Millisecond
+
+// loadLock is the cross process coalescing set by WithLoadLock
+type loadLock struct {
+	ttl  time.Duration
+	wait time.Duration
+}
+
+// tokenLocker is implemented by the stores able to release a lock only if it holds the
+// token of its holder in a single step, such as RedisStore
+type tokenLocker interface {
+	acquireLock(key, token string, ttl time.Duration) error
+	releaseLock(key, token string) error
+}
+
+// ReadThrough loads missing keys from the origin with its loader and caches them.
+//
+// To avoid a stampede on hot keys it implements probabilistic early expiration
+// (XFetch, Vattani et al.): every entry records how long the loader took to compute
+// it, and each read recomputes it early with a probability that grows as the expiry
+// gets closer and the longer the computation is, so usually a single caller refreshes
+// a hot key ahead of its expiry instead of every caller at once when it expires.
+// Concurrent loads of the same key within the process are also collapsed into one,
+// and across processes with WithLoadLock.
+// A nil loaded is cached like any value and reported with ErrNilValue, so keys the
+// origin has no value for aren't looked up again on every read.
+type ReadThrough struct {
+	store             CacheStore
+	loader            Loader
+	defaultExpiration time.Duration
+	ttlPolicy         ttlPolicy
+	clock             Clock
+	beta              float64
+	lock              loadLock
+	flight            singleflight.Group
+}
+
+// NewReadThrough returns a ReadThrough caching in store the values loaded by loader
+func NewReadThrough(store CacheStore, defaultExpiration time.Duration, loader Loader, opt ...Option) *ReadThrough {
+	opts := GetOpts(opt...)
+	r := &ReadThrough{store: store, loader: loader, defaultExpiration: defaultExpiration, beta: defaultXFetchBeta}
+	r.ttlPolicy = newTTLPolicy(opts)
+	r.clock = newClock(opts)
+	if v, ok := opts[optionWithXFetchBeta].(float64); ok && v >= 0 {
+		r.beta = v
+	}
+	if v, ok := opts[optionWithLoadLock].(loadLock); ok && v.ttl > 0 {
+		if v.ttl < time.Second {
+			v.ttl = time.Second
+		}
+		r.lock = v
+	}
+	return r
+}
+
+// entries are stored as the time the loader took and the expiry (unix nano, 0 for
+// never), both 8 byte big endian, followed by the serialized value
+func encodeXFetchEntry(b []byte, delta time.Duration, expiry int64) []byte {
+	entry := make([]byte, 16+len(b))
+	binary.BigEndian.PutUint64(entry, uint64(delta))
+	binary.BigEndian.PutUint64(entry[8:], uint64(expiry))
+	copy(entry[16:], b)
+	return entry
+}
+
+// fresh reports whether the entry can be served, false when XFetch decides it
+// should be recomputed early
+func (r *ReadThrough) fresh(delta time.Duration, expiry int64) bool {
+	if expiry == 0 {
+		return true
+	}
+	// -ln(rand) is exponentially distributed, so early recomputes get likelier
+	// as the expiry approaches
+	early := float64(delta) * r.beta * -math.Log(1-rand.Float64())
+	return float64(r.clock.Now().UnixNano())+early < float64(expiry)
+}
+
+// Get returns the cached value for the key, loading and caching it with the default
+// expiration when it's missing or due for an early recompute
+func (r *ReadThrough) Get(key string, value interface{}) error {
+	return r.GetWithExpiration(key, value, DEFAULT)
+}
+
+// GetWithExpiration is Get caching loaded values with the given expiration
+func (r *ReadThrough) GetWithExpiration(key string, value interface{}, expires time.Duration) error {
+	var entry []byte
+	err := r.store.Get(key, &entry)
+	if err != nil && err != ErrCacheMiss {
+		return err
+	}
+	var stale []byte
+	if err == nil && len(entry) >= 16 {
+		delta := time.Duration(binary.BigEndian.Uint64(entry))
+		expiry := int64(binary.BigEndian.Uint64(entry[8:]))
+		if r.fresh(delta, expiry) {
+			return utils.Deserialize(entry[16:], value)
+		}
+		stale = entry
+	}
+	b, err, _ := r.flight.Do(key, func() (interface{}, error) { return r.loadShared(key, expires, stale) })
+	if err != nil {
+		if stale != nil {
+			// the entry hasn't actually expired yet, so it still beats an error
+			return utils.Deserialize(stale[16:], value)
+		}
+		return err
+	}
+	return utils.Deserialize(b.([]byte), value)
+}
+
+// loadShared loads the key, only once across processes with WithLoadLock: when another
+// process holds the lock of the key, the stale entry being refreshed is served if any,
+// or the store is polled for the value being loaded. The key is loaded anyway if the
+// lock can't be taken or the value doesn't show up in time.
+func (r *ReadThrough) loadShared(key string, expires time.Duration, stale []byte) ([]byte, error) {
+	if r.lock.ttl <= 0 {
+		return r.load(key, expires)
+	}
+	lockKey := key + loadLockSuffix
+	deadline := time.Now().Add(r.lock.wait)
+	for {
+		token := newLockToken()
+		err := r.acquireLoadLock(lockKey, token)
+		if err == nil {
+			defer r.releaseLoadLock(lockKey, token)
+			// the previous holder of the lock may have loaded the key meanwhile
+			var entry []byte
+			if err := r.store.Get(key, &entry); err == nil && len(entry) >= 16 &&
+				(stale == nil || !bytes.Equal(entry[:16], stale[:16])) {
+				return entry[16:], nil
+			}
+			return r.load(key, expires)
+		}
+		if err != ErrNotStored {
+			return r.load(key, expires)
+		}
+		if stale != nil {
+			return stale[16:], nil
+		}
+		if !time.Now().Before(deadline) {
+			return r.load(key, expires)
+		}
+		time.Sleep(loadLockPollInterval)
+		var entry []byte
+		if err := r.store.Get(key, &entry); err == nil && len(entry) >= 16 {
+			return entry[16:], nil
+		}
+	}
+}
+
+// acquireLoadLock takes the load lock lockKey for the holder identified by token,
+// returning ErrNotStored if it's held already
+func (r *ReadThrough) acquireLoadLock(lockKey, token string) error {
+	if l, ok := r.store.(tokenLocker); ok {
+		if err := l.acquireLock(lockKey, token, r.lock.ttl); err != ErrLockNotAcquired {
+			return err
+		}
+		return ErrNotStored
+	}
+	return r.store.Add(lockKey, token, r.lock.ttl)
+}
+
+// releaseLoadLock releases the load lock lockKey unless it expired and was taken by
+// another holder since. Only the stores implementing tokenLocker check the token and
+// delete the lock in one step, the others leave a short window between the two.
+func (r *ReadThrough) releaseLoadLock(lockKey, token string) {
+	if l, ok := r.store.(tokenLocker); ok {
+		l.releaseLock(lockKey, token)
+		return
+	}
+	var holder string
+	if err := r.store.Get(lockKey, &holder); err == nil && holder == token {
+		r.store.Delete(lockKey)
+	}
+}
+
+// load calls the loader and caches its value with the time it took
+func (r *ReadThrough) load(key string, expires time.Duration) ([]byte, error) {
+	start := time.Now()
+	v, err := r.loader(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	delta := time.Since(start)
+	b, err := utils.Serialize(v)
+	if err != nil {
+		return nil, err
+	}
+	if expires == DEFAULT {
+		var jitter ttlJitter
+		expires, jitter = r.ttlPolicy.resolve(key, r.defaultExpiration, 0)
+		expires = jitter.apply(expires)
+	}
+	var expiry int64
+	if expires > 0 {
+		expiry = r.clock.Now().Add(expires).UnixNano()
+	} else {
+		expires = FOREVER
+	}
+	if err := r.store.Set(key, encodeXFetchEntry(b, delta, expiry), expires); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Delete removes the cached value so the next Get loads it again
+func (r *ReadThrough) Delete(key string) error {
+	return r.store.Delete(key)
+}
@@ -0,0 +1,212 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadThrough_LoadsOnce(t *testing.T) {
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "value of " + key, nil
+	}
+	r := NewReadThrough(NewInMemoryStore(time.Hour), time.Hour, loader)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v string
+			if err := r.Get("key", &v); err != nil || v != "value of key" {
+				t.Errorf("unexpected value %q (%v)", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	var v string
+	r.Get("key", &v)
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("expected a single load, got %d", n)
+	}
+}
+
+func TestReadThrough_XFetchRecomputesEarly(t *testing.T) {
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return 1, nil
+	}
+	// with a large beta, a 10ms computation is recomputed well before a 100ms expiry
+	r := NewReadThrough(NewInMemoryStore(time.Hour), 100*time.Millisecond, loader, WithXFetchBeta(100))
+	var v int
+	r.Get("key", &v)
+	deadline := time.Now().Add(90 * time.Millisecond)
+	for time.Now().Before(deadline) && atomic.LoadInt32(&loads) < 2 {
+		r.Get("key", &v)
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&loads); n < 2 {
+		t.Errorf("expected an early recompute before the expiry, got %d loads", n)
+	}
+
+	// without XFetch the entry is served until it expires
+	atomic.StoreInt32(&loads, 0)
+	r = NewReadThrough(NewInMemoryStore(time.Hour), time.Hour, loader, WithXFetchBeta(0))
+	for i := 0; i < 100; i++ {
+		r.Get("key", &v)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("expected a single load, got %d", n)
+	}
+}
+
+func TestReadThrough_Errors(t *testing.T) {
+	fail := false
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		if fail {
+			return nil, errors.New("origin down")
+		}
+		return 1, nil
+	}
+	r := NewReadThrough(NewInMemoryStore(time.Hour), 50*time.Millisecond, loader, WithXFetchBeta(1e9))
+	var v int
+	if err := r.Get("key", &v); err != nil || v != 1 {
+		t.Fatalf("unexpected value %d (%v)", v, err)
+	}
+	// a failed early recompute still serves the unexpired entry
+	fail = true
+	if err := r.Get("key", &v); err != nil || v != 1 {
+		t.Errorf("expected the cached value, got %d (%v)", v, err)
+	}
+	r.Delete("key")
+	if err := r.Get("key", &v); err == nil || err.Error() != "origin down" {
+		t.Errorf("expected the loader error, got %v", err)
+	}
+}
+
+func TestReadThrough_LoadLock(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour)
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "value of " + key, nil
+	}
+	// each ReadThrough stands for a process sharing the store
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		r := NewReadThrough(store, time.Hour, loader, WithLoadLock(time.Second, time.Second))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v string
+			if err := r.Get("key", &v); err != nil || v != "value of key" {
+				t.Errorf("unexpected value %q (%v)", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("expected a single load across processes, got %d", n)
+	}
+
+	// a lock left by a process that died is waited for no longer than wait
+	store.Set("other"+loadLockSuffix, 1, time.Minute)
+	r := NewReadThrough(store, time.Hour, loader, WithLoadLock(time.Second, 100*time.Millisecond))
+	start := time.Now()
+	var v string
+	if err := r.Get("other", &v); err != nil || v != "value of other" {
+		t.Errorf("unexpected value %q (%v)", v, err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Errorf("expected to wait for the lock before loading, waited %s", d)
+	}
+}
+
+func TestReadThrough_LoaderPanic(t *testing.T) {
+	panics := true
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		if panics {
+			panics = false
+			panic("loader")
+		}
+		return 1, nil
+	}
+	r := NewReadThrough(NewInMemoryStore(time.Hour), time.Hour, loader)
+	done := make(chan error)
+	get := func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- errors.New("panicked")
+			}
+		}()
+		var v int
+		done <- r.Get("key", &v)
+	}
+	go get()
+	if err := <-done; err == nil {
+		t.Error("expected the panic of the loader to reach the caller")
+	}
+	go get()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the key to load, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Get not to block after the loader panicked")
+	}
+}
+
+func TestReadThrough_LoadLockRelease(t *testing.T) {
+	stores := map[string]CacheStore{
+		"redis":     NewRedisCache(newRedisServer(t), "", time.Hour),
+		"in-memory": NewInMemoryStore(time.Hour),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			lockKey := "key" + loadLockSuffix
+			loader := func(ctx context.Context, key string) (interface{}, error) {
+				// the lock expires while loading and another process takes it
+				store.Delete(lockKey)
+				store.Add(lockKey, "other", time.Minute)
+				return 1, nil
+			}
+			r := NewReadThrough(store, time.Hour, loader, WithLoadLock(time.Second, time.Second))
+			var v int
+			if err := r.Get("key", &v); err != nil || v != 1 {
+				t.Fatalf("unexpected value %d (%v)", v, err)
+			}
+			if _, err := store.GetExpiresIn(lockKey); err != nil {
+				t.Errorf("expected the lock of the other process to be kept, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadThrough_LoadLockLoaded(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return 1, nil
+	}
+	// another process loaded the key and released the lock before this one took it
+	other := NewReadThrough(store, time.Hour, loader, WithLoadLock(time.Second, time.Second))
+	var v int
+	other.Get("key", &v)
+	r := NewReadThrough(store, time.Hour, loader, WithLoadLock(time.Second, time.Second))
+	if _, err := r.loadShared("key", DEFAULT, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("expected the key loaded by the other process to be served, got %d loads", n)
+	}
+}
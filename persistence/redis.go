@@ -1,23 +1,30 @@
 package persistence
 
 import (
-	"errors"
-	"fmt"
-
 	"time"
 
 	"github.com/Bose/cache/utils"
 	"github.com/gomodule/redigo/redis"
 )
 
-var (
-	ErrCacheNoTTL = errors.New("cache: key has no TTL.")
-)
-
 // RedisStore represents the cache with redis persistence
 type RedisStore struct {
 	pool              *redis.Pool
 	defaultExpiration time.Duration
+	chunkSize         int
+	valueSize         valueSizeLimit
+	ttlJitter         ttlJitter
+	ttlPolicy         ttlPolicy
+	ttlClamp          ttlClamp
+	opTimeout         time.Duration
+	decodeQuarantine  decodeQuarantine
+	codec             utils.Codec
+	envelope          bool
+	schema            uint16
+	rawStrings        bool
+	nativeIntegers    bool
+	unlink            bool
+	mgetBatching      mgetBatching
 }
 
 // NewRedisCache returns a RedisStore
@@ -28,26 +35,41 @@ func NewRedisCache(host string, password string, defaultExpiration time.Duration
 	if v, ok := opts[optionWithSelectDatabase].(int); ok {
 		selectDatabase = v
 	}
+	var dialOptions []redis.DialOption
+	opTimeout, _ := opts[optionWithOperationTimeout].(time.Duration)
+	if opTimeout > 0 {
+		dialOptions = append(dialOptions, redis.DialConnectTimeout(opTimeout), redis.DialReadTimeout(opTimeout), redis.DialWriteTimeout(opTimeout))
+	}
+	resp3, _ := opts[optionWithRESP3].(bool)
+	healthCheck, _ := opts[optionWithHealthCheck].(time.Duration)
+	maxConnLifetime, _ := opts[optionWithMaxConnLifetime].(time.Duration)
 	var pool = &redis.Pool{
 		MaxIdle:     5,
 		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
-			// the redis protocol should probably be made sett-able
-			c, err := redis.Dial("tcp", host)
-			if err != nil {
-				return nil, err
-			}
-			if len(password) > 0 {
-				if _, err := c.Do("AUTH", password); err != nil {
-					c.Close()
+			var c redis.Conn
+			var err error
+			if resp3 {
+				// HELLO authenticates and checks the connection
+				if c, err = dialRESP3(host, password, opTimeout); err != nil {
 					return nil, err
 				}
 			} else {
-				// check with PING
-				if _, err := c.Do("PING"); err != nil {
-					c.Close()
+				if c, err = redis.Dial("tcp", host, dialOptions...); err != nil {
 					return nil, err
 				}
+				if len(password) > 0 {
+					if _, err := c.Do("AUTH", password); err != nil {
+						c.Close()
+						return nil, err
+					}
+				} else {
+					// check with PING
+					if _, err := c.Do("PING"); err != nil {
+						c.Close()
+						return nil, err
+					}
+				}
 			}
 			if selectDatabase != 0 {
 				// logger.Debugf("NewRedisCache: select database %d", selectDatabase)
@@ -58,26 +80,58 @@ func NewRedisCache(host string, password string, defaultExpiration time.Duration
 			}
 			return c, err
 		},
-		// custom connection test method
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			if _, err := c.Do("PING"); err != nil {
-				return err
-			}
+		MaxConnLifetime: maxConnLifetime,
+		TestOnBorrow:    testOnBorrow(healthCheck),
+	}
+	return NewRedisCacheWithPool(pool, defaultExpiration, opt...)
+}
+
+// testOnBorrow returns the check of the connections NewRedisCache borrows from its
+// pool: a PING of those idle for longer than idle, of all of them when idle is 0, and
+// none when it's negative
+func testOnBorrow(idle time.Duration) func(redis.Conn, time.Time) error {
+	if idle < 0 {
+		return nil
+	}
+	return func(c redis.Conn, t time.Time) error {
+		if idle > 0 && time.Since(t) < idle {
 			return nil
-		},
+		}
+		_, err := c.Do("PING")
+		return err
 	}
-	return &RedisStore{pool, defaultExpiration}
 }
 
 // NewRedisCacheWithPool returns a RedisStore using the provided pool
 // until redigo supports sharding/clustering, only one host will be in hostList
-func NewRedisCacheWithPool(pool *redis.Pool, defaultExpiration time.Duration) *RedisStore {
-	return &RedisStore{pool, defaultExpiration}
+func NewRedisCacheWithPool(pool *redis.Pool, defaultExpiration time.Duration, opt ...Option) *RedisStore {
+	opts := GetOpts(opt...)
+	c := &RedisStore{
+		pool:              pool,
+		defaultExpiration: defaultExpiration,
+		valueSize:         newValueSizeLimit(opts),
+		ttlJitter:         newTTLJitter(opts),
+		ttlPolicy:         newTTLPolicy(opts),
+		ttlClamp:          newTTLClamp(opts),
+		mgetBatching:      newMgetBatching(opts),
+	}
+	if v, ok := opts[optionWithChunkSize].(int); ok && v > 0 {
+		c.chunkSize = v
+	}
+	if v, ok := opts[optionWithOperationTimeout].(time.Duration); ok && v > 0 {
+		c.opTimeout = v
+	}
+	c.decodeQuarantine = newDecodeQuarantine(opts)
+	c.codec, c.envelope, c.schema = newCodecOptions(opts)
+	c.rawStrings, _ = opts[optionWithRawStrings].(bool)
+	c.nativeIntegers, _ = opts[optionWithNativeIntegers].(bool)
+	c.unlink, _ = opts[optionWithUnlink].(bool)
+	return c
 }
 
 // Set (see CacheStore interface)
 func (c *RedisStore) Set(key string, value interface{}, expires time.Duration) error {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 	return c.invoke(conn.Do, key, value, expires)
 }
@@ -86,66 +140,127 @@ func (c *RedisStore) Set(key string, value interface{}, expires time.Duration) e
 // kv is a list of key value pairs: k1, v1, k2, v2, ...
 func (c *RedisStore) MSetNX(expires time.Duration, kv ...interface{}) error {
 	l := len(kv)
+	if l == 0 {
+		return nil
+	}
 	if l%2 != 0 {
-		return fmt.Errorf("Got %v keys but %v values", l/2, l/2+1)
+		return &ValueCountError{Got: l / 2, Required: l/2 + 1}
 	}
 	keys := []string{}
 	values := []interface{}{}
 	for i := 0; i < l; i += 2 {
 		if k, ok := kv[i].(string); !ok {
-			return fmt.Errorf("key %v: %v is not string", i, kv[i])
+			return &KeyTypeError{Index: i, Key: kv[i]}
 		} else {
 			keys = append(keys, k)
 			values = append(values, kv[i+1])
 		}
 	}
 
-	ex := c.translateExpire(expires)
+	// the values are serialized up front, so nothing is sent when one of them fails
+	heads := make([][]byte, len(keys))
+	chunks := make([][][]byte, len(keys))
+	ttls := make([]time.Duration, len(keys))
+	for i, key := range keys {
+		b, err := c.marshal(values[i])
+		if err != nil {
+			return &SerializationError{Key: key, Cause: err}
+		}
+		if skip, err := c.valueSize.check(key, len(b)); err != nil {
+			return err
+		} else if skip {
+			continue
+		}
+		heads[i] = b
+		if c.chunkSize > 0 {
+			if chunks[i] = splitChunks(b, c.chunkSize); len(chunks[i]) > 0 {
+				heads[i] = newChunkManifest(b, len(chunks[i])).encode()
+			}
+		}
+		// each key gets its own expiration, as WithTTLPolicy rules may differ per key
+		ttls[i] = c.expiration(key, expires)
+	}
 
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 
 	if err := conn.Send("MULTI"); err != nil {
 		return err
 	}
-	for i := 0; i < len(keys); i++ {
-		b, err := utils.Serialize(values[i])
-		if err != nil {
-			return fmt.Errorf("Failed to serialize value %v: %v", i, values[i])
+	for i, key := range keys {
+		if heads[i] == nil {
+			continue
 		}
-		if err := conn.Send("SETNX", keys[i], b); err != nil {
-			return err
+		args := []interface{}{key, heads[i], "NX"}
+		if ttls[i] > 0 {
+			args = append(args, "EX", int32(ttls[i]/time.Second))
 		}
-		if ex > 0 {
-			if err := conn.Send("EXPIRE", keys[i], ex); err != nil {
-				return err
-			}
+		if err := conn.Send("SET", args...); err != nil {
+			return err
 		}
 	}
-	_, err := conn.Do("EXEC")
+	replies, err := redis.Values(conn.Do("EXEC"))
 	if err != nil {
 		return err
 	}
+	// as with Add, the chunks of the values whose manifest won its key are written
+	// once the manifest is, and readers see a miss until then
+	won := 0
+	for i := range keys {
+		if heads[i] == nil {
+			continue
+		}
+		if replies[won] != nil && len(chunks[i]) > 0 {
+			if err := c.writeChunks(conn, keys[i], chunks[i], ttls[i]); err != nil {
+				return err
+			}
+		}
+		won++
+	}
 	return nil
 }
 
-// Add (see CacheStore interface)
+// Add (see CacheStore interface). The value is written with a single SET NX, so only
+// one of concurrent callers adding the same key succeeds.
 func (c *RedisStore) Add(key string, value interface{}, expires time.Duration) error {
-	conn := c.pool.Get()
-	defer conn.Close()
-	exists, err := exists(conn, key)
+	b, err := c.marshal(value)
 	if err != nil {
+		return &SerializationError{Key: key, Cause: err}
+	}
+	if skip, err := c.valueSize.check(key, len(b)); err != nil || skip {
 		return err
 	}
-	if exists {
+	expires = c.expiration(key, expires)
+	var chunks [][]byte
+	if c.chunkSize > 0 {
+		chunks = splitChunks(b, c.chunkSize)
+	}
+	head := b
+	if len(chunks) > 0 {
+		head = newChunkManifest(b, len(chunks)).encode()
+	}
+
+	conn := c.getConn()
+	defer conn.Close()
+	args := []interface{}{key, head, "NX"}
+	if expires > 0 {
+		args = append(args, "EX", int32(expires/time.Second))
+	}
+	if _, err := redis.String(conn.Do("SET", args...)); err == redis.ErrNil {
 		return ErrNotStored
+	} else if err != nil {
+		return err
 	}
-	return c.invoke(conn.Do, key, value, expires)
+	if len(chunks) == 0 {
+		return nil
+	}
+	// the manifest won the key, readers see a miss until its chunks are written
+	return c.writeChunks(conn, key, chunks, expires)
 }
 
 // Replace (see CacheStore interface)
 func (c *RedisStore) Replace(key string, value interface{}, expires time.Duration) error {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 	if exists, err := exists(conn, key); !exists {
 		if err != nil {
@@ -153,43 +268,49 @@ func (c *RedisStore) Replace(key string, value interface{}, expires time.Duratio
 		}
 		return ErrNotStored
 	}
-	err := c.invoke(conn.Do, key, value, expires)
-	if value == nil {
-		return ErrNotStored
-	}
-
-	return err
-
+	return c.invoke(conn.Do, key, value, expires)
 }
 
 // Get (see CacheStore interface)
 func (c *RedisStore) Get(key string, ptrValue interface{}) error {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 	raw, err := conn.Do("GET", key)
+	if isWrongType(err) {
+		return wrongType(conn, key, err)
+	}
+	if err != nil {
+		return err
+	}
 	if raw == nil {
 		return ErrCacheMiss
 	}
-	item, err := redis.Bytes(raw, err)
+	stored, err := redis.Bytes(raw, err)
 	if err != nil {
 		return err
 	}
-	return utils.Deserialize(item, ptrValue)
+	item, err := c.resolveChunks(conn, key, stored)
+	if err != nil {
+		return err
+	}
+	if err := c.unmarshal(item, ptrValue); err == ErrNilValue {
+		return err
+	} else if err != nil {
+		c.quarantine(conn, key, stored, item)
+		return &SerializationError{Key: key, Cause: err, Decoding: true}
+	}
+	return nil
 }
 
 // MGet retrieves a list of items for the list of keys provided. If an item does not exist, an ErrCacheMiss is returned.
+// Long key lists are fetched in batches, see WithMgetBatchSize.
 func (c *RedisStore) Mget(ptrValue []interface{}, keys ...string) error {
 	if len(ptrValue) != len(keys) {
-		return fmt.Errorf("Length of value array is different from number of keys. Got %v, requires %v", len(ptrValue), len(keys))
+		return &ValueCountError{Got: len(ptrValue), Required: len(keys)}
 	}
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
-	var ks []interface{}
-	for _, k := range keys {
-		ks = append(ks, k)
-	}
-
-	raw, err := redis.Values(conn.Do("MGET", ks...))
+	raw, err := c.mgetRaw(conn, keys)
 	if err != nil {
 		return err
 	}
@@ -197,14 +318,21 @@ func (c *RedisStore) Mget(ptrValue []interface{}, keys ...string) error {
 		return ErrCacheMiss
 	}
 	for idx, r := range raw {
-		item, err := redis.Bytes(r, err)
+		stored, err := redis.Bytes(r, err)
 		if err != nil {
 			return err
 		}
-		err = utils.Deserialize(item, ptrValue[idx])
+		item, err := c.resolveChunks(conn, keys[idx], stored)
 		if err != nil {
 			return err
 		}
+		err = c.unmarshal(item, ptrValue[idx])
+		if err == ErrNilValue {
+			return err
+		} else if err != nil {
+			c.quarantine(conn, keys[idx], stored, item)
+			return &SerializationError{Key: keys[idx], Cause: err, Decoding: true}
+		}
 	}
 	return nil
 }
@@ -214,23 +342,101 @@ func exists(conn redis.Conn, key string) (bool, error) {
 	return retval, err
 }
 
-// Delete (see CacheStore interface)
+// Delete (see CacheStore interface). The key is removed with a single DEL, or UNLINK
+// with WithUnlink, whose count tells whether it was there.
 func (c *RedisStore) Delete(key string) error {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
-	if exists, err := exists(conn, key); !exists {
+	args := []interface{}{key}
+	if c.chunkSize > 0 {
+		// remove the manifest and its chunks with a single DEL so readers never see a partial value
+		stale, err := c.staleChunks(conn.Do, key, 0)
 		if err != nil {
 			return err
 		}
+		args = append(args, stale...)
+	}
+	n, err := redis.Int(conn.Do(c.delCommand(), args...))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
 		return ErrCacheMiss
 	}
-	_, err := conn.Do("DEL", key)
-	return err
+	return nil
+}
+
+// delCommand returns the command keys are removed with
+func (c *RedisStore) delCommand() string {
+	if c.unlink {
+		return "UNLINK"
+	}
+	return "DEL"
+}
+
+// DeleteByPattern removes every key matching the glob pattern and returns the number
+// of keys removed. It walks the keyspace with SCAN rather than KEYS so the server is
+// never blocked, which also means keys written while it runs may be missed.
+func (c *RedisStore) DeleteByPattern(pattern string) (int, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	cursor := 0
+	count := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return count, err
+		}
+		var keys []interface{}
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return count, err
+		}
+		if len(keys) > 0 {
+			n, err := redis.Int(conn.Do(c.delCommand(), keys...))
+			if err != nil {
+				return count, err
+			}
+			count += n
+		}
+		if cursor == 0 {
+			return count, nil
+		}
+	}
+}
+
+// ScanKeys calls f for every key matching the glob pattern until f returns false,
+// leaving out the chunks of chunked values. Like DeleteByPattern it walks the keyspace
+// with SCAN, so keys written while it runs may be missed.
+func (c *RedisStore) ScanKeys(pattern string, f func(key string) bool) error {
+	conn := c.getConn()
+	defer conn.Close()
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if c.chunkSize > 0 && chunkKeyPattern.MatchString(key) {
+				continue
+			}
+			if !f(key) {
+				return nil
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
 }
 
 // Increment (see CacheStore interface)
 func (c *RedisStore) Increment(key string, delta uint64) (uint64, error) {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 	// Check for existance *before* increment as per the cache contract.
 	// redis will auto create the key, and we don't want that. Since we need to do increment
@@ -258,7 +464,7 @@ func (c *RedisStore) Increment(key string, delta uint64) (uint64, error) {
 
 // IncrementCheckSet - special case where you want to increment a value ONLY if it doesn't change between your GET and SET
 func (c *RedisStore) IncrementCheckSet(key string, delta uint64) (uint64, error) {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 	if _, err := conn.Do("WATCH", key); err != nil {
 		return 0, err
@@ -288,7 +494,7 @@ func (c *RedisStore) IncrementCheckSet(key string, delta uint64) (uint64, error)
 // IncrementAtomic - special case for Redis storage to handle the need for atomic increments without a data race problem when
 // a consumer wants to use this storage for something outside the standard cache contract.
 func (c *RedisStore) IncrementAtomic(key string, delta uint64) (uint64, error) {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 
 	newValue, err := conn.Do("INCRBY", key, delta)
@@ -300,41 +506,74 @@ func (c *RedisStore) IncrementAtomic(key string, delta uint64) (uint64, error) {
 
 // ExpireAt - special case for Redis storage to handle updating the TTL for the entry for when
 // a consumer wants to use this storage for something outside the standard cache contract.
+// epoc is in seconds since the Unix epoch, see ExpireAtTime.
 func (c *RedisStore) ExpireAt(key string, epoc uint64) error {
-	conn := c.pool.Get()
+	return c.ExpireAtTime(key, time.Unix(int64(epoc), 0))
+}
+
+// ExpireAtTime makes the entry at key, chunks included, expire at t, to the
+// millisecond. It returns ErrCacheMiss if the key doesn't exist.
+func (c *RedisStore) ExpireAtTime(key string, t time.Time) error {
+	conn := c.getConn()
 	defer conn.Close()
-	ret, err := conn.Do("EXPIREAT", key, epoc)
-	if ret == 0 {
-		return ErrCacheMiss
+	ms := t.UnixMilli()
+	_, m, err := c.storedManifest(conn, key)
+	if err != nil {
+		return err
 	}
+	if m.count > 0 {
+		if err := conn.Send("MULTI"); err != nil {
+			return err
+		}
+		for _, k := range append([]interface{}{key}, m.keys(key)...) {
+			if err := conn.Send("PEXPIREAT", k, ms); err != nil {
+				return err
+			}
+		}
+		replies, err := redis.Ints(conn.Do("EXEC"))
+		if err != nil {
+			return err
+		}
+		if replies[0] == 0 {
+			return ErrCacheMiss
+		}
+		return nil
+	}
+	set, err := redis.Int(conn.Do("PEXPIREAT", key, ms))
 	if err != nil {
 		return err
 	}
+	if set == 0 {
+		return ErrCacheMiss
+	}
 	return nil
 }
 
-// GetExpiresIn returns the number of milliseconds until the key expires
-// returns ErrCacheNoTTL if no expiration is set on the entry
-func (c *RedisStore) GetExpiresIn(key string) (int64, error) {
-	conn := c.pool.Get()
+// GetExpiresIn (see CacheStore interface)
+func (c *RedisStore) GetExpiresIn(key string) (time.Duration, error) {
+	conn := c.getConn()
 	defer conn.Close()
-	ret, err := conn.Do("PTTL", key)
+	ttl, err := redis.Int64(conn.Do("PTTL", key))
 	if err != nil {
 		return 0, err
 	}
-	ttl := ret.(int64)
-	if ttl == -2 {
+	switch ttl {
+	case -2:
 		return 0, ErrCacheMiss
-	}
-	if ttl == -1 {
+	case -1:
 		return 0, ErrCacheNoTTL
 	}
-	return ret.(int64), nil
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (c *RedisStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(c, key, ttl, ptr, fill)
 }
 
 // Decrement (see CacheStore interface)
 func (c *RedisStore) Decrement(key string, delta uint64) (newValue uint64, err error) {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 	// Check for existance *before* increment as per the cache contract.
 	// redis will auto create the key, and we don't want that, hence the exists call
@@ -358,7 +597,7 @@ func (c *RedisStore) Decrement(key string, delta uint64) (newValue uint64, err e
 
 // Flush (see CacheStore interface)
 func (c *RedisStore) Flush() error {
-	conn := c.pool.Get()
+	conn := c.getConn()
 	defer conn.Close()
 	_, err := conn.Do("FLUSHALL")
 	return err
@@ -367,36 +606,61 @@ func (c *RedisStore) Flush() error {
 func (c *RedisStore) invoke(f func(string, ...interface{}) (interface{}, error),
 	key string, value interface{}, expires time.Duration) error {
 
-	switch expires {
-	case DEFAULT:
-		expires = c.defaultExpiration
-	case FOREVER:
-		expires = time.Duration(0)
+	b, err := c.marshal(value)
+	if err != nil {
+		return &SerializationError{Key: key, Cause: err}
 	}
+	return c.invokeBytes(f, key, b, expires)
+}
 
-	b, err := utils.Serialize(value)
-	if err != nil {
+// invokeBytes writes the already serialized value b
+func (c *RedisStore) invokeBytes(f func(string, ...interface{}) (interface{}, error),
+	key string, b []byte, expires time.Duration) error {
+
+	return c.write(f, key, b, c.expiration(key, expires))
+}
+
+// write writes the already serialized value b with the already translated expiration
+func (c *RedisStore) write(f func(string, ...interface{}) (interface{}, error),
+	key string, b []byte, expires time.Duration) error {
+
+	if skip, err := c.valueSize.check(key, len(b)); err != nil {
 		return err
+	} else if skip {
+		_, err := f("DEL", key)
+		return err
+	}
+
+	if c.chunkSize > 0 {
+		return c.setChunked(f, key, b, expires)
+	}
+	return c.set(f, key, b, expires)
+}
+
+// expiration translates DEFAULT (see WithTTLPolicy) and FOREVER into the expiration of
+// key to write, 0 for none, clamped to WithMinTTL and WithMaxTTL and jittered with
+// WithTTLJitter
+func (c *RedisStore) expiration(key string, expires time.Duration) time.Duration {
+	jitter := c.ttlJitter
+	if expires == DEFAULT {
+		expires, jitter = c.ttlPolicy.resolve(key, c.defaultExpiration, jitter)
 	}
+	if expires == FOREVER {
+		expires = time.Duration(0)
+	}
+	return jitter.apply(c.ttlClamp.apply(expires))
+}
+
+// set writes the serialized value with the already translated expiration
+func (c *RedisStore) set(f func(string, ...interface{}) (interface{}, error),
+	key string, b []byte, expires time.Duration) error {
 
 	if expires > 0 {
 		_, err := f("SETEX", key, int32(expires/time.Second), b)
 		return err
 	}
 
-	_, err = f("SET", key, b)
+	_, err := f("SET", key, b)
 	return err
 
 }
-
-// translate time duration to int32
-func (c *RedisStore) translateExpire(expires time.Duration) int32 {
-	result := expires
-	switch expires {
-	case DEFAULT:
-		result = c.defaultExpiration
-	case FOREVER:
-		result = time.Duration(0)
-	}
-	return int32(result / time.Second)
-}
@@ -0,0 +1,38 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// SetBytes works like Set for callers doing their own encoding: b is written as is,
+// without serializing it nor boxing it in an interface{}
+func (c *RedisStore) SetBytes(key string, b []byte, expires time.Duration) error {
+	conn := c.getConn()
+	defer conn.Close()
+	return c.invokeBytes(conn.Do, key, b, expires)
+}
+
+// GetBytes returns the value of key as is, without deserializing it, copied into buf
+// if it's large enough (so buf can be reused across calls) or the buffer the client
+// read the reply into otherwise. It returns ErrCacheMiss if the key doesn't exist.
+func (c *RedisStore) GetBytes(key string, buf []byte) ([]byte, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	stored, err := redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	item, err := c.resolveChunks(conn, key, stored)
+	if err != nil {
+		return nil, err
+	}
+	if cap(buf) < len(item) {
+		return item, nil
+	}
+	return append(buf[:0], item...), nil
+}
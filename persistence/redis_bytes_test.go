@@ -0,0 +1,40 @@
+package persistence
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_Bytes(t *testing.T) {
+	server := newRedisServer(t)
+	for name, store := range map[string]*RedisStore{
+		"plain":   NewRedisCache(server, "", time.Hour, WithEnvelope(1)),
+		"chunked": NewRedisCache(server, "", time.Hour, WithChunkSize(4)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			value := []byte("encoded by the caller")
+			if err := store.SetBytes("bytes", value, DEFAULT); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 0, 64)
+			got, err := store.GetBytes("bytes", buf)
+			if err != nil || !bytes.Equal(got, value) {
+				t.Errorf("expected %q, got %q (%v)", value, got, err)
+			}
+			if &got[0] != &buf[:1][0] {
+				t.Error("expected the value copied into buf")
+			}
+			if got, err = store.GetBytes("bytes", nil); err != nil || !bytes.Equal(got, value) {
+				t.Errorf("expected %q, got %q (%v)", value, got, err)
+			}
+			var viaGet []byte
+			if err := store.Get("bytes", &viaGet); err != nil || !bytes.Equal(viaGet, value) {
+				t.Errorf("expected Get to read it too, got %q (%v)", viaGet, err)
+			}
+			if _, err := store.GetBytes("missing", buf); err != ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// chunkManifestMagic starts the manifest stored under the key of a chunked value.
+// Neither gob streams nor the decimal integers written by utils.Serialize can start
+// with a zero byte followed by this text.
+var chunkManifestMagic = []byte("\x00cache:chunked\x00")
+
+// chunkManifest describes a value split across count chunk keys
+type chunkManifest struct {
+	count uint32
+	size  uint32
+	crc   uint32
+}
+
+func chunkKey(key string, i int) string {
+	return fmt.Sprintf("%s:chunk:%d", key, i)
+}
+
+func (m chunkManifest) encode() []byte {
+	b := make([]byte, len(chunkManifestMagic)+12)
+	n := copy(b, chunkManifestMagic)
+	binary.BigEndian.PutUint32(b[n:], m.count)
+	binary.BigEndian.PutUint32(b[n+4:], m.size)
+	binary.BigEndian.PutUint32(b[n+8:], m.crc)
+	return b
+}
+
+// decodeChunkManifest returns the manifest stored in b, or false if b is a plain value
+func decodeChunkManifest(b []byte) (chunkManifest, bool) {
+	if len(b) != len(chunkManifestMagic)+12 || !bytes.HasPrefix(b, chunkManifestMagic) {
+		return chunkManifest{}, false
+	}
+	b = b[len(chunkManifestMagic):]
+	return chunkManifest{
+		count: binary.BigEndian.Uint32(b),
+		size:  binary.BigEndian.Uint32(b[4:]),
+		crc:   binary.BigEndian.Uint32(b[8:]),
+	}, true
+}
+
+func (m chunkManifest) keys(key string) []interface{} {
+	keys := make([]interface{}, m.count)
+	for i := range keys {
+		keys[i] = chunkKey(key, i)
+	}
+	return keys
+}
+
+// staleChunks returns the chunk keys of the value currently stored under key that
+// won't be overwritten by a value with count chunks. A key of another type has no
+// chunks, and is overwritten like SET would.
+func (c *RedisStore) staleChunks(f func(string, ...interface{}) (interface{}, error), key string, count int) ([]interface{}, error) {
+	old, err := redis.Bytes(f("GET", key))
+	if err == redis.ErrNil || isWrongType(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, ok := decodeChunkManifest(old)
+	if !ok || int(m.count) <= count {
+		return nil, nil
+	}
+	return m.keys(key)[count:], nil
+}
+
+// splitChunks splits b into chunks of size bytes, or returns nil if it fits in one
+func splitChunks(b []byte, size int) [][]byte {
+	if len(b) <= size {
+		return nil
+	}
+	var chunks [][]byte
+	for start := 0; start < len(b); start += size {
+		end := start + size
+		if end > len(b) {
+			end = len(b)
+		}
+		chunks = append(chunks, b[start:end])
+	}
+	return chunks
+}
+
+// newChunkManifest returns the manifest of b split into count chunks
+func newChunkManifest(b []byte, count int) chunkManifest {
+	return chunkManifest{count: uint32(count), size: uint32(len(b)), crc: crc32.ChecksumIEEE(b)}
+}
+
+// setChunked stores b under key, splitting it into chunks when it is larger than
+// the chunk size. The manifest, the chunks and the removal of chunks left over
+// from a previous value are written in a single MULTI/EXEC.
+func (c *RedisStore) setChunked(f func(string, ...interface{}) (interface{}, error), key string, b []byte, expires time.Duration) error {
+	chunks := splitChunks(b, c.chunkSize)
+	stale, err := c.staleChunks(f, key, len(chunks))
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 && len(stale) == 0 {
+		return c.set(f, key, b, expires)
+	}
+	if _, err := f("MULTI"); err != nil {
+		return err
+	}
+	value := b
+	if len(chunks) > 0 {
+		value = newChunkManifest(b, len(chunks)).encode()
+	}
+	if err := c.set(f, key, value, expires); err != nil {
+		f("DISCARD")
+		return err
+	}
+	for i, chunk := range chunks {
+		if err := c.set(f, chunkKey(key, i), chunk, expires); err != nil {
+			f("DISCARD")
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		if _, err := f("DEL", stale...); err != nil {
+			f("DISCARD")
+			return err
+		}
+	}
+	_, err = f("EXEC")
+	return err
+}
+
+// writeChunks writes the chunks of the value whose manifest is stored under key, in
+// a single MULTI/EXEC
+func (c *RedisStore) writeChunks(conn redis.Conn, key string, chunks [][]byte, expires time.Duration) error {
+	if _, err := conn.Do("MULTI"); err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		if err := c.set(conn.Do, chunkKey(key, i), chunk, expires); err != nil {
+			conn.Do("DISCARD")
+			return err
+		}
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// getChunked reassembles the value described by the manifest. A chunk that is
+// missing or a checksum that doesn't match (the value was rewritten while it was
+// being read) is reported as a cache miss.
+func getChunked(conn redis.Conn, key string, m chunkManifest) ([]byte, error) {
+	chunks, err := redis.ByteSlices(conn.Do("MGET", m.keys(key)...))
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, m.size)
+	for _, chunk := range chunks {
+		if chunk == nil {
+			return nil, ErrCacheMiss
+		}
+		b = append(b, chunk...)
+	}
+	if uint32(len(b)) != m.size || crc32.ChecksumIEEE(b) != m.crc {
+		return nil, ErrCacheMiss
+	}
+	return b, nil
+}
+
+// resolveChunks returns the value itself, or the reassembled value when item is a manifest
+func (c *RedisStore) resolveChunks(conn redis.Conn, key string, item []byte) ([]byte, error) {
+	if c.chunkSize <= 0 {
+		return item, nil
+	}
+	if m, ok := decodeChunkManifest(item); ok {
+		return getChunked(conn, key, m)
+	}
+	return item, nil
+}
@@ -0,0 +1,112 @@
+package persistence
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+)
+
+var newChunkedRedisStore = func(t *testing.T, defaultExpiration time.Duration) *RedisStore {
+	return NewRedisCache(newRedisServer(t), "", defaultExpiration, WithChunkSize(16))
+}
+
+func chunkedValues(t *testing.T, newStore redisStoreFactory) {
+	store := newStore(t, time.Hour)
+	large := bytes.Repeat([]byte("0123456789"), 10)
+	if err := store.Set("large", large, DEFAULT); err != nil {
+		t.Fatalf("Error setting a chunked value: %s", err)
+	}
+	var got []byte
+	if err := store.Get("large", &got); err != nil || !bytes.Equal(got, large) {
+		t.Errorf("expected the chunked value back, got %q (%v)", got, err)
+	}
+	var raw []byte
+	if err := store.Get(chunkKey("large", 6), &raw); err != nil || len(raw) != 4 {
+		t.Errorf("expected the last chunk to hold 4 bytes, got %q (%v)", raw, err)
+	}
+
+	// a smaller value must not leave stale chunks behind
+	if err := store.Set("large", large[:20], DEFAULT); err != nil {
+		t.Fatalf("Error overwriting a chunked value: %s", err)
+	}
+	if err := store.Get(chunkKey("large", 2), &raw); err != ErrCacheMiss {
+		t.Errorf("expected stale chunks to be removed, got %v", err)
+	}
+	s := ""
+	other := []byte{}
+	if err := store.Set("small", "foo", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := store.Mget([]interface{}{&other, &s}, "large", "small"); err != nil || !bytes.Equal(other, large[:20]) || s != "foo" {
+		t.Errorf("unexpected mget result %q %q (%v)", other, s, err)
+	}
+
+	if err := store.Delete("large"); err != nil {
+		t.Errorf("Error deleting a chunked value: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := store.Get(chunkKey("large", i), &raw); err != ErrCacheMiss {
+			t.Errorf("expected chunk %d to be deleted, got %v", i, err)
+		}
+	}
+}
+
+func TestChunkManifest(t *testing.T) {
+	m := chunkManifest{count: 3, size: 40, crc: 12345}
+	got, ok := decodeChunkManifest(m.encode())
+	if !ok || got != m {
+		t.Errorf("expected %+v, got %+v (%v)", m, got, ok)
+	}
+	if _, ok := decodeChunkManifest([]byte("10")); ok {
+		t.Errorf("expected a plain value not to be read as a manifest")
+	}
+}
+
+func TestRedis_ChunkedMSetNX(t *testing.T) {
+	store := newChunkedRedisStore(t, time.Hour)
+	large := bytes.Repeat([]byte("0123456789"), 10)
+	if err := store.Set("taken", large[:40], DEFAULT); err != nil {
+		t.Fatalf("Error setting a chunked value: %s", err)
+	}
+	if err := store.MSetNX(DEFAULT, "large", large, "small", "foo", "taken", large); err != nil {
+		t.Fatalf("expected MSetNX to succeed, got %v", err)
+	}
+	var got []byte
+	if err := store.Get("large", &got); err != nil || !bytes.Equal(got, large) {
+		t.Errorf("expected the chunked value back, got %q (%v)", got, err)
+	}
+	var raw []byte
+	if err := store.Get(chunkKey("large", 6), &raw); err != nil || len(raw) != 4 {
+		t.Errorf("expected the last chunk to hold 4 bytes, got %q (%v)", raw, err)
+	}
+	s := ""
+	if err := store.Get("small", &s); err != nil || s != "foo" {
+		t.Errorf("expected foo, got %q (%v)", s, err)
+	}
+	// the chunks of a key that was already set are left alone
+	if err := store.Get("taken", &got); err != nil || !bytes.Equal(got, large[:40]) {
+		t.Errorf("expected the existing value to be kept, got %q (%v)", got, err)
+	}
+	if err := store.Get(chunkKey("taken", 3), &raw); err != ErrCacheMiss {
+		t.Errorf("expected no chunk to be written for the existing value, got %v", err)
+	}
+}
+
+func TestRedis_ChunkedOverwriteOtherType(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour, WithChunkSize(16))
+	large := bytes.Repeat([]byte("0123456789"), 10)
+	for _, v := range [][]byte{large, large[:10]} {
+		server.Lpush("list", "a")
+		if err := store.Set("list", v, DEFAULT); err != nil {
+			t.Fatalf("expected Set to overwrite a list, got %v", err)
+		}
+		var got []byte
+		if err := store.Get("list", &got); err != nil || !bytes.Equal(got, v) {
+			t.Errorf("expected the value back, got %q (%v)", got, err)
+		}
+		server.Del("list")
+	}
+}
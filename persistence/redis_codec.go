@@ -0,0 +1,96 @@
+package persistence
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/Bose/cache/utils"
+)
+
+func newCodecOptions(opts Options) (codec utils.Codec, envelope bool, schema uint16) {
+	codec = utils.Gob
+	if v, ok := opts[optionWithCodec].(utils.Codec); ok && v != nil {
+		codec = v
+	}
+	if v, ok := opts[optionWithEnvelope].(uint16); ok {
+		envelope, schema = true, v
+	}
+	return codec, envelope, schema
+}
+
+// marshal serializes value with the store's codec, enveloped if WithEnvelope is set,
+// or keeps it as is for strings with WithRawStrings and as decimal text for integers
+// with WithNativeIntegers. Nil values are written as the nil payload whatever the codec.
+func (c *RedisStore) marshal(value interface{}) ([]byte, error) {
+	if utils.IsNil(value) {
+		return utils.Serialize(nil)
+	}
+	if c.nativeIntegers {
+		if v, ok := integerValue(value); ok {
+			return utils.Serialize(v.Interface())
+		}
+	}
+	if s, ok := value.(string); ok && c.rawStrings {
+		return []byte(s), nil
+	}
+	if c.envelope {
+		return utils.Encode(c.codec, c.schema, value)
+	}
+	return c.codec.Marshal(value)
+}
+
+// unmarshal deserializes b with the codec its envelope names, or the store's codec.
+// With WithRawStrings, b is copied as is into *string targets unless enveloped, and
+// with WithNativeIntegers, integer targets are parsed from decimal text. The nil
+// payload is reported with ErrNilValue.
+func (c *RedisStore) unmarshal(b []byte, ptr interface{}) error {
+	if utils.IsNilValue(b) {
+		return ErrNilValue
+	}
+	if v, ok := integerValue(ptr); ok && c.nativeIntegers && v.CanAddr() {
+		if _, _, enveloped := utils.ParseEnvelope(b); !enveloped {
+			return utils.Deserialize(b, v.Addr().Interface())
+		}
+	}
+	if s, ok := ptr.(*string); ok && c.rawStrings {
+		if _, _, enveloped := utils.ParseEnvelope(b); !enveloped {
+			*s = string(b)
+			return nil
+		}
+	}
+	return utils.Decode(b, ptr, c.schema, c.codec)
+}
+
+// integerValue returns the integer value is or points to
+func integerValue(value interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v, true
+	}
+	return v, false
+}
+
+// withCodec returns a copy of the store serializing with codec, sharing its pool
+func (c *RedisStore) withCodec(codec utils.Codec) *RedisStore {
+	cp := *c
+	cp.codec = codec
+	return &cp
+}
+
+// SetWithCodec works like Set, serializing the value with codec rather than the store's
+// codec, e.g. to write some keys in a new codec while migrating to it
+func (c *RedisStore) SetWithCodec(codec utils.Codec, key string, value interface{}, expires time.Duration) error {
+	return c.withCodec(codec).Set(key, value, expires)
+}
+
+// GetWithCodec works like Get, deserializing the value with codec rather than the
+// store's codec. Enveloped values are decoded with the codec their envelope names
+// either way.
+func (c *RedisStore) GetWithCodec(codec utils.Codec, key string, ptrValue interface{}) error {
+	return c.withCodec(codec).Get(key, ptrValue)
+}
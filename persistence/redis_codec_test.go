@@ -0,0 +1,147 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestRedisStore_Envelope(t *testing.T) {
+	server := newRedisServer(t)
+	legacy := NewRedisCache(server, "", time.Hour)
+	enveloped := NewRedisCache(server, "", time.Hour, WithEnvelope(0))
+	legacy.Set("old", "written before", DEFAULT)
+	enveloped.Set("new", "written after", DEFAULT)
+	enveloped.Set("counter", 1, DEFAULT)
+
+	conn := legacy.getConn()
+	raw, _ := redis.Bytes(conn.Do("GET", "new"))
+	conn.Close()
+	if h, _, ok := utils.ParseEnvelope(raw); !ok || h.Codec != utils.CodecIDGob {
+		t.Errorf("expected an enveloped value, got %q", raw)
+	}
+	var s string
+	for _, store := range []*RedisStore{legacy, enveloped} {
+		if err := store.Get("old", &s); err != nil || s != "written before" {
+			t.Errorf("expected the legacy value, got %q (%v)", s, err)
+		}
+		if err := store.Get("new", &s); err != nil || s != "written after" {
+			t.Errorf("expected the enveloped value, got %q (%v)", s, err)
+		}
+	}
+	if n, err := enveloped.Increment("counter", 1); err != nil || n != 2 {
+		t.Errorf("expected 2, got %d (%v)", n, err)
+	}
+
+	// a new schema version makes the old values undecodable
+	v2 := NewRedisCache(server, "", time.Hour, WithEnvelope(2), WithDeleteUndecodable())
+	var schemaErr *utils.SchemaError
+	if err := v2.Get("new", &s); !errors.Is(err, ErrDecodeFailed) || !errors.As(err, &schemaErr) {
+		t.Errorf("expected a SchemaError, got %v", err)
+	}
+	if err := v2.Get("new", &s); err != ErrCacheMiss {
+		t.Errorf("expected the old value to be deleted, got %v", err)
+	}
+}
+
+// jsonCodec stands in for a codec being migrated to
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte                                  { return 201 }
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) { return json.Marshal(value) }
+func (jsonCodec) Unmarshal(b []byte, ptr interface{}) error { return json.Unmarshal(b, ptr) }
+
+func TestRedisStore_PerCallCodec(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour)
+	type profile struct{ Name string }
+	if err := store.SetWithCodec(jsonCodec{}, "json", profile{Name: "json"}, DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	store.Set("gob", profile{Name: "gob"}, DEFAULT)
+
+	var raw []byte
+	if err := store.Get("json", &raw); err != nil || string(raw) != `{"Name":"json"}` {
+		t.Errorf("expected JSON, got %q (%v)", raw, err)
+	}
+	var p profile
+	if err := store.GetWithCodec(jsonCodec{}, "json", &p); err != nil || p.Name != "json" {
+		t.Errorf("expected the JSON value, got %+v (%v)", p, err)
+	}
+	if err := store.Get("gob", &p); err != nil || p.Name != "gob" {
+		t.Errorf("expected the gob value, got %+v (%v)", p, err)
+	}
+	if err := store.Get("json", &p); !errors.Is(err, ErrDecodeFailed) {
+		t.Errorf("expected the JSON value not to decode as gob, got %v", err)
+	}
+}
+
+func TestRedisStore_RawStrings(t *testing.T) {
+	server := newRedisServer(t)
+	store := NewRedisCache(server, "", time.Hour, WithRawStrings())
+	store.Set("greeting", "hello", DEFAULT)
+	store.Set("struct", struct{ N int }{1}, DEFAULT)
+
+	conn := store.getConn()
+	raw, _ := redis.String(conn.Do("GET", "greeting"))
+	conn.Do("SET", "foreign", "written by another client")
+	conn.Close()
+	if raw != "hello" {
+		t.Errorf("expected the raw string, got %q", raw)
+	}
+	var s string
+	if err := store.Get("greeting", &s); err != nil || s != "hello" {
+		t.Errorf("expected hello, got %q (%v)", s, err)
+	}
+	if err := store.Get("foreign", &s); err != nil || s != "written by another client" {
+		t.Errorf("expected the foreign value, got %q (%v)", s, err)
+	}
+	var v struct{ N int }
+	if err := store.Get("struct", &v); err != nil || v.N != 1 {
+		t.Errorf("expected other values through the codec, got %+v (%v)", v, err)
+	}
+
+	// the default keeps serializing strings
+	legacy := NewRedisCache(server, "", time.Hour)
+	legacy.Set("greeting", "hello", DEFAULT)
+	if err := legacy.Get("greeting", &s); err != nil || s != "hello" {
+		t.Errorf("expected hello, got %q (%v)", s, err)
+	}
+	conn = legacy.getConn()
+	raw, _ = redis.String(conn.Do("GET", "greeting"))
+	conn.Close()
+	if raw == "hello" {
+		t.Error("expected the default to serialize strings")
+	}
+}
+
+func TestRedisStore_NativeIntegers(t *testing.T) {
+	server := newRedisServer(t)
+	store := NewRedisCache(server, "", time.Hour, WithNativeIntegers(), WithEnvelope(1))
+	n := 41
+	if err := store.Set("counter", &n, DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := store.IncrementAtomic("counter", 1); err != nil || v != 42 {
+		t.Errorf("expected 42, got %d (%v)", v, err)
+	}
+	var out int
+	if err := store.Get("counter", &out); err != nil || out != 42 {
+		t.Errorf("expected 42, got %d (%v)", out, err)
+	}
+	type count uint16
+	var c count
+	if err := store.Get("counter", &c); err != nil || c != 42 {
+		t.Errorf("expected 42, got %d (%v)", c, err)
+	}
+
+	// pointers to integers are gob-encoded by default, which INCRBY refuses
+	legacy := NewRedisCache(server, "", time.Hour)
+	legacy.Set("counter", &n, DEFAULT)
+	if _, err := legacy.IncrementAtomic("counter", 1); err == nil {
+		t.Error("expected INCRBY to fail on a gob-encoded integer")
+	}
+}
@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// storeConn is a pooled connection returning the errors that aren't redis error
+// replies as ConnErrors, and waiting at most timeout for each reply when set (see
+// WithOperationTimeout)
+type storeConn struct {
+	redis.Conn
+	timeout time.Duration
+}
+
+// connError wraps err in a ConnError unless it is nil or an error reply
+func connError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(redis.Error); ok {
+		return err
+	}
+	return &ConnError{Cause: err}
+}
+
+func (c storeConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	var reply interface{}
+	var err error
+	if c.timeout > 0 {
+		reply, err = redis.DoWithTimeout(c.Conn, c.timeout, commandName, args...)
+	} else {
+		reply, err = c.Conn.Do(commandName, args...)
+	}
+	return reply, connError(err)
+}
+
+func (c storeConn) Send(commandName string, args ...interface{}) error {
+	return connError(c.Conn.Send(commandName, args...))
+}
+
+func (c storeConn) Flush() error {
+	return connError(c.Conn.Flush())
+}
+
+func (c storeConn) Receive() (interface{}, error) {
+	var reply interface{}
+	var err error
+	if c.timeout > 0 {
+		reply, err = redis.ReceiveWithTimeout(c.Conn, c.timeout)
+	} else {
+		reply, err = c.Conn.Receive()
+	}
+	return reply, connError(err)
+}
+
+// getConn returns a connection from the pool
+func (c *RedisStore) getConn() redis.Conn {
+	return storeConn{Conn: c.pool.Get(), timeout: c.opTimeout}
+}
+
+// Conn returns a connection from the store's pool, with its operation timeout, for the
+// commands and scripts the store has no method for. The caller must close it.
+func (c *RedisStore) Conn() redis.Conn {
+	return c.getConn()
+}
+
+// PoolStats returns the number of connections of the store's pool, open and idle
+func (c *RedisStore) PoolStats() redis.PoolStats {
+	return c.pool.Stats()
+}
@@ -0,0 +1,118 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// KeepTTL makes CopyKey give the copy the remaining TTL of the source
+const KeepTTL = time.Duration(-2)
+
+// copyKeyScript copies KEYS pairwise (the key then its chunks if any, each followed by
+// its destination) with COPY, or DUMP/RESTORE on servers older than 6.2. ARGV[1] is
+// the manifest the key held when read ("" if it held a plain value), ARGV[2] the
+// manifest magic ("" when the store isn't chunked), ARGV[3] "1" to replace an existing
+// destination and ARGV[4] the TTL in milliseconds, -1 to keep the source's and 0 for
+// none. It returns 1 once copied, 0 if the source doesn't exist, -1 if it was
+// rewritten since it was read and -2 if the destination exists.
+var copyKeyScript = redis.NewScript(-1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if ARGV[2] ~= '' and redis.call('TYPE', KEYS[1]).ok == 'string' then
+	local v = redis.call('GET', KEYS[1])
+	if ARGV[1] ~= '' and v ~= ARGV[1] then
+		return -1
+	end
+	if ARGV[1] == '' and string.sub(v, 1, #ARGV[2]) == ARGV[2] then
+		return -1
+	end
+end
+if ARGV[3] ~= '1' and redis.call('EXISTS', KEYS[2]) == 1 then
+	return -2
+end
+local ttl = tonumber(ARGV[4])
+for i = 1, #KEYS, 2 do
+	local ok = pcall(redis.call, 'COPY', KEYS[i], KEYS[i+1], 'REPLACE')
+	if not ok then
+		local dump = redis.call('DUMP', KEYS[i])
+		local pttl = redis.call('PTTL', KEYS[i])
+		if pttl < 0 then
+			pttl = 0
+		end
+		redis.call('RESTORE', KEYS[i+1], pttl, dump, 'REPLACE')
+	end
+	if ttl == 0 then
+		redis.call('PERSIST', KEYS[i+1])
+	elseif ttl > 0 then
+		redis.call('PEXPIRE', KEYS[i+1], ttl)
+	end
+end
+return 1
+`)
+
+// CopyKey copies the entry at src to dst server side, whatever its type, without
+// deserializing it. The copy expires after ttl like a value Set with it, or with the
+// source for KeepTTL. It returns ErrCacheMiss if src doesn't exist, and ErrNotStored
+// if dst exists and replace is false.
+func (c *RedisStore) CopyKey(src, dst string, ttl time.Duration, replace bool) error {
+	if ttl == KeepTTL {
+		ttl = -time.Millisecond
+	} else {
+		ttl = c.expiration(dst, ttl)
+	}
+	replaceArg := "0"
+	if replace {
+		replaceArg = "1"
+	}
+
+	conn := c.getConn()
+	defer conn.Close()
+	for {
+		keys := []interface{}{src, dst}
+		var magic []byte
+		if c.chunkSize > 0 {
+			magic = chunkManifestMagic
+		}
+		manifest, m, err := c.storedManifest(conn, src)
+		if err != nil {
+			return err
+		}
+		srcChunks, dstChunks := m.keys(src), m.keys(dst)
+		for i := range srcChunks {
+			keys = append(keys, srcChunks[i], dstChunks[i])
+		}
+		args := append([]interface{}{len(keys)}, keys...)
+		args = append(args, manifest, magic, replaceArg, int64(ttl/time.Millisecond))
+		copied, err := redis.Int(copyKeyScript.Do(conn, args...))
+		if err != nil {
+			return err
+		}
+		switch copied {
+		case 0:
+			return ErrCacheMiss
+		case -2:
+			return ErrNotStored
+		case -1:
+			continue // src was rewritten meanwhile, copy its new value
+		}
+		return nil
+	}
+}
+
+// storedManifest returns the chunk manifest stored under key, and its encoding, or a
+// manifest of no chunks if key holds a plain value, isn't a string or doesn't exist
+func (c *RedisStore) storedManifest(conn redis.Conn, key string) ([]byte, chunkManifest, error) {
+	if c.chunkSize <= 0 {
+		return nil, chunkManifest{}, nil
+	}
+	stored, err := redis.Bytes(conn.Do("GET", key))
+	if _, wrongType := err.(redis.Error); err != nil && err != redis.ErrNil && !wrongType {
+		return nil, chunkManifest{}, err
+	}
+	if m, ok := decodeChunkManifest(stored); ok {
+		return stored, m, nil
+	}
+	return nil, chunkManifest{}, nil
+}
@@ -0,0 +1,63 @@
+package persistence
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_CopyKey(t *testing.T) {
+	server := newRedisServer(t)
+	for name, store := range map[string]*RedisStore{
+		"plain":   NewRedisCache(server, "", time.Hour),
+		"chunked": NewRedisCache(server, "", time.Hour, WithChunkSize(8)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			value := strings.Repeat("value ", 10)
+			if err := store.Set(name+":src", value, 10*time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.CopyKey(name+":src", name+":dst", KeepTTL, false); err != nil {
+				t.Fatal(err)
+			}
+			var s string
+			if err := store.Get(name+":dst", &s); err != nil || s != value {
+				t.Errorf("expected the copy, got %q (%v)", s, err)
+			}
+			if ttl, err := store.GetExpiresIn(name + ":dst"); err != nil || ttl <= 0 || ttl > 10*time.Second {
+				t.Errorf("expected the source's TTL, got %v (%v)", ttl, err)
+			}
+
+			store.Set(name+":src", "new value", DEFAULT)
+			if err := store.CopyKey(name+":src", name+":dst", time.Minute, false); err != ErrNotStored {
+				t.Errorf("expected ErrNotStored, got %v", err)
+			}
+			if err := store.CopyKey(name+":src", name+":dst", FOREVER, true); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Get(name+":dst", &s); err != nil || s != "new value" {
+				t.Errorf("expected the replaced copy, got %q (%v)", s, err)
+			}
+			if _, err := store.GetExpiresIn(name + ":dst"); err != ErrCacheNoTTL {
+				t.Errorf("expected no TTL, got %v", err)
+			}
+			if err := store.CopyKey(name+":missing", name+":dst", DEFAULT, true); err != ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss, got %v", err)
+			}
+
+			// entries other than strings are copied too
+			conn := store.getConn()
+			conn.Do("HSET", name+":hash", "f", "v")
+			conn.Close()
+			if err := store.CopyKey(name+":hash", name+":hashcopy", DEFAULT, false); err != nil {
+				t.Fatal(err)
+			}
+			var users []struct {
+				F string `redis:"f"`
+			}
+			if err := store.HGetAllMulti([]string{name + ":hashcopy"}, &users); err != nil || users[0].F != "v" {
+				t.Errorf("expected the hash copied, got %v (%v)", users, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package persistence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const defaultCounterFlushInterval = time.Second
+
+// Counter accumulates increments of counters locally and flushes them to redis in
+// aggregate with INCRBY, so hot counters cost one command per flush rather than one per
+// increment. See RedisStore.NewCounter.
+type Counter struct {
+	store     *RedisStore
+	threshold int
+	onError   func(err error)
+
+	mu      sync.Mutex
+	pending map[string]int64
+	// buffered is the number of increments since the last flush
+	buffered int
+	// flushing serializes flushes, so pending deltas are never sent twice at once
+	flushing sync.Mutex
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCounter returns a Counter flushing to the store every second, or at the interval
+// of WithCounterFlushInterval, and as soon as WithCounterFlushThreshold increments are
+// buffered if set. It must be closed to flush what is left and stop flushing.
+//
+// Counters are stored as plain integers, as Increment does. Increments buffered when
+// the process dies are lost, and those of a flush failing midway may be applied twice
+// when retried: counters are meant for metrics that tolerate it, such as hit counts.
+func (c *RedisStore) NewCounter(opt ...Option) *Counter {
+	opts := GetOpts(opt...)
+	interval := defaultCounterFlushInterval
+	if v, ok := opts[optionWithCounterFlushInterval].(time.Duration); ok && v > 0 {
+		interval = v
+	}
+	counter := &Counter{
+		store:   c,
+		pending: map[string]int64{},
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	counter.threshold, _ = opts[optionWithCounterFlushThreshold].(int)
+	counter.onError, _ = opts[optionWithCounterFlushError].(func(error))
+	go counter.run(interval)
+	return counter
+}
+
+// Incr adds delta to the counter key
+func (c *Counter) Incr(key string, delta int64) {
+	c.mu.Lock()
+	c.pending[key] += delta
+	c.buffered++
+	full := c.threshold > 0 && c.buffered >= c.threshold
+	c.mu.Unlock()
+	if full {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Value returns the value of the counter key: the value in redis plus the increments
+// not flushed yet
+func (c *Counter) Value(key string) (int64, error) {
+	conn := c.store.getConn()
+	defer conn.Close()
+	remote, err := redis.Int64(conn.Do("GET", key))
+	if err != nil && err != redis.ErrNil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return remote + c.pending[key], nil
+}
+
+// Flush sends the pending increments to redis. Those that fail are kept for the next
+// flush.
+func (c *Counter) Flush() error {
+	c.flushing.Lock()
+	defer c.flushing.Unlock()
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = map[string]int64{}
+	c.buffered = 0
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(pending))
+	conn := c.store.getConn()
+	defer conn.Close()
+	var err error
+	for key, delta := range pending {
+		if delta == 0 {
+			continue
+		}
+		if err = conn.Send("INCRBY", key, delta); err != nil {
+			break
+		}
+		keys = append(keys, key)
+	}
+	if err == nil {
+		err = conn.Flush()
+	}
+	sent := map[string]bool{}
+	if err == nil {
+		for _, key := range keys {
+			if _, e := conn.Receive(); e != nil {
+				err = e
+				if _, ok := e.(redis.Error); ok {
+					// the key holds something else than a counter: drop its increments
+					sent[key] = true
+					continue
+				}
+				break
+			}
+			sent[key] = true
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	for key, delta := range pending {
+		if !sent[key] {
+			c.pending[key] += delta
+		}
+	}
+	c.mu.Unlock()
+	return err
+}
+
+// Close stops flushing in the background and flushes the pending increments
+func (c *Counter) Close() error {
+	close(c.stop)
+	<-c.done
+	return c.Flush()
+}
+
+// run flushes every interval, or when woken up, until Close
+func (c *Counter) run(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		case <-c.wake:
+		}
+		if err := c.Flush(); err != nil && c.onError != nil {
+			c.onError(err)
+		}
+	}
+}
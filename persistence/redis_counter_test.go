@@ -0,0 +1,106 @@
+package persistence
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+)
+
+func TestCounter(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour, WithHealthCheck(-1))
+	counter := store.NewCounter(WithCounterFlushInterval(time.Hour))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				counter.Incr("hits", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	counter.Incr("misses", -2)
+	if server.Exists("hits") {
+		t.Error("expected the increments buffered")
+	}
+	if n, err := counter.Value("hits"); err != nil || n != 1000 {
+		t.Errorf("expected the pending increments counted, got %d (%v)", n, err)
+	}
+
+	before := server.CommandCount()
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.CommandCount() - before; n != 2 {
+		t.Errorf("expected one INCRBY per counter, got %d commands", n)
+	}
+	if v, _ := server.Get("hits"); v != "1000" {
+		t.Errorf("expected 1000 hits, got %q", v)
+	}
+	if v, _ := server.Get("misses"); v != "-2" {
+		t.Errorf("expected -2 misses, got %q", v)
+	}
+	counter.Incr("hits", 5)
+	if n, err := counter.Value("hits"); err != nil || n != 1005 {
+		t.Errorf("expected the remote and pending values summed, got %d (%v)", n, err)
+	}
+	if err := counter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := server.Get("hits"); v != "1005" {
+		t.Errorf("expected Close to flush, got %q", v)
+	}
+}
+
+func TestCounter_Threshold(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour)
+	counter := store.NewCounter(WithCounterFlushInterval(time.Hour), WithCounterFlushThreshold(10))
+	defer counter.Close()
+
+	for i := 0; i < 10; i++ {
+		counter.Incr("hits", 1)
+	}
+	deadline := time.Now().Add(time.Second)
+	for v, _ := server.Get("hits"); v != "10"; v, _ = server.Get("hits") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a flush at the threshold, got %q", v)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCounter_FlushError(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour)
+	counter := store.NewCounter(WithCounterFlushInterval(time.Hour))
+	defer counter.Close()
+
+	server.Set("name", "not a number")
+	counter.Incr("name", 1)
+	counter.Incr("hits", 1)
+	if err := counter.Flush(); err == nil {
+		t.Error("expected the error of the key that isn't a counter")
+	}
+	if v, _ := server.Get("hits"); v != "1" {
+		t.Errorf("expected the other counters flushed, got %q", v)
+	}
+
+	server.SetError("down")
+	counter.Incr("hits", 1)
+	if err := counter.Flush(); err == nil {
+		t.Error("expected the flush to fail")
+	}
+	server.SetError("")
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := server.Get("hits"); v != "2" {
+		t.Errorf("expected the failed increments retried, got %q", v)
+	}
+}
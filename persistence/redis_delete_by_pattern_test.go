@@ -0,0 +1,26 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func deleteByPattern(t *testing.T, newStore redisStoreFactory) {
+	store := newStore(t, time.Hour)
+	for _, k := range []string{"user:1:profile", "user:2:profile", "user:2:settings"} {
+		if err := store.Set(k, 1, DEFAULT); err != nil {
+			t.Errorf("Error setting %s: %s", k, err)
+		}
+	}
+	n, err := store.DeleteByPattern("user:*:profile")
+	if err != nil {
+		t.Fatalf("Error deleting by pattern: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 keys deleted, got %d", n)
+	}
+	var v int
+	if err := store.Get("user:2:settings", &v); err != nil {
+		t.Errorf("expected user:2:settings to be kept: %s", err)
+	}
+}
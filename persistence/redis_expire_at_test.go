@@ -0,0 +1,40 @@
+package persistence
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_ExpireAtTime(t *testing.T) {
+	server := newRedisServer(t)
+	for name, store := range map[string]*RedisStore{
+		"plain":   NewRedisCache(server, "", time.Hour),
+		"chunked": NewRedisCache(server, "", time.Hour, WithChunkSize(8)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			key := name + ":key"
+			store.Set(key, strings.Repeat("v", 40), FOREVER)
+			at := time.Now().Add(90*time.Second + 500*time.Millisecond)
+			if err := store.ExpireAtTime(key, at); err != nil {
+				t.Fatal(err)
+			}
+			// PTTL is rounded to milliseconds, so allow for a little drift both ways
+			ttl, err := store.GetExpiresIn(key)
+			if expected := time.Until(at); err != nil || ttl > expected+time.Second || ttl < expected-time.Second {
+				t.Errorf("expected about %v, got %v (%v)", expected, ttl, err)
+			}
+			if name == "chunked" {
+				if ttl, err := store.GetExpiresIn(chunkKey(key, 0)); err != nil || ttl <= 0 {
+					t.Errorf("expected the chunks to expire too, got %v (%v)", ttl, err)
+				}
+			}
+			if err := store.ExpireAtTime(name+":missing", at); err != ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss, got %v", err)
+			}
+			if err := store.ExpireAt(name+":missing", uint64(at.Unix())); err != ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss from ExpireAt, got %v", err)
+			}
+		})
+	}
+}
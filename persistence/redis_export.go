@@ -0,0 +1,135 @@
+package persistence
+
+import (
+	"encoding/gob"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// chunkKeyPattern matches the keys holding the chunks of a chunked value
+var chunkKeyPattern = regexp.MustCompile(`:chunk:\d+$`)
+
+// exportRecord is an entry of an Export stream
+type exportRecord struct {
+	Key   string
+	Value []byte
+	// TTL is the time left to live, or 0 when the key doesn't expire
+	TTL time.Duration
+}
+
+// Export writes every key matching the glob pattern, with its serialized value and
+// remaining TTL, to w for Import to restore later, e.g. to snapshot a namespace before
+// a risky migration. Keys not holding plain cache values (such as tag sets) are left
+// out, chunked values are written whole. Like DeleteByPattern it walks the keyspace
+// with SCAN, so keys written while it runs may be missed. It returns the number of
+// keys written.
+func (c *RedisStore) Export(pattern string, w io.Writer) (int, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	enc := gob.NewEncoder(w)
+	cursor := 0
+	count := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return count, err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return count, err
+		}
+		records, err := c.exportRecords(conn, keys)
+		if err != nil {
+			return count, err
+		}
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if cursor == 0 {
+			return count, nil
+		}
+	}
+}
+
+// exportRecords reads the values and TTLs of keys in a single round trip, skipping
+// the keys that are gone or hold something else than a cache value
+func (c *RedisStore) exportRecords(conn redis.Conn, keys []string) ([]exportRecord, error) {
+	if c.chunkSize > 0 {
+		var own []string
+		for _, key := range keys {
+			if !chunkKeyPattern.MatchString(key) {
+				own = append(own, key)
+			}
+		}
+		keys = own
+	}
+	for _, key := range keys {
+		conn.Send("GET", key)
+		conn.Send("PTTL", key)
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	records := make([]exportRecord, 0, len(keys))
+	for _, key := range keys {
+		b, getErr := redis.Bytes(conn.Receive())
+		ttl, err := redis.Int64(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		if getErr != nil {
+			if _, ok := getErr.(redis.Error); ok || getErr == redis.ErrNil {
+				continue
+			}
+			return nil, getErr
+		}
+		r := exportRecord{Key: key, Value: b}
+		if ttl > 0 {
+			r.TTL = time.Duration(ttl) * time.Millisecond
+		}
+		records = append(records, r)
+	}
+	for i := range records {
+		b, err := c.resolveChunks(conn, records[i].Key, records[i].Value)
+		if err != nil {
+			return nil, err
+		}
+		records[i].Value = b
+	}
+	return records, nil
+}
+
+// Import restores the keys written by Export, overwriting existing ones, with the
+// TTLs they had left when exported (rounded up to the second). It returns the number
+// of keys restored.
+func (c *RedisStore) Import(r io.Reader) (int, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	dec := gob.NewDecoder(r)
+	count := 0
+	for {
+		var rec exportRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		expires := (rec.TTL + time.Second - 1) / time.Second * time.Second
+		var err error
+		if c.chunkSize > 0 {
+			err = c.setChunked(conn.Do, rec.Key, rec.Value, expires)
+		} else {
+			err = c.set(conn.Do, rec.Key, rec.Value, expires)
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
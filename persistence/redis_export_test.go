@@ -0,0 +1,61 @@
+package persistence
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_ExportImport(t *testing.T) {
+	store := newRedisStore(t, time.Hour).(*RedisStore)
+	store.Set("user:1", "alice", time.Minute)
+	store.Set("user:2", 2, FOREVER)
+	store.Set("order:1", "other", DEFAULT)
+	store.SetWithTags("user:3", "carol", DEFAULT, "users")
+
+	var buf bytes.Buffer
+	n, err := store.Export("user:*", &buf)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 keys exported, got %d (%v)", n, err)
+	}
+	store.Flush()
+	if n, err := store.Import(&buf); err != nil || n != 3 {
+		t.Fatalf("expected 3 keys imported, got %d (%v)", n, err)
+	}
+
+	var s string
+	if err := store.Get("user:1", &s); err != nil || s != "alice" {
+		t.Errorf("expected alice, got %q (%v)", s, err)
+	}
+	if ttl, err := store.GetExpiresIn("user:1"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected user:1 to keep its TTL, got %d (%v)", ttl, err)
+	}
+	if _, err := store.GetExpiresIn("user:2"); err != ErrCacheNoTTL {
+		t.Errorf("expected user:2 not to expire, got %v", err)
+	}
+	if n, err := store.Increment("user:2", 1); err != nil || n != 3 {
+		t.Errorf("expected 3, got %d (%v)", n, err)
+	}
+	if err := store.Get("order:1", &s); err != ErrCacheMiss {
+		t.Errorf("expected order:1 not to be exported, got %v", err)
+	}
+}
+
+func TestRedisStore_ExportImportChunked(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour, WithChunkSize(16))
+	value := string(bytes.Repeat([]byte("x"), 100))
+	store.Set("big", value, DEFAULT)
+
+	var buf bytes.Buffer
+	if n, err := store.Export("big*", &buf); err != nil || n != 1 {
+		t.Fatalf("expected the chunked value exported as 1 key, got %d (%v)", n, err)
+	}
+	store.Flush()
+	if _, err := store.Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := store.Get("big", &s); err != nil || s != value {
+		t.Errorf("expected the chunked value back, got %d bytes (%v)", len(s), err)
+	}
+}
@@ -0,0 +1,62 @@
+package persistence
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// HGetAllMulti reads the hashes at keys into out, a pointer to a slice of structs or
+// of pointers to structs, with the HGETALLs pipelined in a single round trip. Fields
+// are matched as redis.ScanStruct does, by name or `redis:"field"` tag. out gets an
+// element per key, in key order; hashes that don't exist leave a zero struct, or a
+// nil pointer so they can be told apart.
+func (c *RedisStore) HGetAllMulti(keys []string, out interface{}) error {
+	slice := reflect.ValueOf(out)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("cache: HGetAllMulti needs a pointer to a slice, got %T: %w", out, ErrInvalidArgument)
+	}
+	slice = slice.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	structType := elemType
+	if isPtr {
+		structType = elemType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return fmt.Errorf("cache: HGetAllMulti needs a slice of structs, got %T: %w", out, ErrInvalidArgument)
+	}
+
+	conn := c.getConn()
+	defer conn.Close()
+	for _, key := range keys {
+		if err := conn.Send("HGETALL", key); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	result := reflect.MakeSlice(slice.Type(), len(keys), len(keys))
+	for i, key := range keys {
+		fields, err := redis.Values(conn.Receive())
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		v := reflect.New(structType)
+		if err := redis.ScanStruct(fields, v.Interface()); err != nil {
+			return &SerializationError{Key: key, Cause: err, Decoding: true}
+		}
+		if isPtr {
+			result.Index(i).Set(v)
+		} else {
+			result.Index(i).Set(v.Elem())
+		}
+	}
+	slice.Set(result)
+	return nil
+}
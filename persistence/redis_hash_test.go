@@ -0,0 +1,49 @@
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type hashUser struct {
+	Name  string `redis:"name"`
+	Age   int    `redis:"age"`
+	Admin bool   `redis:"admin"`
+}
+
+func TestRedisStore_HGetAllMulti(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour)
+	conn := store.getConn()
+	conn.Do("HSET", "user:1", "name", "ada", "age", 36, "admin", 1)
+	conn.Do("HSET", "user:2", "name", "alan", "age", 41)
+	conn.Close()
+
+	keys := []string{"user:1", "missing", "user:2"}
+	var users []hashUser
+	if err := store.HGetAllMulti(keys, &users); err != nil {
+		t.Fatal(err)
+	}
+	expected := []hashUser{{"ada", 36, true}, {}, {"alan", 41, false}}
+	if len(users) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, users)
+	}
+	for i := range expected {
+		if users[i] != expected[i] {
+			t.Errorf("expected %v at %d, got %v", expected[i], i, users[i])
+		}
+	}
+
+	var ptrs []*hashUser
+	if err := store.HGetAllMulti(keys, &ptrs); err != nil {
+		t.Fatal(err)
+	}
+	if ptrs[0] == nil || ptrs[0].Name != "ada" || ptrs[1] != nil || ptrs[2] == nil || ptrs[2].Age != 41 {
+		t.Errorf("expected a nil pointer for the missing hash only, got %v", ptrs)
+	}
+
+	var notStructs []string
+	if err := store.HGetAllMulti(keys, &notStructs); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected ErrInvalidArgument, got %v", err)
+	}
+}
@@ -1,7 +1,6 @@
 package persistence
 
 import (
-	"net"
 	"testing"
 	"time"
 )
@@ -9,20 +8,7 @@ import (
 type redisStoreFactory func(*testing.T, time.Duration) *RedisStore
 
 var newRawRedisStore = func(t *testing.T, defaultExpiration time.Duration) *RedisStore {
-	c, err := net.Dial("tcp", redisTestServer)
-	if err == nil {
-		_, err := c.Write([]byte("flush_all\r\n"))
-		if err != nil {
-			t.Fatalf("Unexpected error: %s", err.Error())
-		}
-		c.Close()
-		redisCache := NewRedisCache(redisTestServer, "", defaultExpiration)
-		redisCache.Flush()
-		return redisCache
-	}
-	t.Errorf("couldn't connect to redis on %s", redisTestServer)
-	t.FailNow()
-	panic("")
+	return NewRedisCache(newRedisServer(t), "", defaultExpiration)
 }
 
 // Test the increment-decrement cases
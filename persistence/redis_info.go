@@ -0,0 +1,134 @@
+package persistence
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ServerInfo is the parsed reply of the redis INFO command
+type ServerInfo struct {
+	Memory      MemoryInfo
+	Replication ReplicationInfo
+	// Keyspace holds the keyspace statistics by database number
+	Keyspace map[int]KeyspaceInfo
+	// Fields holds every field of the sections returned, by name, e.g. "redis_version"
+	Fields map[string]string
+}
+
+// MemoryInfo is the memory section of ServerInfo
+type MemoryInfo struct {
+	UsedMemory         int64
+	UsedMemoryPeak     int64
+	MaxMemory          int64
+	MaxMemoryPolicy    string
+	FragmentationRatio float64
+}
+
+// ReplicationInfo is the replication section of ServerInfo
+type ReplicationInfo struct {
+	// Role is "master" or "slave"
+	Role              string
+	ConnectedReplicas int
+	// MasterLinkUp reports whether a replica is connected to its master
+	MasterLinkUp      bool
+	ReplicationOffset int64
+}
+
+// KeyspaceInfo is the keyspace statistics of a database in ServerInfo
+type KeyspaceInfo struct {
+	Keys    int64
+	Expires int64
+	AvgTTL  time.Duration
+}
+
+// ServerInfo returns the parsed INFO of the redis server, limited to sections (e.g.
+// "memory", "keyspace", "replication") if any are given
+func (c *RedisStore) ServerInfo(sections ...string) (*ServerInfo, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	args := make([]interface{}, len(sections))
+	for i, s := range sections {
+		args[i] = s
+	}
+	reply, err := redis.String(conn.Do("INFO", args...))
+	if err != nil {
+		return nil, err
+	}
+	return parseServerInfo(reply), nil
+}
+
+// DBSize returns the number of keys in the database of the store
+func (c *RedisStore) DBSize() (int64, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	return redis.Int64(conn.Do("DBSIZE"))
+}
+
+// parseServerInfo parses the "field:value" lines of an INFO reply, fields it can't
+// parse being left to their zero value
+func parseServerInfo(reply string) *ServerInfo {
+	info := &ServerInfo{Keyspace: map[int]KeyspaceInfo{}, Fields: map[string]string{}}
+	scanner := bufio.NewScanner(strings.NewReader(reply))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		info.Fields[name] = value
+		if strings.HasPrefix(name, "db") {
+			if db, err := strconv.Atoi(name[2:]); err == nil {
+				info.Keyspace[db] = parseKeyspace(value)
+			}
+		}
+	}
+	f := info.Fields
+	info.Memory = MemoryInfo{
+		UsedMemory:         parseInt(f["used_memory"]),
+		UsedMemoryPeak:     parseInt(f["used_memory_peak"]),
+		MaxMemory:          parseInt(f["maxmemory"]),
+		MaxMemoryPolicy:    f["maxmemory_policy"],
+		FragmentationRatio: parseFloat(f["mem_fragmentation_ratio"]),
+	}
+	info.Replication = ReplicationInfo{
+		Role:              f["role"],
+		ConnectedReplicas: int(parseInt(f["connected_slaves"])),
+		MasterLinkUp:      f["master_link_status"] == "up",
+		ReplicationOffset: parseInt(f["master_repl_offset"]),
+	}
+	return info
+}
+
+// parseKeyspace parses the "keys=1,expires=0,avg_ttl=0" value of a keyspace field
+func parseKeyspace(value string) KeyspaceInfo {
+	var ks KeyspaceInfo
+	for _, kv := range strings.Split(value, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "keys":
+			ks.Keys = parseInt(v)
+		case "expires":
+			ks.Expires = parseInt(v)
+		case "avg_ttl":
+			ks.AvgTTL = time.Duration(parseInt(v)) * time.Millisecond
+		}
+	}
+	return ks
+}
+
+func parseInt(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
@@ -0,0 +1,39 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseServerInfo(t *testing.T) {
+	info := parseServerInfo("# Server\r\nredis_version:7.2.4\r\n\r\n" +
+		"# Memory\r\nused_memory:1048576\r\nused_memory_peak:2097152\r\nmaxmemory:0\r\nmaxmemory_policy:allkeys-lru\r\nmem_fragmentation_ratio:1.25\r\n\r\n" +
+		"# Replication\r\nrole:slave\r\nmaster_link_status:up\r\nconnected_slaves:0\r\nmaster_repl_offset:4242\r\n\r\n" +
+		"# Keyspace\r\ndb0:keys=10,expires=4,avg_ttl=60000\r\ndb3:keys=1,expires=0,avg_ttl=0\r\n")
+
+	if info.Fields["redis_version"] != "7.2.4" {
+		t.Errorf("expected the raw fields, got %v", info.Fields)
+	}
+	if expected := (MemoryInfo{1048576, 2097152, 0, "allkeys-lru", 1.25}); info.Memory != expected {
+		t.Errorf("expected %+v, got %+v", expected, info.Memory)
+	}
+	if expected := (ReplicationInfo{"slave", 0, true, 4242}); info.Replication != expected {
+		t.Errorf("expected %+v, got %+v", expected, info.Replication)
+	}
+	if len(info.Keyspace) != 2 || info.Keyspace[0] != (KeyspaceInfo{10, 4, time.Minute}) || info.Keyspace[3].Keys != 1 {
+		t.Errorf("unexpected keyspace %+v", info.Keyspace)
+	}
+}
+
+func TestRedisStore_ServerInfo(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour)
+	store.Set("a", 1, DEFAULT)
+	store.Set("b", 2, DEFAULT)
+	if n, err := store.DBSize(); err != nil || n != 2 {
+		t.Errorf("expected 2 keys, got %d (%v)", n, err)
+	}
+	// the embedded server only has a few sections
+	if info, err := store.ServerInfo("clients"); err != nil || info.Fields["connected_clients"] == "" {
+		t.Errorf("expected the clients section, got %+v (%v)", info, err)
+	}
+}
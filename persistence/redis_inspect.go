@@ -0,0 +1,69 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// KeyInfo is the metadata of a redis key returned by Inspect
+type KeyInfo struct {
+	Exists bool
+	// Type is the type of the entry as reported by TYPE, e.g. "string" or "hash"
+	Type string
+	// TTL is the time left before the key expires, or FOREVER if it doesn't expire
+	TTL time.Duration
+	// MemoryUsage is the number of bytes the key takes, not counting the chunks of
+	// chunked values (see MemoryUsage)
+	MemoryUsage int64
+	// Encoding is the internal encoding of the entry, e.g. "embstr" or "listpack"
+	Encoding string
+}
+
+// Inspect returns the metadata of key, fetched with EXISTS, TYPE, PTTL, MEMORY USAGE
+// and OBJECT ENCODING pipelined in a single round trip. Fields the server can't
+// report, e.g. as it doesn't support the command, are left empty. A key that doesn't
+// exist isn't an error: Exists reports it.
+func (c *RedisStore) Inspect(key string) (*KeyInfo, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	commands := [][]interface{}{
+		{"EXISTS", key},
+		{"TYPE", key},
+		{"PTTL", key},
+		{"MEMORY", "USAGE", key},
+		{"OBJECT", "ENCODING", key},
+	}
+	for _, cmd := range commands {
+		if err := conn.Send(cmd[0].(string), cmd[1:]...); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(commands))
+	for i := range commands {
+		reply, err := conn.Receive()
+		if _, isReply := err.(redis.Error); err != nil && !isReply {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+
+	info := &KeyInfo{}
+	info.Exists, _ = redis.Bool(replies[0], nil)
+	if !info.Exists {
+		return info, nil
+	}
+	info.Type, _ = redis.String(replies[1], nil)
+	if ms, err := redis.Int64(replies[2], nil); err == nil {
+		info.TTL = FOREVER
+		if ms >= 0 {
+			info.TTL = time.Duration(ms) * time.Millisecond
+		}
+	}
+	info.MemoryUsage, _ = redis.Int64(replies[3], nil)
+	info.Encoding, _ = redis.String(replies[4], nil)
+	return info, nil
+}
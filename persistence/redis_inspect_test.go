@@ -0,0 +1,28 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRedisStore_Inspect(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour)
+	store.Set("value", "v", 10*time.Second)
+	conn := store.getConn()
+	conn.Do("HSET", "hash", "f", "v")
+	conn.Close()
+
+	info, err := store.Inspect("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Exists || info.Type != "string" || info.TTL <= 0 || info.TTL > 10*time.Second || info.MemoryUsage <= 0 {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if info, err := store.Inspect("hash"); err != nil || info.Type != "hash" || info.TTL != FOREVER {
+		t.Errorf("expected a hash without TTL, got %+v (%v)", info, err)
+	}
+	if info, err := store.Inspect("missing"); err != nil || info.Exists || *info != (KeyInfo{}) {
+		t.Errorf("expected a missing key, got %+v (%v)", info, err)
+	}
+}
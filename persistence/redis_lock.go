@@ -0,0 +1,126 @@
+package persistence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// ErrLockNotAcquired is returned when a lock is held by someone else
+	ErrLockNotAcquired = errors.New("cache: lock not acquired.")
+	// ErrLockNotHeld is returned when releasing or extending a lock that expired or
+	// was taken over since it was acquired
+	ErrLockNotHeld = errors.New("cache: lock not held.")
+)
+
+// unlockScript deletes KEYS[1] provided it holds the token ARGV[1]
+var unlockScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// extendLockScript sets the TTL of KEYS[1] to ARGV[2] milliseconds provided it holds
+// the token ARGV[1]
+var extendLockScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Lock is a lock held on a single redis node, see RedisStore.Lock
+type Lock struct {
+	store *RedisStore
+	key   string
+	token string
+}
+
+// newLockToken returns a random token identifying the holder of a lock
+func newLockToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Lock acquires the lock named key for ttl, returning ErrLockNotAcquired if it is held
+// by someone else. The lock is an entry of the store holding a random token, so it can
+// only be released or extended by its holder, and expires after ttl if it's not.
+//
+// The lock is only as safe as the node holding it: see Redlock to lock across several
+// independent nodes.
+func (c *RedisStore) Lock(key string, ttl time.Duration) (*Lock, error) {
+	token := newLockToken()
+	if err := c.acquireLock(key, token, ttl); err != nil {
+		return nil, err
+	}
+	return &Lock{store: c, key: key, token: token}, nil
+}
+
+// Key returns the name of the lock
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Unlock releases the lock, returning ErrLockNotHeld if it expired or was taken over
+func (l *Lock) Unlock() error {
+	return l.store.releaseLock(l.key, l.token)
+}
+
+// Extend sets the TTL of the lock to ttl, returning ErrLockNotHeld if it expired or was
+// taken over
+func (l *Lock) Extend(ttl time.Duration) error {
+	return l.store.extendLock(l.key, l.token, ttl)
+}
+
+// acquireLock sets key to token for ttl unless it exists
+func (c *RedisStore) acquireLock(key, token string, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidArgument
+	}
+	conn := c.getConn()
+	defer conn.Close()
+	_, err := redis.String(conn.Do("SET", key, token, "NX", "PX", int64(ttl/time.Millisecond)))
+	if err == redis.ErrNil {
+		return ErrLockNotAcquired
+	}
+	return err
+}
+
+// releaseLock deletes key if it holds token
+func (c *RedisStore) releaseLock(key, token string) error {
+	conn := c.getConn()
+	defer conn.Close()
+	n, err := redis.Int(unlockScript.Do(conn, key, token))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// extendLock sets the TTL of key to ttl if it holds token
+func (c *RedisStore) extendLock(key, token string, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidArgument
+	}
+	conn := c.getConn()
+	defer conn.Close()
+	n, err := redis.Int(extendLockScript.Do(conn, key, token, int64(ttl/time.Millisecond)))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
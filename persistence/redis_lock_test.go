@@ -0,0 +1,49 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+)
+
+func TestRedisStore_Lock(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour)
+
+	lock, err := store.Lock("lock", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lock("lock", time.Second); err != ErrLockNotAcquired {
+		t.Errorf("expected ErrLockNotAcquired, got %v", err)
+	}
+	if err := lock.Extend(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("lock"); ttl != time.Minute {
+		t.Errorf("expected the lock extended, got a TTL of %s", ttl)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+
+	// an expired lock can't be released by its former holder once taken over
+	lock, _ = store.Lock("lock", time.Second)
+	server.FastForward(time.Second)
+	if _, err := store.Lock("lock", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if err := lock.Extend(time.Second); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if !server.Exists("lock") {
+		t.Error("expected the new holder's lock kept")
+	}
+}
@@ -0,0 +1,38 @@
+package persistence
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+// MemoryUsage returns the number of bytes the entry at key takes in redis, as reported
+// by MEMORY USAGE, its chunks included for chunked values. It returns ErrCacheMiss if
+// the key doesn't exist.
+func (c *RedisStore) MemoryUsage(key string) (int64, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	_, m, err := c.storedManifest(conn, key)
+	if err != nil {
+		return 0, err
+	}
+	keys := append([]interface{}{key}, m.keys(key)...)
+	for _, k := range keys {
+		if err := conn.Send("MEMORY", "USAGE", k); err != nil {
+			return 0, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return 0, err
+	}
+	var total int64
+	for i := range keys {
+		n, err := redis.Int64(conn.Receive())
+		if err == redis.ErrNil && i == 0 {
+			return 0, ErrCacheMiss
+		}
+		if err != nil && err != redis.ErrNil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
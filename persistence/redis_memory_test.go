@@ -0,0 +1,30 @@
+package persistence
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_MemoryUsage(t *testing.T) {
+	server := newRedisServer(t)
+	for name, store := range map[string]*RedisStore{
+		"plain":   NewRedisCache(server, "", time.Hour),
+		"chunked": NewRedisCache(server, "", time.Hour, WithChunkSize(64)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			store.Set(name+":small", "v", DEFAULT)
+			store.Set(name+":large", strings.Repeat("v", 1000), DEFAULT)
+			small, err := store.MemoryUsage(name + ":small")
+			if err != nil || small <= 0 {
+				t.Fatalf("expected the size of the small value, got %d (%v)", small, err)
+			}
+			if large, err := store.MemoryUsage(name + ":large"); err != nil || large < 1000 {
+				t.Errorf("expected at least 1000 bytes for the large value, got %d (%v)", large, err)
+			}
+			if _, err := store.MemoryUsage(name + ":missing"); err != ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss, got %v", err)
+			}
+		})
+	}
+}
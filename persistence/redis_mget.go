@@ -0,0 +1,231 @@
+package persistence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+// defaultMgetBatchSize is the number of keys Mget asks for per MGET by default
+const defaultMgetBatchSize = 1000
+
+// mgetBatching is how Mget splits long key lists, see WithMgetBatchSize
+type mgetBatching struct {
+	size        int
+	concurrency int
+}
+
+func newMgetBatching(opts Options) mgetBatching {
+	b := mgetBatching{size: defaultMgetBatchSize, concurrency: 1}
+	if v, ok := opts[optionWithMgetBatchSize].(int); ok && v >= 0 {
+		b.size = v
+	}
+	if v, ok := opts[optionWithMgetConcurrency].(int); ok && v > 0 {
+		b.concurrency = v
+	}
+	return b
+}
+
+// mgetRaw returns the stored values of keys, with one MGET per batch of keys
+// pipelined on conn, or spread over connections when batches run concurrently
+func (c *RedisStore) mgetRaw(conn redis.Conn, keys []string) ([]interface{}, error) {
+	size := c.mgetBatching.size
+	if size <= 0 || len(keys) <= size {
+		return redis.Values(conn.Do("MGET", keyArgs(keys)...))
+	}
+	var batches [][]string
+	for start := 0; start < len(keys); start += size {
+		end := start + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batches = append(batches, keys[start:end])
+	}
+
+	workers := c.mgetBatching.concurrency
+	if workers > len(batches) {
+		workers = len(batches)
+	}
+	if workers == 1 {
+		return mgetPipelined(conn, batches)
+	}
+	// worker w fetches batches w, w+workers, w+2*workers...
+	parts := make([][]interface{}, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		var share [][]string
+		for i := w; i < len(batches); i += workers {
+			share = append(share, batches[i])
+		}
+		wg.Add(1)
+		go func(w int, share [][]string) {
+			defer wg.Done()
+			wconn := conn
+			if w > 0 {
+				wconn = c.getConn()
+				defer wconn.Close()
+			}
+			parts[w], errs[w] = mgetPipelined(wconn, share)
+		}(w, share)
+	}
+	wg.Wait()
+	values := make([]interface{}, 0, len(keys))
+	offsets := make([]int, workers)
+	for i, batch := range batches {
+		w := i % workers
+		if errs[w] != nil {
+			return nil, errs[w]
+		}
+		values = append(values, parts[w][offsets[w]:offsets[w]+len(batch)]...)
+		offsets[w] += len(batch)
+	}
+	return values, nil
+}
+
+// mgetPipelined sends an MGET per batch on conn before reading the replies
+func mgetPipelined(conn redis.Conn, batches [][]string) ([]interface{}, error) {
+	for _, batch := range batches {
+		if err := conn.Send("MGET", keyArgs(batch)...); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for range batches {
+		reply, err := redis.Values(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, reply...)
+	}
+	return values, nil
+}
+
+// MGetOrLoad reads keys into ptrValues like Mget, with a single call of loader for
+// the keys that are missing (or can't be decoded), whose values are set into
+// ptrValues and cached for ttl, all writes pipelined in one round trip. It returns
+// the keys left without a value, in order: those the loader has no value for, which
+// are loaded again next time, and those cached or loaded as nil, which aren't. ptrs
+// of such keys are left as is.
+func (c *RedisStore) MGetOrLoad(ptrValues []interface{}, keys []string, ttl time.Duration, loader func(missing []string) (map[string]interface{}, error)) ([]string, error) {
+	if len(ptrValues) != len(keys) {
+		return nil, &ValueCountError{Got: len(ptrValues), Required: len(keys)}
+	}
+	conn := c.getConn()
+	defer conn.Close()
+	raw, err := c.mgetRaw(conn, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	absent := map[string]bool{}
+	var missing []string
+	indexes := map[string][]int{}
+	for i, r := range raw {
+		key := keys[i]
+		stored, err := redis.Bytes(r, nil)
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		if err == nil {
+			item, err := c.resolveChunks(conn, key, stored)
+			if err != nil && err != ErrCacheMiss {
+				return nil, err
+			}
+			if err == nil {
+				err = c.unmarshal(item, ptrValues[i])
+				if err == nil {
+					continue
+				}
+				if err == ErrNilValue {
+					absent[key] = true
+					continue
+				}
+				c.quarantine(conn, key, stored, item)
+			}
+		}
+		if _, ok := indexes[key]; !ok {
+			missing = append(missing, key)
+		}
+		indexes[key] = append(indexes[key], i)
+	}
+
+	if len(missing) > 0 {
+		loaded, err := loader(missing)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.backfill(conn, missing, loaded, ttl); err != nil {
+			return nil, err
+		}
+		for _, key := range missing {
+			v, ok := loaded[key]
+			if !ok || utils.IsNil(v) {
+				absent[key] = true
+				continue
+			}
+			for _, i := range indexes[key] {
+				if err := assign(ptrValues[i], v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var left []string
+	for _, key := range keys {
+		if absent[key] {
+			left = append(left, key)
+			delete(absent, key)
+		}
+	}
+	return left, nil
+}
+
+// backfill caches the values loaded for the missing keys that have one, pipelined
+// unless values may be chunked, which takes reading replies along the way
+func (c *RedisStore) backfill(conn redis.Conn, missing []string, loaded map[string]interface{}, ttl time.Duration) error {
+	f := conn.Do
+	if c.chunkSize <= 0 {
+		f = func(commandName string, args ...interface{}) (interface{}, error) {
+			return nil, conn.Send(commandName, args...)
+		}
+	}
+	sent := 0
+	for _, key := range missing {
+		v, ok := loaded[key]
+		if !ok {
+			continue
+		}
+		if err := c.invoke(f, key, v, ttl); err != nil {
+			return err
+		}
+		sent++
+	}
+	if c.chunkSize > 0 || sent == 0 {
+		return nil
+	}
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func keyArgs(keys []string) []interface{} {
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+	return args
+}
@@ -0,0 +1,96 @@
+package persistence
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_MgetBatches(t *testing.T) {
+	server := newRedisServer(t)
+	const n = 2500
+	keys := make([]string, n)
+	seed := NewRedisCache(server, "", time.Hour)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		if err := seed.Set(keys[i], i, DEFAULT); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, store := range map[string]*RedisStore{
+		"single":     NewRedisCache(server, "", time.Hour, WithMgetBatchSize(0)),
+		"pipelined":  NewRedisCache(server, "", time.Hour, WithMgetBatchSize(300)),
+		"concurrent": NewRedisCache(server, "", time.Hour, WithMgetBatchSize(300), WithMgetConcurrency(4)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			values := make([]int, n)
+			ptrs := make([]interface{}, n)
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := store.Mget(ptrs, keys...); err != nil {
+				t.Fatal(err)
+			}
+			for i, v := range values {
+				if v != i {
+					t.Fatalf("expected %d at %d, got %d", i, i, v)
+				}
+			}
+
+			// a missing key still fails the whole Mget
+			seed.Delete(keys[n-1])
+			defer seed.Set(keys[n-1], n-1, DEFAULT)
+			if err := store.Mget(ptrs, keys...); err == nil {
+				t.Error("expected an error for the missing key")
+			}
+		})
+	}
+}
+
+func TestRedisStore_MGetOrLoad(t *testing.T) {
+	server := newRedisServer(t)
+	for name, store := range map[string]*RedisStore{
+		"plain":   NewRedisCache(server, "", time.Hour),
+		"chunked": NewRedisCache(server, "", time.Hour, WithChunkSize(4)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			prefix := name + ":"
+			store.Set(prefix+"a", "cached a", DEFAULT)
+			store.Set(prefix+"nil", nil, DEFAULT)
+			keys := []string{prefix + "a", prefix + "b", prefix + "nil", prefix + "c", prefix + "b", prefix + "d"}
+
+			var calls [][]string
+			loader := func(missing []string) (map[string]interface{}, error) {
+				calls = append(calls, missing)
+				return map[string]interface{}{
+					prefix + "b": "loaded b",
+					prefix + "c": nil,
+				}, nil
+			}
+			for i := 0; i < 2; i++ {
+				values := make([]string, len(keys))
+				ptrs := make([]interface{}, len(keys))
+				for j := range values {
+					ptrs[j] = &values[j]
+				}
+				left, err := store.MGetOrLoad(ptrs, keys, time.Minute, loader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprint(values) != "[cached a loaded b   loaded b ]" {
+					t.Errorf("unexpected values %q", values)
+				}
+				if fmt.Sprint(left) != fmt.Sprintf("[%[1]snil %[1]sc %[1]sd]", prefix) {
+					t.Errorf("unexpected keys left %q", left)
+				}
+			}
+			// the second call only loads the key the loader had nothing for
+			if fmt.Sprint(calls) != fmt.Sprintf("[[%[1]sb %[1]sc %[1]sd] [%[1]sd]]", prefix) {
+				t.Errorf("unexpected loads %q", calls)
+			}
+			if ttl, err := store.GetExpiresIn(prefix + "b"); err != nil || ttl != time.Minute {
+				t.Errorf("expected the loaded value cached for a minute, got %s (%v)", ttl, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// quarantineTTL is the longest a quarantined value is kept
+const quarantineTTL = 24 * time.Hour
+
+// deleteIfUnchangedScript deletes KEYS (the key, then its chunks if any) provided the
+// key still holds ARGV[1], so a value written since it was read is never lost
+var deleteIfUnchangedScript = redis.NewScript(-1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', unpack(KEYS))
+end
+return 0
+`)
+
+// decodeQuarantine is what the redis store does with the values it can't deserialize
+// (see WithDeleteUndecodable and WithDecodeQuarantine)
+type decodeQuarantine struct {
+	enabled bool
+	prefix  string
+}
+
+func newDecodeQuarantine(opts Options) decodeQuarantine {
+	var q decodeQuarantine
+	if v, ok := opts[optionWithDeleteUndecodable].(bool); ok && v {
+		q.enabled = true
+	}
+	if v, ok := opts[optionWithDecodeQuarantine].(string); ok && v != "" {
+		q.enabled, q.prefix = true, v
+	}
+	return q
+}
+
+// quarantine removes the value of key that failed to deserialize, stored being what
+// is stored under key (a manifest for chunked values) and value the value itself. It
+// is best effort: the read fails with the decoding error anyway.
+func (c *RedisStore) quarantine(conn redis.Conn, key string, stored, value []byte) {
+	if !c.decodeQuarantine.enabled {
+		return
+	}
+	if c.decodeQuarantine.prefix != "" {
+		ttl := quarantineTTL
+		if ms, err := redis.Int64(conn.Do("PTTL", key)); err == nil && ms > 0 && time.Duration(ms)*time.Millisecond < ttl {
+			ttl = time.Duration(ms) * time.Millisecond
+		}
+		conn.Do("SET", c.decodeQuarantine.prefix+key, value, "PX", int64(ttl/time.Millisecond))
+	}
+	keys := []interface{}{key}
+	if m, ok := decodeChunkManifest(stored); ok && c.chunkSize > 0 {
+		keys = append(keys, m.keys(key)...)
+	}
+	args := append([]interface{}{len(keys)}, keys...)
+	deleteIfUnchangedScript.Do(conn, append(args, stored)...)
+}
@@ -0,0 +1,78 @@
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_DecodeQuarantine(t *testing.T) {
+	server := newRedisServer(t)
+	var n int
+	var s string
+
+	store := NewRedisCache(server, "", time.Hour)
+	store.Set("key", "not a number", DEFAULT)
+	if err := store.Get("key", &n); !errors.Is(err, ErrDecodeFailed) {
+		t.Fatalf("expected ErrDecodeFailed, got %v", err)
+	}
+	if err := store.Get("key", &s); err != nil {
+		t.Errorf("expected the key to be kept by default, got %v", err)
+	}
+
+	deleting := NewRedisCache(server, "", time.Hour, WithDeleteUndecodable())
+	if err := deleting.Get("key", &n); !errors.Is(err, ErrDecodeFailed) {
+		t.Fatalf("expected ErrDecodeFailed, got %v", err)
+	}
+	if err := deleting.Get("key", &n); err != ErrCacheMiss {
+		t.Errorf("expected the key to be deleted, got %v", err)
+	}
+
+	quarantining := NewRedisCache(server, "", time.Hour, WithDecodeQuarantine("quarantine:"))
+	quarantining.Set("a", "not a number", time.Minute)
+	quarantining.Set("b", 2, DEFAULT)
+	var a, b int
+	if err := quarantining.Mget([]interface{}{&a, &b}, "a", "b"); !errors.Is(err, ErrDecodeFailed) {
+		t.Fatalf("expected ErrDecodeFailed, got %v", err)
+	}
+	if err := quarantining.Get("a", &s); err != ErrCacheMiss {
+		t.Errorf("expected a to be removed, got %v", err)
+	}
+	if err := quarantining.Get("quarantine:a", &s); err != nil || s != "not a number" {
+		t.Errorf("expected a to be quarantined, got %q (%v)", s, err)
+	}
+	if ttl, err := quarantining.GetExpiresIn("quarantine:a"); err != nil || ttl > time.Minute {
+		t.Errorf("expected the quarantined copy to keep the TTL left, got %d (%v)", ttl, err)
+	}
+	if err := quarantining.Get("b", &b); err != nil || b != 2 {
+		t.Errorf("expected b to be kept, got %d (%v)", b, err)
+	}
+}
+
+func TestRedisStore_DecodeQuarantineChunked(t *testing.T) {
+	server := newRedisServer(t)
+	store := NewRedisCache(server, "", time.Hour, WithChunkSize(16), WithDecodeQuarantine("quarantine:"))
+	value := string(bytes.Repeat([]byte("x"), 100))
+	store.Set("big", value, DEFAULT)
+	var n int
+	if err := store.Get("big", &n); !errors.Is(err, ErrDecodeFailed) {
+		t.Fatalf("expected ErrDecodeFailed, got %v", err)
+	}
+	var s string
+	if err := store.Get("quarantine:big", &s); err != nil || s != value {
+		t.Errorf("expected the whole value quarantined, got %d bytes (%v)", len(s), err)
+	}
+	if ttl, err := store.GetExpiresIn("quarantine:big"); err != nil || ttl > quarantineTTL {
+		t.Errorf("expected the quarantined copy to expire within a day, got %d (%v)", ttl, err)
+	}
+	// a store without chunking lists the chunk keys too
+	var left []string
+	NewRedisCache(server, "", time.Hour).ScanKeys("big*", func(key string) bool {
+		left = append(left, key)
+		return true
+	})
+	if len(left) != 0 {
+		t.Errorf("expected the value and its chunks removed, got %v", left)
+	}
+}
@@ -0,0 +1,170 @@
+package persistence
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// redlockRetries is the retry policy set by WithRedlockRetries
+type redlockRetries struct {
+	count int
+	delay time.Duration
+}
+
+// redlockClockPrecision is added to the drift allowed for clocks of a millisecond
+// precision, as in the reference algorithm
+const redlockClockPrecision = 2 * time.Millisecond
+
+// Redlock locks across several independent redis nodes with the Redlock algorithm:
+// a lock is held once acquired on a majority of the nodes within its TTL, so it
+// survives the failure of a minority of them. Each node is locked with the single
+// node primitive of RedisStore.Lock.
+type Redlock struct {
+	nodes       []*RedisStore
+	retries     redlockRetries
+	driftFactor float64
+}
+
+// NewRedlock returns a Redlock locking on nodes, which should be independent masters
+// rather than replicas of each other, e.g. stores made with NewRedisCache for each.
+func NewRedlock(nodes []*RedisStore, opt ...Option) *Redlock {
+	opts := GetOpts(opt...)
+	r := &Redlock{
+		nodes:       nodes,
+		retries:     redlockRetries{count: 3, delay: 200 * time.Millisecond},
+		driftFactor: 0.01,
+	}
+	if v, ok := opts[optionWithRedlockRetries].(redlockRetries); ok && v.count >= 0 {
+		r.retries = v
+	}
+	if v, ok := opts[optionWithRedlockDriftFactor].(float64); ok && v >= 0 {
+		r.driftFactor = v
+	}
+	return r
+}
+
+// RedlockLock is a lock held by a Redlock
+type RedlockLock struct {
+	redlock *Redlock
+	key     string
+	token   string
+	until   time.Time
+}
+
+// quorum returns the number of nodes a lock must be held on
+func (r *Redlock) quorum() int {
+	return len(r.nodes)/2 + 1
+}
+
+// drift returns the clock drift allowed for a lock of ttl
+func (r *Redlock) drift(ttl time.Duration) time.Duration {
+	return time.Duration(float64(ttl)*r.driftFactor) + redlockClockPrecision
+}
+
+// each calls f on every node concurrently, returning the number of calls that
+// succeeded and an error among the failures, the last one other than notHeld if any
+func (r *Redlock) each(notHeld error, f func(node *RedisStore) error) (int, error) {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		n   int
+		err error
+	)
+	for _, node := range r.nodes {
+		wg.Add(1)
+		go func(node *RedisStore) {
+			defer wg.Done()
+			e := f(node)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case e == nil:
+				n++
+			case err == nil || e != notHeld:
+				err = e
+			}
+		}(node)
+	}
+	wg.Wait()
+	return n, err
+}
+
+// Lock acquires the lock named key on a majority of the nodes for ttl, retrying as set
+// with WithRedlockRetries while it can't. It returns ErrLockNotAcquired if someone else
+// holds the lock, or the error of the nodes that failed if too many did. The lock is
+// valid until the time its Until method returns: ttl from the start of the last
+// attempt, less the time the attempt took and the allowed clock drift.
+func (r *Redlock) Lock(key string, ttl time.Duration) (*RedlockLock, error) {
+	if ttl < time.Millisecond || len(r.nodes) == 0 {
+		return nil, ErrInvalidArgument
+	}
+	token := newLockToken()
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		n, err := r.each(ErrLockNotAcquired, func(node *RedisStore) error {
+			return node.acquireLock(key, token, ttl)
+		})
+		until := start.Add(ttl - r.drift(ttl))
+		if n >= r.quorum() && time.Now().Before(until) {
+			return &RedlockLock{redlock: r, key: key, token: token, until: until}, nil
+		}
+		// release the minority acquired, or the majority acquired too late
+		r.each(ErrLockNotHeld, func(node *RedisStore) error {
+			return node.releaseLock(key, token)
+		})
+		if attempt >= r.retries.count {
+			if err == nil {
+				err = ErrLockNotAcquired
+			}
+			return nil, err
+		}
+		if r.retries.delay > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(r.retries.delay))))
+		}
+	}
+}
+
+// Key returns the name of the lock
+func (l *RedlockLock) Key() string {
+	return l.key
+}
+
+// Until returns the time until which the lock is known to be held
+func (l *RedlockLock) Until() time.Time {
+	return l.until
+}
+
+// Unlock releases the lock on every node. It returns ErrLockNotHeld if it was held on
+// less than a majority of them, as it expired or was taken over.
+func (l *RedlockLock) Unlock() error {
+	n, err := l.redlock.each(ErrLockNotHeld, func(node *RedisStore) error {
+		return node.releaseLock(l.key, l.token)
+	})
+	if n >= l.redlock.quorum() {
+		return nil
+	}
+	return err
+}
+
+// Extend sets the TTL of the lock to ttl on every node holding it, and moves its
+// validity accordingly. It returns ErrLockNotHeld if the lock couldn't be extended on
+// a majority of the nodes in time, in which case it should be considered lost.
+func (l *RedlockLock) Extend(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidArgument
+	}
+	start := time.Now()
+	n, err := l.redlock.each(ErrLockNotHeld, func(node *RedisStore) error {
+		return node.extendLock(l.key, l.token, ttl)
+	})
+	until := start.Add(ttl - l.redlock.drift(ttl))
+	if n >= l.redlock.quorum() && time.Now().Before(until) {
+		l.until = until
+		return nil
+	}
+	if err == nil {
+		err = ErrLockNotHeld
+	}
+	return err
+}
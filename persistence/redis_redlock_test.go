@@ -0,0 +1,82 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newRedlockNodes(t *testing.T, n int) ([]*miniredis.Miniredis, []*RedisStore) {
+	var servers []*miniredis.Miniredis
+	var nodes []*RedisStore
+	for i := 0; i < n; i++ {
+		server := miniredistest.Run(t)
+		servers = append(servers, server)
+		nodes = append(nodes, NewRedisCache(server.Addr(), "", time.Hour, WithOperationTimeout(100*time.Millisecond)))
+	}
+	return servers, nodes
+}
+
+func TestRedlock(t *testing.T) {
+	servers, nodes := newRedlockNodes(t, 3)
+	redlock := NewRedlock(nodes, WithRedlockRetries(0, 0))
+
+	lock, err := redlock.Lock("lock", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(lock.Until()); d <= 0 || d > time.Second-redlockClockPrecision {
+		t.Errorf("expected the validity to account for drift, got %s", d)
+	}
+	if _, err := redlock.Lock("lock", time.Second); err != ErrLockNotAcquired {
+		t.Errorf("expected ErrLockNotAcquired, got %v", err)
+	}
+	if err := lock.Extend(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	for i, server := range servers {
+		if server.Exists("lock") {
+			t.Errorf("expected the lock released on node %d", i)
+		}
+	}
+
+	// a minority of the nodes held by someone else doesn't keep the lock from being
+	// acquired, a majority does and the minority acquired is released
+	nodes[0].Lock("lock", time.Minute)
+	lock, err = redlock.Lock("lock", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Unlock()
+	nodes[1].Lock("lock", time.Minute)
+	if _, err := redlock.Lock("lock", time.Second); err != ErrLockNotAcquired {
+		t.Errorf("expected ErrLockNotAcquired, got %v", err)
+	}
+	if servers[2].Exists("lock") {
+		t.Error("expected the minority acquired released")
+	}
+}
+
+func TestRedlock_NodeDown(t *testing.T) {
+	servers, nodes := newRedlockNodes(t, 3)
+	redlock := NewRedlock(nodes, WithRedlockRetries(1, 10*time.Millisecond))
+
+	servers[0].Close()
+	lock, err := redlock.Lock("lock", time.Second)
+	if err != nil {
+		t.Fatalf("expected the lock acquired on the majority up, got %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	servers[1].Close()
+	if _, err := redlock.Lock("lock", time.Second); err == nil || err == ErrLockNotAcquired {
+		t.Errorf("expected the error of the nodes down, got %v", err)
+	}
+}
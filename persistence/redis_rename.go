@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+// renameScript renames the first ARGV[5] KEYS pairwise (the key then its chunks if
+// any, each followed by its new name) and deletes the remaining KEYS, the chunks of
+// the destination's value that aren't overwritten. ARGV[1] and ARGV[4] are the
+// manifests the source and destination held when read ("" for plain values), ARGV[2]
+// the manifest magic ("" when the store isn't chunked) and ARGV[3] "1" to fail if the
+// destination exists. It returns 1 once renamed, 0 if the source doesn't exist, -1 if
+// either key was rewritten since it was read and -2 if the destination exists.
+var renameScript = redis.NewScript(-1, `
+local function changed(key, manifest)
+	if ARGV[2] == '' or redis.call('TYPE', key).ok ~= 'string' then
+		return false
+	end
+	local v = redis.call('GET', key)
+	if manifest ~= '' then
+		return v ~= manifest
+	end
+	return string.sub(v, 1, #ARGV[2]) == ARGV[2]
+end
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if changed(KEYS[1], ARGV[1]) or changed(KEYS[2], ARGV[4]) then
+	return -1
+end
+if ARGV[3] == '1' and redis.call('EXISTS', KEYS[2]) == 1 then
+	return -2
+end
+local n = tonumber(ARGV[5])
+for i = 1, n, 2 do
+	redis.call('RENAME', KEYS[i], KEYS[i+1])
+end
+if #KEYS > n then
+	redis.call('DEL', unpack(KEYS, n + 1))
+end
+return 1
+`)
+
+// Rename atomically moves the entry at oldKey, with its TTL, to newKey, replacing the
+// entry there if any, e.g. to promote a value prepared under a staging key. It returns
+// ErrCacheMiss if oldKey doesn't exist.
+func (c *RedisStore) Rename(oldKey, newKey string) error {
+	return c.rename(oldKey, newKey, false)
+}
+
+// RenameNX works like Rename, but returns ErrNotStored rather than replacing an
+// existing entry at newKey
+func (c *RedisStore) RenameNX(oldKey, newKey string) error {
+	return c.rename(oldKey, newKey, true)
+}
+
+func (c *RedisStore) rename(oldKey, newKey string, nx bool) error {
+	nxArg := "0"
+	if nx {
+		nxArg = "1"
+	}
+	var magic []byte
+	if c.chunkSize > 0 {
+		magic = chunkManifestMagic
+	}
+
+	conn := c.getConn()
+	defer conn.Close()
+	for {
+		oldManifest, m, err := c.storedManifest(conn, oldKey)
+		if err != nil {
+			return err
+		}
+		newManifest, stale, err := c.storedManifest(conn, newKey)
+		if err != nil {
+			return err
+		}
+		keys := []interface{}{oldKey, newKey}
+		oldChunks, newChunks := m.keys(oldKey), m.keys(newKey)
+		for i := range oldChunks {
+			keys = append(keys, oldChunks[i], newChunks[i])
+		}
+		pairs := len(keys)
+		if stale.count > m.count {
+			keys = append(keys, stale.keys(newKey)[m.count:]...)
+		}
+		args := append([]interface{}{len(keys)}, keys...)
+		args = append(args, oldManifest, magic, nxArg, newManifest, pairs)
+		renamed, err := redis.Int(renameScript.Do(conn, args...))
+		if err != nil {
+			return err
+		}
+		switch renamed {
+		case 0:
+			return ErrCacheMiss
+		case -2:
+			return ErrNotStored
+		case -1:
+			continue // either key was rewritten meanwhile
+		}
+		return nil
+	}
+}
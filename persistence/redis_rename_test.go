@@ -0,0 +1,58 @@
+package persistence
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_Rename(t *testing.T) {
+	server := newRedisServer(t)
+	for name, store := range map[string]*RedisStore{
+		"plain":   NewRedisCache(server, "", time.Hour),
+		"chunked": NewRedisCache(server, "", time.Hour, WithChunkSize(8)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			staging, live := name+":staging", name+":live"
+			long := strings.Repeat("long value ", 10)
+			store.Set(live, long, DEFAULT)
+			store.Set(staging, 1, 10*time.Second)
+			if err := store.RenameNX(staging, live); err != ErrNotStored {
+				t.Errorf("expected ErrNotStored, got %v", err)
+			}
+			if err := store.Rename(staging, live); err != nil {
+				t.Fatal(err)
+			}
+			var n int
+			if err := store.Get(live, &n); err != nil || n != 1 {
+				t.Errorf("expected the promoted value, got %d (%v)", n, err)
+			}
+			if ttl, err := store.GetExpiresIn(live); err != nil || ttl <= 0 || ttl > 10*time.Second {
+				t.Errorf("expected the staging TTL, got %v (%v)", ttl, err)
+			}
+			var s string
+			if err := store.Get(staging, &s); err != ErrCacheMiss {
+				t.Errorf("expected the staging key gone, got %v", err)
+			}
+			if err := store.Rename(staging, live); err != ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss, got %v", err)
+			}
+
+			// no chunk of the replaced or moved values is left behind
+			store.Set(staging, long, DEFAULT)
+			if err := store.RenameNX(staging, name+":other"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Get(name+":other", &s); err != nil || s != long {
+				t.Errorf("expected the chunked value moved, got %q (%v)", s, err)
+			}
+			conn := store.getConn()
+			defer conn.Close()
+			for _, pattern := range []string{staging + "*", live + ":chunk:*"} {
+				if keys, _ := conn.Do("KEYS", pattern); len(keys.([]interface{})) != 0 {
+					t.Errorf("expected no %s key left, got %s", pattern, keys)
+				}
+			}
+		})
+	}
+}
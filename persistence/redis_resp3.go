@@ -0,0 +1,422 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// resp3Conn is a redis connection speaking RESP3, which redigo can't parse. Replies
+// keep the types redigo returns for RESP2 where RESP3 doesn't add one, so its reply
+// helpers keep working:
+//
+//	simple string         string
+//	error, blob error     redis.Error
+//	integer, boolean      int64 (booleans as 1 and 0)
+//	bulk, verbatim string []byte (the format of verbatim strings is dropped)
+//	array, set            []interface{}
+//	map                   []interface{} of alternated keys and values (see replyMap)
+//	null                  nil
+//	double                float64 (see replyFloat64)
+//	big number            *big.Int (see replyBigInt)
+//
+// Attributes are skipped. Push messages are returned by Receive as arrays, as pub/sub
+// messages are with RESP2, and skipped by Do.
+type resp3Conn struct {
+	mu      sync.Mutex
+	err     error
+	conn    net.Conn
+	br      *bufio.Reader
+	bw      *bufio.Writer
+	timeout time.Duration
+	pending int
+}
+
+// resp3Push is a push message, told apart from replies to commands
+type resp3Push []interface{}
+
+var _ redis.ConnWithTimeout = &resp3Conn{}
+
+// dialRESP3 connects to the redis server at address and switches the connection to
+// RESP3 with HELLO, authenticating with password if set. timeout bounds connecting and
+// each write and reply when set.
+func dialRESP3(address, password string, timeout time.Duration) (redis.Conn, error) {
+	netConn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &resp3Conn{conn: netConn, br: bufio.NewReader(netConn), bw: bufio.NewWriter(netConn), timeout: timeout}
+	args := []interface{}{3}
+	if len(password) > 0 {
+		args = append(args, "AUTH", "default", password)
+	}
+	hello, err := replyMap(c.Do("HELLO", args...))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if proto, _ := redis.Int(hello["proto"], nil); proto != 3 {
+		c.Close()
+		return nil, fmt.Errorf("cache: redis server negotiated protocol %v instead of RESP3", hello["proto"])
+	}
+	return c, nil
+}
+
+func (c *resp3Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = errors.New("cache: connection closed")
+	}
+	return c.conn.Close()
+}
+
+func (c *resp3Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// fatal closes the connection after an I/O or protocol error
+func (c *resp3Conn) fatal(err error) error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *resp3Conn) Send(cmd string, args ...interface{}) error {
+	c.mu.Lock()
+	c.pending++
+	c.mu.Unlock()
+	if c.timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	c.writeCommand(cmd, args)
+	return nil
+}
+
+func (c *resp3Conn) Flush() error {
+	if c.timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	if err := c.bw.Flush(); err != nil {
+		return c.fatal(err)
+	}
+	return nil
+}
+
+func (c *resp3Conn) Receive() (interface{}, error) {
+	return c.ReceiveWithTimeout(c.timeout)
+}
+
+func (c *resp3Conn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	c.setReadDeadline(timeout)
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, c.fatal(err)
+	}
+	if push, ok := reply.(resp3Push); ok {
+		return []interface{}(push), nil
+	}
+	c.mu.Lock()
+	if c.pending > 0 {
+		c.pending--
+	}
+	c.mu.Unlock()
+	if err, ok := reply.(redis.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *resp3Conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.DoWithTimeout(c.timeout, cmd, args...)
+}
+
+// DoWithTimeout sends cmd and returns its reply, after reading the replies of the
+// commands sent before it, like redigo: with an empty cmd, the replies of those are
+// returned as an array.
+func (c *resp3Conn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = 0
+	c.mu.Unlock()
+	if cmd == "" && pending == 0 {
+		return nil, nil
+	}
+	if c.timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	if cmd != "" {
+		c.writeCommand(cmd, args)
+	}
+	if err := c.bw.Flush(); err != nil {
+		return nil, c.fatal(err)
+	}
+	c.setReadDeadline(timeout)
+
+	if cmd == "" {
+		replies := make([]interface{}, pending)
+		for i := range replies {
+			reply, err := c.readCommandReply()
+			if err != nil {
+				return nil, c.fatal(err)
+			}
+			replies[i] = reply
+		}
+		return replies, nil
+	}
+	var err error
+	var reply interface{}
+	for i := 0; i <= pending; i++ {
+		var e error
+		if reply, e = c.readCommandReply(); e != nil {
+			return nil, c.fatal(e)
+		}
+		if e, ok := reply.(redis.Error); ok && err == nil {
+			err = e
+		}
+	}
+	return reply, err
+}
+
+func (c *resp3Conn) setReadDeadline(timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.conn.SetReadDeadline(deadline)
+}
+
+// readCommandReply reads the next reply to a command, skipping push messages
+func (c *resp3Conn) readCommandReply() (interface{}, error) {
+	for {
+		reply, err := c.readReply()
+		if _, ok := reply.(resp3Push); !ok || err != nil {
+			return reply, err
+		}
+	}
+}
+
+// writeCommand buffers cmd, errors writing it surfacing when the buffer is flushed
+func (c *resp3Conn) writeCommand(cmd string, args []interface{}) {
+	c.bw.WriteString("*")
+	c.bw.WriteString(strconv.Itoa(1 + len(args)))
+	c.bw.WriteString("\r\n")
+	c.writeBulk([]byte(cmd))
+	for _, arg := range args {
+		c.writeBulk(argBytes(arg, true))
+	}
+}
+
+func (c *resp3Conn) writeBulk(b []byte) {
+	c.bw.WriteString("$")
+	c.bw.WriteString(strconv.Itoa(len(b)))
+	c.bw.WriteString("\r\n")
+	c.bw.Write(b)
+	c.bw.WriteString("\r\n")
+}
+
+// argBytes formats a command argument the way redigo does
+func argBytes(arg interface{}, argumentOK bool) []byte {
+	switch arg := arg.(type) {
+	case string:
+		return []byte(arg)
+	case []byte:
+		return arg
+	case int:
+		return strconv.AppendInt(nil, int64(arg), 10)
+	case int64:
+		return strconv.AppendInt(nil, arg, 10)
+	case float64:
+		return strconv.AppendFloat(nil, arg, 'g', -1, 64)
+	case bool:
+		if arg {
+			return []byte("1")
+		}
+		return []byte("0")
+	case nil:
+		return nil
+	case redis.Argument:
+		if argumentOK {
+			return argBytes(arg.RedisArg(), false)
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, arg)
+	return buf.Bytes()
+}
+
+func (c *resp3Conn) readLine() ([]byte, error) {
+	p, err := c.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errors.New("cache: long response line")
+	}
+	if err != nil {
+		return nil, err
+	}
+	i := len(p) - 2
+	if i < 0 || p[i] != '\r' {
+		return nil, errors.New("cache: bad response line terminator")
+	}
+	return p[:i], nil
+}
+
+// readBlob reads a blob of n bytes and its line terminator
+func (c *resp3Conn) readBlob(n int) ([]byte, error) {
+	p := make([]byte, n+2)
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		return nil, err
+	}
+	if p[n] != '\r' || p[n+1] != '\n' {
+		return nil, errors.New("cache: bad blob format")
+	}
+	return p[:n], nil
+}
+
+func (c *resp3Conn) readReplies(n int) ([]interface{}, error) {
+	r := make([]interface{}, n)
+	for i := range r {
+		var err error
+		if r[i], err = c.readReply(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (c *resp3Conn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("cache: short response line")
+	}
+	payload := string(line[1:])
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return redis.Error(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '_':
+		return nil, nil
+	case '#':
+		if payload == "t" {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case ',':
+		return strconv.ParseFloat(payload, 64)
+	case '(':
+		n, ok := new(big.Int).SetString(payload, 10)
+		if !ok {
+			return nil, fmt.Errorf("cache: malformed big number %q", payload)
+		}
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(payload)
+	if err != nil {
+		return nil, fmt.Errorf("cache: malformed length %q", payload)
+	}
+	if n < 0 {
+		// RESP2 null bulk strings and arrays
+		return nil, nil
+	}
+	switch line[0] {
+	case '$':
+		return c.readBlob(n)
+	case '!':
+		b, err := c.readBlob(n)
+		return redis.Error(b), err
+	case '=':
+		b, err := c.readBlob(n)
+		if err == nil && len(b) >= 4 && b[3] == ':' {
+			b = b[4:]
+		}
+		return b, err
+	case '*', '~':
+		return c.readReplies(n)
+	case '%':
+		return c.readReplies(2 * n)
+	case '>':
+		r, err := c.readReplies(n)
+		return resp3Push(r), err
+	case '|':
+		if _, err := c.readReplies(2 * n); err != nil {
+			return nil, err
+		}
+		return c.readReply()
+	}
+	return nil, fmt.Errorf("cache: unexpected response line %q", line)
+}
+
+// replyMap converts a map reply, a RESP3 map or the flat key/value array RESP2 has
+// instead, to a map
+func replyMap(reply interface{}, err error) (map[string]interface{}, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, errors.New("cache: map reply with an odd number of elements")
+	}
+	m := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		key, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = values[i+1]
+	}
+	return m, nil
+}
+
+// replyFloat64 converts a RESP3 double, or the bulk string RESP2 has instead, to a
+// float64
+func replyFloat64(reply interface{}, err error) (float64, error) {
+	if f, ok := reply.(float64); ok && err == nil {
+		return f, nil
+	}
+	return redis.Float64(reply, err)
+}
+
+// replyBigInt converts a RESP3 big number, or the integer or bulk string RESP2 has
+// instead, to a *big.Int
+func replyBigInt(reply interface{}, err error) (*big.Int, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch reply := reply.(type) {
+	case *big.Int:
+		return reply, nil
+	case int64:
+		return big.NewInt(reply), nil
+	case []byte:
+		if n, ok := new(big.Int).SetString(string(reply), 10); ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("cache: malformed big number %q", reply)
+	case nil:
+		return nil, redis.ErrNil
+	case redis.Error:
+		return nil, reply
+	}
+	return nil, fmt.Errorf("cache: unexpected type for a big number, got type %T", reply)
+}
@@ -0,0 +1,84 @@
+package persistence
+
+import (
+	"bufio"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestRedisStore_RESP3(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour, WithRESP3())
+	incrDecr(t, func(*testing.T, time.Duration) CacheStore { return store })
+
+	if err := store.Set("value", "foo", DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if err := store.Get("value", &value); err != nil || value != "foo" {
+		t.Errorf("expected foo, got %q (%v)", value, err)
+	}
+	if err := store.Get("missing", &value); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+
+	// HGETALL replies with a map
+	conn := store.getConn()
+	conn.Do("HSET", "user:1", "name", "ada", "age", 36)
+	hash, err := replyMap(conn.Do("HGETALL", "user:1"))
+	conn.Close()
+	if err != nil || len(hash) != 2 {
+		t.Fatalf("expected a map of 2 fields, got %v (%v)", hash, err)
+	}
+	var users []hashUser
+	if err := store.HGetAllMulti([]string{"user:1"}, &users); err != nil || users[0].Name != "ada" || users[0].Age != 36 {
+		t.Errorf("expected ada, got %v (%v)", users, err)
+	}
+}
+
+func TestRESP3Conn_ReadReply(t *testing.T) {
+	stream := strings.Join([]string{
+		"+OK", ":42", "_", "#t", "#f", ",3.5", ",inf", "(3492890328409238509324850943850943825024385",
+		"$3", "foo", "$-1", "=8", "txt:text", "!5", "ERR x", "%1", "+key", ":1", "~2", ":1", ":2",
+		"|1", "+ttl", ":3", ":7", ">2", "+message", "+hi", "",
+	}, "\r\n")
+	c := &resp3Conn{br: bufio.NewReader(strings.NewReader(stream))}
+	bignum, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+	for _, expected := range []interface{}{
+		"OK", int64(42), nil, int64(1), int64(0), 3.5, math.Inf(1), bignum,
+		[]byte("foo"), nil, []byte("text"), redis.Error("ERR x"), []interface{}{"key", int64(1)},
+		[]interface{}{int64(1), int64(2)}, int64(7), resp3Push{"message", "hi"},
+	} {
+		reply, err := c.readReply()
+		if err != nil {
+			t.Fatalf("reading %v: %s", expected, err)
+		}
+		if !reflect.DeepEqual(reply, expected) {
+			t.Errorf("expected %#v, got %#v", expected, reply)
+		}
+	}
+}
+
+func TestReplyHelpers(t *testing.T) {
+	for _, reply := range []interface{}{2.5, []byte("2.5")} {
+		if f, err := replyFloat64(reply, nil); err != nil || f != 2.5 {
+			t.Errorf("%T: expected 2.5, got %v (%v)", reply, f, err)
+		}
+	}
+	for _, reply := range []interface{}{big.NewInt(7), int64(7), []byte("7")} {
+		if n, err := replyBigInt(reply, nil); err != nil || n.Int64() != 7 {
+			t.Errorf("%T: expected 7, got %v (%v)", reply, n, err)
+		}
+	}
+	if _, err := replyBigInt(nil, nil); err != redis.ErrNil {
+		t.Errorf("expected ErrNil, got %v", err)
+	}
+	if _, err := replyMap([]interface{}{"odd"}, nil); err == nil {
+		t.Error("expected an error for an odd map reply")
+	}
+}
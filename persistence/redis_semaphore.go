@@ -0,0 +1,213 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// semaphoreNow sets now to the time of the server in milliseconds, so the TTLs of
+// holders don't depend on the clocks of the clients
+const semaphoreNow = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// acquireSemaphoreScript grants ARGV[1] a permit of the semaphore whose holders are
+// the sorted set KEYS[1], scored by their expiry, provided less than ARGV[2] hold one
+// and no waiter of the queue KEYS[2], scored by arrival, is ahead. The permit is held
+// for ARGV[3] milliseconds. Otherwise ARGV[1] waits in the queue for ARGV[4]
+// milliseconds, as recorded in the sorted set KEYS[3], unless ARGV[5] is "0" in which
+// case it leaves the queue. It returns 1 if the permit was granted, 0 otherwise.
+var acquireSemaphoreScript = redis.NewScript(3, semaphoreNow+`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+local gone = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', now)
+for i = 1, #gone, 100 do
+	local batch = {unpack(gone, i, math.min(i + 99, #gone))}
+	redis.call('ZREM', KEYS[2], unpack(batch))
+	redis.call('ZREM', KEYS[3], unpack(batch))
+end
+if not redis.call('ZSCORE', KEYS[2], ARGV[1]) then
+	redis.call('ZADD', KEYS[2], now, ARGV[1])
+end
+local free = tonumber(ARGV[2]) - redis.call('ZCARD', KEYS[1])
+if redis.call('ZRANK', KEYS[2], ARGV[1]) < free then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	redis.call('ZADD', KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+	local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	redis.call('PEXPIREAT', KEYS[1], last[2])
+	return 1
+end
+if ARGV[5] == '0' then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return 0
+end
+redis.call('ZADD', KEYS[3], now + tonumber(ARGV[4]), ARGV[1])
+redis.call('PEXPIRE', KEYS[2], ARGV[4])
+redis.call('PEXPIRE', KEYS[3], ARGV[4])
+return 0
+`)
+
+// extendSemaphoreScript sets the expiry of the permit ARGV[1] of the semaphore KEYS[1]
+// to ARGV[2] milliseconds from now, provided it's still held. It returns 1 if it is, 0
+// otherwise.
+var extendSemaphoreScript = redis.NewScript(1, semaphoreNow+`
+local expiry = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not expiry or tonumber(expiry) <= now then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+redis.call('PEXPIREAT', KEYS[1], last[2])
+return 1
+`)
+
+// countSemaphoreScript returns the number of live permits of the semaphore KEYS[1]
+var countSemaphoreScript = redis.NewScript(1, semaphoreNow+`
+return redis.call('ZCOUNT', KEYS[1], '(' .. now, '+inf')
+`)
+
+// Semaphore is a counting semaphore shared through a RedisStore, bounding the number of
+// concurrent holders of its permits across processes, see RedisStore.NewSemaphore
+type Semaphore struct {
+	store        *RedisStore
+	key          string
+	limit        int
+	ttl          time.Duration
+	pollInterval time.Duration
+}
+
+// Permit is a permit of a Semaphore
+type Permit struct {
+	semaphore *Semaphore
+	token     string
+}
+
+// NewSemaphore returns the semaphore named key, letting up to limit holders have a
+// permit at once. Permits are held for ttl unless extended, so the permits of crashed
+// holders are eventually given to others. Waiters get permits in the order they
+// started waiting, as long as they keep polling (see WithSemaphorePollInterval).
+//
+// The semaphore keeps its holders under key, and its waiters under key+":queue" and
+// key+":waiting".
+func (c *RedisStore) NewSemaphore(key string, limit int, ttl time.Duration, opt ...Option) *Semaphore {
+	opts := GetOpts(opt...)
+	s := &Semaphore{store: c, key: key, limit: limit, ttl: ttl, pollInterval: 50 * time.Millisecond}
+	if v, ok := opts[optionWithSemaphorePollInterval].(time.Duration); ok && v > 0 {
+		s.pollInterval = v
+	}
+	return s
+}
+
+// waitTTL is how long a waiter that stopped polling holds its place in the queue
+func (s *Semaphore) waitTTL() time.Duration {
+	if d := 10 * s.pollInterval; d > time.Second {
+		return d
+	}
+	return time.Second
+}
+
+// TryAcquire returns a permit if one is free and nobody is waiting for it, or
+// ErrLockNotAcquired otherwise
+func (s *Semaphore) TryAcquire() (*Permit, error) {
+	token := newLockToken()
+	if err := s.acquire(token, false); err != nil {
+		return nil, err
+	}
+	return &Permit{semaphore: s, token: token}, nil
+}
+
+// Acquire waits for a permit until ctx is done, in which case it returns the error of
+// ctx
+func (s *Semaphore) Acquire(ctx context.Context) (*Permit, error) {
+	token := newLockToken()
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		err := s.acquire(token, true)
+		if err == nil {
+			return &Permit{semaphore: s, token: token}, nil
+		}
+		if err != ErrLockNotAcquired {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			s.leave(token)
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Count returns the number of permits held
+func (s *Semaphore) Count() (int, error) {
+	conn := s.store.getConn()
+	defer conn.Close()
+	return redis.Int(countSemaphoreScript.Do(conn, s.key))
+}
+
+func (s *Semaphore) acquire(token string, wait bool) error {
+	if s.limit <= 0 || s.ttl < time.Millisecond {
+		return ErrInvalidArgument
+	}
+	waitArg := "0"
+	if wait {
+		waitArg = "1"
+	}
+	conn := s.store.getConn()
+	defer conn.Close()
+	n, err := redis.Int(acquireSemaphoreScript.Do(conn, s.key, s.key+":queue", s.key+":waiting",
+		token, s.limit, int64(s.ttl/time.Millisecond), int64(s.waitTTL()/time.Millisecond), waitArg))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotAcquired
+	}
+	return nil
+}
+
+// leave removes the waiter token from the queue, best effort as it's dropped once it
+// stops polling anyway
+func (s *Semaphore) leave(token string) {
+	conn := s.store.getConn()
+	defer conn.Close()
+	conn.Do("ZREM", s.key+":queue", token)
+	conn.Do("ZREM", s.key+":waiting", token)
+}
+
+// Release gives the permit back, returning ErrLockNotHeld if it had expired and was
+// given to someone else
+func (p *Permit) Release() error {
+	conn := p.semaphore.store.getConn()
+	defer conn.Close()
+	n, err := redis.Int(conn.Do("ZREM", p.semaphore.key, p.token))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend holds the permit for ttl from now, returning ErrLockNotHeld if it had expired
+func (p *Permit) Extend(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidArgument
+	}
+	conn := p.semaphore.store.getConn()
+	defer conn.Close()
+	n, err := redis.Int(extendSemaphoreScript.Do(conn, p.semaphore.key, p.token, int64(ttl/time.Millisecond)))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
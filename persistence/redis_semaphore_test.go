@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+)
+
+func TestSemaphore(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour)
+	sem := store.NewSemaphore("sem", 2, time.Minute)
+
+	p1, err := sem.TryAcquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sem.TryAcquire(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sem.TryAcquire(); err != ErrLockNotAcquired {
+		t.Errorf("expected ErrLockNotAcquired, got %v", err)
+	}
+	if n, err := sem.Count(); err != nil || n != 2 {
+		t.Errorf("expected 2 permits held, got %d (%v)", n, err)
+	}
+	if err := p1.Extend(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := p1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p1.Release(); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if _, err := sem.TryAcquire(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := sem.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline exceeded, got %v", err)
+	}
+	if server.Exists("sem:queue") {
+		t.Error("expected the waiter to leave the queue")
+	}
+}
+
+func TestSemaphore_Expiry(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour)
+	sem := store.NewSemaphore("sem", 1, time.Minute)
+
+	p, err := sem.TryAcquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetTime(time.Now().Add(2 * time.Minute))
+	if err := p.Extend(time.Minute); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if _, err := sem.TryAcquire(); err != nil {
+		t.Errorf("expected the expired permit given away, got %v", err)
+	}
+}
+
+func TestSemaphore_Bound(t *testing.T) {
+	store := NewRedisCache(miniredistest.Run(t).Addr(), "", time.Hour)
+	sem := store.NewSemaphore("sem", 3, time.Minute, WithSemaphorePollInterval(time.Millisecond))
+
+	var held, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := sem.Acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&held, 1)
+			for {
+				m := atomic.LoadInt32(&peak)
+				if n <= m || atomic.CompareAndSwapInt32(&peak, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&held, -1)
+			p.Release()
+		}()
+	}
+	wg.Wait()
+	if peak != 3 {
+		t.Errorf("expected up to 3 holders at once, got %d", peak)
+	}
+}
@@ -0,0 +1,65 @@
+package persistence
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// SlowLogEntry is a command logged by the redis slow log
+type SlowLogEntry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	// Args is the command and its arguments, as far as the server kept them
+	Args []string
+	// ClientAddr and ClientName identify the client that ran the command (redis 4.0+)
+	ClientAddr string
+	ClientName string
+}
+
+// SlowLog returns the n latest entries of the redis slow log, the latest first, or
+// the server's default number of them if n <= 0
+func (c *RedisStore) SlowLog(n int) ([]SlowLogEntry, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	args := []interface{}{"GET"}
+	if n > 0 {
+		args = append(args, n)
+	}
+	reply, err := redis.Values(conn.Do("SLOWLOG", args...))
+	if err != nil {
+		return nil, err
+	}
+	return parseSlowLog(reply)
+}
+
+func parseSlowLog(reply []interface{}) ([]SlowLogEntry, error) {
+	entries := make([]SlowLogEntry, 0, len(reply))
+	for _, r := range reply {
+		fields, err := redis.Values(r, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("cache: malformed slow log entry %v", fields)
+		}
+		var e SlowLogEntry
+		var unix, micros int64
+		if _, err := redis.Scan(fields[:3], &e.ID, &unix, &micros); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(unix, 0)
+		e.Duration = time.Duration(micros) * time.Microsecond
+		if e.Args, err = redis.Strings(fields[3], nil); err != nil {
+			return nil, err
+		}
+		if len(fields) >= 6 {
+			e.ClientAddr, _ = redis.String(fields[4], nil)
+			e.ClientName, _ = redis.String(fields[5], nil)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
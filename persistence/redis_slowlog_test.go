@@ -0,0 +1,31 @@
+package persistence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSlowLog(t *testing.T) {
+	entries, err := parseSlowLog([]interface{}{
+		[]interface{}{int64(14), int64(1700000000), int64(25000),
+			[]interface{}{[]byte("KEYS"), []byte("*")}, []byte("10.0.0.1:52340"), []byte("api")},
+		// before redis 4.0, entries had no client
+		[]interface{}{int64(13), int64(1699999999), int64(12000),
+			[]interface{}{[]byte("FLUSHALL")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SlowLogEntry{
+		{14, time.Unix(1700000000, 0), 25 * time.Millisecond, []string{"KEYS", "*"}, "10.0.0.1:52340", "api"},
+		{13, time.Unix(1699999999, 0), 12 * time.Millisecond, []string{"FLUSHALL"}, "", ""},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+
+	if _, err := parseSlowLog([]interface{}{[]interface{}{int64(1)}}); err == nil {
+		t.Error("expected an error for a malformed entry")
+	}
+}
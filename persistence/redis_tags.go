@@ -0,0 +1,109 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// tagKeyPrefix prefixes the redis sets holding the keys tagged with a tag
+const tagKeyPrefix = "cache:tag:"
+
+// tagKeysScript adds ARGV[1] to every tag set in KEYS. A tag set lives as long as the
+// longest lived key in it (ARGV[2] seconds, <= 0 for keys that never expire), so it
+// never outlives what it has to invalidate by much and never expires before it.
+var tagKeysScript = redis.NewScript(-1, `
+local ttl = tonumber(ARGV[2])
+for _, tag in ipairs(KEYS) do
+	local existed = redis.call('EXISTS', tag) == 1
+	local cur = redis.call('TTL', tag)
+	redis.call('SADD', tag, ARGV[1])
+	if ttl <= 0 then
+		redis.call('PERSIST', tag)
+	elseif not existed or (cur >= 0 and cur < ttl) then
+		redis.call('EXPIRE', tag, ttl)
+	end
+end
+return 0
+`)
+
+// popTagScript returns the keys tagged with KEYS[1] and removes the tag set in one step,
+// so a key tagged concurrently is either returned or stays in a new tag set
+var popTagScript = redis.NewScript(1, `
+local keys = redis.call('SMEMBERS', KEYS[1])
+redis.call('DEL', KEYS[1])
+return keys
+`)
+
+func tagKey(tag string) string {
+	return tagKeyPrefix + tag
+}
+
+// SetWithTags sets the item like Set and records the key under each tag, so the key
+// is removed by InvalidateTag for any of them
+func (c *RedisStore) SetWithTags(key string, value interface{}, expires time.Duration, tags ...string) error {
+	b, err := c.marshal(value)
+	if err != nil {
+		return &SerializationError{Key: key, Cause: err}
+	}
+	// the expiration is translated once, so the tag sets get the jittered TTL of the key
+	expires = c.expiration(key, expires)
+	conn := c.getConn()
+	defer conn.Close()
+	if err := c.write(conn.Do, key, b, expires); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(tags)+3)
+	args = append(args, len(tags))
+	for _, tag := range tags {
+		args = append(args, tagKey(tag))
+	}
+	args = append(args, key, int32(expires/time.Second))
+	_, err = tagKeysScript.Do(conn, args...)
+	return err
+}
+
+// InvalidateTag removes every key tagged with tag and returns the number of keys removed
+func (c *RedisStore) InvalidateTag(tag string) (int, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	keys, err := redis.Strings(popTagScript.Do(conn, tagKey(tag)))
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	var chunks []interface{}
+	if c.chunkSize > 0 {
+		for _, key := range keys {
+			stale, err := c.staleChunks(conn.Do, key, 0)
+			if err != nil {
+				return 0, err
+			}
+			chunks = append(chunks, stale...)
+		}
+	}
+	n := 0
+	for start := 0; start < len(keys); start += 1000 {
+		end := start + 1000
+		if end > len(keys) {
+			end = len(keys)
+		}
+		args := make([]interface{}, 0, end-start)
+		for _, key := range keys[start:end] {
+			args = append(args, key)
+		}
+		deleted, err := redis.Int(conn.Do("DEL", args...))
+		if err != nil {
+			return n, err
+		}
+		n += deleted
+	}
+	if len(chunks) > 0 {
+		if _, err := conn.Do("DEL", chunks...); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,61 @@
+package persistence
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+)
+
+func tags(t *testing.T, newStore redisStoreFactory) {
+	store := newStore(t, time.Hour)
+	if err := store.SetWithTags("user:42:profile", "profile", DEFAULT, "user:42"); err != nil {
+		t.Fatalf("Error setting a tagged value: %s", err)
+	}
+	if err := store.SetWithTags("user:42:orders", "orders", time.Minute, "user:42", "orders"); err != nil {
+		t.Fatalf("Error setting a tagged value: %s", err)
+	}
+	if err := store.SetWithTags("user:7:orders", "orders", DEFAULT, "user:7", "orders"); err != nil {
+		t.Fatalf("Error setting a tagged value: %s", err)
+	}
+
+	n, err := store.InvalidateTag("user:42")
+	if err != nil {
+		t.Fatalf("Error invalidating a tag: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 keys invalidated, got %d", n)
+	}
+	var v string
+	for _, key := range []string{"user:42:profile", "user:42:orders"} {
+		if err := store.Get(key, &v); err != ErrCacheMiss {
+			t.Errorf("expected %s to be invalidated, got %v", key, err)
+		}
+	}
+	if err := store.Get("user:7:orders", &v); err != nil {
+		t.Errorf("expected user:7:orders to be kept: %s", err)
+	}
+	// keys already gone are not counted
+	if n, err := store.InvalidateTag("orders"); err != nil || n != 1 {
+		t.Errorf("expected 1 key invalidated, got %d (%v)", n, err)
+	}
+	if n, err := store.InvalidateTag("orders"); err != nil || n != 0 {
+		t.Errorf("expected the tag to be gone, got %d (%v)", n, err)
+	}
+}
+
+func TestRedis_TagsJitter(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour, WithTTLJitter(0.5))
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		tag := fmt.Sprintf("tag:%d", i)
+		if err := store.SetWithTags(key, i, DEFAULT, tag); err != nil {
+			t.Fatalf("Error setting a tagged value: %s", err)
+		}
+		if keyTTL, tagTTL := server.TTL(key), server.TTL(tagKey(tag)); tagTTL != keyTTL {
+			t.Errorf("expected the tag set to expire with %s in %s, got %s", key, keyTTL, tagTTL)
+		}
+	}
+}
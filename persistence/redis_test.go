@@ -1,45 +1,30 @@
 package persistence
 
 import (
-	"net"
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
 )
 
-// These tests require redis server running on localhost:6379 (the default)
-const redisTestServer = "localhost:6379"
+// newRedisServer starts an embedded redis server for the test and returns its address
+func newRedisServer(t *testing.T) string {
+	return miniredistest.Run(t).Addr()
+}
 
 var newRedisStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
-	c, err := net.Dial("tcp", redisTestServer)
-	if err == nil {
-		_, err := c.Write([]byte("flush_all\r\n"))
-		if err != nil {
-			t.Fatalf("Unexpected error: %s", err.Error())
-		}
-		c.Close()
-		redisCache := NewRedisCache(redisTestServer, "", defaultExpiration)
-		redisCache.Flush()
-		return redisCache
-	}
-	t.Errorf("couldn't connect to redis on %s", redisTestServer)
-	t.FailNow()
-	panic("")
+	return NewRedisCache(newRedisServer(t), "", defaultExpiration)
 }
 
 func TestRedisStoreSelectDatabase(t *testing.T) {
-	c, err := net.Dial("tcp", redisTestServer)
-	if err != nil {
-		t.Errorf("couldn't connect to redis on %s", redisTestServer)
-	}
-	_, err = c.Write([]byte("flush_all\r\n"))
-	if err != nil {
-		t.Fatalf("Unexpected error: %s", err.Error())
-	}
-	c.Close()
-	redisCache := NewRedisCache(redisTestServer, "", 1*time.Second, WithSelectDatabase(1))
-	err = redisCache.Flush()
+	redisCache := NewRedisCache(newRedisServer(t), "", 1*time.Second, WithSelectDatabase(1))
+	err := redisCache.Flush()
 	if err != nil {
-		t.Errorf("couldn't connect to redis on %s", redisTestServer)
+		t.Errorf("couldn't connect to redis: %s", err)
 	}
 }
 func TestRedisCache_MgetTwoKeys(t *testing.T) {
@@ -54,6 +39,13 @@ func TestRedisCache_MsetNXThenMgetThreeKeys(t *testing.T) {
 	msetNXThenMgetThreeKeys(t, newRedisStore)
 }
 
+func TestRedisCache_MsetNXEmpty(t *testing.T) {
+	cache := newRedisStore(t, time.Hour).(*RedisStore)
+	if err := cache.MSetNX(time.Hour); err != nil {
+		t.Errorf("expected MSetNX without keys to do nothing, got %v", err)
+	}
+}
+
 func TestRedisCache_TypicalGetSet(t *testing.T) {
 	typicalGetSet(t, newRedisStore)
 }
@@ -66,7 +58,132 @@ func TestRedis_IncrAtomic(t *testing.T) {
 }
 
 func TestRedis_GetExpiresIn(t *testing.T) {
-	getExpiresIn(t, newRawRedisStore)
+	getExpiresIn(t, newRedisStore)
+}
+
+func TestRedis_CachedNil(t *testing.T) {
+	cachedNil(t, newRedisStore)
+}
+
+func TestRedis_NilLookalikes(t *testing.T) {
+	// payloads holding the first byte of the nil payload are values like any other
+	store := NewRedisCache(newRedisServer(t), "", time.Hour, WithRawStrings())
+	if err := store.Set("bytes", []byte{0x80}, DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	if err := store.Get("bytes", &b); err != nil || !bytes.Equal(b, []byte{0x80}) {
+		t.Errorf("expected [0x80], got %x (%v)", b, err)
+	}
+	if err := store.Set("string", "\x80", DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := store.Get("string", &s); err != nil || s != "\x80" {
+		t.Errorf("expected \\x80, got %q (%v)", s, err)
+	}
+}
+
+func TestRedis_GetOrSet(t *testing.T) {
+	getOrSet(t, newRedisStore)
+}
+
+func TestRedisStore_AddRace(t *testing.T) {
+	for name, store := range map[string]*RedisStore{
+		"plain":   NewRedisCache(newRedisServer(t), "", time.Hour),
+		"chunked": newChunkedRedisStore(t, time.Hour),
+	} {
+		t.Run(name, func(t *testing.T) {
+			value := bytes.Repeat([]byte("v"), 40)
+			var added int32
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := store.Add("key", value, time.Minute); err == nil {
+						atomic.AddInt32(&added, 1)
+					} else if err != ErrNotStored {
+						t.Errorf("unexpected error: %s", err)
+					}
+				}()
+			}
+			wg.Wait()
+			if added != 1 {
+				t.Errorf("expected a single Add to succeed, %d did", added)
+			}
+			var got []byte
+			if err := store.Get("key", &got); err != nil || !bytes.Equal(got, value) {
+				t.Errorf("expected the added value back, got %q (%v)", got, err)
+			}
+			if ttl, err := store.GetExpiresIn("key"); err != nil || ttl <= 0 || ttl > time.Minute {
+				t.Errorf("expected a ttl within a minute, got %s (%v)", ttl, err)
+			}
+		})
+	}
+}
+
+func TestRedisStore_HealthCheck(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts  []Option
+		pings int
+	}{
+		"every borrow": {nil, 10},
+		"idle":         {[]Option{WithHealthCheck(time.Hour)}, 0},
+		"disabled":     {[]Option{WithHealthCheck(-1)}, 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := miniredistest.Run(t)
+			store := NewRedisCache(server.Addr(), "", time.Hour, tc.opts...)
+			store.Set("key", 1, DEFAULT)
+			before := server.CommandCount()
+			var v int
+			for i := 0; i < 10; i++ {
+				store.Get("key", &v)
+			}
+			if pings := server.CommandCount() - before - 10; pings != tc.pings {
+				t.Errorf("expected %d PINGs, got %d", tc.pings, pings)
+			}
+		})
+	}
+
+	store := NewRedisCache(newRedisServer(t), "", time.Hour, WithMaxConnLifetime(time.Minute))
+	if store.pool.MaxConnLifetime != time.Minute {
+		t.Errorf("expected the max connection lifetime to be set, got %s", store.pool.MaxConnLifetime)
+	}
+}
+
+func TestRedisStore_Delete(t *testing.T) {
+	for name, opts := range map[string][]Option{"del": nil, "unlink": {WithUnlink()}} {
+		t.Run(name, func(t *testing.T) {
+			store := NewRedisCache(newRedisServer(t), "", time.Hour, opts...)
+			if err := store.Set("key", "value", DEFAULT); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete("key"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := store.Delete("key"); err != ErrCacheMiss {
+				t.Errorf("expected ErrCacheMiss, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRedis_DeleteByPattern(t *testing.T) {
+	deleteByPattern(t, newRawRedisStore)
+}
+
+func TestRedis_ChunkedValues(t *testing.T) {
+	chunkedValues(t, newChunkedRedisStore)
+}
+
+func TestRedis_MaxValueSize(t *testing.T) {
+	redisMaxValueSize(t)
+}
+
+func TestRedis_Tags(t *testing.T) {
+	tags(t, newRawRedisStore)
 }
 
 func TestRedisCache_Expiration(t *testing.T) {
@@ -140,10 +257,10 @@ func simpleMsetNXTwoKeys(t *testing.T, newCache cacheFactory) {
 	k2 := "test2"
 	v2 := "value2"
 
-	if err := cache.Delete(k1); err != nil {
+	if err := cache.Delete(k1); err != nil && !errors.Is(err, ErrCacheMiss) {
 		t.Fatalf("Unexpected error: %s", err.Error())
 	}
-	if err := cache.Delete(k2); err != nil {
+	if err := cache.Delete(k2); err != nil && !errors.Is(err, ErrCacheMiss) {
 		t.Fatalf("Unexpected error: %s", err.Error())
 	}
 	// mset two keys
@@ -180,13 +297,13 @@ func msetNXThenMgetThreeKeys(t *testing.T, newCache cacheFactory) {
 	k3 := "test3"
 	v3 := "value3"
 
-	if err := cache.Delete(k1); err != nil {
+	if err := cache.Delete(k1); err != nil && !errors.Is(err, ErrCacheMiss) {
 		t.Fatalf("Unexpected error: %s", err.Error())
 	}
-	if err := cache.Delete(k2); err != nil {
+	if err := cache.Delete(k2); err != nil && !errors.Is(err, ErrCacheMiss) {
 		t.Fatalf("Unexpected error: %s", err.Error())
 	}
-	if err := cache.Delete(k3); err != nil {
+	if err := cache.Delete(k3); err != nil && !errors.Is(err, ErrCacheMiss) {
 		t.Fatalf("Unexpected error: %s", err.Error())
 	}
 	// mset two keys
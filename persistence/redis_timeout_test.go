@@ -0,0 +1,62 @@
+package persistence
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// newStalledServer returns the address of a server reading commands and never replying
+func newStalledServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+			go io.Copy(io.Discard, c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestRedisStore_OperationTimeout(t *testing.T) {
+	addr := newStalledServer(t)
+	// a pool dialing without any timeout, so only the operation timeout applies
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", addr) }}
+	store := NewRedisCacheWithPool(pool, time.Hour, WithOperationTimeout(50*time.Millisecond))
+	start := time.Now()
+	var v string
+	if err := store.Get("key", &v); err == nil {
+		t.Fatal("expected the Get to time out")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the Get to give up after the operation timeout, took %v", d)
+	}
+
+	// NewRedisCache bounds dialing too, whose PING gets no reply
+	start = time.Now()
+	if err := NewRedisCache(addr, "", time.Hour, WithOperationTimeout(50*time.Millisecond)).Set("key", "value", DEFAULT); err == nil {
+		t.Fatal("expected the Set to time out")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the Set to give up after the operation timeout, took %v", d)
+	}
+
+	store = NewRedisCache(newRedisServer(t), "", time.Hour, WithOperationTimeout(time.Second))
+	if err := store.Set("key", "value", DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Get("key", &v); err != nil || v != "value" {
+		t.Errorf("expected value, got %q (%v)", v, err)
+	}
+}
@@ -0,0 +1,43 @@
+package persistence
+
+import (
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Type returns the type of the entry at key as reported by the redis TYPE command:
+// "string" for the values Set stores, or "hash", "list", "set", "zset" or "stream".
+// It returns ErrCacheMiss if the key doesn't exist.
+func (c *RedisStore) Type(key string) (string, error) {
+	conn := c.getConn()
+	defer conn.Close()
+	return keyType(conn, key)
+}
+
+func keyType(conn redis.Conn, key string) (string, error) {
+	t, err := redis.String(conn.Do("TYPE", key))
+	if err != nil {
+		return "", err
+	}
+	if t == "none" {
+		return "", ErrCacheMiss
+	}
+	return t, nil
+}
+
+// isWrongType reports whether err is the reply of the server to a command run against
+// a key of another type
+func isWrongType(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), "WRONGTYPE")
+}
+
+// wrongType returns the *WrongTypeError for reading key, which isn't a string
+func wrongType(conn redis.Conn, key string, cause error) error {
+	t, err := keyType(conn, key)
+	if err != nil {
+		t = "entry of another type"
+	}
+	return &WrongTypeError{Key: key, Type: t, Cause: cause}
+}
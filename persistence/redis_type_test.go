@@ -0,0 +1,32 @@
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_Type(t *testing.T) {
+	store := NewRedisCache(newRedisServer(t), "", time.Hour)
+	store.Set("value", "v", DEFAULT)
+	conn := store.getConn()
+	conn.Do("HSET", "hash", "f", "v")
+	conn.Do("RPUSH", "list", "v")
+	conn.Close()
+
+	for key, expected := range map[string]string{"value": "string", "hash": "hash", "list": "list"} {
+		if typ, err := store.Type(key); err != nil || typ != expected {
+			t.Errorf("expected %s to be a %s, got %q (%v)", key, expected, typ, err)
+		}
+	}
+	if _, err := store.Type("missing"); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+
+	var s string
+	err := store.Get("hash", &s)
+	var wrongType *WrongTypeError
+	if !errors.Is(err, ErrWrongType) || !errors.As(err, &wrongType) || wrongType.Type != "hash" || wrongType.Key != "hash" {
+		t.Errorf("expected a WrongTypeError for the hash, got %v", err)
+	}
+}
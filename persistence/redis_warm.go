@@ -0,0 +1,157 @@
+package persistence
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultWarmBatchSize = 100
+
+// KV is an entry to load into the cache
+type KV struct {
+	Key     string
+	Value   interface{}
+	Expires time.Duration
+}
+
+// Warm bulk loads the entries into redis until the channel is closed or ctx is done,
+// pipelining them in batches (see WithWarmBatchSize and WithWarmProgress). It returns
+// the number of entries loaded, which are all of them unless an error is returned.
+func (c *RedisStore) Warm(ctx context.Context, entries <-chan KV, opt ...Option) (int, error) {
+	opts := GetOpts(opt...)
+	batchSize := defaultWarmBatchSize
+	if v, ok := opts[optionWithWarmBatchSize].(int); ok && v > 0 {
+		batchSize = v
+	}
+	progress, _ := opts[optionWithWarmProgress].(func(int))
+
+	conn := c.getConn()
+	defer conn.Close()
+	loaded, batched, pending := 0, 0, 0
+	send := func(cmd string, args ...interface{}) (interface{}, error) {
+		pending++
+		return nil, conn.Send(cmd, args...)
+	}
+	flush := func() error {
+		if batched == 0 {
+			return nil
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		for ; pending > 0; pending-- {
+			if _, err := conn.Receive(); err != nil {
+				return err
+			}
+		}
+		loaded, batched = loaded+batched, 0
+		if progress != nil {
+			progress(loaded)
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := flush(); err != nil {
+				return loaded, err
+			}
+			return loaded, ctx.Err()
+		case kv, ok := <-entries:
+			if !ok {
+				return loaded, flush()
+			}
+			f := send
+			if c.chunkSize > 0 {
+				// chunked writes need their replies as they go
+				f = conn.Do
+			}
+			if err := c.invoke(f, kv.Key, kv.Value, kv.Expires); err != nil {
+				return loaded, err
+			}
+			if batched++; batched >= batchSize {
+				if err := flush(); err != nil {
+					return loaded, err
+				}
+			}
+		}
+	}
+}
+
+// warmFileEntry is a line of a warm file
+type warmFileEntry struct {
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value"`
+	Expires string          `json:"expires,omitempty"`
+}
+
+// WarmFromFile loads the entries of a file of JSON lines like
+//
+//	{"key": "user:42", "value": "profile", "expires": "1h"}
+//
+// with Warm. String and integer values are stored as such, any other value as its JSON
+// text; entries without expires get the default expiration.
+func (c *RedisStore) WarmFromFile(ctx context.Context, path string, opt ...Option) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	entries := make(chan KV)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 64<<20)
+		for line := 1; scanner.Scan(); line++ {
+			kv, err := parseWarmLine(scanner.Bytes())
+			if err != nil {
+				readErr <- fmt.Errorf("%s:%d: %v", path, line, err)
+				return
+			}
+			select {
+			case entries <- kv:
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	n, err := c.Warm(ctx, entries, opt...)
+	if err != nil {
+		return n, err
+	}
+	return n, <-readErr
+}
+
+func parseWarmLine(line []byte) (KV, error) {
+	var e warmFileEntry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return KV{}, err
+	}
+	kv := KV{Key: e.Key, Value: string(e.Value), Expires: DEFAULT}
+	var s string
+	var n int64
+	if json.Unmarshal(e.Value, &s) == nil {
+		kv.Value = s
+	} else if json.Unmarshal(e.Value, &n) == nil {
+		kv.Value = n
+	}
+	if e.Expires != "" {
+		d, err := time.ParseDuration(e.Expires)
+		if err != nil {
+			return KV{}, err
+		}
+		kv.Expires = d
+	}
+	return kv, nil
+}
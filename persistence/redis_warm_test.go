@@ -0,0 +1,71 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRedisStore_Warm(t *testing.T) {
+	store := newRedisStore(t, time.Hour).(*RedisStore)
+	entries := make(chan KV)
+	go func() {
+		defer close(entries)
+		for i := 0; i < 250; i++ {
+			entries <- KV{Key: fmt.Sprintf("warm:%d", i), Value: i, Expires: DEFAULT}
+		}
+	}()
+	var progress []int
+	n, err := store.Warm(context.Background(), entries, WithWarmBatchSize(100), WithWarmProgress(func(loaded int) {
+		progress = append(progress, loaded)
+	}))
+	if err != nil || n != 250 {
+		t.Fatalf("expected 250 entries loaded, got %d (%v)", n, err)
+	}
+	if !reflect.DeepEqual(progress, []int{100, 200, 250}) {
+		t.Errorf("unexpected progress %v", progress)
+	}
+	var v int
+	if err := store.Get("warm:249", &v); err != nil || v != 249 {
+		t.Errorf("expected 249, got %d (%v)", v, err)
+	}
+}
+
+func TestRedisStore_WarmFromFile(t *testing.T) {
+	store := newRedisStore(t, time.Hour).(*RedisStore)
+	path := filepath.Join(t.TempDir(), "warm.jsonl")
+	content := `{"key": "a", "value": "alpha", "expires": "1m"}
+{"key": "b", "value": 42}
+{"key": "c", "value": {"nested": true}}
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.WarmFromFile(context.Background(), path); err != nil || n != 3 {
+		t.Fatalf("expected 3 entries loaded, got %d (%v)", n, err)
+	}
+	var s string
+	if err := store.Get("a", &s); err != nil || s != "alpha" {
+		t.Errorf("expected alpha, got %q (%v)", s, err)
+	}
+	if ttl, err := store.GetExpiresIn("a"); err != nil || ttl > time.Minute {
+		t.Errorf("expected a to expire within a minute, got %d (%v)", ttl, err)
+	}
+	if n, err := store.Increment("b", 1); err != nil || n != 43 {
+		t.Errorf("expected 43, got %d (%v)", n, err)
+	}
+	if err := store.Get("c", &s); err != nil || s != `{"nested": true}` {
+		t.Errorf("expected the JSON text, got %q (%v)", s, err)
+	}
+
+	if err := os.WriteFile(path, []byte("{\"key\": \"a\", \"value\": 1}\nnot json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.WarmFromFile(context.Background(), path); err == nil {
+		t.Error("expected an error for the malformed line")
+	}
+}
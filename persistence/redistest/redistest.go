@@ -0,0 +1,31 @@
+// Package redistest provides RedisStores bound to embedded miniredis servers, so tests
+// of code using redis neither need a live server nor share one, and can run in parallel.
+//
+//	func TestProfiles(t *testing.T) {
+//		t.Parallel()
+//		store := redistest.NewStore(t, time.Hour)
+//		...
+//	}
+package redistest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+	"github.com/Bose/cache/persistence"
+	"github.com/alicebob/miniredis/v2"
+)
+
+// NewServer starts a miniredis server for the test, closed when it ends. Its clock
+// follows the wall clock, so keys expire as they would in redis.
+func NewServer(t testing.TB) *miniredis.Miniredis {
+	t.Helper()
+	return miniredistest.Run(t)
+}
+
+// NewStore returns a RedisStore bound to a new server for the test (see NewServer)
+func NewStore(t testing.TB, defaultExpiration time.Duration, opt ...persistence.Option) *persistence.RedisStore {
+	t.Helper()
+	return persistence.NewRedisCache(NewServer(t).Addr(), "", defaultExpiration, opt...)
+}
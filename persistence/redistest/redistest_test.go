@@ -0,0 +1,34 @@
+package redistest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+func TestNewStore(t *testing.T) {
+	t.Parallel()
+	store := NewStore(t, time.Hour)
+	if err := store.Set("key", "value", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := store.Get("key", &v); err != nil || v != "value" {
+		t.Fatalf("expected value, got %q (%v)", v, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if err := store.Get("key", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected the key to expire, got %v", err)
+	}
+}
+
+func TestNewStore_Isolated(t *testing.T) {
+	t.Parallel()
+	a, b := NewStore(t, time.Hour), NewStore(t, time.Hour)
+	a.Set("key", "a", persistence.DEFAULT)
+	var v string
+	if err := b.Get("key", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected the stores not to share a server, got %q (%v)", v, err)
+	}
+}
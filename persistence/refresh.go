@@ -0,0 +1,300 @@
+package persistence
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/internal/memstore"
+)
+
+const (
+	defaultRefreshWorkers = 4
+	defaultRefreshJitter  = 0.5
+)
+
+// refreshedKey is the state of a key the Refresher keeps warm
+type refreshedKey struct {
+	// due is when the key is next reloaded (unix nano), matching its entry in the heap
+	due int64
+	// seen is when the key was last read through GetOrLoad (unix nano), 0 for keys
+	// registered with Register, which are refreshed until unregistered
+	seen int64
+	// loading is set while a worker reloads the key
+	loading bool
+}
+
+// Refresher keeps hot entries warm by reloading them with its loader shortly before
+// they expire (refresh-ahead), on a pool of workers, so reads don't wait for the origin
+// when entries expire. It refreshes the keys registered with Register, and those read
+// through GetOrLoad for as long as they keep being read (see WithRefreshIdle).
+//
+// Reloads are scheduled WithRefreshAhead of the expiry the store reports, plus a
+// random share of that lead (see WithRefreshJitter) so entries loaded at the same time
+// aren't all reloaded at once. The time is told by the clock set WithClock.
+type Refresher struct {
+	store   CacheStore
+	loader  Loader
+	ttl     time.Duration
+	ahead   time.Duration
+	jitter  float64
+	idle    time.Duration
+	workers int
+	clock   Clock
+
+	mu           sync.Mutex
+	keys         map[string]*refreshedKey
+	schedule     memstore.ExpiryHeap
+	onRefreshErr func(key string, err error)
+
+	wake chan struct{}
+	jobs chan string
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewRefresher returns a Refresher caching in store the values loader loads for ttl,
+// and reloading them before they expire until it's closed
+func NewRefresher(store CacheStore, ttl time.Duration, loader Loader, opt ...Option) *Refresher {
+	opts := GetOpts(opt...)
+	r := &Refresher{
+		store:   store,
+		loader:  loader,
+		ttl:     ttl,
+		ahead:   ttl / 10,
+		jitter:  defaultRefreshJitter,
+		idle:    2 * ttl,
+		workers: defaultRefreshWorkers,
+		clock:   newClock(opts),
+		keys:    map[string]*refreshedKey{},
+		wake:    make(chan struct{}, 1),
+		jobs:    make(chan string),
+		stop:    make(chan struct{}),
+	}
+	if v, ok := opts[optionWithRefreshAhead].(time.Duration); ok && v > 0 {
+		r.ahead = v
+	}
+	if v, ok := opts[optionWithRefreshJitter].(float64); ok && v >= 0 && v <= 1 {
+		r.jitter = v
+	}
+	if v, ok := opts[optionWithRefreshIdle].(time.Duration); ok && v > 0 {
+		r.idle = v
+	}
+	if v, ok := opts[optionWithRefreshWorkers].(int); ok && v > 0 {
+		r.workers = v
+	}
+	r.wg.Add(1 + r.workers)
+	go r.run()
+	for i := 0; i < r.workers; i++ {
+		go r.work()
+	}
+	return r
+}
+
+// OnRefreshError sets an (optional) function called when reloading a key fails; the
+// reload is retried while the entry lasts. Pass nil to disable.
+func (r *Refresher) OnRefreshError(f func(key string, err error)) {
+	r.mu.Lock()
+	r.onRefreshErr = f
+	r.mu.Unlock()
+}
+
+// Register makes the Refresher keep keys warm until they're unregistered. Keys that
+// aren't cached are loaded right away.
+func (r *Refresher) Register(keys ...string) {
+	for _, key := range keys {
+		r.track(key, 0)
+	}
+}
+
+// Unregister stops refreshing keys; their entries expire as usual
+func (r *Refresher) Unregister(keys ...string) {
+	r.mu.Lock()
+	for _, key := range keys {
+		delete(r.keys, key)
+	}
+	r.mu.Unlock()
+}
+
+// GetOrLoad reads key into ptr, loading and caching it if it's missing (see
+// CacheStore.GetOrSet), and keeps it warm as long as it's read within the idle time
+func (r *Refresher) GetOrLoad(key string, ptr interface{}) error {
+	_, err := r.store.GetOrSet(key, r.ttl, ptr, func() (interface{}, error) {
+		return r.loader(context.Background(), key)
+	})
+	if err != nil && err != ErrNilValue {
+		return err
+	}
+	r.track(key, r.clock.Now().UnixNano())
+	return err
+}
+
+// Close stops refreshing, waiting for the reloads in progress
+func (r *Refresher) Close() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// track starts refreshing key, or records it was seen if it's already refreshed
+func (r *Refresher) track(key string, seen int64) {
+	r.mu.Lock()
+	if k, ok := r.keys[key]; ok {
+		if k.seen != 0 {
+			k.seen = seen
+		}
+		r.mu.Unlock()
+		return
+	}
+	k := &refreshedKey{seen: seen}
+	r.keys[key] = k
+	r.mu.Unlock()
+
+	due, ok := r.nextRefresh(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keys[key] != k {
+		return
+	}
+	if !ok {
+		// entries that never expire don't need refreshing
+		delete(r.keys, key)
+		return
+	}
+	r.reschedule(key, k, due)
+}
+
+// nextRefresh returns when key should be reloaded, false if never
+func (r *Refresher) nextRefresh(key string) (int64, bool) {
+	now := r.clock.Now()
+	ttl, err := r.store.GetExpiresIn(key)
+	switch err {
+	case nil:
+	case ErrCacheNoTTL:
+		return 0, false
+	case ErrCacheMiss:
+		return now.UnixNano(), true
+	default:
+		// the store can't tell, assume the entry was just written
+		ttl = r.ttl
+	}
+	// the jitter only ever moves reloads earlier, so none happens later than r.ahead
+	// before the expiry
+	lead := r.ahead + time.Duration(rand.Float64()*r.jitter*float64(r.ahead))
+	if lead > ttl/2 {
+		// short lived entries would be reloaded over and over
+		lead = ttl / 2
+	}
+	return now.Add(ttl - lead).UnixNano(), true
+}
+
+// reschedule sets when k is reloaded next, under the lock
+func (r *Refresher) reschedule(key string, k *refreshedKey, due int64) {
+	k.due = due
+	heap.Push(&r.schedule, memstore.Expiry{Key: key, Expiration: due})
+	if r.schedule[0].Key == key && r.schedule[0].Expiration == due {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run hands the keys due to the workers until Close
+func (r *Refresher) run() {
+	defer r.wg.Done()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		key, wait := r.next()
+		if key != "" {
+			select {
+			case r.jobs <- key:
+				continue
+			case <-r.stop:
+				close(r.jobs)
+				return
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-r.stop:
+			close(r.jobs)
+			return
+		case <-timer.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// next pops the next key due, or returns how long until one is
+func (r *Refresher) next() (string, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now().UnixNano()
+	for len(r.schedule) > 0 {
+		e := r.schedule[0]
+		k, ok := r.keys[e.Key]
+		if !ok || k.due != e.Expiration || k.loading {
+			// stale entry: the key was unregistered or rescheduled since
+			heap.Pop(&r.schedule)
+			continue
+		}
+		if e.Expiration > now {
+			return "", time.Duration(e.Expiration - now)
+		}
+		heap.Pop(&r.schedule)
+		if k.seen != 0 && now-k.seen > int64(r.idle) {
+			// not read for a while: let it expire
+			delete(r.keys, e.Key)
+			continue
+		}
+		k.loading = true
+		return e.Key, 0
+	}
+	return "", time.Hour
+}
+
+// work reloads the keys handed by run
+func (r *Refresher) work() {
+	defer r.wg.Done()
+	for key := range r.jobs {
+		err := r.reload(key)
+		due, ok := r.nextRefresh(key)
+		if err != nil {
+			// retry a few times before the entry expires
+			due, ok = r.clock.Now().Add(r.ahead/4).UnixNano(), true
+		}
+
+		r.mu.Lock()
+		f := r.onRefreshErr
+		if k, tracked := r.keys[key]; tracked {
+			k.loading = false
+			if ok {
+				r.reschedule(key, k, due)
+			} else {
+				delete(r.keys, key)
+			}
+		}
+		r.mu.Unlock()
+		if err != nil && f != nil {
+			f(key, err)
+		}
+	}
+}
+
+// reload loads key and caches it
+func (r *Refresher) reload(key string) error {
+	v, err := r.loader(context.Background(), key)
+	if err != nil {
+		return err
+	}
+	return r.store.Set(key, v, r.ttl)
+}
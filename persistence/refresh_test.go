@@ -0,0 +1,139 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefresher(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return int(atomic.AddInt32(&loads, 1)), nil
+	}
+	r := NewRefresher(store, 200*time.Millisecond, loader, WithRefreshAhead(50*time.Millisecond))
+	r.Register("hot")
+
+	// the entry never expires while it's refreshed
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		var n int
+		if err := store.Get("hot", &n); err != nil && atomic.LoadInt32(&loads) > 0 {
+			t.Fatalf("expected the entry kept warm, got %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&loads); n < 5 {
+		t.Errorf("expected the entry reloaded ahead of its expiry, got %d loads", n)
+	}
+
+	r.Unregister("hot")
+	time.Sleep(10 * time.Millisecond)
+	before := atomic.LoadInt32(&loads)
+	time.Sleep(300 * time.Millisecond)
+	if n := atomic.LoadInt32(&loads); n != before {
+		t.Errorf("expected no reloads once unregistered, got %d", n-before)
+	}
+	r.Close()
+}
+
+func TestRefresher_GetOrLoad(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return "value of " + key, nil
+	}
+	r := NewRefresher(store, 100*time.Millisecond, loader, WithRefreshIdle(150*time.Millisecond))
+	defer r.Close()
+
+	var v string
+	if err := r.GetOrLoad("key", &v); err != nil || v != "value of key" {
+		t.Fatalf("unexpected value %q (%v)", v, err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if err := store.Get("key", &v); err != nil {
+		t.Errorf("expected the key read kept warm, got %v", err)
+	}
+	// keys that stop being read are let expire
+	time.Sleep(400 * time.Millisecond)
+	if err := store.Get("key", &v); err != ErrCacheMiss {
+		t.Errorf("expected the idle key to expire, got %v", err)
+	}
+	n := atomic.LoadInt32(&loads)
+	if n < 2 || n > 4 {
+		t.Errorf("expected the key refreshed until idle, got %d loads", n)
+	}
+}
+
+func TestRefresher_Errors(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		if atomic.AddInt32(&loads, 1) > 1 {
+			return nil, errors.New("origin down")
+		}
+		return 1, nil
+	}
+	r := NewRefresher(store, 200*time.Millisecond, loader, WithRefreshAhead(100*time.Millisecond), WithRefreshJitter(0))
+	defer r.Close()
+	failed := make(chan string, 10)
+	r.OnRefreshError(func(key string, err error) { failed <- key })
+
+	r.Register("key")
+	select {
+	case key := <-failed:
+		if key != "key" {
+			t.Errorf("unexpected key %q", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the failed reload reported")
+	}
+	// retried while the entry lasts
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the reload retried")
+	}
+}
+
+func TestRefresher_Clock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	store := NewInMemoryStore(time.Hour, WithClock(clock))
+	defer store.Close()
+	loaded := make(chan struct{}, 10)
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		loaded <- struct{}{}
+		return 1, nil
+	}
+	r := NewRefresher(store, time.Minute, loader,
+		WithRefreshAhead(10*time.Second), WithRefreshJitter(0.5), WithClock(clock))
+	defer r.Close()
+	store.Set("key", 1, time.Minute)
+
+	// the jitter moves reloads earlier, never later than the refresh-ahead time
+	now := clock.Now()
+	for i := 0; i < 100; i++ {
+		due, ok := r.nextRefresh("key")
+		if !ok || due < now.Add(45*time.Second).UnixNano() || due > now.Add(50*time.Second).UnixNano() {
+			t.Fatalf("expected a reload 10 to 15s before the expiry, got %s", time.Unix(0, due).Sub(now))
+		}
+	}
+
+	r.Register("key")
+	select {
+	case <-loaded:
+		t.Fatal("expected no reload before the clock moves")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(50 * time.Second)
+	r.wake <- struct{}{}
+	select {
+	case <-loaded:
+	case <-time.After(time.Second):
+		t.Fatal("expected a reload once the clock reached the refresh time")
+	}
+}
@@ -0,0 +1,263 @@
+// Package ristretto provides a CacheStore backed by dgraph-io/ristretto, which
+// brings TinyLFU admission and contention-free concurrent access to the L1 tier.
+package ristretto
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/dgraph-io/ristretto"
+)
+
+const (
+	optionWithNumCounters      = "optionWithRistrettoNumCounters"
+	optionWithMaxCost          = "optionWithRistrettoMaxCost"
+	optionWithBufferItems      = "optionWithRistrettoBufferItems"
+	optionWithCost             = "optionWithRistrettoCost"
+	optionWithSynchronousWrite = "optionWithRistrettoSynchronousWrite"
+)
+
+// WithNumCounters sets the number of keys whose access frequency is tracked
+// (ristretto recommends 10x the number of items expected when full)
+func WithNumCounters(n int64) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithNumCounters] = n
+	}
+}
+
+// WithMaxCost sets the total cost the store may hold before evicting
+func WithMaxCost(c int64) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithMaxCost] = c
+	}
+}
+
+// WithBufferItems sets the size of ristretto's Get buffers
+func WithBufferItems(n int64) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithBufferItems] = n
+	}
+}
+
+// WithCost sets the function used to compute the cost of a value (every entry costs 1 by default)
+func WithCost(f func(value interface{}) int64) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithCost] = f
+	}
+}
+
+// WithSynchronousWrites makes every write wait until ristretto has applied it, so a
+// Get right after a Set sees the value. This trades write throughput for consistency.
+func WithSynchronousWrites() persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithSynchronousWrite] = true
+	}
+}
+
+// Store represents the cache with ristretto persistence
+type Store struct {
+	cache             *ristretto.Cache
+	defaultExpiration time.Duration
+	cost              func(value interface{}) int64
+	sync              bool
+	// ristretto has no compare-and-set, so operations that read before they
+	// write are serialized with this mutex
+	mu sync.Mutex
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// NewStore returns a Store. By default it tracks 1e6 counters and holds up to 1e5 entries.
+func NewStore(defaultExpiration time.Duration, opt ...persistence.Option) (*Store, error) {
+	opts := persistence.GetOpts(opt...)
+	config := &ristretto.Config{
+		NumCounters: 1e6,
+		MaxCost:     1e5,
+		BufferItems: 64,
+	}
+	if v, ok := opts[optionWithNumCounters].(int64); ok {
+		config.NumCounters = v
+	}
+	if v, ok := opts[optionWithMaxCost].(int64); ok {
+		config.MaxCost = v
+	}
+	if v, ok := opts[optionWithBufferItems].(int64); ok {
+		config.BufferItems = v
+	}
+	s := &Store{
+		defaultExpiration: defaultExpiration,
+		cost:              func(interface{}) int64 { return 1 },
+	}
+	if v, ok := opts[optionWithCost].(func(value interface{}) int64); ok {
+		s.cost = v
+	}
+	if v, ok := opts[optionWithSynchronousWrite].(bool); ok {
+		s.sync = v
+	}
+	c, err := ristretto.NewCache(config)
+	if err != nil {
+		return nil, err
+	}
+	s.cache = c
+	return s, nil
+}
+
+// Close stops ristretto's background goroutines
+func (s *Store) Close() {
+	s.cache.Close()
+}
+
+func (s *Store) ttl(expires time.Duration) time.Duration {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		return 0
+	}
+	if expires < 0 {
+		return 0
+	}
+	return expires
+}
+
+func (s *Store) set(key string, value interface{}, expires time.Duration) error {
+	if !s.cache.SetWithTTL(key, value, s.cost(value), s.ttl(expires)) {
+		// dropped by the admission policy or because the buffers were full
+		return persistence.ErrNotStored
+	}
+	if s.sync {
+		s.cache.Wait()
+	}
+	return nil
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, value interface{}) error {
+	val, found := s.cache.Get(key)
+	if !found {
+		return persistence.ErrCacheMiss
+	}
+	if utils.IsNil(val) {
+		return persistence.ErrNilValue
+	}
+	v := reflect.ValueOf(value)
+	if v.Type().Kind() == reflect.Ptr && v.Elem().CanSet() {
+		v.Elem().Set(reflect.ValueOf(val))
+		return nil
+	}
+	return persistence.ErrNotStored
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	return s.set(key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.cache.Get(key); found {
+		return persistence.ErrNotStored
+	}
+	return s.set(key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.cache.Get(key); !found {
+		return persistence.ErrNotStored
+	}
+	return s.set(key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	if _, found := s.cache.Get(key); !found {
+		return persistence.ErrCacheMiss
+	}
+	s.cache.Del(key)
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, n uint64) (uint64, error) {
+	return s.add(key, func(cur uint64) uint64 { return cur + n }, func(cur int64) int64 { return cur + int64(n) })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, n uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(key,
+		func(cur uint64) uint64 {
+			if cur > n {
+				return cur - n
+			}
+			return 0
+		},
+		func(cur int64) int64 {
+			if cur > int64(n) {
+				return cur - int64(n)
+			}
+			return 0
+		})
+}
+
+// add applies op to the integer stored under key, keeping the stored type and remaining TTL
+func (s *Store) add(key string, uop func(uint64) uint64, iop func(int64) int64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, found := s.cache.Get(key)
+	if !found {
+		return 0, persistence.ErrCacheMiss
+	}
+	ttl, _ := s.cache.GetTTL(key)
+	v := reflect.ValueOf(val)
+	nv := reflect.New(v.Type()).Elem()
+	var result uint64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		nv.SetInt(iop(v.Int()))
+		result = uint64(nv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		nv.SetUint(uop(v.Uint()))
+		result = nv.Uint()
+	default:
+		return 0, persistence.ErrNotInteger
+	}
+	if ttl <= 0 {
+		ttl = persistence.FOREVER
+	}
+	if err := s.set(key, nv.Interface(), ttl); err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	ttl, found := s.cache.GetTTL(key)
+	if !found {
+		return 0, persistence.ErrCacheMiss
+	}
+	if ttl == 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return ttl, nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	s.cache.Clear()
+	return nil
+}
@@ -0,0 +1,428 @@
+// Package s3 provides a CacheStore on top of an S3 bucket, meant for very
+// large cached artifacts that would not fit comfortably in Redis or memcached.
+//
+// Every object carries its expiration in the "cache-expires-at" metadata
+// (unix seconds) and reads treat expired objects as missing. S3 cannot expire
+// objects at a precise time, so reclaiming storage is left to a bucket
+// lifecycle rule on the store's prefix that expires objects after more days
+// than the longest TTL in use.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	metaExpiresAt = "cache-expires-at"
+
+	// S3 limits
+	maxDeleteObjects = 1000
+	maxCASRetries    = 10
+)
+
+// API is the subset of the S3 client used by the store, satisfied by *s3.Client
+type API interface {
+	GetObject(ctx context.Context, params *awss3.GetObjectInput, optFns ...func(*awss3.Options)) (*awss3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *awss3.HeadObjectInput, optFns ...func(*awss3.Options)) (*awss3.HeadObjectOutput, error)
+	PutObject(ctx context.Context, params *awss3.PutObjectInput, optFns ...func(*awss3.Options)) (*awss3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context, params *awss3.DeleteObjectInput, optFns ...func(*awss3.Options)) (*awss3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *awss3.DeleteObjectsInput, optFns ...func(*awss3.Options)) (*awss3.DeleteObjectsOutput, error)
+	ListObjectsV2(ctx context.Context, params *awss3.ListObjectsV2Input, optFns ...func(*awss3.Options)) (*awss3.ListObjectsV2Output, error)
+}
+
+// Store represents the cache with S3 persistence
+type Store struct {
+	client            API
+	bucket            string
+	prefix            string
+	defaultExpiration time.Duration
+}
+
+var _ persistence.CacheStore = &Store{}
+
+const optionWithPrefix = "optionWithS3Prefix"
+
+// WithPrefix stores every object under the prefix, which also scopes Flush
+func WithPrefix(prefix string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithPrefix] = prefix
+	}
+}
+
+// NewStore returns a Store using the bucket through the provided client
+func NewStore(client API, bucket string, defaultExpiration time.Duration, opt ...persistence.Option) *Store {
+	opts := persistence.GetOpts(opt...)
+	s := &Store{client: client, bucket: bucket, defaultExpiration: defaultExpiration}
+	if v, ok := opts[optionWithPrefix].(string); ok {
+		s.prefix = v
+	}
+	return s
+}
+
+func (s *Store) objectKey(key string) *string {
+	return aws.String(s.prefix + key)
+}
+
+// expiresAt returns the expiration in unix seconds, 0 when the object never expires
+func (s *Store) expiresAt(expires time.Duration) int64 {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return time.Now().Add(expires + time.Second - 1).Unix()
+}
+
+func (s *Store) putInput(key string, body io.Reader, expiresAt int64) *awss3.PutObjectInput {
+	in := &awss3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+		Body:   body,
+	}
+	if expiresAt > 0 {
+		in.Metadata = map[string]string{metaExpiresAt: strconv.FormatInt(expiresAt, 10)}
+		in.Expires = aws.Time(time.Unix(expiresAt, 0))
+	}
+	return in
+}
+
+// live reports whether the object metadata has not expired yet, and the expiration
+func live(meta map[string]string) (bool, int64) {
+	v, ok := meta[metaExpiresAt]
+	if !ok {
+		return true, 0
+	}
+	expiresAt, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return true, 0
+	}
+	return expiresAt == 0 || expiresAt >= time.Now().Unix(), expiresAt
+}
+
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func isNotFound(err error) bool {
+	switch errorCode(err) {
+	case "NoSuchKey", "NotFound":
+		return true
+	}
+	return false
+}
+
+// isConflict reports whether a conditional write lost against another writer
+func isConflict(err error) bool {
+	switch errorCode(err) {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
+// head returns the ETag of the object if it exists and has not expired
+func (s *Store) head(key string) (string, bool, error) {
+	out, err := s.client.HeadObject(context.Background(), &awss3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+	})
+	if isNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	ok, _ := live(out.Metadata)
+	return aws.ToString(out.ETag), ok, nil
+}
+
+// GetReader returns the raw object body for streaming large values without
+// buffering them. The caller must close the reader.
+func (s *Store) GetReader(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.Background(), &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+	})
+	if isNotFound(err) {
+		return nil, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ok, _ := live(out.Metadata); !ok {
+		out.Body.Close()
+		return nil, persistence.ErrCacheMiss
+	}
+	return out.Body, nil
+}
+
+// SetReader streams r into the object unchanged (no serialization). The size
+// must be the exact number of bytes r yields, so that unseekable readers can be
+// uploaded without being buffered in memory.
+func (s *Store) SetReader(key string, r io.Reader, size int64, expires time.Duration) error {
+	in := s.putInput(key, r, s.expiresAt(expires))
+	in.ContentLength = aws.Int64(size)
+	_, err := s.client.PutObject(context.Background(), in)
+	return err
+}
+
+func (s *Store) getBytes(key string) ([]byte, string, int64, error) {
+	out, err := s.client.GetObject(context.Background(), &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+	})
+	if isNotFound(err) {
+		return nil, "", 0, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer out.Body.Close()
+	ok, expiresAt := live(out.Metadata)
+	if !ok {
+		return nil, "", 0, persistence.ErrCacheMiss
+	}
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return b, aws.ToString(out.ETag), expiresAt, nil
+}
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, ptrValue interface{}) error {
+	b, _, _, err := s.getBytes(key)
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(b, ptrValue)
+}
+
+// Mget retrieves a list of items for the list of keys provided, one GetObject
+// per key since S3 has no batch read. If any item does not exist, an ErrCacheMiss is returned.
+func (s *Store) Mget(ptrValue []interface{}, keys ...string) error {
+	if len(ptrValue) != len(keys) {
+		return &persistence.ValueCountError{Got: len(ptrValue), Required: len(keys)}
+	}
+	for i, key := range keys {
+		if err := s.Get(key, ptrValue[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(context.Background(), s.putInput(key, bytes.NewReader(b), s.expiresAt(expires)))
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	in := s.putInput(key, bytes.NewReader(b), s.expiresAt(expires))
+	in.IfNoneMatch = aws.String("*")
+	_, err = s.client.PutObject(context.Background(), in)
+	if !isConflict(err) {
+		return err
+	}
+	// the object exists, but it may have expired without being reclaimed yet
+	etag, ok, err := s.head(key)
+	if err != nil {
+		return err
+	}
+	if ok || etag == "" {
+		return persistence.ErrNotStored
+	}
+	in = s.putInput(key, bytes.NewReader(b), s.expiresAt(expires))
+	in.IfMatch = aws.String(etag)
+	_, err = s.client.PutObject(context.Background(), in)
+	if isConflict(err) {
+		return persistence.ErrNotStored
+	}
+	return err
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	etag, ok, err := s.head(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return persistence.ErrNotStored
+	}
+	in := s.putInput(key, bytes.NewReader(b), s.expiresAt(expires))
+	in.IfMatch = aws.String(etag)
+	_, err = s.client.PutObject(context.Background(), in)
+	if isConflict(err) {
+		return persistence.ErrNotStored
+	}
+	return err
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	etag, ok, err := s.head(key)
+	if err != nil {
+		return err
+	}
+	if etag == "" {
+		return persistence.ErrCacheMiss
+	}
+	// expired objects are removed as well, but still reported as a miss
+	if _, err := s.client.DeleteObject(context.Background(), &awss3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+	}); err != nil {
+		return err
+	}
+	if !ok {
+		return persistence.ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(cur uint64) uint64 { return cur + delta })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(key, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// add applies op to the integer stored under key with a conditional write on
+// the ETag it read, keeping the object's expiration
+func (s *Store) add(key string, op func(uint64) uint64) (uint64, error) {
+	for i := 0; i < maxCASRetries; i++ {
+		b, etag, expiresAt, err := s.getBytes(key)
+		if err != nil {
+			return 0, err
+		}
+		cur, err := strconv.ParseUint(string(b), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		result := op(cur)
+		in := s.putInput(key, bytes.NewReader([]byte(strconv.FormatUint(result, 10))), expiresAt)
+		in.IfMatch = aws.String(etag)
+		_, err = s.client.PutObject(context.Background(), in)
+		if isConflict(err) {
+			// somebody else wrote in between, try again
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return result, nil
+	}
+	return 0, persistence.ErrNotStored
+}
+
+// GetExpiresIn (see CacheStore interface), from the object metadata. Expirations
+// have second granularity.
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	out, err := s.client.HeadObject(context.Background(), &awss3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.objectKey(key),
+	})
+	if isNotFound(err) {
+		return 0, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return 0, err
+	}
+	ok, expiresAt := live(out.Metadata)
+	if !ok {
+		return 0, persistence.ErrCacheMiss
+	}
+	if expiresAt == 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Until(time.Unix(expiresAt, 0)), nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush deletes every object under the store's prefix, or the whole bucket without one
+func (s *Store) Flush() error {
+	in := &awss3.ListObjectsV2Input{Bucket: aws.String(s.bucket)}
+	if s.prefix != "" {
+		in.Prefix = aws.String(s.prefix)
+	}
+	for {
+		out, err := s.client.ListObjectsV2(context.Background(), in)
+		if err != nil {
+			return err
+		}
+		for start := 0; start < len(out.Contents); start += maxDeleteObjects {
+			end := start + maxDeleteObjects
+			if end > len(out.Contents) {
+				end = len(out.Contents)
+			}
+			ids := make([]types.ObjectIdentifier, 0, end-start)
+			for _, obj := range out.Contents[start:end] {
+				ids = append(ids, types.ObjectIdentifier{Key: obj.Key})
+			}
+			res, err := s.client.DeleteObjects(context.Background(), &awss3.DeleteObjectsInput{
+				Bucket: aws.String(s.bucket),
+				Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+			})
+			if err != nil {
+				return err
+			}
+			if len(res.Errors) > 0 {
+				e := res.Errors[0]
+				return fmt.Errorf("s3: deleting %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+			}
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return nil
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+}
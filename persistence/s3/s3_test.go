@@ -0,0 +1,210 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+type fakeObject struct {
+	body []byte
+	meta map[string]string
+	etag string
+}
+
+// fakeS3 keeps objects in a map and honours If-Match / If-None-Match on PutObject
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string]fakeObject
+	version int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string]fakeObject{}}
+}
+
+var errPreconditionFailed = &smithy.GenericAPIError{Code: "PreconditionFailed"}
+
+func (f *fakeS3) GetObject(_ context.Context, in *awss3.GetObjectInput, _ ...func(*awss3.Options)) (*awss3.GetObjectOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	obj, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &awss3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(obj.body)), Metadata: obj.meta, ETag: aws.String(obj.etag)}, nil
+}
+
+func (f *fakeS3) HeadObject(_ context.Context, in *awss3.HeadObjectInput, _ ...func(*awss3.Options)) (*awss3.HeadObjectOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	obj, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &awss3.HeadObjectOutput{Metadata: obj.meta, ETag: aws.String(obj.etag)}, nil
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *awss3.PutObjectInput, _ ...func(*awss3.Options)) (*awss3.PutObjectOutput, error) {
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.Lock()
+	defer f.Unlock()
+	key := aws.ToString(in.Key)
+	old, exists := f.objects[key]
+	if in.IfNoneMatch != nil && exists {
+		return nil, errPreconditionFailed
+	}
+	if in.IfMatch != nil && (!exists || old.etag != *in.IfMatch) {
+		return nil, errPreconditionFailed
+	}
+	f.version++
+	f.objects[key] = fakeObject{body: body, meta: in.Metadata, etag: strconv.Itoa(f.version)}
+	return &awss3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(_ context.Context, in *awss3.DeleteObjectInput, _ ...func(*awss3.Options)) (*awss3.DeleteObjectOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.objects, aws.ToString(in.Key))
+	return &awss3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(_ context.Context, in *awss3.DeleteObjectsInput, _ ...func(*awss3.Options)) (*awss3.DeleteObjectsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	for _, id := range in.Delete.Objects {
+		delete(f.objects, aws.ToString(id.Key))
+	}
+	return &awss3.DeleteObjectsOutput{}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(_ context.Context, in *awss3.ListObjectsV2Input, _ ...func(*awss3.Options)) (*awss3.ListObjectsV2Output, error) {
+	f.Lock()
+	defer f.Unlock()
+	out := &awss3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for k := range f.objects {
+		if strings.HasPrefix(k, aws.ToString(in.Prefix)) {
+			out.Contents = append(out.Contents, types.Object{Key: aws.String(k)})
+		}
+	}
+	return out, nil
+}
+
+func newStore(defaultExpiration time.Duration, opt ...persistence.Option) *Store {
+	return NewStore(newFakeS3(), "cache", defaultExpiration, opt...)
+}
+
+func TestS3_TypicalGetSet(t *testing.T) {
+	s := newStore(time.Hour)
+	if err := s.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := s.Get("value", &value); err != nil {
+		t.Errorf("Error getting a value: %s", err)
+	}
+	if value != "foo" {
+		t.Errorf("Expected to get foo back, got %s", value)
+	}
+}
+
+func TestS3_Streaming(t *testing.T) {
+	s := newStore(time.Hour)
+	payload := bytes.Repeat([]byte("artifact"), 1<<12)
+	if err := s.SetReader("blob", bytes.NewReader(payload), int64(len(payload)), persistence.DEFAULT); err != nil {
+		t.Fatalf("Error streaming a value: %s", err)
+	}
+	r, err := s.GetReader("blob")
+	if err != nil {
+		t.Fatalf("Error getting a reader: %s", err)
+	}
+	defer r.Close()
+	got, _ := io.ReadAll(r)
+	if !bytes.Equal(got, payload) {
+		t.Errorf("streamed payload differs, got %d bytes", len(got))
+	}
+	if _, err := s.GetReader("missing"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestS3_IncrDecr(t *testing.T) {
+	s := newStore(time.Hour)
+	if _, err := s.Increment("int", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("int", 10, persistence.DEFAULT)
+	if n, err := s.Increment("int", 50); err != nil || n != 60 {
+		t.Errorf("Expected 60, was %d (%v)", n, err)
+	}
+	if n, err := s.Decrement("int", 100); err != nil || n != 0 {
+		t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+	}
+}
+
+func TestS3_AddReplaceDelete(t *testing.T) {
+	s := newStore(time.Hour)
+	if err := s.Replace("key", 1, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, persistence.DEFAULT); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Replace("key", 3, persistence.DEFAULT); err != nil {
+		t.Errorf("Error replacing: %s", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := s.Delete("key"); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestS3_ExpiredObjectsAreMisses(t *testing.T) {
+	s := newStore(time.Hour)
+	// objects the lifecycle rule hasn't reclaimed yet must still read as missing
+	s.client.(*fakeS3).objects["expired"] = fakeObject{
+		body: []byte("1"),
+		meta: map[string]string{metaExpiresAt: strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)},
+		etag: "old",
+	}
+	var v int
+	if err := s.Get("expired", &v); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := s.Add("expired", 2, persistence.DEFAULT); err != nil {
+		t.Errorf("expected Add to overwrite an expired object: %s", err)
+	}
+}
+
+func TestS3_FlushPrefix(t *testing.T) {
+	fake := newFakeS3()
+	s := NewStore(fake, "cache", time.Hour, WithPrefix("cache/"))
+	fake.objects["other/key"] = fakeObject{body: []byte("x")}
+	s.Set("a", 1, persistence.DEFAULT)
+	s.Set("b", 2, persistence.DEFAULT)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if len(fake.objects) != 1 {
+		t.Errorf("expected only the object outside the prefix to remain, got %d", len(fake.objects))
+	}
+}
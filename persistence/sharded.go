@@ -0,0 +1,193 @@
+package persistence
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// multiGetter is implemented by the stores fetching several keys in a round trip
+type multiGetter interface {
+	Mget(ptrValue []interface{}, keys ...string) error
+}
+
+// ShardedStore spreads keys over several stores with rendezvous hashing, so adding or
+// removing a shard only moves the keys of that shard. Batch operations fan out one
+// sub-batch per shard concurrently, so they take as long as the slowest shard rather
+// than the sum of them.
+type ShardedStore struct {
+	shards []CacheStore
+}
+
+var _ CacheStore = &ShardedStore{}
+
+// NewShardedStore returns a ShardedStore over shards, which must be given in the same
+// order by every process sharing them. It returns ErrInvalidArgument without shards.
+func NewShardedStore(shards ...CacheStore) (*ShardedStore, error) {
+	if len(shards) == 0 {
+		return nil, ErrInvalidArgument
+	}
+	return &ShardedStore{shards: shards}, nil
+}
+
+// shardOf returns the index of the shard holding key: the one scoring highest for it
+func (s *ShardedStore) shardOf(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	keyHash := h.Sum64()
+	best, bestScore := 0, uint64(0)
+	for i := range s.shards {
+		if score := mix64(keyHash ^ uint64(i+1)*0x9e3779b97f4a7c15); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// mix64 is the splitmix64 finalizer, spreading close inputs over the whole range
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Shard returns the store holding key
+func (s *ShardedStore) Shard(key string) CacheStore {
+	return s.shards[s.shardOf(key)]
+}
+
+// Get (see CacheStore interface)
+func (s *ShardedStore) Get(key string, value interface{}) error {
+	return s.Shard(key).Get(key, value)
+}
+
+// Set (see CacheStore interface)
+func (s *ShardedStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.Shard(key).Set(key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *ShardedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.Shard(key).Add(key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *ShardedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.Shard(key).Replace(key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *ShardedStore) Delete(key string) error {
+	return s.Shard(key).Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (s *ShardedStore) Increment(key string, delta uint64) (uint64, error) {
+	return s.Shard(key).Increment(key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (s *ShardedStore) Decrement(key string, delta uint64) (uint64, error) {
+	return s.Shard(key).Decrement(key, delta)
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *ShardedStore) GetExpiresIn(key string) (time.Duration, error) {
+	return s.Shard(key).GetExpiresIn(key)
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *ShardedStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush flushes every shard concurrently
+func (s *ShardedStore) Flush() error {
+	var g errgroup.Group
+	for _, shard := range s.shards {
+		shard := shard
+		g.Go(shard.Flush)
+	}
+	return g.Wait()
+}
+
+// group returns the indexes of keys per shard, in key order
+func (s *ShardedStore) group(keys []string) map[int][]int {
+	groups := map[int][]int{}
+	for i, key := range keys {
+		shard := s.shardOf(key)
+		groups[shard] = append(groups[shard], i)
+	}
+	return groups
+}
+
+// Mget retrieves the items of keys into ptrValue, in key order, with a sub-batch per
+// shard fetched concurrently, through the shard's own Mget if it has one. Like the
+// stores' Mget, it fails if an item doesn't exist.
+func (s *ShardedStore) Mget(ptrValue []interface{}, keys ...string) error {
+	if len(ptrValue) != len(keys) {
+		return &ValueCountError{Got: len(ptrValue), Required: len(keys)}
+	}
+	g, ctx := errgroup.WithContext(context.Background())
+	for shard, idx := range s.group(keys) {
+		store, idx := s.shards[shard], idx
+		g.Go(func() error {
+			subKeys := make([]string, len(idx))
+			subPtrs := make([]interface{}, len(idx))
+			for j, i := range idx {
+				subKeys[j], subPtrs[j] = keys[i], ptrValue[i]
+			}
+			if m, ok := store.(multiGetter); ok {
+				return m.Mget(subPtrs, subKeys...)
+			}
+			for j, key := range subKeys {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err := store.Get(key, subPtrs[j]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// MSet sets the items of kv, a list of key value pairs: k1, v1, k2, v2..., with a
+// sub-batch per shard written concurrently. Unlike MSetNX, it's not atomic: when it
+// fails, some items may have been set.
+func (s *ShardedStore) MSet(expires time.Duration, kv ...interface{}) error {
+	if len(kv)%2 != 0 {
+		return &ValueCountError{Got: len(kv) / 2, Required: len(kv)/2 + 1}
+	}
+	keys := make([]string, 0, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		k, ok := kv[i].(string)
+		if !ok {
+			return &KeyTypeError{Index: i, Key: kv[i]}
+		}
+		keys = append(keys, k)
+	}
+	g, ctx := errgroup.WithContext(context.Background())
+	for shard, idx := range s.group(keys) {
+		store, idx := s.shards[shard], idx
+		g.Go(func() error {
+			for _, i := range idx {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err := store.Set(keys[i], kv[2*i+1], expires); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
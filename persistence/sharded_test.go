@@ -0,0 +1,90 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// slowGetStore delays every Get, to tell concurrent shards from sequential ones
+type slowGetStore struct {
+	CacheStore
+	delay time.Duration
+}
+
+func (s slowGetStore) Get(key string, value interface{}) error {
+	time.Sleep(s.delay)
+	return s.CacheStore.Get(key, value)
+}
+
+func TestShardedStore(t *testing.T) {
+	server := newRedisServer(t)
+	redisShard := NewRedisCache(server, "", time.Hour)
+	memShards := []*InMemoryStore{NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)}
+	store, err := NewShardedStore(redisShard, slowGetStore{memShards[0], 20 * time.Millisecond}, slowGetStore{memShards[1], 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 30
+	keys := make([]string, n)
+	kv := make([]interface{}, 0, 2*n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		kv = append(kv, keys[i], i)
+	}
+	if err := store.MSet(DEFAULT, kv...); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[CacheStore]int{}
+	for _, key := range keys {
+		counts[store.Shard(key)]++
+	}
+	if len(counts) != 3 {
+		t.Errorf("expected the keys spread over the 3 shards, got %v", counts)
+	}
+
+	values := make([]int, n)
+	ptrs := make([]interface{}, n)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	start := time.Now()
+	if err := store.Mget(ptrs, keys...); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range values {
+		if v != i {
+			t.Errorf("expected %d at %d, got %d", i, i, v)
+		}
+	}
+	// each slow shard costs 20ms per key, so about n/3*20ms if the shards run concurrently
+	if elapsed, max := time.Since(start), time.Duration(counts[store.shards[1]]+counts[store.shards[2]])*20*time.Millisecond; elapsed >= max {
+		t.Errorf("expected the shards fetched concurrently, took %v", elapsed)
+	}
+
+	var v int
+	if err := store.Get(keys[0], &v); err != nil || v != 0 {
+		t.Errorf("expected 0, got %d (%v)", v, err)
+	}
+	store.Delete(keys[1])
+	if err := store.Mget(ptrs, keys...); err == nil {
+		t.Error("expected the missing key to fail the Mget")
+	}
+	if err := store.MSet(DEFAULT, 1, "one"); err == nil {
+		t.Error("expected a KeyTypeError")
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Get(keys[0], &v); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss after Flush, got %v", err)
+	}
+}
+
+func TestShardedStore_NoShards(t *testing.T) {
+	if _, err := NewShardedStore(); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected ErrInvalidArgument without shards, got %v", err)
+	}
+}
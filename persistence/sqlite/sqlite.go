@@ -0,0 +1,287 @@
+// Package sqlite provides a CacheStore on top of a single SQLite table, for CLI
+// tools and desktop agents that want the CacheStore contract without running
+// any server. Expired rows are never returned and are removed periodically.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/utils"
+	// registers the sqlite3 driver used by Open
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	optionWithTableName      = "optionWithSQLiteTableName"
+	optionWithVacuumInterval = "optionWithSQLiteVacuumInterval"
+)
+
+// WithTableName sets the table used by the store (the default is "cache")
+func WithTableName(name string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithTableName] = name
+	}
+}
+
+// WithVacuumInterval sets how often expired rows are deleted and the file is
+// vacuumed (the default is every 10 minutes, <= 0 disables it)
+func WithVacuumInterval(d time.Duration) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithVacuumInterval] = d
+	}
+}
+
+// Store represents the cache with SQLite persistence
+type Store struct {
+	db                *sql.DB
+	table             string
+	defaultExpiration time.Duration
+	stop              chan struct{}
+	closeOnce         sync.Once
+}
+
+var _ persistence.CacheStore = &Store{}
+
+// Open opens (or creates) the SQLite file at path and returns a Store. Writes
+// are serialized over a single connection since SQLite only allows one writer.
+func Open(path string, defaultExpiration time.Duration, opt ...persistence.Option) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	s, err := NewStore(db, defaultExpiration, opt...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewStore returns a Store using the provided SQLite database, creating the table if needed
+func NewStore(db *sql.DB, defaultExpiration time.Duration, opt ...persistence.Option) (*Store, error) {
+	opts := persistence.GetOpts(opt...)
+	s := &Store{db: db, table: "cache", defaultExpiration: defaultExpiration, stop: make(chan struct{})}
+	if v, ok := opts[optionWithTableName].(string); ok {
+		s.table = v
+	}
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]q (
+		key TEXT PRIMARY KEY,
+		value BLOB NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS %[2]q ON %[1]q (expires_at) WHERE expires_at > 0;`, s.table, s.table+"_expires_at"))
+	if err != nil {
+		return nil, err
+	}
+	interval := 10 * time.Minute
+	if v, ok := opts[optionWithVacuumInterval].(time.Duration); ok {
+		interval = v
+	}
+	if interval > 0 {
+		go s.vacuum(interval)
+	}
+	return s, nil
+}
+
+// Close stops the periodic vacuum and closes the database
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	return s.db.Close()
+}
+
+func (s *Store) vacuum(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if n, err := s.DeleteExpired(); err == nil && n > 0 {
+				_, _ = s.db.Exec("VACUUM")
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// DeleteExpired removes every expired row and returns how many were removed
+func (s *Store) DeleteExpired() (int64, error) {
+	res, err := s.db.Exec(s.query("DELETE FROM %q WHERE expires_at > 0 AND expires_at <= ?"), time.Now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) query(q string) string {
+	return fmt.Sprintf(q, s.table)
+}
+
+func (s *Store) expiresAt(expires time.Duration) int64 {
+	switch expires {
+	case persistence.DEFAULT:
+		expires = s.defaultExpiration
+	case persistence.FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return time.Now().Add(expires).UnixNano()
+}
+
+// live is the condition matching unexpired rows, it takes the current time as parameter
+const live = "(expires_at = 0 OR expires_at > ?)"
+
+// Get (see CacheStore interface)
+func (s *Store) Get(key string, value interface{}) error {
+	var b []byte
+	err := s.db.QueryRow(s.query("SELECT value FROM %q WHERE key = ? AND "+live), key, time.Now().UnixNano()).Scan(&b)
+	if err == sql.ErrNoRows {
+		return persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (s *Store) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.query(`INSERT INTO %q (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`),
+		key, b, s.expiresAt(expires))
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *Store) Add(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	// an expired row counts as missing, so it may be overwritten
+	res, err := s.db.Exec(s.query(`INSERT INTO %[1]q (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at
+		WHERE %[1]q.expires_at > 0 AND %[1]q.expires_at <= ?`),
+		key, b, s.expiresAt(expires), time.Now().UnixNano())
+	return notStored(res, err)
+}
+
+// Replace (see CacheStore interface)
+func (s *Store) Replace(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(s.query("UPDATE %q SET value = ?, expires_at = ? WHERE key = ? AND "+live),
+		b, s.expiresAt(expires), key, time.Now().UnixNano())
+	return notStored(res, err)
+}
+
+// notStored maps a write that affected no rows to ErrNotStored
+func notStored(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return persistence.ErrNotStored
+	}
+	return nil
+}
+
+// Delete (see CacheStore interface)
+func (s *Store) Delete(key string) error {
+	res, err := s.db.Exec(s.query("DELETE FROM %q WHERE key = ? AND "+live), key, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return persistence.ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (s *Store) Increment(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(cur uint64) uint64 { return cur + delta })
+}
+
+// Decrement (see CacheStore interface)
+func (s *Store) Decrement(key string, delta uint64) (uint64, error) {
+	// Decrement stops at 0 on underflow
+	return s.add(key, func(cur uint64) uint64 {
+		if cur > delta {
+			return cur - delta
+		}
+		return 0
+	})
+}
+
+// add applies op to the integer stored under key in a transaction, keeping its expiration
+func (s *Store) add(key string, op func(uint64) uint64) (uint64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var b []byte
+	err = tx.QueryRow(s.query("SELECT value FROM %q WHERE key = ? AND "+live), key, time.Now().UnixNano()).Scan(&b)
+	if err == sql.ErrNoRows {
+		return 0, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return 0, err
+	}
+	cur, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	result := op(cur)
+	if _, err := tx.Exec(s.query("UPDATE %q SET value = ? WHERE key = ?"), []byte(strconv.FormatUint(result, 10)), key); err != nil {
+		return 0, err
+	}
+	return result, tx.Commit()
+}
+
+// GetExpiresIn (see CacheStore interface)
+func (s *Store) GetExpiresIn(key string) (time.Duration, error) {
+	now := time.Now().UnixNano()
+	var expiresAt int64
+	err := s.db.QueryRow(s.query("SELECT expires_at FROM %q WHERE key = ? AND "+live), key, now).Scan(&expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, persistence.ErrCacheMiss
+	}
+	if err != nil {
+		return 0, err
+	}
+	if expiresAt == 0 {
+		return 0, persistence.ErrCacheNoTTL
+	}
+	return time.Duration(expiresAt - now), nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *Store) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return persistence.GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *Store) Flush() error {
+	_, err := s.db.Exec(s.query("DELETE FROM %q"))
+	return err
+}
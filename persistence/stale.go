@@ -0,0 +1,193 @@
+package persistence
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/utils"
+)
+
+const defaultStaleTTL = time.Minute
+
+// Loader loads the current value for a key from the origin
+type Loader func(ctx context.Context, key string) (interface{}, error)
+
+// StaleStore serves entries past their expiration for a while (stale-while-revalidate):
+// every entry carries a soft expiry inside its payload and is kept by the wrapped store
+// for the stale TTL past it. A Get after the soft expiry returns the stale value right
+// away and refreshes it in the background with the loader; only once the stale TTL has
+// passed as well is it a miss.
+//
+// Values are stored serialized behind a small header, so Increment and Decrement aren't
+// supported and the keys should only be read through the StaleStore.
+type StaleStore struct {
+	store             CacheStore
+	loader            Loader
+	defaultExpiration time.Duration
+	staleTTL          time.Duration
+	ttlPolicy         ttlPolicy
+	clock             Clock
+
+	mu           sync.Mutex
+	refreshing   map[string]struct{}
+	onRefreshErr func(key string, err error)
+}
+
+var _ CacheStore = &StaleStore{}
+
+// NewStaleStore returns a StaleStore wrapping store, refreshing stale entries with loader
+func NewStaleStore(store CacheStore, defaultExpiration time.Duration, loader Loader, opt ...Option) *StaleStore {
+	opts := GetOpts(opt...)
+	s := &StaleStore{
+		store:             store,
+		loader:            loader,
+		defaultExpiration: defaultExpiration,
+		staleTTL:          defaultStaleTTL,
+		refreshing:        map[string]struct{}{},
+	}
+	if v, ok := opts[optionWithStaleTTL].(time.Duration); ok && v >= 0 {
+		s.staleTTL = v
+	}
+	s.ttlPolicy = newTTLPolicy(opts)
+	s.clock = newClock(opts)
+	return s
+}
+
+// OnRefreshError sets an (optional) function called when a background refresh fails;
+// the stale value stays in place until its stale TTL runs out. Pass nil to disable.
+func (s *StaleStore) OnRefreshError(f func(key string, err error)) {
+	s.mu.Lock()
+	s.onRefreshErr = f
+	s.mu.Unlock()
+}
+
+// entries are stored as the soft expiry (unix nano, 0 for never) and the expiration
+// they were set with, both 8 byte big endian, followed by the serialized value
+func (s *StaleStore) encode(key string, value interface{}, expires time.Duration) ([]byte, time.Duration, error) {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return nil, 0, err
+	}
+	entry := make([]byte, 16+len(b))
+	copy(entry[16:], b)
+	binary.BigEndian.PutUint64(entry[8:], uint64(expires))
+	if expires == DEFAULT {
+		var jitter ttlJitter
+		expires, jitter = s.ttlPolicy.resolve(key, s.defaultExpiration, 0)
+		expires = jitter.apply(expires)
+	}
+	if expires <= 0 {
+		return entry, FOREVER, nil
+	}
+	binary.BigEndian.PutUint64(entry, uint64(s.clock.Now().Add(expires).UnixNano()))
+	return entry, expires + s.ttlPolicy.staleTTL(key, s.staleTTL), nil
+}
+
+func (s *StaleStore) write(op func(string, interface{}, time.Duration) error, key string, value interface{}, expires time.Duration) error {
+	entry, hard, err := s.encode(key, value, expires)
+	if err != nil {
+		return err
+	}
+	return op(key, entry, hard)
+}
+
+// Get (see CacheStore interface)
+func (s *StaleStore) Get(key string, value interface{}) error {
+	var entry []byte
+	if err := s.store.Get(key, &entry); err != nil {
+		return err
+	}
+	if len(entry) < 16 {
+		return ErrCacheMiss
+	}
+	if soft := int64(binary.BigEndian.Uint64(entry)); soft > 0 && s.clock.Now().UnixNano() > soft {
+		s.refresh(key, time.Duration(binary.BigEndian.Uint64(entry[8:])))
+	}
+	return utils.Deserialize(entry[16:], value)
+}
+
+// refresh reloads the key in the background, once at a time per key
+func (s *StaleStore) refresh(key string, expires time.Duration) {
+	s.mu.Lock()
+	if _, ok := s.refreshing[key]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.refreshing[key] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		value, err := s.loader(context.Background(), key)
+		if err == nil {
+			err = s.Set(key, value, expires)
+		}
+		s.mu.Lock()
+		delete(s.refreshing, key)
+		f := s.onRefreshErr
+		s.mu.Unlock()
+		if err != nil && f != nil {
+			f(key, err)
+		}
+	}()
+}
+
+// Set (see CacheStore interface)
+func (s *StaleStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.write(s.store.Set, key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *StaleStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.write(s.store.Add, key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *StaleStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.write(s.store.Replace, key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *StaleStore) Delete(key string) error {
+	return s.store.Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (s *StaleStore) Increment(key string, delta uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+// Decrement (see CacheStore interface)
+func (s *StaleStore) Decrement(key string, delta uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+// GetExpiresIn returns the time left before the entry at key goes stale, 0 once it is
+// stale but still served (see CacheStore interface)
+func (s *StaleStore) GetExpiresIn(key string) (time.Duration, error) {
+	var entry []byte
+	if err := s.store.Get(key, &entry); err != nil {
+		return 0, err
+	}
+	if len(entry) < 16 {
+		return 0, ErrCacheMiss
+	}
+	soft := int64(binary.BigEndian.Uint64(entry))
+	if soft == 0 {
+		return 0, ErrCacheNoTTL
+	}
+	if left := time.Duration(soft - s.clock.Now().UnixNano()); left > 0 {
+		return left, nil
+	}
+	return 0, nil
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *StaleStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *StaleStore) Flush() error {
+	return s.store.Flush()
+}
@@ -0,0 +1,86 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleStore_ServesStaleWhileRefreshing(t *testing.T) {
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "fresh", nil
+	}
+	s := NewStaleStore(NewInMemoryStore(time.Hour), time.Hour, loader, WithStaleTTL(time.Second))
+	if err := s.Set("key", "stale", 50*time.Millisecond); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var v string
+	if err := s.Get("key", &v); err != nil || v != "stale" {
+		t.Fatalf("expected the fresh value, got %q (%v)", v, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// past the soft expiry every caller gets the stale value while one refresh runs
+	for i := 0; i < 5; i++ {
+		if err := s.Get("key", &v); err != nil || v != "stale" {
+			t.Errorf("expected the stale value, got %q (%v)", v, err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := s.Get("key", &v); err != nil || v != "fresh" {
+		t.Errorf("expected the refreshed value, got %q (%v)", v, err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("expected a single refresh, got %d", n)
+	}
+}
+
+func TestStaleStore_HardExpiry(t *testing.T) {
+	refreshErr := make(chan error, 1)
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return nil, errors.New("origin down")
+	}
+	clock := NewFakeClock(time.Now())
+	s := NewStaleStore(NewInMemoryStore(time.Hour, WithClock(clock)), time.Hour, loader,
+		WithStaleTTL(50*time.Millisecond), WithClock(clock))
+	s.OnRefreshError(func(key string, err error) { refreshErr <- err })
+	s.Set("key", 1, 50*time.Millisecond)
+	clock.Advance(60 * time.Millisecond)
+	var v int
+	if err := s.Get("key", &v); err != nil || v != 1 {
+		t.Errorf("expected the stale value, got %d (%v)", v, err)
+	}
+	select {
+	case err := <-refreshErr:
+		if err.Error() != "origin down" {
+			t.Errorf("unexpected refresh error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the failed refresh to be reported")
+	}
+	clock.Advance(60 * time.Millisecond)
+	if err := s.Get("key", &v); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss after the stale TTL, got %v", err)
+	}
+}
+
+func TestStaleStore_AddReplace(t *testing.T) {
+	s := NewStaleStore(NewInMemoryStore(time.Hour), time.Hour, nil)
+	if err := s.Replace("key", 1, DEFAULT); err != ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := s.Add("key", 1, FOREVER); err != nil {
+		t.Errorf("Error adding: %s", err)
+	}
+	if err := s.Add("key", 2, DEFAULT); err != ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if _, err := s.Increment("key", 1); err != ErrNotSupport {
+		t.Errorf("expected ErrNotSupport, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Package statsd provides a persistence.MetricsSink sending metrics to a StatsD
+// server over UDP, tagged the DogStatsD way, e.g. to the Datadog agent:
+//
+//	sink, err := statsd.NewSink("127.0.0.1:8125", statsd.WithPrefix("myservice."))
+//	...
+//	store := persistence.NewMiddlewareStore(redisStore,
+//		persistence.WithMiddleware(persistence.MetricsMiddleware(sink, "sessions")))
+package statsd
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+const optionWithPrefix = "optionWithStatsdPrefix"
+
+// WithPrefix prepends prefix to the name of every metric
+func WithPrefix(prefix string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithPrefix] = prefix
+	}
+}
+
+const optionWithTags = "optionWithStatsdTags"
+
+// WithTags adds tags, as "name:value" pairs, to every metric
+func WithTags(tags ...string) persistence.Option {
+	return func(o persistence.Options) {
+		prev, _ := o[optionWithTags].([]string)
+		o[optionWithTags] = append(prev, tags...)
+	}
+}
+
+// Sink sends metrics to a StatsD server, one datagram each. Sending is best effort:
+// like StatsD clients do, errors are dropped rather than slow down the cache.
+type Sink struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	prefix string
+	tags   []string
+	buf    []byte
+}
+
+var _ persistence.MetricsSink = &Sink{}
+
+// NewSink returns a Sink sending to the StatsD server at addr ("host:port")
+func NewSink(addr string, opt ...persistence.Option) (*Sink, error) {
+	opts := persistence.GetOpts(opt...)
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sink{conn: conn}
+	s.prefix, _ = opts[optionWithPrefix].(string)
+	s.tags, _ = opts[optionWithTags].([]string)
+	return s, nil
+}
+
+// Timing (see persistence.MetricsSink interface), sent in milliseconds
+func (s *Sink) Timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(d.Seconds()*1000, 'f', -1, 64), "ms", tags)
+}
+
+// Count (see persistence.MetricsSink interface)
+func (s *Sink) Count(name string, n int64, tags ...string) {
+	s.send(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+// send writes the metric as "prefix.name:value|type|#tag,tag"
+func (s *Sink) send(name, value, typ string, tags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := append(s.buf[:0], s.prefix...)
+	b = append(b, name...)
+	b = append(b, ':')
+	b = append(b, value...)
+	b = append(b, '|')
+	b = append(b, typ...)
+	if len(s.tags)+len(tags) > 0 {
+		b = append(b, "|#"...)
+		b = append(b, strings.Join(append(s.tags[:len(s.tags):len(s.tags)], tags...), ",")...)
+	}
+	s.buf = b
+	s.conn.Write(b)
+}
+
+// Close closes the connection to the server
+func (s *Sink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,37 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSink(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	sink, err := NewSink(server.LocalAddr().String(), WithPrefix("svc."), WithTags("env:test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	sink.Timing("cache.op.duration", 1500*time.Microsecond, "op:get")
+	sink.Count("cache.hit", 1)
+	for _, expected := range []string{
+		"svc.cache.op.duration:1.5|ms|#env:test,op:get",
+		"svc.cache.hit:1|c|#env:test",
+	} {
+		buf := make([]byte, 512)
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	}
+}
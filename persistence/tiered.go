@@ -0,0 +1,277 @@
+package persistence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const tieredResubscribeDelay = time.Second
+
+var errUnsubscribed = errors.New("cache: unsubscribed from invalidations.")
+
+// TieredStore fronts a shared L2 store (e.g. redis) with a fast, process local L1 (e.g.
+// an InMemoryStore): Gets are served from the L1 when possible and fill it from the L2
+// otherwise, and writes go to the L2 first and then the L1.
+//
+// Without invalidation, other processes keep serving their L1 copy of a key written
+// elsewhere until its L1 expiration. WithInvalidationPubSub broadcasts every write on a
+// redis channel, so every TieredStore on it drops the key from its L1 right away.
+type TieredStore struct {
+	l1, l2       CacheStore
+	l1Expiration time.Duration
+
+	pubSub invalidationPubSub
+	id     string
+	ready  chan struct{}
+
+	mu            sync.Mutex
+	psc           *redis.PubSubConn
+	closed        bool
+	onInvalidErr  func(err error)
+	subscribeOnce sync.Once
+}
+
+var _ CacheStore = &TieredStore{}
+
+// NewTieredStore returns a TieredStore caching the values of l2 in l1 for l1Expiration
+// at most
+func NewTieredStore(l1, l2 CacheStore, l1Expiration time.Duration, opt ...Option) *TieredStore {
+	opts := GetOpts(opt...)
+	s := &TieredStore{
+		l1:           l1,
+		l2:           l2,
+		l1Expiration: l1Expiration,
+		ready:        make(chan struct{}),
+	}
+	if v, ok := opts[optionWithInvalidationPubSub].(invalidationPubSub); ok && v.pool != nil {
+		b := make([]byte, 8)
+		rand.Read(b)
+		s.pubSub, s.id = v, hex.EncodeToString(b)
+		go s.subscribe()
+	} else {
+		close(s.ready)
+	}
+	return s
+}
+
+// OnInvalidationError sets an (optional) function called when broadcasting or
+// receiving invalidations fails; the write itself has succeeded. Pass nil to disable.
+func (s *TieredStore) OnInvalidationError(f func(err error)) {
+	s.mu.Lock()
+	s.onInvalidErr = f
+	s.mu.Unlock()
+}
+
+func (s *TieredStore) invalidationError(err error) {
+	s.mu.Lock()
+	f := s.onInvalidErr
+	s.mu.Unlock()
+	if f != nil {
+		f(err)
+	}
+}
+
+// Close stops listening for invalidations
+func (s *TieredStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.psc != nil {
+		// the subscriber closes the connection once unsubscribed
+		return s.psc.Unsubscribe()
+	}
+	return nil
+}
+
+// subscribe listens for invalidations until Close, resubscribing when the connection
+// breaks. Invalidations may have been missed meanwhile, so the L1 is flushed then.
+func (s *TieredStore) subscribe() {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		psc := &redis.PubSubConn{Conn: s.pubSub.pool.Get()}
+		s.psc = psc
+		s.mu.Unlock()
+
+		err := psc.Subscribe(s.pubSub.channel)
+		for err == nil {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				s.invalidated(string(v.Data))
+			case redis.Subscription:
+				if v.Kind == "unsubscribe" {
+					err = errUnsubscribed
+					break
+				}
+				s.subscribeOnce.Do(func() { close(s.ready) })
+			case error:
+				err = v
+			}
+		}
+		// under the lock, as Close may be writing the UNSUBSCRIBE to the connection
+		s.mu.Lock()
+		psc.Close()
+		s.psc = nil
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return
+		}
+		s.invalidationError(err)
+		s.l1.Flush()
+		time.Sleep(tieredResubscribeDelay)
+	}
+}
+
+// invalidated handles a broadcast message: the sender id, then "k" and the key, or "f"
+// for a flush
+func (s *TieredStore) invalidated(msg string) {
+	i := strings.IndexByte(msg, ' ')
+	if i < 0 || i+1 >= len(msg) || msg[:i] == s.id {
+		return
+	}
+	switch msg[i+1] {
+	case 'k':
+		s.l1.Delete(msg[i+2:])
+	case 'f':
+		s.l1.Flush()
+	}
+}
+
+func (s *TieredStore) publish(msg string) {
+	if s.pubSub.pool == nil {
+		return
+	}
+	conn := s.pubSub.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PUBLISH", s.pubSub.channel, s.id+" "+msg); err != nil {
+		s.invalidationError(err)
+	}
+}
+
+// written drops or refreshes key in the L1 after it was written to the L2, and tells
+// the other TieredStores
+func (s *TieredStore) written(key string, value interface{}, expires time.Duration) {
+	if value := l1Value(value); value != nil {
+		s.l1.Set(key, value, s.l1TTL(expires))
+	} else {
+		s.l1.Delete(key)
+	}
+	s.publish("k" + key)
+}
+
+// l1Value returns what to keep in the L1 for value: the value pointed to rather than
+// the caller's pointer, as the L2 serializes it, so reading it back into a pointer of
+// that type works and later changes through the pointer don't reach the L1. It's nil
+// for nil values, which aren't kept.
+func l1Value(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr {
+		return value
+	}
+	if v.IsNil() {
+		return nil
+	}
+	return v.Elem().Interface()
+}
+
+func (s *TieredStore) l1TTL(expires time.Duration) time.Duration {
+	if expires > DEFAULT && expires < s.l1Expiration {
+		return expires
+	}
+	return s.l1Expiration
+}
+
+// Get (see CacheStore interface)
+func (s *TieredStore) Get(key string, value interface{}) error {
+	if err := s.l1.Get(key, value); err == nil {
+		return nil
+	}
+	if err := s.l2.Get(key, value); err != nil {
+		return err
+	}
+	s.l1.Set(key, reflect.ValueOf(value).Elem().Interface(), s.l1Expiration)
+	return nil
+}
+
+// Set (see CacheStore interface)
+func (s *TieredStore) Set(key string, value interface{}, expires time.Duration) error {
+	if err := s.l2.Set(key, value, expires); err != nil {
+		return err
+	}
+	s.written(key, value, expires)
+	return nil
+}
+
+// Add (see CacheStore interface)
+func (s *TieredStore) Add(key string, value interface{}, expires time.Duration) error {
+	if err := s.l2.Add(key, value, expires); err != nil {
+		return err
+	}
+	s.written(key, value, expires)
+	return nil
+}
+
+// Replace (see CacheStore interface)
+func (s *TieredStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if err := s.l2.Replace(key, value, expires); err != nil {
+		return err
+	}
+	s.written(key, value, expires)
+	return nil
+}
+
+// Delete (see CacheStore interface)
+func (s *TieredStore) Delete(key string) error {
+	err := s.l2.Delete(key)
+	s.written(key, nil, DEFAULT)
+	return err
+}
+
+// Increment (see CacheStore interface)
+func (s *TieredStore) Increment(key string, delta uint64) (uint64, error) {
+	n, err := s.l2.Increment(key, delta)
+	if err == nil {
+		s.written(key, nil, DEFAULT)
+	}
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (s *TieredStore) Decrement(key string, delta uint64) (uint64, error) {
+	n, err := s.l2.Decrement(key, delta)
+	if err == nil {
+		s.written(key, nil, DEFAULT)
+	}
+	return n, err
+}
+
+// GetExpiresIn (see CacheStore interface), as told by the L2 store
+func (s *TieredStore) GetExpiresIn(key string) (time.Duration, error) {
+	return s.l2.GetExpiresIn(key)
+}
+
+// GetOrSet (see CacheStore interface)
+func (s *TieredStore) GetOrSet(key string, ttl time.Duration, ptr interface{}, fill func() (interface{}, error)) (bool, error) {
+	return GetOrSet(s, key, ttl, ptr, fill)
+}
+
+// Flush (see CacheStore interface)
+func (s *TieredStore) Flush() error {
+	if err := s.l2.Flush(); err != nil {
+		return err
+	}
+	s.l1.Flush()
+	s.publish("f")
+	return nil
+}
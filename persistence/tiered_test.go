@@ -0,0 +1,89 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+)
+
+func TestTieredStore(t *testing.T) {
+	l1, l2 := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	s := NewTieredStore(l1, l2, time.Minute)
+	defer s.Close()
+	l2.Set("key", "value", DEFAULT)
+	var v string
+	if err := s.Get("key", &v); err != nil || v != "value" {
+		t.Fatalf("expected value, got %q (%v)", v, err)
+	}
+	// the L1 serves the value from now on
+	l2.Delete("key")
+	if err := s.Get("key", &v); err != nil || v != "value" {
+		t.Errorf("expected the L1 copy, got %q (%v)", v, err)
+	}
+	if _, err := s.Increment("counter", 1); err != ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+	s.Set("counter", 1, DEFAULT)
+	if n, err := s.Increment("counter", 2); err != nil || n != 3 {
+		t.Errorf("expected 3, got %d (%v)", n, err)
+	}
+	var n int
+	if err := s.Get("counter", &n); err != nil || n != 3 {
+		t.Errorf("expected the L1 copy to be dropped by Increment, got %d (%v)", n, err)
+	}
+}
+
+func TestTieredStore_SetPointer(t *testing.T) {
+	type user struct{ Name string }
+	server := miniredistest.Run(t)
+	s := NewTieredStore(NewInMemoryStore(time.Hour), NewRedisCache(server.Addr(), "", time.Hour), time.Minute)
+	defer s.Close()
+	u := &user{Name: "alice"}
+	if err := s.Set("user", u, DEFAULT); err != nil {
+		t.Fatalf("expected Set to succeed, got %v", err)
+	}
+	u.Name = "bob"
+	var got user
+	if err := s.Get("user", &got); err != nil || got.Name != "alice" {
+		t.Errorf("expected the value as it was set, got %+v (%v)", got, err)
+	}
+	if err := s.Set("nil", (*user)(nil), DEFAULT); err != nil {
+		t.Fatalf("expected Set to succeed, got %v", err)
+	}
+}
+
+func TestTieredStore_PubSub(t *testing.T) {
+	l2 := newRedisStore(t, time.Hour).(*RedisStore)
+	pubSub := WithInvalidationPubSub(l2.pool, "cache:test:invalidations")
+	a := NewTieredStore(NewInMemoryStore(time.Hour), l2, time.Hour, pubSub)
+	defer a.Close()
+	b := NewTieredStore(NewInMemoryStore(time.Hour), l2, time.Hour, pubSub)
+	defer b.Close()
+	<-a.ready
+	<-b.ready
+
+	var v string
+	if err := a.Set("key", "v1", DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Get("key", &v); err != nil || v != "v1" {
+		t.Fatalf("expected v1, got %q (%v)", v, err)
+	}
+	if err := a.Set("key", "v2", DEFAULT); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return b.Get("key", &v) == nil && v == "v2" })
+	a.Delete("key")
+	eventually(t, func() bool { return b.Get("key", &v) == ErrCacheMiss })
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Error("condition not met within a second")
+}
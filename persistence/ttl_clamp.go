@@ -0,0 +1,32 @@
+package persistence
+
+import "time"
+
+// ttlClamp bounds the expirations written to WithMinTTL and WithMaxTTL, so a mistyped
+// duration can't make a store useless or keep entries around for ever
+type ttlClamp struct {
+	min, max time.Duration
+}
+
+func newTTLClamp(opts Options) ttlClamp {
+	var c ttlClamp
+	if v, ok := opts[optionWithMinTTL].(time.Duration); ok && v > 0 {
+		c.min = v
+	}
+	if v, ok := opts[optionWithMaxTTL].(time.Duration); ok && v > 0 {
+		c.max = v
+	}
+	return c
+}
+
+// apply clamps a translated expiration, leaving "never expires" (<= 0) alone
+func (c ttlClamp) apply(expires time.Duration) time.Duration {
+	switch {
+	case expires <= 0:
+	case expires < c.min:
+		return c.min
+	case c.max > 0 && expires > c.max:
+		return c.max
+	}
+	return expires
+}
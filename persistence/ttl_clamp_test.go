@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+)
+
+func TestTTLClamp(t *testing.T) {
+	c := newTTLClamp(GetOpts(WithMinTTL(time.Minute), WithMaxTTL(time.Hour)))
+	cases := map[time.Duration]time.Duration{
+		time.Millisecond:   time.Minute,
+		10 * time.Minute:   10 * time.Minute,
+		7 * 24 * time.Hour: time.Hour,
+		0:                  0,
+		FOREVER:            FOREVER,
+	}
+	for expires, expected := range cases {
+		if got := c.apply(expires); got != expected {
+			t.Errorf("%s: expected %s, got %s", expires, expected, got)
+		}
+	}
+	if d := newTTLClamp(GetOpts()).apply(time.Millisecond); d != time.Millisecond {
+		t.Errorf("expected no clamping by default, got %s", d)
+	}
+}
+
+func TestInMemoryCache_TTLClamp(t *testing.T) {
+	store := NewInMemoryStore(time.Minute, WithMinTTL(time.Minute), WithMaxTTL(time.Hour))
+	store.Set("short", 1, time.Millisecond)
+	store.Add("long", 1, 7*24*time.Hour)
+	store.Set("never", 1, FOREVER)
+	for key, ttl := range map[string]time.Duration{"short": time.Minute, "long": time.Hour} {
+		exp, err := store.GetExpiresIn(key)
+		if err != nil || exp > ttl || exp < ttl-time.Second {
+			t.Errorf("%s: expected to expire in %s, got %s (%v)", key, ttl, exp, err)
+		}
+	}
+	if _, err := store.GetExpiresIn("never"); err != ErrCacheNoTTL {
+		t.Errorf("expected keys that never expire to be left alone, got %v", err)
+	}
+}
+
+func TestRedis_TTLClamp(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Minute, WithMinTTL(time.Minute), WithMaxTTL(time.Hour))
+	store.Set("short", 1, time.Millisecond)
+	store.Replace("short", 2, time.Second)
+	store.Add("long", 1, 7*24*time.Hour)
+	store.Set("never", 1, FOREVER)
+	for key, ttl := range map[string]time.Duration{"short": time.Minute, "long": time.Hour, "never": 0} {
+		if got := server.TTL(key); got != ttl {
+			t.Errorf("%s: expected a TTL of %s, got %s", key, ttl, got)
+		}
+	}
+}
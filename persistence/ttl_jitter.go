@@ -0,0 +1,40 @@
+package persistence
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ttlJitter spreads expirations by up to ±fraction so keys written together
+// don't all expire in the same second
+type ttlJitter float64
+
+func newTTLJitter(opts Options) ttlJitter {
+	v, ok := opts[optionWithTTLJitter].(float64)
+	if !ok || v <= 0 {
+		return 0
+	}
+	if v > 1 {
+		v = 1
+	}
+	return ttlJitter(v)
+}
+
+// apply randomizes a translated expiration, leaving "never expires" (<= 0) alone
+func (j ttlJitter) apply(expires time.Duration) time.Duration {
+	if j == 0 || expires <= 0 {
+		return expires
+	}
+	d := expires + time.Duration(float64(expires)*float64(j)*(2*rand.Float64()-1))
+	// the redis and memcached stores have second granularity, where anything under a
+	// second would turn an expiring key into one that never expires
+	if floor := time.Second; d < floor {
+		if expires < floor {
+			floor = time.Duration(1)
+		}
+		if d < floor {
+			return floor
+		}
+	}
+	return d
+}
@@ -0,0 +1,42 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLJitter(t *testing.T) {
+	j := newTTLJitter(GetOpts(WithTTLJitter(0.1)))
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := j.apply(time.Hour)
+		if d < 54*time.Minute || d > 66*time.Minute {
+			t.Fatalf("expected an hour ±10%%, got %s", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected the expirations to be spread out")
+	}
+	if d := j.apply(0); d != 0 {
+		t.Errorf("expected keys that never expire to be left alone, got %s", d)
+	}
+	full := newTTLJitter(GetOpts(WithTTLJitter(1)))
+	for i := 0; i < 100; i++ {
+		if d := full.apply(time.Second); d < time.Second {
+			t.Fatalf("expected expirations to stay at a second or more, got %s", d)
+		}
+	}
+	if d := newTTLJitter(GetOpts()).apply(time.Hour); d != time.Hour {
+		t.Errorf("expected no jitter by default, got %s", d)
+	}
+}
+
+func TestInMemoryCache_TTLJitter(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithTTLJitter(0.5))
+	store.Set("key", 1, DEFAULT)
+	exp, err := store.GetExpiresIn("key")
+	if err != nil || exp < 29*time.Minute || exp > 91*time.Minute {
+		t.Errorf("expected an hour ±50%%, got %s (%v)", exp, err)
+	}
+}
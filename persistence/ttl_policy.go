@@ -0,0 +1,71 @@
+package persistence
+
+import "time"
+
+// TTLRule sets how the keys matching Pattern expire when they're written with DEFAULT
+// (see WithTTLPolicy)
+type TTLRule struct {
+	// Pattern is matched against the whole key, with the glob rules of the redis KEYS
+	// command ("user:*", "session:??:*", ...)
+	Pattern string
+	// TTL replaces the default expiration of the store, FOREVER for keys that never
+	// expire; 0 keeps the default expiration
+	TTL time.Duration
+	// Jitter replaces the fraction of WithTTLJitter, negative to disable it; 0 keeps the
+	// jitter of the store
+	Jitter float64
+	// StaleTTL replaces WithStaleTTL for a StaleStore, negative to serve no stale
+	// entries; 0 keeps the stale TTL of the store
+	StaleTTL time.Duration
+}
+
+// ttlPolicy maps key patterns to the expiration of the keys written with DEFAULT
+type ttlPolicy []TTLRule
+
+func newTTLPolicy(opts Options) ttlPolicy {
+	rules, _ := opts[optionWithTTLPolicy].([]TTLRule)
+	return rules
+}
+
+// match returns the first rule matching key, nil if none does
+func (p ttlPolicy) match(key string) *TTLRule {
+	for i := range p {
+		if globMatch(p[i].Pattern, key) {
+			return &p[i]
+		}
+	}
+	return nil
+}
+
+// resolve returns the expiration and jitter of key written with DEFAULT: those of the
+// rule matching it, falling back on def and jitter
+func (p ttlPolicy) resolve(key string, def time.Duration, jitter ttlJitter) (time.Duration, ttlJitter) {
+	rule := p.match(key)
+	if rule == nil {
+		return def, jitter
+	}
+	if rule.TTL != 0 {
+		def = rule.TTL
+	}
+	switch {
+	case rule.Jitter < 0:
+		jitter = 0
+	case rule.Jitter > 1:
+		jitter = 1
+	case rule.Jitter > 0:
+		jitter = ttlJitter(rule.Jitter)
+	}
+	return def, jitter
+}
+
+// staleTTL returns the stale TTL of key: that of the rule matching it, falling back on def
+func (p ttlPolicy) staleTTL(key string, def time.Duration) time.Duration {
+	rule := p.match(key)
+	switch {
+	case rule == nil || rule.StaleTTL == 0:
+		return def
+	case rule.StaleTTL < 0:
+		return 0
+	}
+	return rule.StaleTTL
+}
@@ -0,0 +1,120 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+)
+
+func TestTTLPolicy(t *testing.T) {
+	p := newTTLPolicy(GetOpts(WithTTLPolicy(
+		TTLRule{Pattern: "session:*", TTL: 30 * time.Minute, Jitter: 0.2},
+		TTLRule{Pattern: "config:*", TTL: FOREVER, Jitter: -1},
+		TTLRule{Pattern: "user:*", Jitter: 0.5, StaleTTL: -1},
+		TTLRule{Pattern: "*", TTL: time.Minute},
+	)))
+	cases := []struct {
+		key    string
+		ttl    time.Duration
+		jitter ttlJitter
+	}{
+		{"session:1", 30 * time.Minute, 0.2},
+		{"config:flags", FOREVER, 0},
+		{"user:1", time.Hour, 0.5},
+		{"other", time.Minute, 0.1},
+	}
+	for _, c := range cases {
+		ttl, jitter := p.resolve(c.key, time.Hour, 0.1)
+		if ttl != c.ttl || jitter != c.jitter {
+			t.Errorf("%s: expected %s ±%v, got %s ±%v", c.key, c.ttl, c.jitter, ttl, jitter)
+		}
+	}
+	if d := p.staleTTL("user:1", time.Minute); d != 0 {
+		t.Errorf("expected no stale TTL, got %s", d)
+	}
+	if d := p.staleTTL("session:1", time.Minute); d != time.Minute {
+		t.Errorf("expected the default stale TTL, got %s", d)
+	}
+	if ttl, jitter := newTTLPolicy(GetOpts()).resolve("key", time.Hour, 0.1); ttl != time.Hour || jitter != 0.1 {
+		t.Errorf("expected the defaults without a policy, got %s ±%v", ttl, jitter)
+	}
+}
+
+func TestInMemoryCache_TTLPolicy(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithTTLPolicy(
+		TTLRule{Pattern: "short:*", TTL: time.Minute},
+		TTLRule{Pattern: "never:*", TTL: FOREVER},
+	))
+	store.Set("short:1", 1, DEFAULT)
+	store.Set("short:2", 1, 2*time.Hour)
+	store.Set("never:1", 1, DEFAULT)
+	store.Set("other", 1, DEFAULT)
+	expected := map[string]time.Duration{
+		"short:1": time.Minute,
+		"short:2": 2 * time.Hour,
+		"other":   time.Hour,
+	}
+	for key, ttl := range expected {
+		exp, err := store.GetExpiresIn(key)
+		if err != nil || exp > ttl || exp < ttl-time.Second {
+			t.Errorf("%s: expected to expire in %s, got %s (%v)", key, ttl, exp, err)
+		}
+	}
+	if _, err := store.GetExpiresIn("never:1"); err != ErrCacheNoTTL {
+		t.Errorf("expected never:1 not to expire, got %v", err)
+	}
+}
+
+func TestRedis_TTLPolicy(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewRedisCache(server.Addr(), "", time.Hour, WithTTLPolicy(
+		TTLRule{Pattern: "short:*", TTL: time.Minute},
+		TTLRule{Pattern: "never:*", TTL: FOREVER},
+	))
+	store.Set("short:1", 1, DEFAULT)
+	store.Add("short:2", 1, DEFAULT)
+	store.Set("short:3", 1, 2*time.Hour)
+	store.Set("never:1", 1, DEFAULT)
+	store.Set("other", 1, DEFAULT)
+	if err := store.MSetNX(DEFAULT, "never:2", 1, "short:4", 1); err != nil {
+		t.Fatalf("expected MSetNX to succeed, got %v", err)
+	}
+	expected := map[string]time.Duration{
+		"short:1": time.Minute,
+		"short:2": time.Minute,
+		"short:3": 2 * time.Hour,
+		"short:4": time.Minute,
+		"other":   time.Hour,
+		"never:1": 0,
+		"never:2": 0,
+	}
+	for key, ttl := range expected {
+		if got := server.TTL(key); got != ttl {
+			t.Errorf("%s: expected a TTL of %s, got %s", key, ttl, got)
+		}
+	}
+}
+
+func TestStaleStore_TTLPolicy(t *testing.T) {
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		return "fresh", nil
+	}
+	inner := NewInMemoryStore(time.Hour)
+	s := NewStaleStore(inner, time.Hour, loader, WithStaleTTL(time.Minute), WithTTLPolicy(
+		TTLRule{Pattern: "short:*", TTL: 10 * time.Minute, StaleTTL: 5 * time.Minute},
+	))
+	s.Set("short:1", "v", DEFAULT)
+	s.Set("other", "v", DEFAULT)
+	expected := map[string]time.Duration{
+		"short:1": 15 * time.Minute,
+		"other":   61 * time.Minute,
+	}
+	for key, ttl := range expected {
+		exp, err := inner.GetExpiresIn(key)
+		if err != nil || exp > ttl || exp < ttl-time.Second {
+			t.Errorf("%s: expected to be kept for %s, got %s (%v)", key, ttl, exp, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package persistence
+
+import "fmt"
+
+// ValueTooLargeError is returned when a serialized value exceeds the store's
+// WithMaxValueSize limit; errors.Is(err, ErrValueTooLarge) reports true for it
+type ValueTooLargeError struct {
+	Key   string
+	Size  int
+	Limit int
+}
+
+func (e *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("cache: value for %s is %d bytes, over the %d byte limit.", e.Key, e.Size, e.Limit)
+}
+
+// Is makes errors.Is match ErrValueTooLarge
+func (e *ValueTooLargeError) Is(target error) bool {
+	return target == ErrValueTooLarge
+}
+
+// valueSizeLimit enforces WithMaxValueSize for the stores that serialize values
+type valueSizeLimit struct {
+	max  int
+	skip bool
+}
+
+func newValueSizeLimit(opts Options) valueSizeLimit {
+	var l valueSizeLimit
+	if v, ok := opts[optionWithMaxValueSize].(int); ok && v > 0 {
+		l.max = v
+	}
+	if v, ok := opts[optionWithSkipOversizedValues].(bool); ok {
+		l.skip = v
+	}
+	return l
+}
+
+// check reports whether a serialized value of size bytes may be stored under key.
+// An oversized value is an error, unless oversized values are skipped, in which
+// case the caller drops the write and removes the key so a stale value isn't served.
+func (l valueSizeLimit) check(key string, size int) (skip bool, err error) {
+	if l.max == 0 || size <= l.max {
+		return false, nil
+	}
+	if l.skip {
+		return true, nil
+	}
+	return false, &ValueTooLargeError{Key: key, Size: size, Limit: l.max}
+}
@@ -0,0 +1,50 @@
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValueSizeLimit(t *testing.T) {
+	l := newValueSizeLimit(GetOpts(WithMaxValueSize(4)))
+	if skip, err := l.check("key", 4); skip || err != nil {
+		t.Errorf("expected a value at the limit to be stored, got %v %v", skip, err)
+	}
+	_, err := l.check("key", 5)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	var tooLarge *ValueTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Size != 5 || tooLarge.Limit != 4 {
+		t.Errorf("expected a *ValueTooLargeError for 5 > 4 bytes, got %#v", err)
+	}
+
+	l = newValueSizeLimit(GetOpts(WithMaxValueSize(4), WithSkipOversizedValues()))
+	if skip, err := l.check("key", 5); !skip || err != nil {
+		t.Errorf("expected an oversized value to be skipped, got %v %v", skip, err)
+	}
+	if skip, err := newValueSizeLimit(GetOpts()).check("key", 1<<30); skip || err != nil {
+		t.Errorf("expected no limit by default, got %v %v", skip, err)
+	}
+}
+
+func redisMaxValueSize(t *testing.T) {
+	server := newRedisServer(t)
+	store := NewRedisCache(server, "", time.Hour, WithMaxValueSize(16))
+	if err := store.Set("key", "small", DEFAULT); err != nil {
+		t.Errorf("Error setting a small value: %s", err)
+	}
+	if err := store.Set("key", "a value way over the limit", DEFAULT); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+
+	skipping := NewRedisCache(server, "", time.Hour, WithMaxValueSize(16), WithSkipOversizedValues())
+	if err := skipping.Set("key", "a value way over the limit", DEFAULT); err != nil {
+		t.Errorf("expected the oversized value to be skipped, got %v", err)
+	}
+	var s string
+	if err := skipping.Get("key", &s); err != ErrCacheMiss {
+		t.Errorf("expected the key to be removed, got %q (%v)", s, err)
+	}
+}
@@ -0,0 +1,172 @@
+package persistence
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"github.com/Bose/cache/utils"
+)
+
+const (
+	defaultVerifySample      = 1000
+	defaultVerifyMaxTTLDrift = 2 * time.Second
+)
+
+// DiscrepancyKind describes how a key differs between the stores compared by Verify
+type DiscrepancyKind int
+
+const (
+	// DiscrepancyMissing - the key is only in the first store
+	DiscrepancyMissing DiscrepancyKind = iota
+	// DiscrepancyValue - the values differ
+	DiscrepancyValue
+	// DiscrepancyTTL - the TTLs differ by more than the allowed drift
+	DiscrepancyTTL
+)
+
+func (k DiscrepancyKind) String() string {
+	switch k {
+	case DiscrepancyMissing:
+		return "missing"
+	case DiscrepancyValue:
+		return "value"
+	case DiscrepancyTTL:
+		return "ttl"
+	}
+	return fmt.Sprintf("DiscrepancyKind(%d)", int(k))
+}
+
+// Discrepancy is a key differing between the stores compared by Verify
+type Discrepancy struct {
+	Key  string
+	Kind DiscrepancyKind
+	// TTLA and TTLB are the TTLs left in each store for a DiscrepancyTTL, FOREVER for
+	// a key that doesn't expire
+	TTLA, TTLB time.Duration
+}
+
+// VerifyReport is the outcome of Verify
+type VerifyReport struct {
+	// Checked is the number of keys compared
+	Checked       int
+	Discrepancies []Discrepancy
+}
+
+// Verify samples the keys of a matching the glob pattern and checks b holds the same
+// values with about the same TTLs, e.g. to validate a dual-write migration or a tiered
+// cache (see WithVerifySample, WithVerifyValue and WithVerifyMaxTTLDrift). a has to be
+// a KeyScanner; keys only in b go unnoticed. TTLs are only compared when both stores
+// can tell them (see GetExpiresIn), and values are compared serialized unless a type
+// is given WithVerifyValue. Keys expiring from a while being checked are skipped.
+func Verify(a, b CacheStore, pattern string, opt ...Option) (VerifyReport, error) {
+	opts := GetOpts(opt...)
+	sample := defaultVerifySample
+	if v, ok := opts[optionWithVerifySample].(int); ok && v >= 0 {
+		sample = v
+	}
+	maxDrift := defaultVerifyMaxTTLDrift
+	if v, ok := opts[optionWithVerifyMaxTTLDrift].(time.Duration); ok && v >= 0 {
+		maxDrift = v
+	}
+	var valueType reflect.Type
+	if v, ok := opts[optionWithVerifyValue]; ok && v != nil {
+		valueType = reflect.TypeOf(v).Elem()
+	}
+
+	scanner, ok := a.(KeyScanner)
+	if !ok {
+		return VerifyReport{}, ErrNotSupport
+	}
+	// reservoir sampling, so every key has the same chance to be checked
+	var keys []string
+	seen := 0
+	err := scanner.ScanKeys(pattern, func(key string) bool {
+		seen++
+		if sample == 0 || len(keys) < sample {
+			keys = append(keys, key)
+		} else if i := rand.Intn(seen); i < sample {
+			keys[i] = key
+		}
+		return true
+	})
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	var report VerifyReport
+	for _, key := range keys {
+		d, checked, err := verifyKey(a, b, key, valueType, maxDrift)
+		if err != nil {
+			return report, err
+		}
+		if checked {
+			report.Checked++
+		}
+		if d != nil {
+			report.Discrepancies = append(report.Discrepancies, *d)
+		}
+	}
+	return report, nil
+}
+
+// verifyKey compares key in both stores, returning false if it is gone from a
+func verifyKey(a, b CacheStore, key string, valueType reflect.Type, maxDrift time.Duration) (*Discrepancy, bool, error) {
+	var va, vb []byte
+	if err := a.Get(key, &va); err == ErrCacheMiss {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if err := b.Get(key, &vb); err == ErrCacheMiss {
+		return &Discrepancy{Key: key, Kind: DiscrepancyMissing}, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	equal := bytes.Equal(va, vb)
+	if !equal && valueType != nil {
+		pa, pb := reflect.New(valueType), reflect.New(valueType)
+		if utils.Deserialize(va, pa.Interface()) == nil && utils.Deserialize(vb, pb.Interface()) == nil {
+			equal = reflect.DeepEqual(pa.Elem().Interface(), pb.Elem().Interface())
+		}
+	}
+	if !equal {
+		return &Discrepancy{Key: key, Kind: DiscrepancyValue}, true, nil
+	}
+
+	ttlA, err := expiresIn(a, key)
+	if err == ErrCacheMiss {
+		return nil, false, nil
+	} else if err == ErrNotSupport {
+		return nil, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	ttlB, err := expiresIn(b, key)
+	if err == ErrCacheMiss {
+		return &Discrepancy{Key: key, Kind: DiscrepancyMissing}, true, nil
+	} else if err == ErrNotSupport {
+		return nil, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	drift := ttlA - ttlB
+	if drift < 0 {
+		drift = -drift
+	}
+	if (ttlA == FOREVER) != (ttlB == FOREVER) || drift > maxDrift {
+		return &Discrepancy{Key: key, Kind: DiscrepancyTTL, TTLA: ttlA, TTLB: ttlB}, true, nil
+	}
+	return nil, true, nil
+}
+
+// expiresIn returns the TTL left on key, FOREVER if it doesn't expire
+func expiresIn(store CacheStore, key string) (time.Duration, error) {
+	ttl, err := store.GetExpiresIn(key)
+	if err == ErrCacheNoTTL {
+		return FOREVER, nil
+	}
+	return ttl, err
+}
@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	server := newRedisServer(t)
+	a := NewRedisCache(server, "", time.Hour)
+	b := NewRedisCache(server, "", time.Hour, WithSelectDatabase(1))
+	for _, s := range []CacheStore{a, b} {
+		s.Set("same", "value", time.Minute)
+		s.Set("counter", 1, FOREVER)
+	}
+	a.Set("missing", "value", DEFAULT)
+	a.Set("changed", "old", DEFAULT)
+	b.Set("changed", "new", DEFAULT)
+	a.Set("ttl", "value", time.Minute)
+	b.Set("ttl", "value", time.Hour)
+	a.Set("forever", "value", FOREVER)
+	b.Set("forever", "value", time.Hour)
+
+	report, err := Verify(a, b, "*", WithVerifyValue(new(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 6 {
+		t.Errorf("expected 6 keys checked, got %d", report.Checked)
+	}
+	kinds := map[string]DiscrepancyKind{}
+	for _, d := range report.Discrepancies {
+		kinds[d.Key] = d.Kind
+	}
+	expected := map[string]DiscrepancyKind{
+		"missing": DiscrepancyMissing,
+		"changed": DiscrepancyValue,
+		"ttl":     DiscrepancyTTL,
+		"forever": DiscrepancyTTL,
+	}
+	if len(kinds) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, kinds)
+	}
+	for key, kind := range expected {
+		if kinds[key] != kind {
+			t.Errorf("expected a %s discrepancy on %s, got %v", kind, key, report.Discrepancies)
+		}
+	}
+
+	report, err = Verify(a, b, "*", WithVerifySample(2))
+	if err != nil || report.Checked != 2 {
+		t.Errorf("expected 2 keys checked, got %d (%v)", report.Checked, err)
+	}
+	if _, err := Verify(NewInMemoryStore(time.Hour), b, "*"); err != ErrNotSupport {
+		t.Errorf("expected ErrNotSupport, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package ratelimit limits the rate of events across processes with a RedisStore, with
+// a sliding window log (NewSlidingWindow) or a token bucket (NewTokenBucket). Both
+// are decided by a single Lua script on the server's clock, so they hold across any
+// number of clients.
+package ratelimit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/gomodule/redigo/redis"
+)
+
+// DefaultKeyPrefix prefixes the keys of limits unless WithKeyPrefix is given
+const DefaultKeyPrefix = "ratelimit"
+
+const optionWithKeyPrefix = "optionWithRateLimitKeyPrefix"
+
+// WithKeyPrefix sets the prefix of the keys of limits (DefaultKeyPrefix by default)
+func WithKeyPrefix(prefix string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithKeyPrefix] = prefix
+	}
+}
+
+const optionWithBurst = "optionWithRateLimitBurst"
+
+// WithBurst sets the capacity of the buckets of NewTokenBucket, the most events let
+// through at once (the limit by default)
+func WithBurst(n int) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithBurst] = n
+	}
+}
+
+// Result is the decision on events
+type Result struct {
+	// Allowed is set if the events are allowed
+	Allowed bool
+	// Remaining is the number of events still allowed right now
+	Remaining int
+	// RetryAfter is how long to wait for the events to be allowed, 0 if they are
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether events are allowed under the limit of a key, e.g. a client
+type Limiter interface {
+	// Allow reports whether an event is allowed for key, and counts it if it is
+	Allow(key string) (Result, error)
+	// AllowN reports whether n events are allowed at once for key, and counts them if
+	// they are. It returns persistence.ErrInvalidArgument if n can never be allowed.
+	AllowN(key string, n int) (Result, error)
+}
+
+// serverNow sets now to the time of the server in milliseconds
+const serverNow = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// slidingWindowScript records ARGV[3] events of ids prefixed with ARGV[4] in the sorted
+// set KEYS[1], scored by time, provided the events of the last ARGV[2] milliseconds
+// plus them don't exceed ARGV[1]. It returns whether they were recorded, the number of
+// events remaining and the milliseconds to wait for them to be allowed.
+var slidingWindowScript = redis.NewScript(1, serverNow+`
+local limit, window, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count + n > limit then
+	local oldest = redis.call('ZRANGE', KEYS[1], count + n - limit - 1, count + n - limit - 1, 'WITHSCORES')
+	return {0, limit - count, tonumber(oldest[2]) + window - now}
+end
+for i = 1, n do
+	redis.call('ZADD', KEYS[1], now, ARGV[4] .. ':' .. i)
+end
+redis.call('PEXPIRE', KEYS[1], window)
+return {1, limit - count - n, 0}
+`)
+
+// tokenBucketScript takes ARGV[3] tokens from the bucket KEYS[1], a hash of its tokens
+// and the time they were counted, holding up to ARGV[1] tokens and refilled with
+// ARGV[2] tokens per millisecond. It returns whether they were taken, the tokens left
+// and the milliseconds to wait for enough tokens.
+var tokenBucketScript = redis.NewScript(1, serverNow+`
+local capacity, rate, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens, ts = tonumber(state[1]) or capacity, tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed, wait = 0, 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	wait = math.ceil((n - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity / rate))
+return {allowed, math.floor(tokens), wait}
+`)
+
+// limiter is what both limiters share
+type limiter struct {
+	store  *persistence.RedisStore
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+func newLimiter(store *persistence.RedisStore, limit int, window time.Duration, opts persistence.Options) limiter {
+	l := limiter{store: store, prefix: DefaultKeyPrefix, limit: limit, window: window}
+	if v, ok := opts[optionWithKeyPrefix].(string); ok {
+		l.prefix = v
+	}
+	return l
+}
+
+// run runs script for key with args, returning its result
+func (l *limiter) run(script *redis.Script, key string, args ...interface{}) (Result, error) {
+	conn := l.store.Conn()
+	defer conn.Close()
+	reply, err := redis.Int64s(script.Do(conn, append([]interface{}{l.prefix + ":" + key}, args...)...))
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    reply[0] == 1,
+		Remaining:  int(reply[1]),
+		RetryAfter: time.Duration(reply[2]) * time.Millisecond,
+	}, nil
+}
+
+// SlidingWindow allows up to a number of events in any window of time, see
+// NewSlidingWindow
+type SlidingWindow struct {
+	limiter
+}
+
+var _ Limiter = &SlidingWindow{}
+
+// NewSlidingWindow returns a Limiter allowing up to limit events per key in any window
+// of time. Every event allowed is logged in a sorted set until it leaves the window,
+// so the limit is exact, at the cost of memory in proportion to limit.
+func NewSlidingWindow(store *persistence.RedisStore, limit int, window time.Duration, opt ...persistence.Option) *SlidingWindow {
+	return &SlidingWindow{limiter: newLimiter(store, limit, window, persistence.GetOpts(opt...))}
+}
+
+// Allow (see Limiter interface)
+func (l *SlidingWindow) Allow(key string) (Result, error) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN (see Limiter interface)
+func (l *SlidingWindow) AllowN(key string, n int) (Result, error) {
+	if n <= 0 || n > l.limit || l.window < time.Millisecond {
+		return Result{}, persistence.ErrInvalidArgument
+	}
+	return l.run(slidingWindowScript, key, l.limit, int64(l.window/time.Millisecond), n, newEventID())
+}
+
+// TokenBucket allows events at a steady rate with bursts, see NewTokenBucket
+type TokenBucket struct {
+	limiter
+	burst int
+}
+
+var _ Limiter = &TokenBucket{}
+
+// NewTokenBucket returns a Limiter allowing limit events per window on average for
+// each key, from a bucket of tokens refilled at that rate. The bucket holds limit
+// tokens unless WithBurst says otherwise, which is the most events allowed at once.
+func NewTokenBucket(store *persistence.RedisStore, limit int, window time.Duration, opt ...persistence.Option) *TokenBucket {
+	opts := persistence.GetOpts(opt...)
+	l := &TokenBucket{limiter: newLimiter(store, limit, window, opts), burst: limit}
+	if v, ok := opts[optionWithBurst].(int); ok && v > 0 {
+		l.burst = v
+	}
+	return l
+}
+
+// Allow (see Limiter interface)
+func (l *TokenBucket) Allow(key string) (Result, error) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN (see Limiter interface)
+func (l *TokenBucket) AllowN(key string, n int) (Result, error) {
+	if n <= 0 || n > l.burst || l.limit <= 0 || l.window < time.Millisecond {
+		return Result{}, persistence.ErrInvalidArgument
+	}
+	rate := float64(l.limit) / float64(l.window/time.Millisecond)
+	return l.run(tokenBucketScript, key, l.burst, rate, n)
+}
+
+// newEventID returns a random ID for events, so those of concurrent calls don't
+// collide in the log
+func newEventID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
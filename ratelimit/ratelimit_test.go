@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+	"github.com/Bose/cache/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	server := miniredistest.Run(t)
+	start := time.Now()
+	server.SetTime(start)
+	l := NewSlidingWindow(persistence.NewRedisCache(server.Addr(), "", time.Minute), 3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		r, err := l.Allow("client")
+		assert.NoError(t, err)
+		assert.True(t, r.Allowed)
+		assert.Equal(t, 2-i, r.Remaining)
+		server.SetTime(start.Add(time.Duration(i+1) * 10 * time.Second))
+	}
+	r, err := l.Allow("client")
+	assert.NoError(t, err)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+	assert.Equal(t, 30*time.Second, r.RetryAfter)
+	r, _ = l.Allow("other")
+	assert.True(t, r.Allowed)
+
+	// the first event leaves the window
+	server.SetTime(start.Add(time.Minute + time.Millisecond))
+	r, _ = l.AllowN("client", 2)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 1, r.Remaining)
+	assert.Equal(t, 9999*time.Millisecond, r.RetryAfter)
+	r, _ = l.Allow("client")
+	assert.True(t, r.Allowed)
+
+	_, err = l.AllowN("client", 4)
+	assert.Equal(t, persistence.ErrInvalidArgument, err)
+}
+
+func TestTokenBucket(t *testing.T) {
+	server := miniredistest.Run(t)
+	start := time.Now()
+	server.SetTime(start)
+	l := NewTokenBucket(persistence.NewRedisCache(server.Addr(), "", time.Minute), 10, time.Second, WithBurst(5), WithKeyPrefix("rl"))
+
+	r, err := l.AllowN("client", 5)
+	assert.NoError(t, err)
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+	assert.True(t, server.Exists("rl:client"))
+
+	r, _ = l.Allow("client")
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 100*time.Millisecond, r.RetryAfter)
+
+	// refilled at 10 tokens per second, up to the burst
+	server.SetTime(start.Add(300 * time.Millisecond))
+	r, _ = l.AllowN("client", 3)
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+	server.SetTime(start.Add(time.Hour))
+	r, _ = l.Allow("client")
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 4, r.Remaining)
+
+	_, err = l.AllowN("client", 6)
+	assert.Equal(t, persistence.ErrInvalidArgument, err)
+}
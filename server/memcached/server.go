@@ -0,0 +1,441 @@
+// Package memcached exposes any CacheStore over the memcached text protocol,
+// so clients that only speak memcached can share a cache managed from Go.
+//
+// Values are handed to the store as []byte and read back into a []byte, so Go
+// code sharing the cache should store []byte (or rely on a store that
+// serializes values, like RedisStore, and read the serialized bytes). incr and
+// decr work with both kinds of stores (see persistence.IncrementBytes). Client
+// flags are not persisted and always read back as 0, and cas unique values are
+// always 0; the cas command isn't supported.
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+)
+
+const (
+	defaultMaxItemSize = 1 << 20
+	maxKeyLength       = 250
+	// maxLineLength bounds command lines, which only multi-key gets make long
+	maxLineLength = 64 << 10
+	// exptimes above 30 days are absolute unix timestamps
+	maxRelativeExptime = 60 * 60 * 24 * 30
+
+	version = "1.6.0-cache"
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("memcached: server closed")
+
+// errLineTooLong is returned by readLine for lines over maxLineLength
+var errLineTooLong = errors.New("memcached: line too long")
+
+// Server answers memcached text protocol requests from a CacheStore
+type Server struct {
+	store       persistence.CacheStore
+	maxItemSize int
+	errorLog    *log.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+const optionWithMaxItemSize = "optionWithMemcachedMaxItemSize"
+
+// WithMaxItemSize sets the largest value a client may store, 1MB by default like memcached
+func WithMaxItemSize(n int) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithMaxItemSize] = n
+	}
+}
+
+const optionWithErrorLog = "optionWithMemcachedErrorLog"
+
+// WithErrorLog sets the logger of the errors serving connections, such as panics of the
+// store, the log package's standard logger by default
+func WithErrorLog(l *log.Logger) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithErrorLog] = l
+	}
+}
+
+// NewServer returns a Server fronting the store
+func NewServer(store persistence.CacheStore, opt ...persistence.Option) *Server {
+	opts := persistence.GetOpts(opt...)
+	s := &Server{
+		store:       store,
+		maxItemSize: defaultMaxItemSize,
+		listeners:   map[net.Listener]struct{}{},
+		conns:       map[net.Conn]struct{}{},
+	}
+	if v, ok := opts[optionWithMaxItemSize].(int); ok && v > 0 {
+		s.maxItemSize = v
+	}
+	s.errorLog, _ = opts[optionWithErrorLog].(*log.Logger)
+	return s
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.errorLog != nil {
+		s.errorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// ListenAndServe listens on the TCP address and serves connections until Close is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener, handling each on its own goroutine,
+// until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and closes every open connection
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		// a misbehaving store must not take the whole server down
+		if r := recover(); r != nil {
+			s.logf("memcached: panic serving %s: %v", conn.RemoteAddr(), r)
+		}
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := readLine(r)
+		if err == errLineTooLong {
+			fmt.Fprint(w, "CLIENT_ERROR line too long\r\n")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+		} else if quit := s.dispatch(fields, r, w); quit {
+			w.Flush()
+			return
+		}
+		// only flush once pipelined requests have been drained
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLine reads a line of at most maxLineLength bytes, so a client can't make the
+// server buffer an endless one
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLength {
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// dispatch handles a single command, returning true when the connection should be closed
+func (s *Server) dispatch(fields []string, r *bufio.Reader, w *bufio.Writer) bool {
+	switch cmd, args := fields[0], fields[1:]; cmd {
+	case "get", "gets":
+		s.get(w, args, cmd == "gets")
+	case "set", "add", "replace":
+		return s.storage(w, r, cmd, args)
+	case "delete":
+		s.delete(w, args)
+	case "incr", "decr":
+		s.incr(w, args, cmd == "incr")
+	case "touch":
+		s.touch(w, args)
+	case "flush_all":
+		reply(w, noreply(args), s.store.Flush(), "OK")
+	case "version":
+		fmt.Fprintf(w, "VERSION %s\r\n", version)
+	case "verbosity":
+		reply(w, noreply(args), nil, "OK")
+	case "quit":
+		return true
+	default:
+		fmt.Fprint(w, "ERROR\r\n")
+	}
+	return false
+}
+
+func noreply(args []string) bool {
+	return len(args) > 0 && args[len(args)-1] == "noreply"
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// expiration maps a memcached exptime onto a CacheStore expiration; ok is false
+// when the item is already expired
+func expiration(exptime int64) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return persistence.FOREVER, true
+	case exptime < 0:
+		return 0, false
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second, true
+	}
+	d := time.Until(time.Unix(exptime, 0))
+	return d, d > 0
+}
+
+// reply writes the response for a command, or the error mapped onto the protocol
+func reply(w *bufio.Writer, quiet bool, err error, ok string) {
+	switch {
+	case err == nil:
+		if !quiet {
+			fmt.Fprintf(w, "%s\r\n", ok)
+		}
+	case err == persistence.ErrNotStored:
+		if !quiet {
+			fmt.Fprint(w, "NOT_STORED\r\n")
+		}
+	case err == persistence.ErrCacheMiss:
+		if !quiet {
+			fmt.Fprint(w, "NOT_FOUND\r\n")
+		}
+	default:
+		// errors are always reported, even with noreply
+		fmt.Fprintf(w, "SERVER_ERROR %s\r\n", strings.ReplaceAll(err.Error(), "\r\n", " "))
+	}
+}
+
+func (s *Server) get(w *bufio.Writer, keys []string, cas bool) {
+	if len(keys) == 0 {
+		fmt.Fprint(w, "ERROR\r\n")
+		return
+	}
+	for _, key := range keys {
+		if !validKey(key) {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+	}
+	for _, key := range keys {
+		var b []byte
+		if err := s.store.Get(key, &b); err != nil {
+			if err != persistence.ErrCacheMiss {
+				reply(w, false, err, "")
+				return
+			}
+			continue
+		}
+		if cas {
+			fmt.Fprintf(w, "VALUE %s 0 %d 0\r\n", key, len(b))
+		} else {
+			fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(b))
+		}
+		w.Write(b)
+		w.WriteString("\r\n")
+	}
+	fmt.Fprint(w, "END\r\n")
+}
+
+// storage handles "<cmd> <key> <flags> <exptime> <bytes> [noreply]" followed by the data block
+func (s *Server) storage(w *bufio.Writer, r *bufio.Reader, cmd string, args []string) bool {
+	if len(args) < 4 {
+		fmt.Fprint(w, "ERROR\r\n")
+		return false
+	}
+	key := args[0]
+	_, flagsErr := strconv.ParseUint(args[1], 10, 32)
+	exptime, expErr := strconv.ParseInt(args[2], 10, 64)
+	size, sizeErr := strconv.Atoi(args[3])
+	if flagsErr != nil || expErr != nil || sizeErr != nil || size < 0 {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		// without a valid length the data block can't be skipped, so give up on the connection
+		return sizeErr != nil || size < 0
+	}
+	if size > s.maxItemSize {
+		if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+			return true
+		}
+		fmt.Fprint(w, "SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return true
+	}
+	if string(data[size:]) != "\r\n" {
+		fmt.Fprint(w, "CLIENT_ERROR bad data chunk\r\n")
+		return false
+	}
+	if !validKey(key) {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	data = data[:size]
+	expires, live := expiration(exptime)
+	var err error
+	switch {
+	case !live:
+		// storing an already expired item behaves like storing and then expiring it
+		err = s.store.Delete(key)
+		if err == persistence.ErrCacheMiss {
+			err = nil
+			if cmd == "replace" {
+				err = persistence.ErrNotStored
+			}
+		}
+	case cmd == "set":
+		err = s.store.Set(key, data, expires)
+	case cmd == "add":
+		err = s.store.Add(key, data, expires)
+	case cmd == "replace":
+		err = s.store.Replace(key, data, expires)
+	}
+	reply(w, noreply(args), err, "STORED")
+	return false
+}
+
+func (s *Server) delete(w *bufio.Writer, args []string) {
+	if len(args) == 0 || !validKey(args[0]) {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	reply(w, noreply(args), s.store.Delete(args[0]), "DELETED")
+}
+
+func (s *Server) incr(w *bufio.Writer, args []string, incr bool) {
+	if len(args) < 2 || !validKey(args[0]) {
+		fmt.Fprint(w, "ERROR\r\n")
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		fmt.Fprint(w, "CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+	// values are stored as []byte, which only the stores serializing values increment
+	// themselves
+	var n uint64
+	if incr {
+		n, err = persistence.IncrementBytes(s.store, args[0], delta)
+	} else {
+		n, err = persistence.DecrementBytes(s.store, args[0], delta)
+	}
+	if err != nil && err != persistence.ErrCacheMiss && err != persistence.ErrNotStored {
+		fmt.Fprint(w, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+		return
+	}
+	reply(w, noreply(args), err, strconv.FormatUint(n, 10))
+}
+
+// touch rewrites the item with its new expiration, since CacheStore has no way to
+// change an expiration in place
+func (s *Server) touch(w *bufio.Writer, args []string) {
+	if len(args) < 2 || !validKey(args[0]) {
+		fmt.Fprint(w, "ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		fmt.Fprint(w, "CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+	var b []byte
+	if err := s.store.Get(args[0], &b); err != nil {
+		reply(w, noreply(args), err, "")
+		return
+	}
+	if expires, live := expiration(exptime); live {
+		err = s.store.Replace(args[0], b, expires)
+		if err == persistence.ErrNotStored {
+			err = persistence.ErrCacheMiss
+		}
+	} else {
+		err = s.store.Delete(args[0])
+	}
+	reply(w, noreply(args), err, "TOUCHED")
+}
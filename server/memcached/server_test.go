@@ -0,0 +1,239 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/Bose/cache/persistence/freecache"
+)
+
+func startServer(t *testing.T, opt ...persistence.Option) (*Server, string) {
+	t.Helper()
+	return startServerWith(t, freecache.NewStore(1<<20, time.Hour), opt...)
+}
+
+func startServerWith(t *testing.T, store persistence.CacheStore, opt ...persistence.Option) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	s := NewServer(store, opt...)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+// roundTrip sends the raw request and reads back the expected number of reply lines
+func roundTrip(t *testing.T, conn net.Conn, r *bufio.Reader, request string, lines int) string {
+	t.Helper()
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	var sb strings.Builder
+	for i := 0; i < lines; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %s", err)
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+func TestServer_MemcachedStoreClient(t *testing.T) {
+	_, addr := startServer(t)
+	client := persistence.NewMemcachedStore([]string{addr}, time.Hour)
+
+	if err := client.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := client.Get("value", &value); err != nil || value != "foo" {
+		t.Errorf("Expected to get foo back, got %q (%v)", value, err)
+	}
+	if err := client.Add("value", "bar", persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	if err := client.Replace("missing", "bar", persistence.DEFAULT); err != persistence.ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	client.Set("int", 10, persistence.DEFAULT)
+	if n, err := client.Increment("int", 5); err != nil || n != 15 {
+		t.Errorf("Expected 15, was %d (%v)", n, err)
+	}
+	if n, err := client.Decrement("int", 20); err != nil || n != 0 {
+		t.Errorf("Expected capped at 0, was %d (%v)", n, err)
+	}
+	if err := client.Delete("value"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := client.Get("value", &value); err != persistence.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestServer_TextProtocol(t *testing.T) {
+	_, addr := startServer(t, WithMaxItemSize(16))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		request string
+		lines   int
+		want    string
+	}{
+		{"set a 5 0 3\r\nabc\r\n", 1, "STORED\r\n"},
+		{"get a missing\r\n", 3, "VALUE a 0 3\r\nabc\r\nEND\r\n"},
+		{"gets a\r\n", 3, "VALUE a 0 3 0\r\nabc\r\nEND\r\n"},
+		// pipelined noreply commands only answer the final get
+		{"set b 0 0 1 noreply\r\nx\r\nadd b 0 0 1 noreply\r\ny\r\nget b\r\n", 3, "VALUE b 0 1\r\nx\r\nEND\r\n"},
+		{"set big 0 0 17\r\n01234567890123456\r\n", 1, "SERVER_ERROR object too large for cache\r\n"},
+		// like memcached, the rest of a bad data block is read as the next command
+		{"set c 0 0 2\r\nabcd\r\n", 2, "CLIENT_ERROR bad data chunk\r\nERROR\r\n"},
+		{"set a 0 -1 1\r\nz\r\n", 1, "STORED\r\n"},
+		{"get a\r\n", 1, "END\r\n"},
+		{"incr b 1\r\n", 1, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{"incr missing 1\r\n", 1, "NOT_FOUND\r\n"},
+		{"delete missing\r\n", 1, "NOT_FOUND\r\n"},
+		{"touch b 100\r\n", 1, "TOUCHED\r\n"},
+		{"bogus\r\n", 1, "ERROR\r\n"},
+		{"flush_all\r\n", 1, "OK\r\n"},
+		{"get b\r\n", 1, "END\r\n"},
+		{"version\r\n", 1, fmt.Sprintf("VERSION %s\r\n", version)},
+	}
+	for _, tt := range tests {
+		if got := roundTrip(t, conn, r, tt.request, tt.lines); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.request, tt.want, got)
+		}
+	}
+	io.WriteString(conn, "quit\r\n")
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("expected the connection to be closed after quit, got %v", err)
+	}
+}
+
+func TestServer_IncrNonSerializingStore(t *testing.T) {
+	// the in-memory store keeps the []byte values as is rather than serializing them
+	_, addr := startServerWith(t, persistence.NewInMemoryStore(time.Hour))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, tt := range []struct{ request, want string }{
+		{"set n 0 0 2\r\n10\r\n", "STORED\r\n"},
+		{"incr n 5\r\n", "15\r\n"},
+		{"decr n 20\r\n", "0\r\n"},
+		{"set s 0 0 3\r\nabc\r\n", "STORED\r\n"},
+		{"incr s 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{"incr missing 1\r\n", "NOT_FOUND\r\n"},
+	} {
+		if got := roundTrip(t, conn, r, tt.request, 1); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.request, tt.want, got)
+		}
+	}
+	if got := roundTrip(t, conn, r, "get n\r\n", 3); got != "VALUE n 0 1\r\n0\r\nEND\r\n" {
+		t.Errorf("expected the counter to be stored as text, got %q", got)
+	}
+}
+
+func TestServer_LineTooLong(t *testing.T) {
+	_, addr := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+	// the server reads lines in chunks of its 4KB buffer, and gives up on the chunk
+	// going over the limit: send exactly that, so it closes with nothing left unread
+	go conn.Write([]byte("get " + strings.Repeat("k", maxLineLength+4096-4)))
+	r := bufio.NewReader(conn)
+	if line, err := r.ReadString('\n'); err != nil || line != "CLIENT_ERROR line too long\r\n" {
+		t.Errorf("expected the line to be rejected, got %q (%v)", line, err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestServer_ErrorLog(t *testing.T) {
+	var buf lockedBuffer
+	_, addr := startServerWith(t, panickingStore{}, WithErrorLog(log.New(&buf, "", 0)))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "delete k\r\n")
+	if _, err := bufio.NewReader(conn).ReadByte(); err != io.EOF {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "memcached: panic serving") {
+		t.Errorf("expected the panic to be logged, got %q", buf.String())
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// panickingStore panics on Delete
+type panickingStore struct {
+	persistence.CacheStore
+}
+
+func (panickingStore) Delete(string) error {
+	panic("boom")
+}
+
+func TestServer_Close(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	s := NewServer(freecache.NewStore(1<<20, time.Hour))
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+	if err := <-done; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("expected open connections to be closed")
+	}
+}
@@ -0,0 +1,197 @@
+// Package sessionstore provides a gorilla/sessions Store keeping session values in a
+// persistence.CacheStore, typically a RedisStore, with only a signed session ID in the
+// cookie.
+package sessionstore
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Bose/cache/persistence"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// DefaultKeyPrefix prefixes the keys of sessions unless WithKeyPrefix is given
+const DefaultKeyPrefix = "sessionstore.session"
+
+// DefaultMaxAge is the max age of sessions, in seconds, unless Options says otherwise
+const DefaultMaxAge = 86400 * 30
+
+// ErrNoCodecs is returned by Save when the Store has no key pairs to encode cookies
+var ErrNoCodecs = errors.New("cache: session store has no key pairs.")
+
+const optionWithKeyPrefix = "optionWithSessionStoreKeyPrefix"
+
+// WithKeyPrefix sets the prefix of the keys of sessions (DefaultKeyPrefix by default)
+func WithKeyPrefix(prefix string) persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithKeyPrefix] = prefix
+	}
+}
+
+const optionWithRolling = "optionWithSessionStoreRolling"
+
+// WithRolling makes sessions expire MaxAge after they were last read, rather than
+// last saved: loading a session extends its TTL in the store. The cookie is only
+// renewed when the session is saved, so sessions should be saved on each request
+// for the cookie to roll along.
+func WithRolling() persistence.Option {
+	return func(o persistence.Options) {
+		o[optionWithRolling] = true
+	}
+}
+
+// Store is a sessions.Store keeping session values in a persistence.CacheStore
+// under a random ID, which is sent to the client in a cookie signed (and encrypted,
+// if given an encryption key) with securecookie.
+type Store struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration of new sessions
+
+	store   persistence.CacheStore
+	prefix  string
+	rolling bool
+}
+
+var _ sessions.Store = &Store{}
+
+// NewStore returns a Store keeping sessions in store. keyPairs are the securecookie
+// authentication and encryption key pairs of the cookies (see
+// securecookie.CodecsFromPairs); the first pair encodes cookies, all of them decode
+// cookies so keys can be rotated.
+func NewStore(store persistence.CacheStore, keyPairs [][]byte, opt ...persistence.Option) *Store {
+	opts := persistence.GetOpts(opt...)
+	s := &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   DefaultMaxAge,
+			HttpOnly: true,
+		},
+		store:  store,
+		prefix: DefaultKeyPrefix,
+	}
+	if v, ok := opts[optionWithKeyPrefix].(string); ok {
+		s.prefix = v
+	}
+	s.rolling, _ = opts[optionWithRolling].(bool)
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// MaxAge sets the max age of new sessions, in seconds, and of the cookies the codecs
+// accept
+func (s *Store) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns the session named name for r, once per request (see
+// sessions.Registry)
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session named name for r, loaded from the store if its cookie
+// holds a valid ID, or a new session with IsNew set. An error is returned along with
+// a new session if the cookie can't be decoded or the store can't be read.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.Codecs...); err != nil {
+		return session, err
+	}
+	var b []byte
+	if err := s.store.Get(s.key(id), &b); err != nil {
+		if err == persistence.ErrCacheMiss {
+			// expired or deleted: start over with a new session
+			return session, nil
+		}
+		return session, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+	if s.rolling {
+		s.store.Replace(s.key(id), b, s.ttl(session))
+	}
+	return session, nil
+}
+
+// Save saves session in the store and sets its cookie on w. A session with a
+// negative MaxAge is deleted from the store and its cookie is cleared.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.store.Delete(s.key(session.ID)); err != nil && err != persistence.ErrCacheMiss {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if len(s.Codecs) == 0 {
+		return ErrNoCodecs
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	b, err := encode(session.Values)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Set(s.key(session.ID), b, s.ttl(session)); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// key returns the key of the session id
+func (s *Store) key(id string) string {
+	return s.prefix + ":" + id
+}
+
+// ttl returns the TTL of session in the store: its MaxAge, or the store's default
+// TTL for sessions lasting as long as the browser runs
+func (s *Store) ttl(session *sessions.Session) time.Duration {
+	if session.Options.MaxAge > 0 {
+		return time.Duration(session.Options.MaxAge) * time.Second
+	}
+	return persistence.DEFAULT
+}
+
+// encode gob encodes the values of a session. The concrete types of values other
+// than basic types must be registered (see gob.Register).
+func encode(values map[interface{}]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
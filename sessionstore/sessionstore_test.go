@@ -0,0 +1,79 @@
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Bose/cache/internal/miniredistest"
+	"github.com/Bose/cache/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+var keyPairs = [][]byte{[]byte("0123456789abcdef0123456789abcdef")}
+
+// request returns a request carrying the cookies set on w
+func request(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestStore(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewStore(persistence.NewRedisCache(server.Addr(), "", time.Minute), keyPairs)
+
+	session, err := store.Get(httptest.NewRequest("GET", "/", nil), "sid")
+	assert.NoError(t, err)
+	assert.True(t, session.IsNew)
+	session.Values["user"] = "ada"
+	w := httptest.NewRecorder()
+	assert.NoError(t, session.Save(httptest.NewRequest("GET", "/", nil), w))
+	assert.NotEmpty(t, session.ID)
+	cookie := w.Result().Cookies()[0]
+	assert.NotContains(t, cookie.Value, session.ID, "expected the ID to be encoded")
+	assert.True(t, server.Exists(DefaultKeyPrefix+":"+session.ID))
+	assert.Equal(t, time.Duration(DefaultMaxAge)*time.Second, server.TTL(DefaultKeyPrefix+":"+session.ID))
+
+	loaded, err := store.Get(request(w), "sid")
+	assert.NoError(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, session.ID, loaded.ID)
+	assert.Equal(t, "ada", loaded.Values["user"])
+
+	// forged cookies are rejected
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "sid", Value: session.ID})
+	forged, err := store.Get(r, "sid")
+	assert.Error(t, err)
+	assert.True(t, forged.IsNew)
+
+	// a negative MaxAge deletes the session
+	loaded.Options.MaxAge = -1
+	w2 := httptest.NewRecorder()
+	assert.NoError(t, loaded.Save(request(w), w2))
+	assert.False(t, server.Exists(DefaultKeyPrefix+":"+session.ID))
+	gone, err := store.Get(request(w), "sid")
+	assert.NoError(t, err)
+	assert.True(t, gone.IsNew)
+}
+
+func TestStore_Rolling(t *testing.T) {
+	server := miniredistest.Run(t)
+	store := NewStore(persistence.NewRedisCache(server.Addr(), "", time.Minute), keyPairs, WithRolling(), WithKeyPrefix("s"))
+	store.Options.MaxAge = 60
+
+	session, _ := store.New(httptest.NewRequest("GET", "/", nil), "sid")
+	w := httptest.NewRecorder()
+	assert.NoError(t, session.Save(httptest.NewRequest("GET", "/", nil), w))
+	server.FastForward(50 * time.Second)
+	assert.Equal(t, 10*time.Second, server.TTL("s:"+session.ID))
+
+	loaded, err := store.New(request(w), "sid")
+	assert.NoError(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, time.Minute, server.TTL("s:"+session.ID))
+}
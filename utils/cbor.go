@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CodecIDCBOR is the ID of CBOR
+const CodecIDCBOR byte = 2
+
+type cborCodec struct{}
+
+func (cborCodec) ID() byte { return CodecIDCBOR }
+
+func (cborCodec) Marshal(value interface{}) ([]byte, error) {
+	if _, ok := value.([]byte); ok || isInteger(value) {
+		return Serialize(value)
+	}
+	return cbor.Marshal(value)
+}
+
+func (cborCodec) Unmarshal(b []byte, ptr interface{}) error {
+	if _, ok := ptr.(*[]byte); ok {
+		return Deserialize(b, ptr)
+	}
+	if v := reflect.ValueOf(ptr); v.Kind() == reflect.Ptr && !v.IsNil() && isInteger(v.Elem().Interface()) {
+		return Deserialize(b, ptr)
+	}
+	return cbor.Unmarshal(b, ptr)
+}
+
+// CBOR encodes values as CBOR (RFC 8949): self-describing like gob, but standardized,
+// readable from other languages and more compact than JSON. Like Gob, it keeps integers
+// as decimal text and byte slices as is, so the stores can increment the former.
+var CBOR Codec = cborCodec{}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+	in := item{Name: "name", Tags: []string{"a", "b"}}
+	b, err := CBOR.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out item
+	if err := CBOR.Unmarshal(b, &out); err != nil || !reflect.DeepEqual(in, out) {
+		t.Errorf("expected %+v, got %+v (%v)", in, out, err)
+	}
+	gob, _ := Gob.Marshal(in)
+	if len(b) >= len(gob) {
+		t.Errorf("expected CBOR (%d bytes) to be smaller than gob (%d bytes)", len(b), len(gob))
+	}
+
+	if b, err := CBOR.Marshal(uint32(42)); err != nil || string(b) != "42" {
+		t.Errorf("expected integers as decimal text, got %q (%v)", b, err)
+	}
+	var n uint32
+	if err := CBOR.Unmarshal([]byte("43"), &n); err != nil || n != 43 {
+		t.Errorf("expected 43, got %d (%v)", n, err)
+	}
+	var raw []byte
+	if err := CBOR.Unmarshal([]byte{0xff}, &raw); err != nil || len(raw) != 1 {
+		t.Errorf("expected the raw bytes, got %v (%v)", raw, err)
+	}
+
+	enveloped, _ := Encode(CBOR, 0, in)
+	out = item{}
+	if err := Decode(enveloped, &out, 0, Gob); err != nil || !reflect.DeepEqual(in, out) {
+		t.Errorf("expected the registered codec to decode its envelope, got %+v (%v)", out, err)
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Codec turns values into bytes and back. Codecs are told apart in enveloped payloads
+// by their ID, which must be unique and stable: payloads outlive the processes that
+// wrote them.
+type Codec interface {
+	// ID identifies the codec in payload envelopes; 0 is reserved
+	ID() byte
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(b []byte, ptr interface{}) error
+}
+
+// CodecIDGob is the ID of Gob
+const CodecIDGob byte = 1
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte                                  { return CodecIDGob }
+func (gobCodec) Marshal(value interface{}) ([]byte, error) { return Serialize(value) }
+func (gobCodec) Unmarshal(b []byte, ptr interface{}) error { return Deserialize(b, ptr) }
+
+// Gob is the default codec, Serialize and Deserialize: integers as decimal text, byte
+// slices as is and anything else gob encoded
+var Gob Codec = gobCodec{}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{CodecIDGob: Gob, CodecIDCBOR: CBOR, CodecIDFixed: Fixed, CodecIDCompact: Compact}
+)
+
+// RegisterCodec makes c available to decode the envelopes carrying its ID. It panics
+// if another codec is registered with the same ID, or the ID is 0.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	id := c.ID()
+	if id == 0 {
+		panic("cache: codec ID 0 is reserved")
+	}
+	if prev, ok := codecs[id]; ok && prev != c {
+		panic(fmt.Sprintf("cache: codec ID %d registered twice", id))
+	}
+	codecs[id] = c
+}
+
+// LookupCodec returns the codec registered with id
+func LookupCodec(id byte) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[id]
+	return c, ok
+}
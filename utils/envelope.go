@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// envelopeMagic starts every enveloped payload. Gob streams start with a byte count
+// below 0x80 or above 0xf7, and Serialize writes integers as decimal text, so neither
+// can be mistaken for an envelope.
+var envelopeMagic = [2]byte{0xc5, 0xe7}
+
+const (
+	envelopeVersion    = 1
+	envelopeHeaderSize = 7
+)
+
+// Envelope flags, describing transformations applied to the payload after encoding.
+// Decoding fails on flags it doesn't know of rather than return garbage.
+const (
+	FlagCompressed byte = 1 << iota
+	FlagEncrypted
+)
+
+// knownFlags are the flags DecodeEnvelope can handle
+const knownFlags byte = 0
+
+// ErrUnknownCodec is returned when decoding an envelope written with a codec that
+// isn't registered (see RegisterCodec)
+var ErrUnknownCodec = errors.New("cache: unknown codec.")
+
+// Header is the header of an enveloped payload
+type Header struct {
+	Codec byte
+	Flags byte
+	// Schema is the version of the value's type the writer had
+	Schema uint16
+}
+
+// SchemaError is returned when an envelope was written with another schema version
+// than the one expected
+type SchemaError struct {
+	Stored, Expected uint16
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("cache: value written with schema %d, expected %d", e.Stored, e.Expected)
+}
+
+// Encode marshals value with codec into an envelope recording the codec and schema, so
+// readers can tell how to decode it. Integers are left as the decimal text Serialize
+// writes, so the stores can still increment them, and nil values as the nil payload.
+func Encode(codec Codec, schema uint16, value interface{}) ([]byte, error) {
+	if isInteger(value) || IsNil(value) {
+		return Serialize(value)
+	}
+	payload, err := codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, envelopeHeaderSize+len(payload))
+	b[0], b[1], b[2], b[3], b[4] = envelopeMagic[0], envelopeMagic[1], envelopeVersion, codec.ID(), 0
+	binary.BigEndian.PutUint16(b[5:], schema)
+	copy(b[envelopeHeaderSize:], payload)
+	return b, nil
+}
+
+// ParseEnvelope returns the header and payload of b, or false if b isn't enveloped
+func ParseEnvelope(b []byte) (Header, []byte, bool) {
+	if len(b) < envelopeHeaderSize || b[0] != envelopeMagic[0] || b[1] != envelopeMagic[1] || b[2] != envelopeVersion {
+		return Header{}, nil, false
+	}
+	return Header{Codec: b[3], Flags: b[4], Schema: binary.BigEndian.Uint16(b[5:])}, b[envelopeHeaderSize:], true
+}
+
+// Decode unmarshals b into ptr with the codec its envelope names, failing with a
+// *SchemaError if it was written with another schema than expected. Payloads without
+// an envelope, as written before envelopes were used, are decoded with fallback. The
+// nil payload is reported with ErrNilValue whatever the codec.
+func Decode(b []byte, ptr interface{}, schema uint16, fallback Codec) error {
+	if IsNilValue(b) {
+		return ErrNilValue
+	}
+	h, payload, ok := ParseEnvelope(b)
+	if !ok {
+		return fallback.Unmarshal(b, ptr)
+	}
+	if h.Flags&^knownFlags != 0 {
+		return fmt.Errorf("cache: unsupported envelope flags %#x", h.Flags&^knownFlags)
+	}
+	if h.Schema != schema {
+		return &SchemaError{Stored: h.Schema, Expected: schema}
+	}
+	codec, ok := LookupCodec(h.Codec)
+	if !ok {
+		return fmt.Errorf("%w (codec %d)", ErrUnknownCodec, h.Codec)
+	}
+	return codec.Unmarshal(payload, ptr)
+}
+
+func isInteger(value interface{}) bool {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
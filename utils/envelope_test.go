@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// jsonCodec is a codec registered for the tests
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte                                  { return 200 }
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) { return json.Marshal(value) }
+func (jsonCodec) Unmarshal(b []byte, ptr interface{}) error { return json.Unmarshal(b, ptr) }
+
+func init() {
+	RegisterCodec(jsonCodec{})
+}
+
+type item struct {
+	Name string
+	Tags []string
+}
+
+func TestEnvelope(t *testing.T) {
+	in := item{Name: "name", Tags: []string{"a"}}
+	for _, codec := range []Codec{Gob, jsonCodec{}} {
+		b, err := Encode(codec, 3, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, _, ok := ParseEnvelope(b)
+		if !ok || h.Codec != codec.ID() || h.Schema != 3 || h.Flags != 0 {
+			t.Errorf("unexpected header %+v", h)
+		}
+		// the envelope names the codec, whatever the fallback
+		var out item
+		if err := Decode(b, &out, 3, Gob); err != nil || out.Name != "name" || len(out.Tags) != 1 {
+			t.Errorf("expected the item back, got %+v (%v)", out, err)
+		}
+		var schemaErr *SchemaError
+		if err := Decode(b, &out, 4, Gob); !errors.As(err, &schemaErr) || schemaErr.Stored != 3 || schemaErr.Expected != 4 {
+			t.Errorf("expected a SchemaError, got %v", err)
+		}
+	}
+
+	// integers stay decimal text
+	if b, err := Encode(jsonCodec{}, 1, int64(42)); err != nil || string(b) != "42" {
+		t.Errorf("expected 42, got %q (%v)", b, err)
+	}
+
+	// payloads written before envelopes decode with the fallback
+	legacy, _ := Serialize(in)
+	var out item
+	if err := Decode(legacy, &out, 0, Gob); err != nil || out.Name != "name" {
+		t.Errorf("expected the legacy item back, got %+v (%v)", out, err)
+	}
+	if _, _, ok := ParseEnvelope(legacy); ok {
+		t.Error("expected a gob stream not to be taken for an envelope")
+	}
+}
+
+func TestEnvelope_Unsupported(t *testing.T) {
+	b, _ := Encode(Gob, 0, "value")
+	unknown := append([]byte(nil), b...)
+	unknown[3] = 99
+	var s string
+	if err := Decode(unknown, &s, 0, Gob); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec, got %v", err)
+	}
+	compressed := append([]byte(nil), b...)
+	compressed[4] = FlagCompressed
+	if err := Decode(compressed, &s, 0, Gob); err == nil {
+		t.Error("expected unsupported flags to fail decoding")
+	}
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// CodecIDFixed is the ID of Fixed
+const CodecIDFixed byte = 3
+
+// ErrLayoutMismatch is returned by Fixed when decoding into a type whose memory layout
+// isn't the one the value was encoded from
+var ErrLayoutMismatch = errors.New("cache: memory layout mismatch.")
+
+// fixedHashSize is the size of the layout hash heading Fixed payloads
+const fixedHashSize = 8
+
+// layouts caches the layout hash of the types Fixed handled, or the error making them
+// unsuitable
+var layouts sync.Map // reflect.Type -> fixedLayout
+
+type fixedLayout struct {
+	hash uint64
+	err  error
+}
+
+type fixedCodec struct{}
+
+func (fixedCodec) ID() byte { return CodecIDFixed }
+
+// Marshal copies the memory of value, a struct or array (or a pointer to one) without
+// pointers, behind the hash of its layout
+func (fixedCodec) Marshal(value interface{}) ([]byte, error) {
+	if _, ok := value.([]byte); ok || isInteger(value) {
+		return Serialize(value)
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	} else {
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v)
+		v = cp
+	}
+	l := layoutOf(v.Type())
+	if l.err != nil {
+		return nil, l.err
+	}
+	size := int(v.Type().Size())
+	b := make([]byte, fixedHashSize+size)
+	binary.BigEndian.PutUint64(b, l.hash)
+	copy(b[fixedHashSize:], unsafe.Slice((*byte)(v.Addr().UnsafePointer()), size))
+	return b, nil
+}
+
+// Unmarshal copies b into the value ptr points to, once checked its layout is the one
+// b was encoded from
+func (fixedCodec) Unmarshal(b []byte, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("cache: fixed codec needs a non-nil pointer, got %T", ptr)
+	}
+	if _, ok := ptr.(*[]byte); ok || isInteger(v.Elem().Interface()) {
+		return Deserialize(b, ptr)
+	}
+	t := v.Elem().Type()
+	l := layoutOf(t)
+	if l.err != nil {
+		return l.err
+	}
+	size := int(t.Size())
+	if len(b) != fixedHashSize+size || binary.BigEndian.Uint64(b) != l.hash {
+		return ErrLayoutMismatch
+	}
+	copy(unsafe.Slice((*byte)(v.UnsafePointer()), size), b[fixedHashSize:])
+	return nil
+}
+
+// Fixed copies the memory of values as is, for hot structs whose gob encoding costs
+// too much CPU. It only takes structs and arrays made of booleans, numbers and arrays
+// or structs of those (no strings, slices, maps or pointers), and heads each payload
+// with a hash of the type's layout (field names, types, offsets and the architecture),
+// so decoding into a type laid out differently fails with ErrLayoutMismatch instead of
+// returning garbage. Integers are kept as decimal text and byte slices as is, like Gob.
+var Fixed Codec = fixedCodec{}
+
+func layoutOf(t reflect.Type) fixedLayout {
+	if l, ok := layouts.Load(t); ok {
+		return l.(fixedLayout)
+	}
+	var sb strings.Builder
+	sb.WriteString(runtime.GOARCH)
+	l := fixedLayout{}
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Array {
+		l.err = fmt.Errorf("cache: fixed codec can't encode %v, only structs and arrays", t)
+	} else if err := describeLayout(&sb, t); err != nil {
+		l.err = err
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(sb.String()))
+		l.hash = h.Sum64()
+	}
+	layouts.Store(t, l)
+	return l
+}
+
+// describeLayout writes what the memory layout of t depends on, failing for types
+// holding pointers
+func describeLayout(sb *strings.Builder, t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		fmt.Fprintf(sb, "%s:%d", t.Kind(), t.Size())
+	case reflect.Array:
+		fmt.Fprintf(sb, "[%d]", t.Len())
+		return describeLayout(sb, t.Elem())
+	case reflect.Struct:
+		fmt.Fprintf(sb, "struct:%d{", t.Size())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fmt.Fprintf(sb, "%s@%d ", f.Name, f.Offset)
+			if err := describeLayout(sb, f.Type); err != nil {
+				return err
+			}
+			sb.WriteByte(';')
+		}
+		sb.WriteByte('}')
+	default:
+		return fmt.Errorf("cache: fixed codec can't encode %v, which holds pointers", t)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+type position struct {
+	ID       uint64
+	Lat, Lon float64
+	Valid    bool
+	Samples  [4]int16
+	Origin   struct{ X, Y int32 }
+}
+
+// renamed has the layout of position but another field name
+type renamed struct {
+	Key      uint64
+	Lat, Lon float64
+	Valid    bool
+	Samples  [4]int16
+	Origin   struct{ X, Y int32 }
+}
+
+func TestFixed(t *testing.T) {
+	in := position{ID: 7, Lat: 42.36, Lon: -71.06, Valid: true, Samples: [4]int16{1, 2, 3, 4}}
+	in.Origin.X, in.Origin.Y = -1, 1
+	for _, value := range []interface{}{in, &in} {
+		b, err := Fixed.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out position
+		if err := Fixed.Unmarshal(b, &out); err != nil || out != in {
+			t.Errorf("expected %+v, got %+v (%v)", in, out, err)
+		}
+		var other renamed
+		if err := Fixed.Unmarshal(b, &other); !errors.Is(err, ErrLayoutMismatch) {
+			t.Errorf("expected ErrLayoutMismatch, got %v", err)
+		}
+		if err := Fixed.Unmarshal(b[:len(b)-1], &out); !errors.Is(err, ErrLayoutMismatch) {
+			t.Errorf("expected ErrLayoutMismatch for a truncated value, got %v", err)
+		}
+	}
+
+	for _, value := range []interface{}{"string", struct{ S string }{"s"}, struct{ P *int }{}, []int{1}} {
+		if _, err := Fixed.Marshal(value); err == nil {
+			t.Errorf("expected %T to be refused", value)
+		}
+	}
+	if b, err := Fixed.Marshal(42); err != nil || string(b) != "42" {
+		t.Errorf("expected integers as decimal text, got %q (%v)", b, err)
+	}
+}
+
+func BenchmarkFixed(b *testing.B) {
+	in := position{ID: 7, Lat: 42.36, Lon: -71.06}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, _ := Fixed.Marshal(&in)
+		var out position
+		Fixed.Unmarshal(data, &out)
+	}
+}
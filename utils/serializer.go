@@ -3,33 +3,122 @@ package utils
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"reflect"
 	"strconv"
+	"sync"
 )
 
-// Serialize returns a []byte representing the passed value
+// buffers pools the buffers gob-encoding values
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledBuffer is the capacity above which buffers aren't pooled
+const maxPooledBuffer = 64 << 10
+
+// readers pools the readers values are gob-decoded from
+var readers = sync.Pool{New: func() interface{} { return new(bytes.Reader) }}
+
+// nilPayload is the payload nil values are serialized to. Its first byte can't start a
+// gob stream, like the scalar markers, and the tag after it keeps raw []byte and string
+// values, which may hold any bytes, from being mistaken for it in practice.
+var nilPayload = []byte{0x80, 'c', 'a', 'c', 'h', 'e', ':', 'n', 'i', 'l', 0}
+
+// ErrNilValue is returned by Deserialize for the payload of a nil value, so a cached
+// nil can be told apart from a missing value
+var ErrNilValue = errors.New("cache: value is nil.")
+
+// IsNilValue reports whether b is the payload Serialize writes for nil values
+func IsNilValue(b []byte) bool {
+	return bytes.Equal(b, nilPayload)
+}
+
+// IsNil reports whether value is nil or a nil pointer, which Serialize writes as a nil
+// payload
+func IsNil(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	return !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil())
+}
+
+// Serialize returns a []byte representing the passed value. Integers (durations
+// included) are written as decimal text, nil values and pointers as a marker
+// Deserialize reports with ErrNilValue, others are gob-encoded. See Compact for a
+// more compact form of bools, floats and times.
 func Serialize(value interface{}) ([]byte, error) {
 	if bytes, ok := value.([]byte); ok {
 		return bytes, nil
 	}
+	return AppendSerialize(nil, value)
+}
+
+// AppendSerialize appends the serialization of value to dst and returns the extended
+// slice, like Serialize, so callers reusing dst serialize without allocating but for
+// the gob encoder of values gob-encoded
+func AppendSerialize(dst []byte, value interface{}) ([]byte, error) {
+	return appendSerialize(dst, value, false)
+}
+
+// appendSerialize is AppendSerialize, writing bools, floats and times in their
+// compact binary form if compact is set
+func appendSerialize(dst []byte, value interface{}, compact bool) ([]byte, error) {
+	if bytes, ok := value.([]byte); ok {
+		return append(dst, bytes...), nil
+	}
+	if IsNil(value) {
+		return append(dst, nilPayload...), nil
+	}
 
 	switch v := reflect.ValueOf(value); v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return []byte(strconv.FormatInt(v.Int(), 10)), nil
+		return strconv.AppendInt(dst, v.Int(), 10), nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return []byte(strconv.FormatUint(v.Uint(), 10)), nil
+		return strconv.AppendUint(dst, v.Uint(), 10), nil
+	default:
+		if !compact {
+			break
+		}
+		if b, ok, err := appendScalar(dst, v); ok {
+			return b, err
+		}
 	}
 
-	var b bytes.Buffer
-	encoder := gob.NewEncoder(&b)
+	b := buffers.Get().(*bytes.Buffer)
+	defer func() {
+		// don't keep the buffers of outsized values around
+		if b.Cap() <= maxPooledBuffer {
+			buffers.Put(b)
+		}
+	}()
+	b.Reset()
+	encoder := gob.NewEncoder(b)
 	if err := encoder.Encode(value); err != nil {
 		return nil, err
 	}
-	return b.Bytes(), nil
+	return append(dst, b.Bytes()...), nil
+}
+
+// DecodeError is returned by Deserialize when decoding panicked, as malformed data
+// may make it do, so a corrupted or tampered cache entry can't crash the process
+type DecodeError struct {
+	Panic interface{}
 }
 
-// Deserialize deserialices the passed []byte into a the passed ptr interface{}
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("cache: decoding panicked: %v", e.Panic)
+}
+
+// Deserialize deserialices the passed []byte into a the passed ptr interface{}, written
+// by Serialize or Compact. It returns ErrNilValue, leaving ptr as is, if byt is the
+// payload of a nil value.
 func Deserialize(byt []byte, ptr interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &DecodeError{Panic: r}
+		}
+	}()
+	if IsNilValue(byt) {
+		return ErrNilValue
+	}
 	if bytes, ok := ptr.(*[]byte); ok {
 		*bytes = byt
 		return nil
@@ -56,11 +145,18 @@ func Deserialize(byt []byte, ptr interface{}) (err error) {
 
 			p.SetUint(i)
 			return nil
+
+		default:
+			if ok, err := deserializeScalar(byt, p); ok {
+				return err
+			}
 		}
 	}
 
-	b := bytes.NewBuffer(byt)
-	decoder := gob.NewDecoder(b)
+	r := readers.Get().(*bytes.Reader)
+	defer readers.Put(r)
+	r.Reset(byt)
+	decoder := gob.NewDecoder(r)
 	if err = decoder.Decode(ptr); err != nil {
 		return err
 	}
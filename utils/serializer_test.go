@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fuzzStruct struct {
+	Name  string
+	Tags  []string
+	Attrs map[string]int
+	Next  *fuzzStruct
+}
+
+func FuzzDeserialize(f *testing.F) {
+	for _, v := range []interface{}{
+		"value",
+		42,
+		uint64(7),
+		true,
+		3.14,
+		time.Unix(1700000000, 42).UTC(),
+		[]string{"a", "b"},
+		map[string]int{"a": 1},
+		fuzzStruct{Name: "n", Tags: []string{"t"}, Attrs: map[string]int{"a": 1}, Next: &fuzzStruct{Name: "next"}},
+	} {
+		b, err := Serialize(v)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add([]byte{})
+	f.Add([]byte("-"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// whatever the data, decoding must fail with an error rather than panic
+		var s string
+		Deserialize(b, &s)
+		var i int64
+		Deserialize(b, &i)
+		var u uint
+		Deserialize(b, &u)
+		var m map[string]int
+		Deserialize(b, &m)
+		var st fuzzStruct
+		Deserialize(b, &st)
+		var ok bool
+		Deserialize(b, &ok)
+		var fl float64
+		Deserialize(b, &fl)
+		var tm time.Time
+		Deserialize(b, &tm)
+		var raw []byte
+		if err := Deserialize(b, &raw); err != nil || string(raw) != string(b) {
+			t.Errorf("expected the raw bytes back, got %q (%v)", raw, err)
+		}
+	})
+}
+
+// panicky panics when decoded, standing in for a decoder choking on malformed data
+type panicky struct{}
+
+func (panicky) GobEncode() ([]byte, error) { return []byte{1}, nil }
+
+func (*panicky) GobDecode([]byte) error { panic("malformed") }
+
+func TestDeserialize_Panic(t *testing.T) {
+	b, err := Serialize(panicky{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v panicky
+	var decodeErr *DecodeError
+	if err := Deserialize(b, &v); !errors.As(err, &decodeErr) || decodeErr.Panic != "malformed" {
+		t.Errorf("expected a DecodeError, got %v", err)
+	}
+}
+
+func TestSerialize_Scalars(t *testing.T) {
+	now := time.Now().Round(0)
+	type ratio float32
+	for _, tc := range []struct {
+		in, out interface{}
+		size    int
+	}{
+		{true, new(bool), 2},
+		{false, new(bool), 2},
+		{3.14, new(float64), 9},
+		{ratio(0.5), new(ratio), 5},
+		{float32(1.5), new(float64), 5},
+		{now, new(time.Time), 16},
+		{time.Minute, new(time.Duration), 11},
+	} {
+		b, err := Compact.Marshal(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > tc.size {
+			t.Errorf("expected %T in at most %d bytes, got %d", tc.in, tc.size, len(b))
+		}
+		if err := Deserialize(b, tc.out); err != nil {
+			t.Errorf("%T: %v", tc.in, err)
+		}
+		got := reflect.ValueOf(tc.out).Elem().Interface()
+		if tm, ok := got.(time.Time); ok && !tm.Equal(now) || !ok && fmt.Sprint(got) != fmt.Sprint(tc.in) {
+			t.Errorf("expected %v, got %v", tc.in, got)
+		}
+	}
+
+	// Serialize keeps gob-encoding them, so older versions still read what it writes,
+	// and gob-encoded values still decode
+	for _, v := range []interface{}{true, 2.5, now} {
+		b, err := Serialize(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := reflect.New(reflect.TypeOf(v))
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(out.Interface()); err != nil {
+			t.Errorf("%T: expected gob, got %v", v, err)
+		}
+		if err := Deserialize(b, out.Interface()); err != nil {
+			t.Errorf("%T: %v", v, err)
+		}
+	}
+}
+
+func TestSerialize_Nil(t *testing.T) {
+	type user struct{ Name string }
+	for _, v := range []interface{}{nil, (*user)(nil)} {
+		b, err := Serialize(v)
+		if err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		if !IsNilValue(b) {
+			t.Errorf("%T: expected the nil payload, got %x", v, b)
+		}
+		var out user
+		if err := Deserialize(b, &out); err != ErrNilValue {
+			t.Errorf("%T: expected ErrNilValue, got %v", v, err)
+		}
+		if err := Decode(b, &out, 0, Gob); err != ErrNilValue {
+			t.Errorf("%T: expected ErrNilValue decoding, got %v", v, err)
+		}
+		raw := []byte("unchanged")
+		if err := Deserialize(b, &raw); err != ErrNilValue || string(raw) != "unchanged" {
+			t.Errorf("%T: expected ErrNilValue into a []byte, got %v and %q", v, err, raw)
+		}
+	}
+	if b, _ := Encode(CBOR, 1, nil); !IsNilValue(b) {
+		t.Errorf("expected nil to be encoded as the nil payload, got %x", b)
+	}
+	if IsNilValue([]byte{0x80}) {
+		t.Error("expected a lone 0x80 byte not to be taken for the nil payload")
+	}
+}
+
+func TestAppendSerialize(t *testing.T) {
+	for _, v := range []interface{}{[]byte("raw"), 42, uint8(7), true, 2.5, time.Unix(1, 0), fuzzStruct{Name: "n"}} {
+		want, err := Serialize(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := AppendSerialize([]byte("prefix"), v)
+		if err != nil || string(got) != "prefix"+string(want) {
+			t.Errorf("%T: expected %q, got %q (%v)", v, "prefix"+string(want), got, err)
+		}
+	}
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	v := fuzzStruct{Name: "name", Tags: []string{"a", "b"}, Attrs: map[string]int{"a": 1}}
+	b.Run("Serialize", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			Serialize(v)
+		}
+	})
+	b.Run("AppendSerialize", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, _ = AppendSerialize(buf[:0], v)
+		}
+	})
+	b.Run("Deserialize", func(b *testing.B) {
+		b.ReportAllocs()
+		data, _ := Serialize(v)
+		for i := 0; i < b.N; i++ {
+			var out fuzzStruct
+			Deserialize(data, &out)
+		}
+	})
+}